@@ -0,0 +1,46 @@
+package rmq
+
+import "time"
+
+// WithAckDeadline sets how long consumers of this queue may take to ack,
+// reject or push a delivery. rmq itself never redelivers unacked deliveries
+// while their connection is alive, but handlers are often bound by deadlines
+// of their own, like the lease of a job or the timeout of the caller waiting
+// for the result. Once a delivery passed to a consumer is still unhandled
+// after the given fraction of deadline (for example 0.8), an AckDeadlineError
+// gets sent to errChan, so slow handlers show up before the deadline actually
+// passes. Each delivery gets reported at most once.
+// NOTE: doesn't apply to batch consumers
+func WithAckDeadline(deadline time.Duration, warnFraction float64) QueueOption {
+	return func(queue *redisQueue) {
+		queue.ackDeadline = deadline
+		queue.ackWarnAfter = time.Duration(float64(deadline) * warnFraction)
+	}
+}
+
+// watchAckDeadline starts watching the given delivery which is about to get
+// consumed. Call the returned function once Consume() returned.
+func (queue *redisQueue) watchAckDeadline(delivery Delivery) (consumed func()) {
+	redisDelivery, ok := delivery.(*redisDelivery)
+	if !ok || queue.ackDeadline <= 0 {
+		return func() {}
+	}
+
+	start := time.Now()
+	timer := time.AfterFunc(queue.ackWarnAfter, func() {
+		if redisDelivery.handled() {
+			return
+		}
+		queue.options.logf(LogInfo, "rmq queue delivery approaching ack deadline %s %s", queue, redisDelivery)
+		select { // try to add error to channel, but don't block
+		case queue.errChan <- &AckDeadlineError{Queue: queue.name, Delivery: delivery, Elapsed: time.Since(start), Deadline: queue.ackDeadline}:
+		default:
+		}
+	})
+	return func() {
+		if redisDelivery.handled() {
+			timer.Stop()
+		}
+		// otherwise keep watching, the consumer might handle it asynchronously
+	}
+}
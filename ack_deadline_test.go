@@ -0,0 +1,53 @@
+package rmq
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAckDeadline(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("deadline-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("deadline-q", WithAckDeadline(40*time.Millisecond, 0.5))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.StartConsuming(1, time.Millisecond))
+	_, err = queue.AddConsumerFunc("deadline-cons", func(delivery Delivery) {
+		if delivery.Payload() == "deadline-slow" {
+			time.Sleep(40 * time.Millisecond)
+		}
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+
+	// fast deliveries don't get reported
+	assert.NoError(t, queue.Publish("deadline-fast", "deadline-slow"))
+	var deadlineErr *AckDeadlineError
+	select {
+	case err := <-errChan:
+		require.IsType(t, deadlineErr, err)
+		deadlineErr = err.(*AckDeadlineError)
+	case <-time.After(time.Second):
+		t.Fatal("no ack deadline error")
+	}
+	assert.Equal(t, "deadline-q", deadlineErr.Queue)
+	assert.Equal(t, "deadline-slow", deadlineErr.Delivery.Payload())
+	assert.Equal(t, 40*time.Millisecond, deadlineErr.Deadline)
+	assert.True(t, deadlineErr.Elapsed >= 20*time.Millisecond)
+	// gets logged without its payload
+	assert.NotContains(t, fmt.Sprint(deadlineErr.Delivery), "deadline-slow")
+	assert.Contains(t, fmt.Sprint(deadlineErr.Delivery), deliveryID("deadline-slow"))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Len(t, errChan, 0) // reported only once
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
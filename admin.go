@@ -0,0 +1,164 @@
+package rmq
+
+import (
+	"context"
+	"time"
+)
+
+// AdminQueue are the stats of a queue as returned by AdminService, see the
+// Queue message in proto/rmq/admin/v1/admin.proto
+type AdminQueue struct {
+	Name        string
+	Ready       int64
+	Rejected    int64
+	Unacked     int64
+	Consumers   int64 // across all connections
+	Connections int64 // connections consuming the queue
+}
+
+// AdminSnapshot are the stats of some queues at a point in time, as streamed
+// by AdminService.WatchStats()
+type AdminSnapshot struct {
+	Time   time.Time
+	Queues []AdminQueue
+}
+
+// AdminService implements the rpcs of the Admin gRPC service defined in
+// proto/rmq/admin/v1/admin.proto, one method per rpc. The server in the
+// nested module github.com/adjust/rmq/v4/proto only converts between messages
+// and these types, so platform tooling written in other languages can manage
+// queues without touching redis keys. Operations on queues which aren't open
+// return ErrorNotFound, so typos don't create queues. Operations are subject
+// to the operation policy of the connection, see Options.Operations.
+type AdminService struct {
+	connection Connection
+}
+
+func NewAdminService(connection Connection) *AdminService {
+	return &AdminService{connection: connection}
+}
+
+// ListQueues returns the stats of all open queues
+func (service *AdminService) ListQueues() ([]AdminQueue, error) {
+	queueNames, err := service.connection.GetOpenQueues()
+	if err != nil {
+		return nil, err
+	}
+	return service.queues(queueNames)
+}
+
+// PurgeReady removes all ready deliveries of the given queue, see
+// Queue.PurgeReady()
+func (service *AdminService) PurgeReady(queueName string) (purged int64, err error) {
+	queue, err := service.openQueue(queueName)
+	if err != nil {
+		return 0, err
+	}
+	return queue.PurgeReady()
+}
+
+// PurgeRejected removes all rejected deliveries of the given queue, see
+// Queue.PurgeRejected()
+func (service *AdminService) PurgeRejected(queueName string) (purged int64, err error) {
+	queue, err := service.openQueue(queueName)
+	if err != nil {
+		return 0, err
+	}
+	return queue.PurgeRejected()
+}
+
+// ReturnRejected returns up to max rejected deliveries of the given queue to
+// ready, see Queue.ReturnRejected()
+func (service *AdminService) ReturnRejected(queueName string, max int64) (returned int64, err error) {
+	queue, err := service.openQueue(queueName)
+	if err != nil {
+		return 0, err
+	}
+	return queue.ReturnRejected(max)
+}
+
+// DestroyQueue purges the given queue and removes it from the open queues,
+// see Queue.Destroy()
+func (service *AdminService) DestroyQueue(queueName string) (readyCount, rejectedCount int64, err error) {
+	queue, err := service.openQueue(queueName)
+	if err != nil {
+		return 0, 0, err
+	}
+	return queue.Destroy()
+}
+
+// WatchStats calls send with a snapshot of the stats of the given queues (all
+// open queues if none are given) once per interval, starting immediately.
+// Returns once ctx is done or send or collecting the stats failed. Returns
+// ErrorNotFound if one of the given queues isn't open.
+func (service *AdminService) WatchStats(ctx context.Context, queueNames []string, interval time.Duration, send func(AdminSnapshot) error) error {
+	for _, queueName := range queueNames {
+		if _, err := service.openQueue(queueName); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		names := queueNames
+		if len(names) == 0 {
+			openQueues, err := service.connection.GetOpenQueues()
+			if err != nil {
+				return err
+			}
+			names = openQueues
+		}
+		queues, err := service.queues(names)
+		if err != nil {
+			return err
+		}
+		if err := send(AdminSnapshot{Time: time.Now(), Queues: queues}); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// openQueue returns the queue with the given name, or ErrorNotFound if it
+// isn't open
+func (service *AdminService) openQueue(queueName string) (Queue, error) {
+	queueNames, err := service.connection.GetOpenQueues()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range queueNames {
+		if name == queueName {
+			return service.connection.openQueue(queueName), nil
+		}
+	}
+	return nil, ErrorNotFound
+}
+
+// queues returns the stats of the given queues in the given order
+func (service *AdminService) queues(queueNames []string) ([]AdminQueue, error) {
+	stats, err := service.connection.CollectStats(queueNames)
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make([]AdminQueue, 0, len(queueNames))
+	for _, queueName := range queueNames {
+		stat := stats.QueueStats[queueName]
+		queues = append(queues, AdminQueue{
+			Name:        queueName,
+			Ready:       stat.ReadyCount,
+			Rejected:    stat.RejectedCount,
+			Unacked:     stat.UnackedCount(),
+			Consumers:   stat.ConsumerCount(),
+			Connections: stat.ConnectionCount(),
+		})
+	}
+	return queues, nil
+}
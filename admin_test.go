@@ -0,0 +1,82 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminService(t *testing.T) {
+	connection, err := OpenConnection("admin-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("admin-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("admin-d1", "admin-d2", "admin-d3"))
+	redisQueue := queue.(*redisQueue)
+	_, err = redisQueue.redisClient.LPush(redisQueue.rejectedKey, "admin-r1", "admin-r2")
+	assert.NoError(t, err)
+
+	service := NewAdminService(connection)
+	queues, err := service.ListQueues()
+	assert.NoError(t, err)
+	assert.Contains(t, queues, AdminQueue{Name: "admin-q", Ready: 3, Rejected: 2})
+
+	returned, err := service.ReturnRejected("admin-q", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), returned)
+	purged, err := service.PurgeRejected("admin-q")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	purged, err = service.PurgeReady("admin-q")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), purged)
+
+	var snapshots []AdminSnapshot
+	errDone := errors.New("done")
+	err = service.WatchStats(context.Background(), []string{"admin-q"}, time.Millisecond, func(snapshot AdminSnapshot) error {
+		snapshots = append(snapshots, snapshot)
+		if len(snapshots) == 2 {
+			return errDone
+		}
+		return nil
+	})
+	assert.Equal(t, errDone, err)
+	require.Len(t, snapshots, 2)
+	assert.Equal(t, []AdminQueue{{Name: "admin-q"}}, snapshots[1].Queues)
+	assert.False(t, snapshots[1].Time.Before(snapshots[0].Time))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = service.WatchStats(ctx, nil, time.Millisecond, func(snapshot AdminSnapshot) error { return nil })
+	assert.Equal(t, context.Canceled, err)
+
+	readyCount, rejectedCount, err := service.DestroyQueue("admin-q")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), readyCount)
+	assert.Equal(t, int64(0), rejectedCount)
+	_, _, err = service.DestroyQueue("admin-q")
+	assert.Equal(t, ErrorNotFound, err)
+
+	// unknown queues don't get created
+	_, err = service.PurgeReady("admin-typo-q")
+	assert.Equal(t, ErrorNotFound, err)
+	_, err = service.PurgeRejected("admin-typo-q")
+	assert.Equal(t, ErrorNotFound, err)
+	_, err = service.ReturnRejected("admin-typo-q", 1)
+	assert.Equal(t, ErrorNotFound, err)
+	err = service.WatchStats(context.Background(), []string{"admin-typo-q"}, time.Millisecond, func(AdminSnapshot) error { return nil })
+	assert.Equal(t, ErrorNotFound, err)
+	queueNames, err := connection.GetOpenQueues()
+	assert.NoError(t, err)
+	assert.NotContains(t, queueNames, "admin-typo-q")
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
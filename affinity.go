@@ -0,0 +1,173 @@
+package rmq
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/adjust/rmq/v4/internal/goroutines"
+)
+
+// AffinityFunc returns the affinity key of a delivery. Deliveries with the
+// same affinity key get consumed by the same consumer.
+type AffinityFunc func(Delivery) string
+
+// AddAffinityConsumers adds the given consumers to the queue and routes
+// deliveries with the same affinity key to the same consumer. This way each
+// consumer can keep per-entity state (like caches) without needing to
+// synchronize with the other consumers. Returns the internal consumer names.
+// Slow consumer eviction, panic quarantine and the shutdown watchdog apply
+// like for consumers added via AddConsumer(). The affinity keys of evicted
+// and quarantined consumers move to the remaining ones.
+// NOTE: A slow consumer delays the dispatching of deliveries to the other
+// consumers, as the next delivery can only be handed over once the consumer it
+// belongs to is ready to take it.
+func (queue *redisQueue) AddAffinityConsumers(tag string, affinity AffinityFunc, consumers ...Consumer) ([]string, error) {
+	if len(consumers) == 0 {
+		return nil, ErrorNoConsumers
+	}
+	if queue.singleActive && len(consumers) > 1 {
+		return nil, ErrorSingleConsumer
+	}
+
+	names := make([]string, 0, len(consumers))
+	for range consumers {
+		name, err := queue.addConsumer(tag, 1)
+		if err != nil {
+			queue.removeAdded(names)
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	queue.stopWg.Add(len(consumers) + 1)
+	affinityConsumers := make([]*affinityConsumer, len(consumers))
+	for i, consumer := range consumers {
+		name, consumer := names[i], consumer
+		affinityConsumer := &affinityConsumer{deliveries: make(chan Delivery), stopped: make(chan struct{})}
+		affinityConsumers[i] = affinityConsumer
+		goroutines.Go("affinity consumer", func() {
+			queue.withLabels(name, func() { queue.affinityConsume(name, affinityConsumer, consumer) })
+		})
+	}
+	goroutines.Go("affinity dispatch", func() {
+		queue.withLabels(tag, func() { queue.affinityDispatch(affinity, affinityConsumers) })
+	})
+
+	return names, nil
+}
+
+// affinityConsumer is the dispatcher's end of a consumer added via
+// AddAffinityConsumers()
+type affinityConsumer struct {
+	deliveries chan Delivery
+	stopped    chan struct{} // gets closed once the consumer got evicted or quarantined
+}
+
+// removeAdded removes the consumers which got added before adding another
+// one failed, see AddAffinityConsumers()
+func (queue *redisQueue) removeAdded(names []string) {
+	for _, name := range names {
+		queue.removeConsumer(QueueEvent{Event: ConsumerRemoved, Queue: queue.name, Connection: queue.connectionName, Consumer: name})
+	}
+	atomic.AddInt32(&queue.consumerCount, -int32(len(names)))
+	atomic.AddInt64(&queue.concurrency, -int64(len(names)))
+	atomic.AddInt32(&queue.runningCount, -int32(len(names)))
+}
+
+// affinityDispatch passes deliveries from the delivery channel on to the
+// consumer their affinity key maps to
+func (queue *redisQueue) affinityDispatch(affinity AffinityFunc, consumers []*affinityConsumer) {
+	defer queue.stopWg.Done()
+	defer func() {
+		for _, consumer := range consumers {
+			close(consumer.deliveries)
+		}
+	}()
+
+	for {
+		select {
+		case <-queue.consumerStop: // prefer this case
+			return
+		default:
+		}
+
+		select {
+		case <-queue.consumerStop:
+			return
+
+		case delivery, ok := <-queue.deliveryChan:
+			if !ok { // deliveryChan closed
+				return
+			}
+
+			if !queue.affinitySend(affinity(delivery), consumers, delivery) {
+				return
+			}
+		}
+	}
+}
+
+// affinitySend passes the delivery on to the consumer the key maps to. If
+// that one stopped, the key gets mapped to the remaining consumers. Returns
+// false if consuming got stopped or no consumer is left, then the delivery
+// got returned to ready.
+func (queue *redisQueue) affinitySend(key string, consumers []*affinityConsumer, delivery Delivery) bool {
+	target := consumers[affinityIndex(key, len(consumers))]
+	for {
+		select {
+		case <-queue.consumerStop:
+			return false
+		case target.deliveries <- delivery:
+			return true
+		case <-target.stopped:
+		}
+
+		var remaining []*affinityConsumer
+		for _, consumer := range consumers {
+			select {
+			case <-consumer.stopped:
+			default:
+				remaining = append(remaining, consumer)
+			}
+		}
+		if len(remaining) == 0 {
+			queue.journalRemove(delivery)
+			if err := queue.returnDelivery(delivery.(*redisDelivery)); err != nil {
+				select { // try to add error to channel, but don't block
+				case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+				default:
+				}
+			}
+			return false
+		}
+		target = remaining[affinityIndex(key, len(remaining))]
+	}
+}
+
+func (queue *redisQueue) affinityConsume(name string, affinityConsumer *affinityConsumer, consumer Consumer) {
+	defer queue.stopWg.Done()
+	state := queue.newConsumerState(name, consumer)
+	defer queue.dispatcher.remove(state.dispatch)
+	for delivery := range affinityConsumer.deliveries {
+		if queue.consumeTracked(state, delivery) {
+			close(affinityConsumer.stopped) // evicted or quarantined
+			return
+		}
+	}
+}
+
+// affinityIndex maps the given key to one of n buckets using jump consistent
+// hashing, see https://arxiv.org/abs/1406.2294
+func affinityIndex(key string, n int) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	h := hash.Sum64()
+
+	b, j := int64(-1), int64(0)
+	for j < int64(n) {
+		b = j
+		h = h*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((h>>33)+1)))
+	}
+	return int(b)
+}
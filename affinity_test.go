@@ -0,0 +1,177 @@
+package rmq
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffinityConsumers(t *testing.T) {
+	connection, err := OpenConnection("affinity-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("affinity-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	_, err = queue.AddAffinityConsumers("affinity-cons", nil, NewTestConsumer("affinity-A"))
+	assert.Equal(t, ErrorNotConsuming, err)
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddAffinityConsumers("affinity-cons", nil)
+	assert.Equal(t, ErrorNoConsumers, err)
+
+	consumers := []*TestConsumer{
+		NewTestConsumer("affinity-A"),
+		NewTestConsumer("affinity-B"),
+		NewTestConsumer("affinity-C"),
+	}
+	affinity := func(delivery Delivery) string {
+		return delivery.Payload()[:1] // first letter is the key
+	}
+	names, err := queue.AddAffinityConsumers("affinity-cons", affinity, consumers[0], consumers[1], consumers[2])
+	assert.NoError(t, err)
+	assert.Len(t, names, 3)
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, queue.Publish("a", "b", "c", "d", "e"))
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	total := 0
+	for _, consumer := range consumers {
+		total += len(consumer.LastDeliveries)
+		keys := map[string]bool{}
+		for _, delivery := range consumer.LastDeliveries {
+			keys[delivery.Payload()] = true
+		}
+		for key := range keys {
+			// each consumer gets all deliveries of its keys
+			assert.Len(t, filterPayloads(consumer.LastDeliveries, key), 10)
+		}
+	}
+	assert.Equal(t, 50, total)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestAffinityQuarantine(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("affinity-quarantine-conn", "tcp", "localhost:6379", 1, errChan)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("affinity-quarantine-q", WithPanicQuarantine(1, time.Minute))
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	require.NoError(t, err)
+
+	var acked int32
+	consumer := ConsumerFunc(func(delivery Delivery) {
+		if delivery.Payload() == "b-boom" {
+			panic("boom")
+		}
+		assert.NoError(t, delivery.Ack())
+		atomic.AddInt32(&acked, 1)
+	})
+	affinity := func(delivery Delivery) string {
+		return delivery.Payload()[:1] // first letter is the key
+	}
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddAffinityConsumers("affinity-cons", affinity, consumer, consumer, consumer)
+	require.NoError(t, err)
+
+	// the consumer of key b gets quarantined
+	assert.NoError(t, queue.Publish("b-boom"))
+	var panicErr *ConsumerPanicError
+	for panicErr == nil {
+		select {
+		case err := <-errChan:
+			errors.As(err, &panicErr) // skip DuplicateConsumerError
+		case <-time.After(time.Second):
+			t.Fatal("no consumer panic error")
+		}
+	}
+	assert.True(t, panicErr.Quarantined)
+	time.Sleep(5 * time.Millisecond)
+	assert.Len(t, queue.InFlight(), 2)
+
+	// its key moves to the remaining consumers
+	assert.NoError(t, queue.Publish("a1", "b1", "c1", "b2", "d1"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&acked))
+	count, err := queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+// failingConsumersClient fails adding consumers after the given number of
+// calls
+type failingConsumersClient struct {
+	RedisClient
+	calls *int
+	limit int
+}
+
+func (client failingConsumersClient) SAdd(key, value string) (int64, error) {
+	if strings.HasSuffix(key, "::consumers") {
+		if *client.calls++; *client.calls > client.limit {
+			return 0, errors.New("connection reset")
+		}
+	}
+	return client.RedisClient.SAdd(key, value)
+}
+
+func TestAffinityConsumersFailure(t *testing.T) {
+	calls := 0
+	redisClient := failingConsumersClient{RedisClient: NewTestRedisClient(), calls: &calls, limit: 2}
+	connection, err := OpenConnectionWithOptions("affinity-failing-conn", redisClient, nil, TestOptions)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("affinity-failing-q")
+	require.NoError(t, err)
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+
+	// the consumers added before the failing one get removed again
+	consumer := NewTestConsumer("affinity-failing")
+	names, err := queue.AddAffinityConsumers("affinity-cons", nil, consumer, consumer, consumer)
+	assert.EqualError(t, err, "connection reset")
+	assert.Nil(t, names)
+	consumers, err := queue.getConsumers()
+	assert.NoError(t, err)
+	assert.Empty(t, consumers)
+	redisQueue := queue.(*redisQueue)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&redisQueue.consumerCount))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&redisQueue.concurrency))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&redisQueue.runningCount))
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestAffinityIndex(t *testing.T) {
+	for n := 1; n < 10; n++ {
+		for _, key := range []string{"", "a", "b", "customer-123"} {
+			i := affinityIndex(key, n)
+			require.True(t, i >= 0 && i < n)
+			assert.Equal(t, i, affinityIndex(key, n)) // stable
+		}
+	}
+}
+
+func filterPayloads(deliveries []Delivery, payload string) []Delivery {
+	var filtered []Delivery
+	for _, delivery := range deliveries {
+		if delivery.Payload() == payload {
+			filtered = append(filtered, delivery)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,94 @@
+package rmq
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// AgingPolicy prevents starvation of a low priority queue whose consumers
+// can't keep up while a higher priority queue is under sustained load. A
+// Janitor promotes deliveries which waited for longer than MaxWait to the
+// ready list of the higher priority queue, where they get consumed after the
+// high priority deliveries which were ready before. Waiting times are
+// measured from the publish time, so the policy only applies to deliveries
+// published with WithPublishTime().
+type AgingPolicy struct {
+	PromoteTo string        `json:"promoteTo"` // name of the higher priority queue
+	MaxWait   time.Duration `json:"maxWait"`
+}
+
+// SetAgingPolicy persists the aging policy of this (low priority) queue in
+// redis, where it gets enforced by a Janitor. A zero policy removes it.
+// NOTE: in a redis cluster both queues must live on the same node
+func (queue *redisQueue) SetAgingPolicy(policy AgingPolicy) error {
+	if policy == (AgingPolicy{}) {
+		_, err := queue.redisClient.Del(queue.agingKey)
+		return err
+	}
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+	if policy.PromoteTo == "" || policy.PromoteTo == queue.name || policy.MaxWait <= 0 {
+		return ErrorInvalidPolicy
+	}
+
+	bytes, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return queue.redisClient.Set(queue.agingKey, string(bytes), 0)
+}
+
+// AgingPolicy returns the aging policy of this queue, see SetAgingPolicy()
+func (queue *redisQueue) AgingPolicy() (AgingPolicy, error) {
+	var policy AgingPolicy
+	value, err := queue.redisClient.Get(queue.agingKey)
+	if err == ErrorNotFound {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+	err = json.Unmarshal([]byte(value), &policy)
+	return policy, err
+}
+
+// enforceAging promotes the deliveries which waited for longer than allowed
+// by the aging policy and returns how many it promoted. Like dropOlder() it
+// stops at the first delivery which is younger or has no publish time.
+func (queue *redisQueue) enforceAging(now time.Time) (promoted int64, err error) {
+	policy, err := queue.AgingPolicy()
+	if err != nil || policy.MaxWait <= 0 {
+		return 0, err
+	}
+
+	promoteKey := strings.Replace(queueReadyTemplate, phQueue, policy.PromoteTo, 1)
+	cutoff := now.Add(-policy.MaxWait)
+	for {
+		payload, err := queue.redisClient.LIndex(queue.readyKey, -1)
+		if err == ErrorNotFound { // empty
+			return promoted, nil
+		}
+		if err != nil {
+			return promoted, err
+		}
+
+		header, _ := decodeHeader(payload)
+		publishedAt, ok := header.publishedAt()
+		if !ok || !publishedAt.Before(cutoff) {
+			return promoted, nil
+		}
+
+		pushed := payload
+		if updated, ok := addBreadcrumb(payload, TrailPromoted, queue.connectionName); ok {
+			pushed = updated
+		}
+		// remove by value, in case a consumer took this one meanwhile
+		n, err := queue.redisClient.LRemLPush(queue.readyKey, payload, promoteKey, pushed)
+		if err != nil {
+			return promoted, err
+		}
+		promoted += n
+	}
+}
@@ -0,0 +1,79 @@
+package rmq
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgingPolicy(t *testing.T) {
+	connection, err := OpenConnection("aging-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	highQueue, err := connection.OpenQueue("aging-high")
+	require.NoError(t, err)
+	_, err = highQueue.PurgeReady()
+	require.NoError(t, err)
+	lowQueue, err := connection.OpenQueue("aging-low", WithTrail())
+	require.NoError(t, err)
+	_, err = lowQueue.PurgeReady()
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrorInvalidPolicy, lowQueue.SetAgingPolicy(AgingPolicy{MaxWait: time.Minute}))
+	assert.Equal(t, ErrorInvalidPolicy, lowQueue.SetAgingPolicy(AgingPolicy{PromoteTo: "aging-low", MaxWait: time.Minute}))
+	assert.Equal(t, ErrorInvalidPolicy, lowQueue.SetAgingPolicy(AgingPolicy{PromoteTo: "aging-high"}))
+
+	policy := AgingPolicy{PromoteTo: "aging-high", MaxWait: time.Minute}
+	assert.NoError(t, lowQueue.SetAgingPolicy(policy))
+	stored, err := lowQueue.AgingPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, policy, stored)
+
+	publishedAt := func(age time.Duration) Header {
+		return Header{HeaderPublishedAt: strconv.FormatInt(time.Now().Add(-age).UnixNano(), 10)}
+	}
+	assert.NoError(t, highQueue.Publish("aging-h1"))
+	assert.NoError(t, lowQueue.PublishWithHeader(publishedAt(time.Hour), "aging-l1", "aging-l2"))
+	assert.NoError(t, lowQueue.PublishWithHeader(publishedAt(time.Second), "aging-l3"))
+
+	promoted, err := NewJanitor(connection).PromoteAged()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), promoted)
+
+	messages, err := highQueue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 3)
+	assert.Equal(t, "aging-h1", messages[0].Payload) // promoted ones come after
+	assert.Equal(t, "aging-l1", messages[1].Payload)
+	assert.Equal(t, "aging-l2", messages[2].Payload)
+	trail := messages[1].Header.Trail()
+	require.NotEmpty(t, trail)
+	assert.Equal(t, TrailPromoted, trail[len(trail)-1].Event)
+
+	messages, err = lowQueue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "aging-l3", messages[0].Payload)
+
+	assert.NoError(t, lowQueue.SetAgingPolicy(AgingPolicy{}))
+	stored, err = lowQueue.AgingPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, AgingPolicy{}, stored)
+	promoted, err = NewJanitor(connection).PromoteAged()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), promoted)
+
+	// destroying the queue removes its policy
+	assert.NoError(t, lowQueue.SetAgingPolicy(AgingPolicy{PromoteTo: "aging-high", MaxWait: time.Minute}))
+	_, _, err = lowQueue.Destroy()
+	assert.NoError(t, err)
+	stored, err = lowQueue.AgingPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, AgingPolicy{}, stored)
+
+	_, err = highQueue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
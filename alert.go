@@ -0,0 +1,271 @@
+package rmq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// alerts fired and resolved by an Alerter, see AlertRules
+const (
+	AlertBacklogGrowing = "backlog_growing"  // the backlog didn't shrink for AlertRules.BacklogGrowing
+	AlertAckRateDropped = "ack_rate_dropped" // the ack rate dropped by AlertRules.AckRateDrop
+	AlertRejectedRate   = "rejected_rate"    // deliveries got rejected faster than AlertRules.RejectedRate
+)
+
+// default fraction by which a metric has to recover before an alert resolves
+const defaultAlertHysteresis = 0.1
+
+// weight of the latest ack rate in the moving average the drops are relative to
+const alertBaselineWeight = 0.2
+
+// timeout of the requests sent by NewWebhookAlertFunc()
+const alertWebhookTimeout = 10 * time.Second
+
+// Alert notifies that a queue started or stopped violating one of the
+// AlertRules of an Alerter
+type Alert struct {
+	Alert   string    `json:"alert"` // AlertBacklogGrowing, AlertAckRateDropped or AlertRejectedRate
+	Queue   string    `json:"queue"`
+	Firing  bool      `json:"firing"`  // false once the alert resolved
+	Value   float64   `json:"value"`   // the backlog, ack rate or rejected rate (per second)
+	Message string    `json:"message"` // human readable, for chat notifications
+	Time    time.Time `json:"time"`
+}
+
+// AlertRules define when an Alerter fires alerts. Rules with zero values are
+// disabled. To avoid alerts flapping, a firing alert only resolves once its
+// metric recovered by the hysteresis fraction beyond the threshold.
+type AlertRules struct {
+	// BacklogGrowing fires an alert if the backlog (ready and unacked
+	// deliveries) of a queue didn't shrink for this long. It resolves once the
+	// backlog shrank by the hysteresis fraction from its peak.
+	BacklogGrowing time.Duration
+	// AckRateDrop fires an alert if the ack rate (deliveries fetched by
+	// consumers per second) of a queue with a backlog dropped by this fraction
+	// (between 0 and 1) below its moving average. It resolves once the rate
+	// recovered by the hysteresis fraction above the threshold or the backlog
+	// got consumed.
+	AckRateDrop float64
+	// RejectedRate fires an alert if more deliveries per second got rejected.
+	// It resolves once the rate dropped by the hysteresis fraction below.
+	RejectedRate float64
+	// Hysteresis is the fraction by which metrics have to recover before
+	// alerts resolve, defaults to 0.1
+	Hysteresis float64
+}
+
+// AlertFunc gets called whenever an alert fires or resolves
+type AlertFunc func(alert Alert)
+
+// Alerter evaluates AlertRules against the stats of all open queues, so basic
+// queue alerting doesn't need an external rules engine. Rates are derived
+// from consecutive evaluations, so evaluate at a fixed interval which is
+// longer than the heartbeat interval, see Run().
+type Alerter struct {
+	connection Connection
+	rules      AlertRules
+	notify     AlertFunc
+
+	mu       sync.Mutex // protects previous and queues
+	previous *Stats     // stats of the previous evaluation
+	queues   map[string]*alertState
+}
+
+// alertState is what an Alerter remembers about a queue between evaluations
+type alertState struct {
+	firing       map[string]bool // by alert
+	growingSince time.Time       // since when the backlog didn't shrink
+	backlog      int64           // backlog at the previous evaluation
+	peakBacklog  int64           // highest backlog since AlertBacklogGrowing fired
+	ackBaseline  float64         // moving average of the ack rate while the queue has a backlog
+}
+
+func NewAlerter(connection Connection, rules AlertRules, notify AlertFunc) *Alerter {
+	if rules.Hysteresis <= 0 {
+		rules.Hysteresis = defaultAlertHysteresis
+	}
+	return &Alerter{
+		connection: connection,
+		rules:      rules,
+		notify:     notify,
+		queues:     map[string]*alertState{},
+	}
+}
+
+// Evaluate collects the stats of all open queues and notifies about the
+// alerts which fired or resolved since the previous evaluation
+func (alerter *Alerter) Evaluate() error {
+	queueNames, err := alerter.connection.GetOpenQueues()
+	if err != nil {
+		return err
+	}
+	stats, err := alerter.connection.CollectStats(queueNames)
+	if err != nil {
+		return err
+	}
+
+	for _, alert := range alerter.evaluate(stats) {
+		alerter.notify(alert)
+	}
+	return nil
+}
+
+// Run calls Evaluate() once per interval until the context is done. Returns
+// the context's error or any redis error.
+func (alerter *Alerter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := alerter.Evaluate(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// evaluate returns the alerts which fired or resolved in the given stats,
+// ordered by queue
+func (alerter *Alerter) evaluate(stats Stats) []Alert {
+	alerter.mu.Lock()
+	defer alerter.mu.Unlock()
+
+	queueNames := make([]string, 0, len(stats.QueueStats))
+	for queueName := range stats.QueueStats {
+		queueNames = append(queueNames, queueName)
+	}
+	sort.Strings(queueNames)
+
+	var alerts []Alert
+	for _, queueName := range queueNames {
+		stat := stats.QueueStats[queueName]
+		state, ok := alerter.queues[queueName]
+		if !ok {
+			state = &alertState{firing: map[string]bool{}, growingSince: stats.collected}
+			alerter.queues[queueName] = state
+		}
+
+		var previousStat *QueueStat
+		elapsed := 0.0
+		if alerter.previous != nil {
+			if s, ok := alerter.previous.QueueStats[queueName]; ok {
+				previousStat = &s
+				elapsed = stats.collected.Sub(alerter.previous.collected).Seconds()
+			}
+		}
+
+		alert := func(name string, firing bool, value float64, format string, args ...interface{}) {
+			state.firing[name] = firing
+			alerts = append(alerts, Alert{
+				Alert:   name,
+				Queue:   queueName,
+				Firing:  firing,
+				Value:   value,
+				Message: fmt.Sprintf("queue %s: ", queueName) + fmt.Sprintf(format, args...),
+				Time:    stats.collected,
+			})
+		}
+
+		backlog := stat.ReadyCount + stat.UnackedCount()
+		if alerter.rules.BacklogGrowing > 0 {
+			growing := stats.collected.Sub(state.growingSince)
+			switch {
+			case !state.firing[AlertBacklogGrowing] && backlog > 0 && growing >= alerter.rules.BacklogGrowing:
+				state.peakBacklog = backlog
+				alert(AlertBacklogGrowing, true, float64(backlog), "backlog of %d didn't shrink for %s", backlog, growing.Round(time.Second))
+			case state.firing[AlertBacklogGrowing]:
+				if backlog > state.peakBacklog {
+					state.peakBacklog = backlog
+				}
+				if float64(backlog) <= float64(state.peakBacklog)*(1-alerter.rules.Hysteresis) {
+					alert(AlertBacklogGrowing, false, float64(backlog), "backlog shrank to %d", backlog)
+				}
+			}
+		}
+		if backlog == 0 || backlog < state.backlog || state.firing[AlertBacklogGrowing] {
+			state.growingSince = stats.collected
+		}
+		state.backlog = backlog
+
+		if previousStat == nil || elapsed <= 0 {
+			continue // rates need two evaluations
+		}
+
+		if alerter.rules.AckRateDrop > 0 && stat.FetchedCount >= previousStat.FetchedCount { // not reset in between
+			rate := float64(stat.FetchedCount-previousStat.FetchedCount) / elapsed
+			threshold := state.ackBaseline * (1 - alerter.rules.AckRateDrop)
+			switch {
+			case !state.firing[AlertAckRateDropped] && backlog > 0 && rate < threshold:
+				alert(AlertAckRateDropped, true, rate, "ack rate dropped to %.1f/s from %.1f/s", rate, state.ackBaseline)
+			case state.firing[AlertAckRateDropped]:
+				if backlog == 0 || rate >= threshold*(1+alerter.rules.Hysteresis) {
+					alert(AlertAckRateDropped, false, rate, "ack rate recovered to %.1f/s", rate)
+				}
+			case backlog > 0: // only a backlog shows what the consumers can do
+				if state.ackBaseline == 0 {
+					state.ackBaseline = rate
+				} else {
+					state.ackBaseline += alertBaselineWeight * (rate - state.ackBaseline)
+				}
+			}
+		}
+
+		if alerter.rules.RejectedRate > 0 {
+			rate := 0.0
+			if stat.RejectedCount > previousStat.RejectedCount {
+				rate = float64(stat.RejectedCount-previousStat.RejectedCount) / elapsed
+			}
+			switch {
+			case !state.firing[AlertRejectedRate] && rate > alerter.rules.RejectedRate:
+				alert(AlertRejectedRate, true, rate, "%.1f deliveries/s got rejected", rate)
+			case state.firing[AlertRejectedRate] && rate <= alerter.rules.RejectedRate*(1-alerter.rules.Hysteresis):
+				alert(AlertRejectedRate, false, rate, "rejected rate dropped to %.1f/s", rate)
+			}
+		}
+	}
+
+	alerter.previous = &stats
+	return alerts
+}
+
+// NewWebhookAlertFunc returns an AlertFunc which posts the alerts as JSON to
+// the given URL. Failed requests get reported as *WebhookError on errChan
+// without blocking, errChan may be nil.
+func NewWebhookAlertFunc(url string, errChan chan<- error) AlertFunc {
+	client := &http.Client{Timeout: alertWebhookTimeout}
+	return func(alert Alert) {
+		if err := postAlert(client, url, alert); err != nil {
+			select { // try to add error to channel, but don't block
+			case errChan <- &WebhookError{URL: url, Alert: alert, Err: err}:
+			default:
+			}
+		}
+	}
+}
+
+func postAlert(client *http.Client, url string, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	response, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package rmq
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlerter(t *testing.T) {
+	start := time.Now()
+	snapshot := func(minutes int, ready, rejected, fetched int64) Stats {
+		stats := NewStats()
+		stats.collected = start.Add(time.Duration(minutes) * time.Minute)
+		stat := NewQueueStat(ready, rejected)
+		stat.FetchedCount = fetched
+		stats.QueueStats["alert-q"] = stat
+		return stats
+	}
+	type fired struct {
+		alert  string
+		firing bool
+	}
+	evaluate := func(alerter *Alerter, stats Stats) []fired {
+		var alerts []fired
+		for _, alert := range alerter.evaluate(stats) {
+			assert.Equal(t, "alert-q", alert.Queue)
+			alerts = append(alerts, fired{alert.Alert, alert.Firing})
+		}
+		return alerts
+	}
+
+	t.Run("backlog growing", func(t *testing.T) {
+		alerter := NewAlerter(nil, AlertRules{BacklogGrowing: 3 * time.Minute}, nil)
+		assert.Empty(t, evaluate(alerter, snapshot(0, 10, 0, 0)))
+		assert.Empty(t, evaluate(alerter, snapshot(1, 20, 0, 0)))
+		assert.Empty(t, evaluate(alerter, snapshot(2, 15, 0, 0))) // shrank
+		assert.Empty(t, evaluate(alerter, snapshot(4, 100, 0, 0)))
+		assert.Equal(t, []fired{{AlertBacklogGrowing, true}}, evaluate(alerter, snapshot(5, 100, 0, 0)))
+		assert.Empty(t, evaluate(alerter, snapshot(6, 200, 0, 0)))
+		assert.Empty(t, evaluate(alerter, snapshot(7, 190, 0, 0))) // within hysteresis of the peak
+		assert.Equal(t, []fired{{AlertBacklogGrowing, false}}, evaluate(alerter, snapshot(8, 180, 0, 0)))
+		assert.Empty(t, evaluate(alerter, snapshot(10, 180, 0, 0)))
+	})
+
+	t.Run("ack rate dropped", func(t *testing.T) {
+		alerter := NewAlerter(nil, AlertRules{AckRateDrop: 0.5}, nil)
+		assert.Empty(t, evaluate(alerter, snapshot(0, 100, 0, 0)))
+		assert.Empty(t, evaluate(alerter, snapshot(1, 100, 0, 600))) // 10/s
+		assert.Empty(t, evaluate(alerter, snapshot(2, 100, 0, 1200)))
+		assert.Empty(t, evaluate(alerter, snapshot(3, 100, 0, 1560)))                                       // 6/s
+		assert.Equal(t, []fired{{AlertAckRateDropped, true}}, evaluate(alerter, snapshot(4, 100, 0, 1800))) // 4/s
+		assert.Empty(t, evaluate(alerter, snapshot(5, 100, 0, 2100)))                                       // 5/s, within hysteresis
+		assert.Equal(t, []fired{{AlertAckRateDropped, false}}, evaluate(alerter, snapshot(6, 100, 0, 2700)))
+		assert.Empty(t, evaluate(alerter, snapshot(7, 0, 0, 2700))) // no backlog, nothing to consume
+	})
+
+	t.Run("rejected rate", func(t *testing.T) {
+		alerter := NewAlerter(nil, AlertRules{RejectedRate: 1, Hysteresis: 0.5}, nil)
+		assert.Empty(t, evaluate(alerter, snapshot(0, 0, 0, 0)))
+		assert.Empty(t, evaluate(alerter, snapshot(1, 0, 60, 0)))                                      // 1/s
+		assert.Equal(t, []fired{{AlertRejectedRate, true}}, evaluate(alerter, snapshot(2, 0, 180, 0))) // 2/s
+		assert.Empty(t, evaluate(alerter, snapshot(3, 0, 222, 0)))                                     // 0.7/s
+		assert.Equal(t, []fired{{AlertRejectedRate, false}}, evaluate(alerter, snapshot(4, 0, 0, 0)))  // purged
+		assert.Equal(t, []fired{{AlertRejectedRate, true}}, evaluate(alerter, snapshot(5, 0, 1000, 0)))
+	})
+}
+
+func TestAlerterEvaluate(t *testing.T) {
+	connection, err := OpenConnection("alert-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("alert-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+	require.NoError(t, queue.Publish("a1"))
+
+	var alerts []Alert
+	alerter := NewAlerter(connection, AlertRules{BacklogGrowing: time.Nanosecond}, func(alert Alert) {
+		if alert.Queue == "alert-q" {
+			alerts = append(alerts, alert)
+		}
+	})
+	assert.NoError(t, alerter.Evaluate())
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, alerter.Evaluate())
+	require.Len(t, alerts, 1)
+	assert.Equal(t, AlertBacklogGrowing, alerts[0].Alert)
+	assert.True(t, alerts[0].Firing)
+	assert.Equal(t, float64(1), alerts[0].Value)
+
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, alerter.Evaluate())
+	require.Len(t, alerts, 2)
+	assert.False(t, alerts[1].Firing)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestWebhookAlertFunc(t *testing.T) {
+	posted := make(chan Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var alert Alert
+		assert.NoError(t, json.NewDecoder(request.Body).Decode(&alert))
+		posted <- alert
+		if alert.Queue == "broken-q" {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	errChan := make(chan error, 1)
+	notify := NewWebhookAlertFunc(server.URL, errChan)
+	alert := Alert{Alert: AlertRejectedRate, Queue: "webhook-q", Firing: true, Value: 2, Time: time.Now().UTC().Truncate(time.Second)}
+	notify(alert)
+	assert.Equal(t, alert, <-posted)
+	assert.Empty(t, errChan)
+
+	notify(Alert{Alert: AlertRejectedRate, Queue: "broken-q"})
+	<-posted
+	err := <-errChan
+	var webhookErr *WebhookError
+	require.True(t, errors.As(err, &webhookErr))
+	assert.Equal(t, "broken-q", webhookErr.Alert.Queue)
+	assert.Equal(t, "unexpected status 500 Internal Server Error", webhookErr.Err.Error())
+}
@@ -0,0 +1,32 @@
+package rmq
+
+// Backend is the storage rmq keeps its queues in. Queues, deliveries,
+// connections and the cleaner only use the operations of this interface, so
+// any store which implements them with the semantics described below can
+// replace redis, see OpenConnectionWithBackend().
+//
+// The operations are modeled after the redis commands of the same name:
+//   - Keys hold strings, lists, sets or sorted sets. Operations on keys which
+//     don't exist behave as on empty values, Get() and LIndex() return
+//     ErrorNotFound instead.
+//   - Lists are ordered left (head) to right (tail). LPush() with several
+//     values inserts them one after the other, so the last value ends up
+//     leftmost. RPopLPush() returns ErrorNotFound if source is empty.
+//   - The compound operations (ExpireIfEqual, RPopLPushUnless, LPopRPush,
+//     LPopRPushUnless, LPushAllExpire, RPushAll, LRemLPush, LRemLPushNX,
+//     ZAddLimit, LRemZAdd, ZPopRPush) must be atomic: no other client may observe or modify the
+//     keys in between.
+//   - Subscribe() must deliver the messages passed to Publish() on the same
+//     channel after it returned, but may drop messages while a subscriber
+//     is slow.
+//
+// RedisWrapper implements Backend on top of redis and TestRedisClient in
+// memory. Use testsupport.RunBackendConformance() to test other
+// implementations.
+type Backend = RedisClient
+
+// OpenConnectionWithBackend opens and returns a new connection which keeps
+// its queues in the given backend
+func OpenConnectionWithBackend(tag string, backend Backend, errChan chan<- error) (Connection, error) {
+	return OpenConnectionWithOptions(tag, backend, errChan, ProductionOptions)
+}
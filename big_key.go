@@ -0,0 +1,81 @@
+package rmq
+
+import (
+	"sort"
+)
+
+// checkBigKey checks via redisClient whether pushing count deliveries to the
+// list at key makes it longer than Options.BigKeyLimit. If so it reports a
+// *BigKeyError to errChan once, until the list got short enough again, or
+// returns it with Options.RefuseBigKeys. Errors getting the length of the list
+// are ignored, publishing runs into them anyway.
+func (queue *redisQueue) checkBigKey(redisClient RedisClient, key string, count int) error {
+	limit := queue.options.BigKeyLimit
+	if limit <= 0 {
+		return nil
+	}
+	length, err := redisClient.LLen(key)
+	if err != nil {
+		return nil
+	}
+
+	if length+int64(count) <= limit {
+		queue.bigKeyWarned.Delete(key)
+		return nil
+	}
+	bigKeyErr := &BigKeyError{Queue: queue.name, Key: key, Length: length + int64(count), Limit: limit, Refused: queue.options.RefuseBigKeys}
+	if bigKeyErr.Refused {
+		return bigKeyErr
+	}
+	if _, warned := queue.bigKeyWarned.LoadOrStore(key, true); warned {
+		return nil
+	}
+	queue.options.logf(LogInfo, "rmq queue %s: %s", queue, bigKeyErr)
+	select { // try to add error to channel, but don't block
+	case queue.errChan <- bigKeyErr:
+	default:
+	}
+	return nil
+}
+
+// bigKeys returns the lists of the queue which hold more deliveries than
+// Options.BigKeyLimit according to the given stat, in sorted order
+func (queue *redisQueue) bigKeys(stat QueueStat) []string {
+	limit := queue.options.BigKeyLimit
+	if limit <= 0 {
+		return nil
+	}
+
+	readyCount := stat.ReadyCount // of the queue's own ready list
+	for _, count := range stat.Priorities {
+		readyCount -= count
+	}
+	for _, count := range stat.Tenants {
+		readyCount -= count
+	}
+
+	var keys []string
+	if readyCount > limit {
+		keys = append(keys, queue.readyKey)
+	}
+	if stat.RejectedCount > limit {
+		keys = append(keys, queue.rejectedKey)
+	}
+	for class, count := range stat.RejectedClasses {
+		if count > limit {
+			keys = append(keys, queueRejectedClassKey(queue.name, class))
+		}
+	}
+	for tenant, count := range stat.Tenants {
+		if count > limit {
+			keys = append(keys, queueTenantKey(queue.name, tenant))
+		}
+	}
+	for priority, count := range stat.Priorities {
+		if count > limit {
+			keys = append(keys, queuePriorityKey(queue.name, priority))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
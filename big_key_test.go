@@ -0,0 +1,76 @@
+package rmq
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openBigKeyQueue(t *testing.T, refuse bool, errChan chan<- error) (Connection, Queue) {
+	options := TestOptions
+	options.BigKeyLimit = 3
+	options.RefuseBigKeys = refuse
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	connection, err := OpenConnectionWithOptions("big-key-conn", redisClient, errChan, options)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("big-key-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+	return connection, queue
+}
+
+func TestBigKeyWarning(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, queue := openBigKeyQueue(t, false, errChan)
+
+	assert.NoError(t, queue.Publish("big-key-d1", "big-key-d2", "big-key-d3"))
+	assert.Len(t, errChan, 0)
+
+	// published anyway, reported once
+	assert.NoError(t, queue.Publish("big-key-d4"))
+	assert.NoError(t, queue.Publish("big-key-d5"))
+	require.Len(t, errChan, 1)
+	bigKeyErr, ok := (<-errChan).(*BigKeyError)
+	require.True(t, ok)
+	assert.Equal(t, BigKeyError{Queue: "big-key-q", Key: "rmq::queue::[big-key-q]::ready", Length: 4, Limit: 3}, *bigKeyErr)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+
+	stats, err := CollectStats([]string{"big-key-q"}, connection)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rmq::queue::[big-key-q]::ready"}, stats.QueueStats["big-key-q"].BigKeys)
+
+	// reported again once the list got short enough in between
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("big-key-d6"))
+	assert.NoError(t, queue.Publish("big-key-d7", "big-key-d8", "big-key-d9"))
+	assert.Len(t, errChan, 1)
+
+	stats, err = CollectStats([]string{"big-key-q"}, connection)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rmq::queue::[big-key-q]::ready"}, stats.QueueStats["big-key-q"].BigKeys)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestBigKeyRefused(t *testing.T) {
+	connection, queue := openBigKeyQueue(t, true, nil)
+
+	assert.NoError(t, queue.Publish("big-key-d1", "big-key-d2"))
+	err := queue.Publish("big-key-d3", "big-key-d4")
+	require.IsType(t, &BigKeyError{}, err)
+	assert.True(t, err.(*BigKeyError).Refused)
+	assert.Equal(t, "rmq.BigKeyError: list rmq::queue::[big-key-q]::ready of queue big-key-q would hold 4 deliveries, more than the limit of 3 (refused: true), consider sharding the queue", err.Error())
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	stats, err := CollectStats([]string{"big-key-q"}, connection)
+	assert.NoError(t, err)
+	assert.Nil(t, stats.QueueStats["big-key-q"].BigKeys)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,125 @@
+package rmq
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CapabilityError gets returned when opening a connection with
+// Options.CheckCapabilities if redis refused any of the commands rmq needs
+type CapabilityError struct {
+	Missing []*CommandError // one per refused RedisClient operation
+}
+
+func (e *CapabilityError) Error() string {
+	features := map[string][]string{} // by command
+	for _, missing := range e.Missing {
+		if !containsString(features[missing.Command], missing.Feature) {
+			features[missing.Command] = append(features[missing.Command], missing.Feature)
+		}
+	}
+
+	commands := make([]string, 0, len(features))
+	for command, feature := range features {
+		commands = append(commands, fmt.Sprintf("%s (%s)", command, strings.Join(feature, ", ")))
+	}
+	sort.Strings(commands)
+	return fmt.Sprintf("rmq.CapabilityError: redis refused commands rmq needs: %s", strings.Join(commands, ", "))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCapabilities runs each operation of the client rmq uses once on probe
+// keys of the given connection and returns a CapabilityError listing the
+// ones which failed, see Options.CheckCapabilities
+func checkCapabilities(client RedisClient, connectionName string) error {
+	key := strings.Replace(connectionProbeTemplate, phConnection, connectionName, 1)
+	counter, once, nx := key+"::counter", key+"::once", key+"::nx"
+	list, moved, set, zset := key+"::list", key+"::moved", key+"::set", key+"::zset"
+	ignoreNotFound := func(err error) error {
+		if err == ErrorNotFound {
+			return nil
+		}
+		return err
+	}
+
+	probes := []struct {
+		method string
+		probe  func() error
+	}{
+		{"Set", func() error { return client.Set(key, "1", time.Minute) }},
+		{"SetNX", func() error { _, err := client.SetNX(once, "1", time.Minute); return err }},
+		{"ExpireIfEqual", func() error { _, err := client.ExpireIfEqual(once, "1", time.Minute); return err }},
+		{"Get", func() error { _, err := client.Get(key); return ignoreNotFound(err) }},
+		{"TTL", func() error { _, err := client.TTL(key); return err }},
+		{"IncrBy", func() error { _, err := client.IncrBy(counter, 1); return err }},
+		{"LPush", func() error { _, err := client.LPush(list, "a", "b"); return err }},
+		{"RPush", func() error { _, err := client.RPush(list, "c"); return err }},
+		{"LLen", func() error { _, err := client.LLen(list); return err }},
+		{"LLens", func() error { _, err := client.LLens(list, moved); return err }},
+		{"LIndex", func() error { _, err := client.LIndex(list, -1); return ignoreNotFound(err) }},
+		{"LRange", func() error { _, err := client.LRange(list, 0, -1); return err }},
+		{"LRem", func() error { _, err := client.LRem(list, 1, "c"); return err }},
+		{"LTrim", func() error { return client.LTrim(list, 0, -1) }},
+		{"RPopLPush", func() error { _, err := client.RPopLPush(list, list); return ignoreNotFound(err) }},
+		{"RPopLPushUnless", func() error { _, _, err := client.RPopLPushUnless(list, list, moved); return ignoreNotFound(err) }},
+		{"LPopRPush", func() error { _, err := client.LPopRPush(list, list); return ignoreNotFound(err) }},
+		{"LPopRPushUnless", func() error { _, _, err := client.LPopRPushUnless(list, list, moved); return ignoreNotFound(err) }},
+		{"LPushAllExpire", func() error { _, err := client.LPushAllExpire(list, moved, time.Minute); return err }},
+		{"RPushAll", func() error { _, err := client.RPushAll(moved, list); return err }},
+		{"LRemLPush", func() error { _, err := client.LRemLPush(list, "a", list, "a"); return err }},
+		{"LRemLPushNX", func() error { _, _, err := client.LRemLPushNX(list, "a", list, "a", nx, time.Minute); return err }},
+		{"SAdd", func() error { _, err := client.SAdd(set, "a"); return err }},
+		{"SMembers", func() error { _, err := client.SMembers(set); return err }},
+		{"SScan", func() error { _, _, err := client.SScan(set, 0, 10); return err }},
+		{"SRem", func() error { _, err := client.SRem(set, "a"); return err }},
+		{"ZAddLimit", func() error { _, err := client.ZAddLimit(zset, "xa", 1, 0, 10); return err }},
+		{"ZAdd", func() error { _, err := client.ZAdd(zset, "xb", 2); return err }},
+		{"ZRem", func() error { _, err := client.ZRem(zset, "xb"); return err }},
+		{"ZCard", func() error { _, err := client.ZCard(zset); return err }},
+		{"LRemZAdd", func() error { _, err := client.LRemZAdd(list, "b", zset, "xb", 2); return err }},
+		{"ZPopRPush", func() error { _, err := client.ZPopRPush(zset, 2, 10, 1, list); return err }},
+		{"Publish", func() error { return client.Publish(key, "probe") }},
+		{"Subscribe", func() error {
+			_, unsubscribe, err := client.Subscribe(key)
+			if err != nil {
+				return err
+			}
+			return unsubscribe()
+		}},
+		{"Del", func() error {
+			for _, probeKey := range []string{key, counter, once, nx, list, moved, set, zset} {
+				if _, err := client.Del(probeKey); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+
+	var missing []*CommandError
+	for _, probe := range probes {
+		err := probe.probe()
+		if err == nil {
+			continue
+		}
+		commandErr, ok := err.(*CommandError)
+		if !ok { // like errors of Lua scripts calling a refused command
+			commandErr = &CommandError{Command: wrapperCommands[probe.method].command, Feature: wrapperCommands[probe.method].feature, RedisErr: err}
+		}
+		missing = append(missing, commandErr)
+	}
+	if len(missing) > 0 {
+		return &CapabilityError{Missing: missing}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package rmq
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCapabilities(t *testing.T) {
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	options := TestOptions
+	options.CheckCapabilities = true
+	connection, err := OpenConnectionWithOptions("capabilities-conn", NewRedisWrapper(redisClient), nil, options)
+	require.NoError(t, err)
+
+	// the probe keys got deleted
+	key := strings.Replace(connectionProbeTemplate, phConnection, connection.(*redisConnection).Name, 1)
+	for _, suffix := range []string{"", "::list", "::set", "::zset"} {
+		exists, err := redisClient.Exists(unusedContext, key+suffix).Result()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), exists)
+	}
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+// refusingClient refuses ZADD and fails the script of LRemLPush()
+type refusingClient struct {
+	RedisClient
+}
+
+func (refusingClient) ZAdd(key, member string, score float64) (int64, error) {
+	return 0, &CommandError{Command: "ZADD", Feature: "publishing delayed deliveries", RedisErr: errors.New("NOPERM")}
+}
+
+func (refusingClient) LRemLPush(removeKey, value, pushKey, pushValue string) (int64, error) {
+	return 0, errors.New("ERR Error running script: The user executing the script can't run this command")
+}
+
+func TestCheckCapabilitiesRefused(t *testing.T) {
+	options := TestOptions
+	options.CheckCapabilities = true
+	_, err := OpenConnectionWithOptions("capabilities-conn", refusingClient{NewTestRedisClient()}, nil, options)
+	require.IsType(t, &CapabilityError{}, err)
+	missing := err.(*CapabilityError).Missing
+	require.Len(t, missing, 2)
+	assert.Equal(t, "EVALSHA", missing[0].Command)
+	assert.Equal(t, "rejecting and handing off deliveries", missing[0].Feature)
+	assert.Equal(t, "ZADD", missing[1].Command)
+	assert.Equal(t, "rmq.CapabilityError: redis refused commands rmq needs: EVALSHA (rejecting and handing off deliveries), ZADD (publishing delayed deliveries)", err.Error())
+
+	options.CheckCapabilities = false
+	connection, err := OpenConnectionWithOptions("capabilities-conn", refusingClient{NewTestRedisClient()}, nil, options)
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
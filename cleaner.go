@@ -1,6 +1,15 @@
 package rmq
 
-import "math"
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// errorConnectionAlive is returned while cleaning a connection whose
+// heartbeat came back, see redisQueue.moveCleaned()
+var errorConnectionAlive = errors.New("connection is alive again")
 
 type Cleaner struct {
 	connection Connection
@@ -10,30 +19,79 @@ func NewCleaner(connection Connection) *Cleaner {
 	return &Cleaner{connection: connection}
 }
 
+// CleanerStat describes the runs of all cleaners sharing the redis instance,
+// so you can alert if no cleaner succeeded for a while. See Stats.CleanerStat
+// and QueueStat.CleanedCount for the returned deliveries per queue.
+type CleanerStat struct {
+	Runs            int64         `json:"runs"`
+	Failures        int64         `json:"failures"`        // runs which returned an error
+	DeadConnections int64         `json:"deadConnections"` // dead connections which got cleaned
+	LastDuration    time.Duration `json:"lastDuration"`    // duration of the latest run
+	LastSuccess     time.Time     `json:"lastSuccess"`     // zero if no run succeeded yet
+}
+
+// cleanerRun describes a single run of Clean() to be recorded in redis
+type cleanerRun struct {
+	dead     int64 // number of dead connections cleaned
+	duration time.Duration
+	finished time.Time
+	failed   bool
+}
+
 // Clean cleans the connection of the cleaner. This is useful to make sure no
 // deliveries get lost. The main use case is if your consumers get restarted
 // there will be unacked deliveries assigned to the connection. Once the
 // heartbeat of that connection dies the cleaner can recognize that and remove
 // those unacked deliveries back to the ready list. If there was no error it
 // returns the number of deliveries which have been returned from unacked lists
-// to ready lists across all cleaned connections and queues.
+// to ready lists across all cleaned connections and queues. Each run gets
+// recorded in redis, see CleanerStat.
 func (cleaner *Cleaner) Clean() (returned int64, err error) {
+	return cleaner.CleanContext(context.Background())
+}
+
+// CleanContext cleans like Clean(), but stops before cleaning the next
+// connection once the context is done. In that case it returns the context's
+// error along with the number of deliveries returned until then, and the run
+// gets recorded as failed.
+func (cleaner *Cleaner) CleanContext(ctx context.Context) (returned int64, err error) {
+	started := time.Now()
+	run := cleanerRun{}
+	returned, err = cleaner.clean(ctx, &run)
+	run.finished = time.Now()
+	run.duration = run.finished.Sub(started)
+	run.failed = err != nil
+
+	if recordErr := cleaner.connection.recordCleanerRun(run); recordErr != nil && err == nil {
+		return 0, recordErr
+	}
+	return returned, err
+}
+
+func (cleaner *Cleaner) clean(ctx context.Context, run *cleanerRun) (returned int64, err error) {
 	connectionNames, err := cleaner.connection.getConnections()
 	if err != nil {
 		return 0, err
 	}
 
 	for _, connectionName := range connectionNames {
+		if err := ctx.Err(); err != nil {
+			return returned, err
+		}
 		hijackedConnection := cleaner.connection.hijackConnection(connectionName)
 		switch err := hijackedConnection.checkHeartbeat(); err {
 		case nil: // active connection
 			continue
 		case ErrorNotFound:
 			n, err := cleanStaleConnection(hijackedConnection)
-			if err != nil {
+			returned += n
+			switch err {
+			case nil:
+				run.dead++
+			case errorConnectionAlive: // leave the rest to the connection
+			default:
 				return 0, err
 			}
-			returned += n
 		default:
 			return 0, err
 		}
@@ -55,11 +113,13 @@ func cleanStaleConnection(staleConnection Connection) (returned int64, err error
 		}
 
 		n, err := cleanQueue(queue)
+		returned += n
+		if err == errorConnectionAlive {
+			return returned, err
+		}
 		if err != nil {
 			return 0, err
 		}
-
-		returned += n
 	}
 
 	if err := staleConnection.closeStaleConnection(); err != nil {
@@ -71,13 +131,75 @@ func cleanStaleConnection(staleConnection Connection) (returned int64, err error
 }
 
 func cleanQueue(queue Queue) (returned int64, err error) {
-	returned, err = queue.ReturnUnacked(math.MaxInt64)
-	if err != nil {
+	returned, err = queue.returnCleaned()
+	if err == nil {
+		var handedOff int64
+		handedOff, err = queue.returnHandoff()
+		returned += handedOff
+	}
+	if err != nil && err != errorConnectionAlive {
 		return 0, err
 	}
+	if returned > 0 {
+		if err := queue.countCleaned(returned); err != nil {
+			return 0, err
+		}
+	}
+	if err == errorConnectionAlive { // keep the queue of the connection
+		return returned, err
+	}
 	if err := queue.closeInStaleConnection(); err != nil {
 		return 0, err
 	}
 	// log.Printf("rmq cleaner cleaned queue %s %d", queue, returned)
 	return returned, nil
 }
+
+// recordCleanerRun adds the given run of Clean() to the CleanerStat in redis
+func (connection *redisConnection) recordCleanerRun(run cleanerRun) error {
+	if _, err := connection.redisClient.IncrBy(cleanerRunsKey, 1); err != nil {
+		return err
+	}
+	if run.dead > 0 {
+		if _, err := connection.redisClient.IncrBy(cleanerDeadKey, run.dead); err != nil {
+			return err
+		}
+	}
+	duration := strconv.FormatInt(int64(run.duration), 10)
+	if err := connection.redisClient.Set(cleanerDurationKey, duration, 0); err != nil {
+		return err
+	}
+
+	if run.failed {
+		_, err := connection.redisClient.IncrBy(cleanerFailuresKey, 1)
+		return err
+	}
+	success := strconv.FormatInt(run.finished.UnixNano(), 10)
+	return connection.redisClient.Set(cleanerSuccessKey, success, 0)
+}
+
+// cleanerStat reads the CleanerStat recorded by recordCleanerRun()
+func (connection *redisConnection) cleanerStat() (stat CleanerStat, err error) {
+	values := map[string]int64{}
+	for _, key := range []string{cleanerRunsKey, cleanerFailuresKey, cleanerDeadKey, cleanerDurationKey, cleanerSuccessKey} {
+		value, err := connection.redisClient.Get(key)
+		if err == ErrorNotFound {
+			continue
+		}
+		if err != nil {
+			return stat, err
+		}
+		if values[key], err = strconv.ParseInt(value, 10, 64); err != nil {
+			return stat, err
+		}
+	}
+
+	stat.Runs = values[cleanerRunsKey]
+	stat.Failures = values[cleanerFailuresKey]
+	stat.DeadConnections = values[cleanerDeadKey]
+	stat.LastDuration = time.Duration(values[cleanerDurationKey])
+	if success, ok := values[cleanerSuccessKey]; ok {
+		stat.LastSuccess = time.Unix(0, success)
+	}
+	return stat, nil
+}
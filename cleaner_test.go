@@ -219,3 +219,96 @@ func TestCleaner(t *testing.T) {
 	assert.Equal(t, int64(0), returned)
 	assert.NoError(t, cleanerConn.stopHeartbeat())
 }
+
+func TestCleanerStats(t *testing.T) {
+	flushConn, err := OpenConnection("cleaner-flush", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, flushConn.stopHeartbeat())
+	assert.NoError(t, flushConn.flushDb())
+
+	cleanerConn, err := OpenConnection("cleaner-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	stats, err := cleanerConn.CollectStats(nil)
+	require.NoError(t, err)
+	assert.Equal(t, CleanerStat{}, stats.CleanerStat)
+
+	conn, err := OpenConnection("cleaner-conn1", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := conn.OpenQueue("stats-q1")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, queue.Publish("del"))
+	}
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	<-queue.StopConsuming()
+	assert.NoError(t, conn.stopHeartbeat())
+
+	before := time.Now()
+	cleaner := NewCleaner(cleanerConn)
+	returned, err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), returned)
+	_, err = cleaner.Clean()
+	assert.NoError(t, err)
+
+	stats, err = cleanerConn.CollectStats([]string{"stats-q1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.CleanerStat.Runs)
+	assert.Equal(t, int64(0), stats.CleanerStat.Failures)
+	assert.Equal(t, int64(1), stats.CleanerStat.DeadConnections)
+	assert.True(t, stats.CleanerStat.LastDuration > 0)
+	assert.False(t, stats.CleanerStat.LastSuccess.Before(before))
+	assert.Equal(t, int64(3), stats.QueueStats["stats-q1"].CleanedCount)
+
+	// destroying the queue resets its count
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	count, err = queue.cleanedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, cleanerConn.stopHeartbeat())
+}
+
+func TestCleanerRevivedConnection(t *testing.T) {
+	cleanerConn, err := OpenConnection("revived-cleaner-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	conn, err := OpenConnection("revived-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := conn.OpenQueue("revived-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("revived-d1", "revived-d2", "revived-d3"))
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	<-queue.StopConsuming()
+	assert.NoError(t, conn.stopHeartbeat())
+
+	// the heartbeat comes back after the cleaner considered the connection dead
+	redisConn := conn.(*redisConnection)
+	assert.NoError(t, redisConn.updateHeartbeat())
+	returned, err := cleanStaleConnection(cleanerConn.hijackConnection(redisConn.Name))
+	assert.Equal(t, errorConnectionAlive, err)
+	assert.Equal(t, int64(0), returned)
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	connectionNames, err := cleanerConn.getConnections()
+	assert.NoError(t, err)
+	assert.Contains(t, connectionNames, redisConn.Name)
+
+	_, err = redisConn.redisClient.Del(redisConn.heartbeatKey)
+	assert.NoError(t, err)
+	returned, err = NewCleaner(cleanerConn).Clean()
+	assert.NoError(t, err)
+	assert.True(t, returned >= 3)
+	count, err = queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.NoError(t, cleanerConn.stopHeartbeat())
+}
@@ -0,0 +1,76 @@
+package rmq
+
+// Transform transforms a payload along with its header, for example to
+// compress or encrypt it or to wrap it in an envelope
+type Transform func(header Header, payload string) (Header, string, error)
+
+// Codec is a pair of transforms applied to deliveries when they get published
+// and consumed. Decode must undo Encode. Either can be nil, like for codecs
+// which only add headers on publish.
+type Codec struct {
+	Encode Transform
+	Decode Transform
+}
+
+// WithCodecs makes Publish() encode each payload with the given codecs in
+// order and consumers decode deliveries with them in reverse order. This way
+// producers and consumers share one list of codecs, so they can't disagree on
+// how payloads are stored. Publish() returns the first error of an Encode.
+// Deliveries which fail to decode get rejected without being consumed and
+// reported as DecodeError. Codecs also apply to AckAndPublish() when
+// publishing to this queue. Peeked deliveries don't get decoded.
+func WithCodecs(codecs ...Codec) QueueOption {
+	return func(queue *redisQueue) {
+		queue.codecs = append(queue.codecs, codecs...)
+	}
+}
+
+// encodeCodecs encodes each payload with the header using the queue's codecs
+func (queue *redisQueue) encodeCodecs(header Header, payload []string) ([]string, error) {
+	encoded := make([]string, len(payload))
+	for i, p := range payload {
+		h := make(Header, len(header)) // codecs may modify it
+		for key, value := range header {
+			h[key] = value
+		}
+
+		for _, codec := range queue.codecs {
+			if codec.Encode == nil {
+				continue
+			}
+			var err error
+			if h, p, err = codec.Encode(h, p); err != nil {
+				return nil, err
+			}
+		}
+		encoded[i] = encodeHeader(h, p)
+	}
+	return encoded, nil
+}
+
+// decode decodes the delivery using the queue's codecs. Deliveries which
+// fail to decode get rejected. Returns whether the delivery got decoded.
+func (queue *redisQueue) decode(delivery *redisDelivery) bool {
+	if _, ok := delivery.header.Marker(); ok {
+		return true // markers don't get encoded, see PublishMarker()
+	}
+	header, body := delivery.header, delivery.body
+	for i := len(queue.codecs) - 1; i >= 0; i-- {
+		decode := queue.codecs[i].Decode
+		if decode == nil {
+			continue
+		}
+		var err error
+		if header, body, err = decode(header, body); err != nil {
+			select { // try to add error to channel, but don't block
+			case queue.errChan <- &DecodeError{Delivery: delivery, Err: err}:
+			default:
+			}
+			delivery.reject() // won't decode when retried, redis errors get reported by reject() itself
+			return false
+		}
+	}
+
+	delivery.header, delivery.body = header, body
+	return true
+}
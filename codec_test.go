@@ -0,0 +1,80 @@
+package rmq
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var base64Codec = Codec{
+	Encode: func(header Header, payload string) (Header, string, error) {
+		return header, base64.StdEncoding.EncodeToString([]byte(payload)), nil
+	},
+	Decode: func(header Header, payload string) (Header, string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(payload)
+		return header, string(decoded), err
+	},
+}
+
+func TestCodecs(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("codec-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+	envelope := Codec{Encode: func(header Header, payload string) (Header, string, error) {
+		header["envelope"] = "v1"
+		return header, payload, nil
+	}}
+	queue, err := connection.OpenQueue("codec-q", WithCodecs(envelope, base64Codec))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.PublishWithHeader(Header{"key": "value"}, "codec-d1"))
+	messages, err := queue.PeekReady(1) // stored encoded
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{Header: Header{"key": "value", "envelope": "v1"}, Payload: "Y29kZWMtZDE="}}, messages)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	delivery, err := queue.ConsumeOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "codec-d1", delivery.Payload())
+	assert.Equal(t, Header{"key": "value", "envelope": "v1"}, delivery.Header())
+	assert.NoError(t, delivery.Ack())
+
+	// undecodable deliveries get rejected
+	plainQueue, err := connection.OpenQueue("codec-q")
+	assert.NoError(t, err)
+	assert.NoError(t, plainQueue.Publish("not base64!"))
+	assert.NoError(t, queue.Publish("codec-d2"))
+	delivery, err = queue.ConsumeOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "codec-d2", delivery.Payload())
+	assert.NoError(t, delivery.Ack())
+	decodeErr := &DecodeError{}
+	require.True(t, errors.As(<-errChan, &decodeErr))
+	assert.Equal(t, "not base64!", decodeErr.Delivery.Payload())
+	count, err := queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// encode errors get returned
+	failing := Codec{Encode: func(header Header, payload string) (Header, string, error) {
+		return header, payload, errors.New("nope")
+	}}
+	failingQueue, err := connection.OpenQueue("codec-q", WithCodecs(failing))
+	assert.NoError(t, err)
+	assert.EqualError(t, failingQueue.Publish("codec-d3"), "nope")
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
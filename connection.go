@@ -1,31 +1,36 @@
 package rmq
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/adjust/rmq/v4/internal/goroutines"
 	"github.com/go-redis/redis/v8"
 )
 
+// default values of ProductionOptions, see NOTE in Options
 const (
-	// NOTE: Be careful when changing any of these values.
-	// Currently we update the heartbeat every second with a TTL of a minute.
-	// This means that if we fail to update the heartbeat 60 times in a row
-	// the connection might get cleaned up by a cleaner. So we want to set the
-	// error limit to a value lower like this (like 45) to make sure we stop
-	// all consuming before that happens.
 	heartbeatDuration   = time.Minute // TTL of heartbeat key
 	heartbeatInterval   = time.Second // how often we update the heartbeat key
 	HeartbeatErrorLimit = 45          // stop consuming after this many heartbeat errors
+	scanCount           = 100         // members to request per SSCAN call
 )
 
 // Connection is an interface that can be used to test publishing
 type Connection interface {
-	OpenQueue(name string) (Queue, error)
+	OpenQueue(name string, options ...QueueOption) (Queue, error)
 	CollectStats(queueList []string) (Stats, error)
 	GetOpenQueues() ([]string, error)
 	StopAllConsuming() <-chan struct{}
+	StopAllConsumingContext(ctx context.Context) error
+	ReturnAllUnacked() (int64, error)
+	InspectConnection(name string) (ConnectionInspection, error)
+	ShutdownConnection(name string) error
+	Close() error
 
 	// internals
 	// used in cleaner
@@ -34,8 +39,11 @@ type Connection interface {
 	hijackConnection(name string) Connection
 	closeStaleConnection() error
 	getConsumingQueues() ([]string, error)
+	recordCleanerRun(run cleanerRun) error
 	// used for stats
 	openQueue(name string) Queue
+	cleanerStat() (CleanerStat, error)
+	queueCounts(queueNames []string) (readyCounts, rejectedCounts []int64, err error)
 	// used in tests
 	stopHeartbeat() error
 	flushDb() error
@@ -47,14 +55,18 @@ type Connection interface {
 type redisConnection struct {
 	Name          string
 	heartbeatKey  string // key to keep alive
+	shutdownKey   string // key to flag set by ShutdownConnection()
 	queuesKey     string // key to list of queues consumed by this connection
 	redisClient   RedisClient
 	errChan       chan<- error
 	heartbeatStop chan chan struct{}
+	options       Options
+	queueEvents   *queueEventHub // shared by the subscribers of SubscribeQueueEvents()
 
 	// list of all queues that have been opened in this connection
 	// this is used to handle heartbeat errors without relying on the redis connection
-	openQueues []Queue
+	openQueues   []Queue
+	openQueuesMu sync.Mutex // guards openQueues
 }
 
 // OpenConnection opens and returns a new connection
@@ -65,7 +77,7 @@ func OpenConnection(tag string, network string, address string, db int, errChan
 
 // OpenConnectionWithRedisClient opens and returns a new connection
 func OpenConnectionWithRedisClient(tag string, redisClient *redis.Client, errChan chan<- error) (Connection, error) {
-	return OpenConnectionWithRmqRedisClient(tag, RedisWrapper{redisClient}, errChan)
+	return OpenConnectionWithRmqRedisClient(tag, NewRedisWrapper(redisClient), errChan)
 }
 
 // OpenConnectionWithTestRedisClient opens and returns a new connection which
@@ -77,46 +89,91 @@ func OpenConnectionWithTestRedisClient(tag string, errChan chan<- error) (Connec
 // If you would like to use a redis client other than the ones supported in the constructors above, you can implement
 // the RedisClient interface yourself
 func OpenConnectionWithRmqRedisClient(tag string, redisClient RedisClient, errChan chan<- error) (Connection, error) {
+	return OpenConnectionWithOptions(tag, redisClient, errChan, ProductionOptions)
+}
+
+// OpenConnectionWithOptions opens and returns a new connection configured by
+// the given options. Unset options fall back to the values of
+// ProductionOptions. See ProfileOptions() for predefined options.
+func OpenConnectionWithOptions(tag string, redisClient RedisClient, errChan chan<- error, options Options) (Connection, error) {
 	name := fmt.Sprintf("%s-%s", tag, RandomString(6))
+	options = options.withDefaults()
+	if options.MaxConcurrency > 0 {
+		options.concurrency = newSlots(options.MaxConcurrency)
+	}
+	if options.ConnectionBudget > 0 {
+		options.polls = newSlots(options.ConnectionBudget)
+	}
+	if wrapper, ok := redisClient.(RedisWrapper); ok && options.UseFunctions {
+		wrapper, err := wrapper.withFunctions()
+		if err != nil {
+			return nil, err
+		}
+		if wrapper.library == "" {
+			options.logf(LogInfo, "rmq connection %s evaluating scripts, redis doesn't support functions", name)
+		}
+		redisClient = wrapper
+	}
+	if options.RestrictedCommands {
+		redisClient = restrictedClient{redisClient}
+	}
 
 	connection := &redisConnection{
 		Name:          name,
 		heartbeatKey:  strings.Replace(connectionHeartbeatTemplate, phConnection, name, 1),
+		shutdownKey:   strings.Replace(connectionShutdownTemplate, phConnection, name, 1),
 		queuesKey:     strings.Replace(connectionQueuesTemplate, phConnection, name, 1),
 		redisClient:   redisClient,
 		errChan:       errChan,
 		heartbeatStop: make(chan chan struct{}, 1),
+		options:       options,
+		queueEvents:   &queueEventHub{},
 	}
 
 	if err := connection.updateHeartbeat(); err != nil { // checks the connection
 		return nil, err
 	}
+	if options.CheckCapabilities {
+		if err := checkCapabilities(redisClient, name); err != nil {
+			return nil, err
+		}
+	}
 
 	// add to connection set after setting heartbeat to avoid race with cleaner
 	if _, err := redisClient.SAdd(connectionsKey, name); err != nil {
 		return nil, err
 	}
 
-	go connection.heartbeat(errChan)
-	// log.Printf("rmq connection connected to %s %s:%s %d", name, network, address, db)
+	goroutines.Go("heartbeat", func() { connection.heartbeat(errChan) })
+	connection.options.logf(LogDebug, "rmq connection connected %s", name)
 	return connection, nil
 }
 
 func (connection *redisConnection) updateHeartbeat() error {
-	return connection.redisClient.Set(connection.heartbeatKey, "1", heartbeatDuration)
+	return connection.redisClient.Set(connection.heartbeatKey, "1", connection.options.HeartbeatDuration)
 }
 
 // heartbeat keeps the heartbeat key alive
 func (connection *redisConnection) heartbeat(errChan chan<- error) {
 	errorCount := 0 // number of consecutive errors
 
-	ticker := time.NewTicker(heartbeatInterval)
+	ticker := time.NewTicker(connection.options.HeartbeatInterval)
 	defer ticker.Stop()
 
+	var done <-chan struct{} // nil if no context is set, see Options.Context
+	if connection.options.Context != nil {
+		done = connection.options.Context.Done()
+	}
+
 	for {
 		select {
 		case <-ticker.C:
 			// continue below
+		case <-done:
+			connection.options.logf(LogInfo, "rmq connection %s context done, stopping all consuming", connection)
+			connection.StopAllConsuming()
+			done = nil // keep the heartbeat alive until the connection gets closed
+			continue
 		case c := <-connection.heartbeatStop:
 			close(c)
 			return
@@ -125,14 +182,16 @@ func (connection *redisConnection) heartbeat(errChan chan<- error) {
 		err := connection.updateHeartbeat()
 		if err == nil { // success
 			errorCount = 0
+			connection.checkShutdown()
 			continue
 		}
 		// unexpected redis error
 
 		errorCount++
 
-		if errorCount >= HeartbeatErrorLimit {
+		if errorCount >= connection.options.HeartbeatErrorLimit {
 			// reached error limit
+			connection.options.logf(LogInfo, "rmq connection %s reached heartbeat error limit, stopping all consuming: %s", connection, err)
 			connection.StopAllConsuming()
 			// Clients reading from errChan need to see this error
 			// This allows them to shut themselves down
@@ -149,22 +208,139 @@ func (connection *redisConnection) heartbeat(errChan chan<- error) {
 	}
 }
 
+// checkShutdown evicts this connection's consumption if ShutdownConnection()
+// got called for it. Redis errors get ignored, the next heartbeat checks again.
+func (connection *redisConnection) checkShutdown() {
+	if count, err := connection.redisClient.Del(connection.shutdownKey); err != nil || count == 0 {
+		return
+	}
+
+	connection.options.logf(LogInfo, "rmq connection got shut down, stopping all consuming %s", connection)
+	select { // try to add error to channel, but don't block
+	case connection.errChan <- ErrorShutdown:
+	default:
+	}
+
+	goroutines.Go("return unacked", func() {
+		if _, err := connection.ReturnAllUnacked(); err != nil {
+			select { // try to add error to channel, but don't block
+			case connection.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+			default:
+			}
+		}
+	})
+}
+
+// ShutdownConnection asks the connection with the given name, which can be
+// any connection, to stop consuming. The connection notices within a
+// heartbeat interval and then behaves as if ReturnAllUnacked() got called on
+// it: consuming stops gracefully and its unacked deliveries get returned to
+// ready. The connection also sends ErrorShutdown to its error channel, so its
+// process can react, like exiting. This way operators can evict a misbehaving
+// worker without access to its host. Returns ErrorNotFound if the connection
+// isn't alive.
+func (connection *redisConnection) ShutdownConnection(name string) error {
+	if err := connection.hijackConnection(name).checkHeartbeat(); err != nil {
+		return err
+	}
+	shutdownKey := strings.Replace(connectionShutdownTemplate, phConnection, name, 1)
+	return connection.redisClient.Set(shutdownKey, "1", connection.options.HeartbeatDuration)
+}
+
 func (connection *redisConnection) String() string {
 	return connection.Name
 }
 
 // OpenQueue opens and returns the queue with a given name
-func (connection *redisConnection) OpenQueue(name string) (Queue, error) {
-	if _, err := connection.redisClient.SAdd(queuesKey, name); err != nil {
+// the given options get applied in order, after the connection's QueueOptions
+func (connection *redisConnection) OpenQueue(name string, options ...QueueOption) (Queue, error) {
+	queue := connection.openQueue(name)
+	for _, option := range connection.options.QueueOptions {
+		option(queue.(*redisQueue))
+	}
+	for _, option := range options {
+		option(queue.(*redisQueue))
+	}
+	if err := queue.(*redisQueue).loadStream(); err != nil {
+		return nil, err
+	}
+	if err := queue.(*redisQueue).checkOptions(); err != nil {
+		return nil, err
+	}
+	if err := connection.checkConnectionBudget(queue); err != nil {
 		return nil, err
 	}
 
-	queue := connection.openQueue(name)
-	connection.openQueues = append(connection.openQueues, queue)
+	if _, err := connection.redisClient.SAdd(queuesKey, name); err != nil {
+		return nil, err
+	}
+	if err := queue.(*redisQueue).registerStream(); err != nil {
+		return nil, err
+	}
+	if err := publishQueueEvent(connection.redisClient, QueueEvent{Event: QueueOpened, Queue: name, Connection: connection.Name}); err != nil {
+		return nil, err
+	}
+	connection.addOpenQueue(queue)
 
 	return queue, nil
 }
 
+// checkConnectionBudget reports a *ConnectionBudgetError if opening the queue
+// makes the connection have more open queues than Options.ConnectionBudget,
+// or returns it with Options.RefuseOverBudget
+func (connection *redisConnection) checkConnectionBudget(queue Queue) error {
+	budget := connection.options.ConnectionBudget
+	if budget <= 0 {
+		return nil
+	}
+	connection.openQueuesMu.Lock()
+	openQueues := 1
+	for _, open := range connection.openQueues {
+		if !replacesOpenQueue(queue, open) {
+			openQueues++
+		}
+	}
+	connection.openQueuesMu.Unlock()
+	if openQueues <= budget {
+		return nil
+	}
+
+	budgetErr := &ConnectionBudgetError{Connection: connection.Name, Queue: queue.(*redisQueue).name, Queues: openQueues, Budget: budget, Refused: connection.options.RefuseOverBudget}
+	if budgetErr.Refused {
+		return budgetErr
+	}
+	connection.options.logf(LogInfo, "rmq connection %s: %s", connection, budgetErr)
+	select { // try to add error to channel, but don't block
+	case connection.errChan <- budgetErr:
+	default:
+	}
+	return nil
+}
+
+// addOpenQueue adds the queue to the open queues, replacing handles of the
+// same queue which stopped consuming, like after a PatternConsumer forgot a
+// destroyed queue and discovered it again
+func (connection *redisConnection) addOpenQueue(queue Queue) {
+	connection.openQueuesMu.Lock()
+	defer connection.openQueuesMu.Unlock()
+
+	openQueues := connection.openQueues[:0]
+	for _, open := range connection.openQueues {
+		if replacesOpenQueue(queue, open) {
+			continue
+		}
+		openQueues = append(openQueues, open)
+	}
+	connection.openQueues = append(openQueues, queue)
+}
+
+// replacesOpenQueue returns whether the queue replaces the open one, see
+// addOpenQueue()
+func replacesOpenQueue(queue, open Queue) bool {
+	stale, ok := open.(*redisQueue)
+	return ok && stale.name == queue.(*redisQueue).name && stale.stoppedConsuming()
+}
+
 // CollectStats collects and returns stats
 func (connection *redisConnection) CollectStats(queueList []string) (Stats, error) {
 	return CollectStats(queueList, connection)
@@ -172,37 +348,114 @@ func (connection *redisConnection) CollectStats(queueList []string) (Stats, erro
 
 // GetOpenQueues returns a list of all open queues
 func (connection *redisConnection) GetOpenQueues() ([]string, error) {
-	return connection.redisClient.SMembers(queuesKey)
+	return connection.scanSet(queuesKey)
 }
 
 // StopAllConsuming stops consuming on all queues opened in this connection.
 // It returns a channel which can be used to wait for all active consumers to
 // finish their current Consume() call. This is useful to implement graceful
-// shutdown.
+// shutdown. It also stops flushing the spools of the queues, see WithSpool().
 func (connection *redisConnection) StopAllConsuming() <-chan struct{} {
+	connection.openQueuesMu.Lock()
+	openQueues := append([]Queue(nil), connection.openQueues...)
+	connection.openQueuesMu.Unlock()
+
 	finishedChan := make(chan struct{})
-	if len(connection.openQueues) == 0 {
+	if len(openQueues) == 0 {
 		close(finishedChan) // nothing to do
 		return finishedChan
 	}
 
-	chans := make([]<-chan struct{}, 0, len(connection.openQueues))
-	for _, queue := range connection.openQueues {
+	chans := make([]<-chan struct{}, 0, len(openQueues))
+	for _, queue := range openQueues {
 		chans = append(chans, queue.StopConsuming())
 	}
 
-	go func() {
+	goroutines.Go("stop", func() {
 		// wait for all channels to be closed
 		for _, c := range chans {
 			<-c
 		}
+		for _, queue := range openQueues {
+			if queue, ok := queue.(*redisQueue); ok && queue.spool != nil {
+				queue.stopSpool() // see WithSpool()
+			}
+		}
 		close(finishedChan)
-		// log.Printf("rmq connection stopped consuming %s", queue)
-	}()
+		connection.options.logf(LogDebug, "rmq connection stopped consuming %s", connection)
+	})
 
 	return finishedChan
 }
 
+// StopAllConsumingContext stops consuming like StopAllConsuming() and waits
+// for all active consumers to finish their current Consume() call. Returns
+// the context's error if it's done before they finished.
+func (connection *redisConnection) StopAllConsumingContext(ctx context.Context) error {
+	select {
+	case <-connection.StopAllConsuming():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops consuming on all queues opened in this connection, waits for all
+// active consumers to finish their current Consume() call and then stops the
+// heartbeat. Afterwards the cleaner returns the deliveries the connection left
+// unacked to their ready lists. The connection must not be used anymore.
+func (connection *redisConnection) Close() error {
+	<-connection.StopAllConsuming()
+	return connection.stopHeartbeat()
+}
+
+// ReturnAllUnacked stops consuming on all queues opened in this connection,
+// waits for all active consumers to finish their current Consume() call and
+// then returns all unacked and handed off deliveries of this connection to
+// the ready lists of their queues (see Queue.SetRedeliveryOrder()), where
+// other connections consume them.
+// Unlike the cleaner this works while the connection is alive, for example to
+// move in-flight work away from a degraded instance without killing it. The
+// queues don't resume consuming afterwards. Returns the number of returned
+// deliveries.
+func (connection *redisConnection) ReturnAllUnacked() (int64, error) {
+	<-connection.StopAllConsuming()
+
+	queueNames, err := connection.getConsumingQueues()
+	if err != nil {
+		return 0, err
+	}
+
+	total := int64(0)
+	for _, queueName := range queueNames {
+		queue := connection.openQueue(queueName).(*redisQueue)
+		if err := queue.loadStream(); err != nil {
+			return total, err
+		}
+		if queue.streams != nil {
+			count, err := queue.returnStream(math.MaxInt64, "")
+			total += count
+			if err != nil {
+				return total, err
+			}
+			continue
+		}
+		order, err := queue.RedeliveryOrder()
+		if err != nil {
+			return total, err
+		}
+		for _, key := range []string{queue.unackedKey, queue.handoffKey} {
+			count, err := queue.redeliver(key, math.MaxInt64, order)
+			total += count
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	connection.options.logf(LogInfo, "rmq connection returned all unacked %s %d", connection, total)
+	return total, nil
+}
+
 // checkHeartbeat retuns true if the connection is currently active in terms of heartbeat
 func (connection *redisConnection) checkHeartbeat() error {
 	heartbeatKey := strings.Replace(connectionHeartbeatTemplate, phConnection, connection.Name, 1)
@@ -218,7 +471,61 @@ func (connection *redisConnection) checkHeartbeat() error {
 
 // getConnections returns a list of all open connections
 func (connection *redisConnection) getConnections() ([]string, error) {
-	return connection.redisClient.SMembers(connectionsKey)
+	return connection.scanSet(connectionsKey)
+}
+
+// scanSet returns all members of the set at key, see scanMembers()
+func (connection *redisConnection) scanSet(key string) ([]string, error) {
+	return scanMembers(connection.redisClient, key)
+}
+
+// scanMembers returns all members of the set at key. Unlike SMEMBERS it uses
+// SSCAN, so iterating over large sets doesn't block redis
+func scanMembers(redisClient RedisClient, key string) ([]string, error) {
+	var members []string
+	seen := map[string]struct{}{}
+	var cursor uint64
+	for {
+		batch, next, err := redisClient.SScan(key, cursor, scanCount)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range batch {
+			if _, ok := seen[member]; ok {
+				continue // SSCAN may return members more than once
+			}
+			seen[member] = struct{}{}
+			members = append(members, member)
+		}
+		if next == 0 {
+			return members, nil
+		}
+		cursor = next
+	}
+}
+
+// queueCounts returns the ready and rejected counts of the given queues in
+// the given order, using a single pipelined round trip
+func (connection *redisConnection) queueCounts(queueNames []string) (readyCounts, rejectedCounts []int64, err error) {
+	keys := make([]string, 0, 2*len(queueNames))
+	for _, queueName := range queueNames {
+		keys = append(keys,
+			strings.Replace(queueReadyTemplate, phQueue, queueName, 1),
+			strings.Replace(queueRejectedTemplate, phQueue, queueName, 1),
+		)
+	}
+	lengths, err := connection.redisClient.LLens(keys...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	readyCounts = make([]int64, len(queueNames))
+	rejectedCounts = make([]int64, len(queueNames))
+	for i := range queueNames {
+		readyCounts[i] = lengths[2*i]
+		rejectedCounts[i] = lengths[2*i+1]
+	}
+	return readyCounts, rejectedCounts, nil
 }
 
 // hijackConnection reopens an existing connection for inspection purposes without starting a heartbeat
@@ -228,6 +535,8 @@ func (connection *redisConnection) hijackConnection(name string) Connection {
 		heartbeatKey: strings.Replace(connectionHeartbeatTemplate, phConnection, name, 1),
 		queuesKey:    strings.Replace(connectionQueuesTemplate, phConnection, name, 1),
 		redisClient:  connection.redisClient,
+		options:      connection.options,
+		queueEvents:  connection.queueEvents,
 	}
 }
 
@@ -263,6 +572,7 @@ func (connection *redisConnection) openQueue(name string) Queue {
 		connection.queuesKey,
 		connection.redisClient,
 		connection.errChan,
+		connection.options,
 	)
 }
 
@@ -0,0 +1,209 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishContext(t *testing.T) {
+	connection, err := OpenConnection("context-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("context-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.PublishContext(context.Background(), "context-d1"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, queue.PublishContext(ctx, "context-d2"))
+
+	ready, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	if assert.Len(t, ready, 1) {
+		assert.Equal(t, "context-d1", ready[0].Payload)
+	}
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+// slowPushHook lets LPUSH commands wait until their context is done
+type slowPushHook struct{}
+
+func (slowPushHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "lpush" && ctx.Done() != nil {
+		<-ctx.Done()
+		return ctx, ctx.Err()
+	}
+	return ctx, nil
+}
+
+func (slowPushHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (slowPushHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (slowPushHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestPublishContextTimeout(t *testing.T) {
+	rawClient := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	rawClient.AddHook(slowPushHook{})
+	connection, err := OpenConnectionWithOptions("context-timeout-conn", NewRedisWrapper(rawClient), nil, TestOptions)
+	require.NoError(t, err)
+	fallback := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 2}))
+	queue, err := connection.OpenQueue("context-timeout-q", WithFallback(fallback))
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = fallback.Del(queue.(*redisQueue).readyKey)
+	assert.NoError(t, err)
+
+	// the context gets passed to redis, the delivery doesn't get published
+	// after the timeout nor to the fallback
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, queue.PublishContext(ctx, "context-timeout-d1"))
+	time.Sleep(10 * time.Millisecond)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = fallback.LLen(queue.(*redisQueue).readyKey)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.NoError(t, queue.Publish("context-timeout-d2"))
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestStopConsumingContext(t *testing.T) {
+	connection, err := OpenConnection("context-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("context-stop-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	consuming, release := make(chan struct{}), make(chan struct{})
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumerFunc("context-cons", func(delivery Delivery) {
+		close(consuming)
+		<-release
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("context-d1"))
+	<-consuming
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, queue.StopConsumingContext(ctx))
+	close(release)
+	assert.NoError(t, queue.StopConsumingContext(context.Background()))
+	assert.NoError(t, connection.StopAllConsumingContext(context.Background()))
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestConnectionContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	options := TestOptions
+	options.Context = ctx
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	connection, err := OpenConnectionWithOptions("context-conn", redisClient, nil, options)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("context-connection-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	// the consume loop waits for an hour between polls of the empty queue
+	assert.NoError(t, queue.StartConsuming(10, time.Hour))
+	_, err = queue.AddConsumerFunc("context-cons", func(Delivery) {})
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-queue.(*redisQueue).consumingStopped:
+	case <-time.After(time.Second):
+		t.Fatal("consuming didn't stop")
+	}
+	select {
+	case <-queue.StopConsuming():
+	case <-time.After(time.Second):
+		t.Fatal("consumers didn't finish")
+	}
+
+	// heartbeat keeps running
+	assert.NoError(t, connection.checkHeartbeat())
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestConnectionContextWhileStopping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	options := TestOptions
+	options.Context = ctx
+	options.HeartbeatInterval = time.Millisecond
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	connection, err := OpenConnectionWithOptions("context-stopping-conn", redisClient, nil, options)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("context-stopping-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	consuming, release := make(chan struct{}), make(chan struct{})
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumerFunc("context-stopping-cons", func(delivery Delivery) {
+		close(consuming)
+		<-release
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("context-stopping-d1"))
+	<-consuming
+
+	// the heartbeat stops consuming while the application does
+	stopping := make(chan (<-chan struct{}))
+	go func() { stopping <- connection.StopAllConsuming() }()
+	cancel()
+	finished := <-stopping
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-finished:
+		t.Fatal("finished before the consumer")
+	default:
+	}
+
+	close(release)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("consumers didn't finish")
+	}
+	assert.NoError(t, connection.checkHeartbeat())
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestCleanContext(t *testing.T) {
+	connection, err := OpenConnection("context-cleaner-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	cleaner := NewCleaner(connection)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	returned, err := cleaner.CleanContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, int64(0), returned)
+
+	_, err = cleaner.CleanContext(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,129 @@
+package rmq
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// delayed deliveries are stored in a sorted set with a random token of this
+	// length in front of them, so identical deliveries can be delayed at once
+	delayedTokenLength = 8
+	// max number of due delayed deliveries ConsumeOne() returns to ready at once
+	promoteBatchSize = 100
+)
+
+// delay moves the delivery from unacked to the delayed deliveries of its
+// queue, from where consumers return it to ready once the delay passed. If
+// countAttempt is set the attempts in its header get incremented.
+func (delivery *redisDelivery) delay(delay time.Duration, countAttempt bool) error {
+	if delivery.restoreKey != "" {
+		return delivery.restore()
+	}
+	if delivery.streams != nil {
+		return ErrorStreamMixed
+	}
+	delivery.setHandled()
+	payload := delivery.payload
+	if countAttempt {
+		payload = withAttempt(payload)
+	}
+	payload, _ = addBreadcrumb(payload, TrailDelayed, delivery.consumedBy)
+	member := RandomString(delayedTokenLength) + payload
+	score := float64(time.Now().Add(delay).UnixNano() / int64(time.Millisecond))
+	delayedKey := delivery.delayedKey
+	if priority := delivery.header.Priority(); priority != 0 {
+		delayedKey = queueDelayedKey(delivery.queueName, priority) // keeps its priority
+	}
+
+	return delivery.retry(func() (int64, error) {
+		return delivery.redisClient.LRemZAdd(delivery.unackedKey, delivery.payload, delayedKey, member, score)
+	})
+}
+
+// PublishDelayed adds deliveries to the queue which only become ready once
+// the delay passed, see PublishAt()
+func (queue *redisQueue) PublishDelayed(delay time.Duration, payload ...string) error {
+	return queue.PublishAt(time.Now().Add(delay), payload...)
+}
+
+// PublishAt adds deliveries to the queue which only become ready at the
+// given time. Until then they are stored with the deliveries delayed via
+// RetryAfter(), from where consumers of the queue (or a Janitor) return them
+// to the front of ready, so they get consumed next. Like markers they bypass
+// the frozen policy, the spool and the fallback.
+func (queue *redisQueue) PublishAt(at time.Time, payload ...string) error {
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+	payload, err := queue.encode(nil, payload)
+	if err != nil {
+		return err
+	}
+
+	score := float64(at.UnixNano() / int64(time.Millisecond))
+	for _, p := range payload {
+		if _, err := queue.redisClient.ZAdd(queue.delayedKey, RandomString(delayedTokenLength)+p, score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promoteDelayed returns up to max delayed deliveries per priority whose
+// delay passed to the front of the ready list of their priority, so they get
+// consumed next. It checks at most once per
+// interval, even if called concurrently by consumers and ConsumeOne().
+func (queue *redisQueue) promoteDelayed(interval time.Duration, max int64) error {
+	now := time.Now()
+	promoted := atomic.LoadInt64(&queue.delayedPromoted)
+	if now.UnixNano()-promoted < int64(interval) {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt64(&queue.delayedPromoted, promoted, now.UnixNano()) {
+		return nil // promoted by someone else meanwhile
+	}
+
+	delayedKeys, readyKeys, err := queue.delayedLists()
+	if err != nil {
+		return err
+	}
+	maxScore := float64(now.UnixNano() / int64(time.Millisecond))
+	count := int64(0)
+	for i, delayedKey := range delayedKeys {
+		n, err := queue.redisClient.ZPopRPush(delayedKey, maxScore, max, delayedTokenLength, readyKeys[i])
+		count += n
+		if err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		queue.options.logf(LogDebug, "rmq queue returned %d delayed deliveries %s", count, queue)
+	}
+	return nil
+}
+
+// promoteDue returns all delayed deliveries which are due at now to the
+// front of the ready list of their priority and returns how many it
+// returned, see Janitor
+func (queue *redisQueue) promoteDue(now time.Time) (promoted int64, err error) {
+	const chunkSize = 100
+	delayedKeys, readyKeys, err := queue.delayedLists()
+	if err != nil {
+		return 0, err
+	}
+	maxScore := float64(now.UnixNano() / int64(time.Millisecond))
+	for i, delayedKey := range delayedKeys {
+		for {
+			n, err := queue.redisClient.ZPopRPush(delayedKey, maxScore, chunkSize, delayedTokenLength, readyKeys[i])
+			promoted += n
+			if err != nil {
+				return promoted, err
+			}
+			if n < chunkSize {
+				break
+			}
+		}
+	}
+	return promoted, nil
+}
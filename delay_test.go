@@ -0,0 +1,78 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishDelayed(t *testing.T) {
+	connection, err := OpenConnection("delay-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("delay-q")
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.NoError(t, err)
+	queue, err = connection.OpenQueue("delay-q")
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("ready1"))
+	assert.NoError(t, queue.PublishDelayed(time.Hour, "later"))
+	assert.NoError(t, queue.PublishAt(time.Now().Add(-time.Second), "due1", "due1"))
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+
+	promoted, err := NewJanitor(connection).PromoteDelayed()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), promoted)
+
+	messages, err = queue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 3)
+	assert.Equal(t, "due1", messages[0].Payload) // due ones get consumed next
+	assert.Equal(t, "due1", messages[1].Payload)
+	assert.Equal(t, "ready1", messages[2].Payload)
+
+	promoted, err = NewJanitor(connection).PromoteDelayed()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), promoted) // "later" isn't due yet
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestConsumeOneDelayed(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	connection, err := OpenConnectionWithOptions("delay-conn", redisClient, nil, TestOptions)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("delay-pull-q")
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.True(t, err == nil || err == ErrorNotFound)
+	queue, err = connection.OpenQueue("delay-pull-q")
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.PublishDelayed(20*time.Millisecond, "due-soon"))
+	empty, err := queue.(*redisQueue).isEmpty()
+	assert.NoError(t, err)
+	assert.False(t, empty) // delayed deliveries count
+
+	// pull consumers promote due deliveries without a janitor
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	delivery, err := queue.ConsumeOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "due-soon", delivery.Payload())
+	assert.NoError(t, delivery.Ack())
+	assert.NoError(t, queue.WaitUntilEmpty(ctx))
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -2,26 +2,65 @@ package rmq
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Delivery interface {
 	Payload() string
+	Header() Header
+	Context() context.Context
 
 	Ack() error
 	Reject() error
+	RejectAs(class string) error
 	Push() error
+	AckAndPublish(queue Queue, payload string) error
+
+	Checkpoint(state []byte) error
+	LastCheckpoint() ([]byte, error)
 }
 
+// checkpoints expire if they don't get refreshed or removed by Ack() in time,
+// for example because the delivery got rejected and purged
+const checkpointExpiration = 7 * 24 * time.Hour
+
 type redisDelivery struct {
-	ctx         context.Context
-	payload     string
-	unackedKey  string
-	rejectedKey string
-	pushKey     string
-	redisClient RedisClient
-	errChan     chan<- error
+	ctx           context.Context
+	payload       string // as stored in redis, including the encoded header
+	header        Header
+	body          string // payload without header
+	unackedKey    string
+	rejectedKey   string
+	pushKey       string
+	checkpointKey string // queueCheckpointTemplate with the queue filled in, see stateKey()
+	delayedKey    string
+	consumedBy    string // name of the consuming connection
+	redisClient   RedisClient
+	errChan       chan<- error
+	retryInterval time.Duration
+	handledFlag   int32           // set once Ack(), Reject() or Push() got called
+	checkpointed  int32           // set once the delivery's checkpoint got written or read
+	handlerMu     sync.Mutex      // protects handlerCtx and handlerCancel
+	handlerCtx    context.Context // see Context(), nil until requested
+	handlerCancel context.CancelFunc
+	release       func() // called once the delivery got handled, see WithSemaphore()
+	dispatched    func() // called once the delivery got handled, see WithDispatchPolicy()
+	errorPolicy   *ErrorPolicy
+	retryPolicy   *RetryPolicy
+	lostAcksKey   string // counts acks which found the delivery gone, see Ack()
+	queueName     string
+	classesKey    string        // key to set of error classes, empty if rejected deliveries go to a dead letter queue
+	restoreKey    string        // key to ready list if the delivery gets restored instead of handled, see WithDryRun()
+	streams       StreamBackend // nil unless the delivery got read from the queue's stream, see WithStream()
+	streamKey     string
+	streamID      string // ID of the delivery's pending entry in the stream
+	pushStream    bool   // whether the push queue got opened with WithStream(), Push() returns ErrorStreamMixed then
 }
 
 func newDelivery(
@@ -30,26 +69,70 @@ func newDelivery(
 	unackedKey string,
 	rejectedKey string,
 	pushKey string,
+	checkpointKey string,
+	delayedKey string,
+	consumedBy string,
 	redisClient RedisClient,
 	errChan chan<- error,
+	retryInterval time.Duration,
 ) *redisDelivery {
+	header, body := decodeHeader(payload)
 	return &redisDelivery{
-		ctx:         ctx,
-		payload:     payload,
-		unackedKey:  unackedKey,
-		rejectedKey: rejectedKey,
-		pushKey:     pushKey,
-		redisClient: redisClient,
-		errChan:     errChan,
+		ctx:           ctx,
+		payload:       payload,
+		header:        header,
+		body:          body,
+		unackedKey:    unackedKey,
+		rejectedKey:   rejectedKey,
+		pushKey:       pushKey,
+		checkpointKey: checkpointKey,
+		delayedKey:    delayedKey,
+		consumedBy:    consumedBy,
+		redisClient:   redisClient,
+		errChan:       errChan,
+		retryInterval: retryInterval,
 	}
 }
 
+// String mentions the delivery's ID rather than its payload, so logging it
+// doesn't expose data Options.Redact would hide
 func (delivery *redisDelivery) String() string {
-	return fmt.Sprintf("[%s %s]", delivery.payload, delivery.unackedKey)
+	return fmt.Sprintf("[%s %s]", deliveryID(delivery.payload), delivery.unackedKey)
 }
 
 func (delivery *redisDelivery) Payload() string {
-	return delivery.payload
+	return delivery.body
+}
+
+// Header returns the header the delivery got published with, nil if none
+func (delivery *redisDelivery) Header() Header {
+	return delivery.header
+}
+
+// Context returns a context for handling the delivery. It gets canceled once
+// Ack(), Reject() or Push() got called or the deadline from the header passed
+// (see Header.SetDeadline()).
+func (delivery *redisDelivery) Context() context.Context {
+	delivery.handlerMu.Lock()
+	defer delivery.handlerMu.Unlock()
+
+	if delivery.handlerCtx == nil {
+		if deadline, ok := delivery.header.Deadline(); ok {
+			delivery.handlerCtx, delivery.handlerCancel = context.WithDeadline(context.Background(), deadline)
+		} else {
+			delivery.handlerCtx, delivery.handlerCancel = context.WithCancel(context.Background())
+		}
+		if delivery.handled() {
+			delivery.handlerCancel()
+		}
+	}
+	return delivery.handlerCtx
+}
+
+// expired returns whether the deadline from the header passed
+func (delivery *redisDelivery) expired() bool {
+	deadline, ok := delivery.header.Deadline()
+	return ok && !time.Now().Before(deadline)
 }
 
 // blocking versions of the functions below with the following behavior:
@@ -57,10 +140,159 @@ func (delivery *redisDelivery) Payload() string {
 // 2. in case of other redis errors, send them to the errors chan and retry after a sleep
 // 3. if redis errors occur after StopConsuming() has been called, ErrorConsumingStopped will be returned
 
+// Ack acks the delivery and removes its checkpoint, if any. Returns
+// ErrorNotFound if the delivery wasn't unacked anymore, which usually means a
+// cleaner returned it and it got delivered again. Those acks get counted,
+// see QueueStat.LostAckCount.
 func (delivery *redisDelivery) Ack() error {
+	if delivery.restoreKey != "" {
+		return delivery.restore()
+	}
+	delivery.setHandled()
+	if err := delivery.ack(); err != nil {
+		if err == ErrorNotFound {
+			delivery.countLostAck()
+		}
+		return err
+	}
+
+	delivery.removeCheckpoint()
+	return nil
+}
+
+// removeCheckpoint removes the delivery's checkpoint once it's done with this
+// queue, so identical deliveries published later don't resume from it
+func (delivery *redisDelivery) removeCheckpoint() {
+	if atomic.LoadInt32(&delivery.checkpointed) == 0 {
+		return
+	}
+	// the checkpoint expires eventually, no need to retry
+	if _, err := delivery.redisClient.Del(delivery.stateKey()); err != nil {
+		select { // try to add error to channel, but don't block
+		case delivery.errChan <- &DeliveryError{Delivery: delivery, RedisErr: err, Count: 1}:
+		default:
+		}
+	}
+}
+
+// countLostAck counts an ack which found the delivery gone, without retrying
+func (delivery *redisDelivery) countLostAck() {
+	if delivery.lostAcksKey == "" {
+		return
+	}
+	if _, err := delivery.redisClient.IncrBy(delivery.lostAcksKey, 1); err != nil {
+		select { // try to add error to channel, but don't block
+		case delivery.errChan <- &DeliveryError{Delivery: delivery, RedisErr: err, Count: 1}:
+		default:
+		}
+	}
+}
+
+func (delivery *redisDelivery) ack() error {
+	errorCount := 0
+	for {
+		count, err := delivery.remove()
+		if err == nil { // no redis error
+			if count == 0 {
+				return ErrorNotFound
+			}
+			return nil
+		}
+
+		// redis error
+
+		errorCount++
+
+		select { // try to add error to channel, but don't block
+		case delivery.errChan <- &DeliveryError{Delivery: delivery, RedisErr: err, Count: errorCount}:
+		default:
+		}
+
+		if err := delivery.ctx.Err(); err != nil {
+			return ErrorConsumingStopped
+		}
+
+		time.Sleep(delivery.retryInterval)
+	}
+}
+
+// remove removes the delivery from the unacked list, or acks and deletes its
+// pending entry if it got read from the stream
+func (delivery *redisDelivery) remove() (affected int64, err error) {
+	if delivery.streams != nil {
+		return delivery.streams.XAckDel(delivery.streamKey, streamGroup, delivery.streamID)
+	}
+	return delivery.redisClient.LRem(delivery.unackedKey, 1, delivery.payload)
+}
+
+// AckAndPublish acks the delivery and publishes the given payload to the given
+// queue in one atomic operation, so a crash in between can't lose the
+// follow-up delivery. The frozen policy of queue doesn't apply. Returns
+// ErrorNotFound without publishing if the delivery was not unacked anymore.
+// Returns ErrorStreamMixed without acking if queue got opened with
+// WithStream().
+// NOTE: panics if queue is not opened via a redis connection, in a redis
+// cluster both queues must live on the same node
+func (delivery *redisDelivery) AckAndPublish(queue Queue, payload string) error {
+	if delivery.restoreKey != "" {
+		return delivery.restore()
+	}
+	redisQueue := queue.(*redisQueue)
+	if isStreamQueue(redisQueue) {
+		return ErrorStreamMixed
+	}
+	encoded, err := redisQueue.encode(nil, []string{payload})
+	if err != nil {
+		return err
+	}
+	delivery.setHandled()
+
+	if err := delivery.retry(func() (int64, error) {
+		if delivery.streams != nil {
+			return delivery.streams.XAckDelLPush(delivery.streamKey, streamGroup, delivery.streamID, redisQueue.readyKey, encoded[0])
+		}
+		return delivery.redisClient.LRemLPush(delivery.unackedKey, delivery.payload, redisQueue.readyKey, encoded[0])
+	}); err != nil {
+		return err
+	}
+	delivery.removeCheckpoint()
+	return nil
+}
+
+// ackAndPublishOnce is like AckAndPublish(), but only publishes if no
+// delivery with the same idempotency key got published to queue this way
+// within ttl. The idempotency key gets passed on in the published header.
+func (delivery *redisDelivery) ackAndPublishOnce(queue *redisQueue, payload, idempotencyKey string, ttl time.Duration) error {
+	if delivery.restoreKey != "" {
+		return delivery.restore()
+	}
+	if delivery.streams != nil || isStreamQueue(queue) {
+		return ErrorStreamMixed
+	}
+	encoded, err := queue.encode(Header{HeaderIdempotencyKey: idempotencyKey}, []string{payload})
+	if err != nil {
+		return err
+	}
+	delivery.setHandled()
+	onceKey := strings.Replace(queueIdempotencyTemplate, phQueue, queue.name, 1)
+	onceKey = strings.Replace(onceKey, phKey, idempotencyKey, 1)
+
+	if err := delivery.retry(func() (int64, error) {
+		affected, _, err := delivery.redisClient.LRemLPushNX(delivery.unackedKey, delivery.payload, queue.readyKey, encoded[0], onceKey, ttl)
+		return affected, err
+	}); err != nil {
+		return err
+	}
+	delivery.removeCheckpoint()
+	return nil
+}
+
+// retry calls f until it doesn't return a redis error. Returns ErrorNotFound
+// if f didn't affect anything.
+func (delivery *redisDelivery) retry(f func() (affected int64, err error)) error {
 	errorCount := 0
 	for {
-		count, err := delivery.redisClient.LRem(delivery.unackedKey, 1, delivery.payload)
+		count, err := f()
 		if err == nil { // no redis error
 			if count == 0 {
 				return ErrorNotFound
@@ -81,26 +313,66 @@ func (delivery *redisDelivery) Ack() error {
 			return ErrorConsumingStopped
 		}
 
-		time.Sleep(time.Second)
+		time.Sleep(delivery.retryInterval)
 	}
 }
 
+// Reject rejects the delivery. If the queue has a retry policy the delivery
+// gets requeued with a backoff instead until it hit the max retries, see
+// WithRetryPolicy().
 func (delivery *redisDelivery) Reject() error {
-	return delivery.move(delivery.rejectedKey)
+	if policy := delivery.retryPolicy; policy != nil && delivery.header.Attempts() < policy.MaxRetries {
+		return delivery.delay(policy.backoff(delivery.header.Attempts()), true)
+	}
+	return delivery.reject()
 }
 
+// reject moves the delivery to the rejected list (or dead letter queue)
+// without retrying it
+func (delivery *redisDelivery) reject() error {
+	delivery.setHandled()
+	return delivery.move(delivery.rejectedKey, TrailRejected, true)
+}
+
+// Push moves the delivery to the push queue, see Queue.SetPushQueue().
+// Without push queue it gets rejected, skipping the retry policy. Returns
+// ErrorStreamMixed without pushing if the push queue got opened with
+// WithStream().
 func (delivery *redisDelivery) Push() error {
+	if delivery.pushStream {
+		return ErrorStreamMixed
+	}
+	delivery.setHandled()
 	if delivery.pushKey == "" {
-		return delivery.Reject() // fall back to rejecting
+		return delivery.reject() // fall back to rejecting
 	}
 
-	return delivery.move(delivery.pushKey)
+	return delivery.move(delivery.pushKey, TrailPushed, false)
 }
 
-func (delivery *redisDelivery) move(key string) error {
+// move pushes the delivery to the given list and acks it. Deliveries with
+// trail get the given event added to it. Unless keepCheckpoint is set the
+// checkpoint gets removed like by Ack(), for lists of other queues.
+func (delivery *redisDelivery) move(key, event string, keepCheckpoint bool) error {
+	if delivery.restoreKey != "" {
+		return delivery.restore()
+	}
+	payload, _ := addBreadcrumb(delivery.payload, event, delivery.consumedBy)
+	if delivery.streams != nil {
+		// acks and pushes atomically, no need to push first
+		if err := delivery.retry(func() (int64, error) {
+			return delivery.streams.XAckDelLPush(delivery.streamKey, streamGroup, delivery.streamID, key, payload)
+		}); err != nil {
+			return err
+		}
+		if !keepCheckpoint {
+			delivery.removeCheckpoint()
+		}
+		return nil
+	}
 	errorCount := 0
 	for {
-		_, err := delivery.redisClient.LPush(key, delivery.payload)
+		_, err := delivery.redisClient.LPush(key, payload)
 		if err == nil { // success
 			break
 		}
@@ -117,10 +389,110 @@ func (delivery *redisDelivery) move(key string) error {
 			return ErrorConsumingStopped
 		}
 
-		time.Sleep(time.Second)
+		time.Sleep(delivery.retryInterval)
+	}
+
+	if err := delivery.ack(); err != nil {
+		return err
 	}
+	if !keepCheckpoint {
+		delivery.removeCheckpoint()
+	}
+	return nil
+}
+
+// Checkpoint persists the intermediate progress of handling the delivery. If
+// the delivery gets redelivered, because its connection died or it got
+// rejected and returned, LastCheckpoint() returns the latest state, so long
+// running jobs can resume instead of restarting from scratch. The checkpoint
+// is removed by Ack(), AckAndPublish() and Push() and expires after a week
+// otherwise. Deliveries are
+// identified by their payload including the header (except for trail and
+// attempts), so identical deliveries published to the same queue share their
+// checkpoint.
+func (delivery *redisDelivery) Checkpoint(state []byte) error {
+	if delivery.restoreKey != "" {
+		return nil
+	}
+	atomic.StoreInt32(&delivery.checkpointed, 1)
+	return delivery.retry(func() (int64, error) {
+		return 1, delivery.redisClient.Set(delivery.stateKey(), string(state), checkpointExpiration)
+	})
+}
+
+// LastCheckpoint returns the state of the latest Checkpoint() call for this
+// delivery, also if it was made before the delivery got redelivered. Returns
+// ErrorNotFound if there is no checkpoint.
+func (delivery *redisDelivery) LastCheckpoint() ([]byte, error) {
+	errorCount := 0
+	for {
+		state, err := delivery.redisClient.Get(delivery.stateKey())
+		if err == nil {
+			atomic.StoreInt32(&delivery.checkpointed, 1)
+			return []byte(state), nil
+		}
+		if err == ErrorNotFound {
+			return nil, err
+		}
+
+		errorCount++
+
+		select { // try to add error to channel, but don't block
+		case delivery.errChan <- &DeliveryError{Delivery: delivery, RedisErr: err, Count: errorCount}:
+		default:
+		}
+
+		if err := delivery.ctx.Err(); err != nil {
+			return nil, ErrorConsumingStopped
+		}
+
+		time.Sleep(delivery.retryInterval)
+	}
+}
+
+// stateKey returns the key to the delivery's checkpoint, see deliveryID()
+func (delivery *redisDelivery) stateKey() string {
+	return strings.Replace(delivery.checkpointKey, phKey, deliveryID(delivery.payload), 1)
+}
+
+// deliveryID returns the ID of the delivery with the given payload as stored
+// in redis. It's the hex encoded SHA-1 of the payload, without the header
+// fields rmq rewrites on the way (trail and attempts).
+func deliveryID(payload string) string {
+	header, body := decodeHeader(payload)
+	if _, trail := header[HeaderTrail]; trail || header[HeaderAttempts] != "" {
+		stable := make(Header, len(header))
+		for key, value := range header {
+			if key != HeaderTrail && key != HeaderAttempts {
+				stable[key] = value
+			}
+		}
+		payload = encodeHeader(stable, body)
+	}
+	sum := sha1.Sum([]byte(payload))
+	return hex.EncodeToString(sum[:])
+}
+
+func (delivery *redisDelivery) setHandled() {
+	if atomic.CompareAndSwapInt32(&delivery.handledFlag, 0, 1) {
+		if delivery.release != nil {
+			delivery.release()
+		}
+		if delivery.dispatched != nil {
+			delivery.dispatched()
+		}
+	}
+
+	delivery.handlerMu.Lock()
+	defer delivery.handlerMu.Unlock()
+	if delivery.handlerCancel != nil {
+		delivery.handlerCancel()
+	}
+}
 
-	return delivery.Ack()
+// handled returns whether Ack(), Reject() or Push() has been called
+func (delivery *redisDelivery) handled() bool {
+	return atomic.LoadInt32(&delivery.handledFlag) == 1
 }
 
 // lower level functions which don't retry but just return the first error
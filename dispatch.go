@@ -0,0 +1,177 @@
+package rmq
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DispatchPolicy defines which of the idle consumers added on a connection
+// gets the next prefetched delivery, see WithDispatchPolicy()
+type DispatchPolicy int
+
+const (
+	DispatchFirst       DispatchPolicy = iota // whichever consumer takes it first (default)
+	DispatchRoundRobin                        // the consumer which got a delivery least recently
+	DispatchLeastLoaded                       // the consumer with the fewest deliveries in flight, see Queue.InFlight()
+)
+
+// WithDispatchPolicy sets which consumer gets the next prefetched delivery
+// if several consumers are waiting for one. Without it deliveries go to
+// whichever consumer reads first, so the same few consumers can end up
+// getting most deliveries. DispatchLeastLoaded is useful for consumers which
+// ack asynchronously: It prefers the consumers with the fewest deliveries
+// which they got but didn't ack, reject or push yet. Doesn't apply to batch
+// consumers.
+func WithDispatchPolicy(policy DispatchPolicy) QueueOption {
+	return func(queue *redisQueue) {
+		queue.dispatcher.policy = policy
+	}
+}
+
+// InFlight returns the number of deliveries each consumer added on this
+// connection got but didn't ack, reject or push yet, by consumer name.
+// Doesn't include batch consumers.
+func (queue *redisQueue) InFlight() map[string]int64 {
+	return queue.dispatcher.inFlight()
+}
+
+// dispatcher lets the consumers of a queue take turns reading from its
+// delivery chan. Only the consumer holding the turn reads, once it got a
+// delivery the turn goes to the waiting consumer preferred by the policy.
+type dispatcher struct {
+	policy DispatchPolicy
+
+	mu        sync.Mutex
+	consumers map[string]*dispatchConsumer // by name
+	reader    *dispatchConsumer            // holds the turn, nil if no consumer is waiting
+	waiting   []*dispatchConsumer          // waiting for the turn, in order of arrival
+	served    uint64                       // number of turns taken so far
+}
+
+type dispatchConsumer struct {
+	name       string
+	turn       chan struct{} // receives once the consumer holds the turn
+	inFlight   int64         // deliveries not handled yet (atomic)
+	lastServed uint64        // value of served when the consumer last took a turn
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{consumers: map[string]*dispatchConsumer{}}
+}
+
+// add registers a consumer, call remove() once it stopped consuming
+func (dispatcher *dispatcher) add(name string) *dispatchConsumer {
+	consumer := &dispatchConsumer{name: name, turn: make(chan struct{}, 1)}
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.consumers[name] = consumer
+	return consumer
+}
+
+func (dispatcher *dispatcher) remove(consumer *dispatchConsumer) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	delete(dispatcher.consumers, consumer.name)
+}
+
+// wait blocks until the consumer holds the turn to read the next delivery.
+// Returns false if stop got closed before, then the consumer must not read.
+func (dispatcher *dispatcher) wait(consumer *dispatchConsumer, stop <-chan struct{}) bool {
+	if dispatcher.policy == DispatchFirst {
+		return true
+	}
+
+	dispatcher.mu.Lock()
+	if dispatcher.reader == nil {
+		dispatcher.reader = consumer
+		dispatcher.mu.Unlock()
+		return true
+	}
+	dispatcher.waiting = append(dispatcher.waiting, consumer)
+	dispatcher.mu.Unlock()
+
+	select {
+	case <-consumer.turn:
+		return true
+	case <-stop:
+	}
+
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	for i, waiting := range dispatcher.waiting {
+		if waiting == consumer {
+			dispatcher.waiting = append(dispatcher.waiting[:i], dispatcher.waiting[i+1:]...)
+			return false
+		}
+	}
+	<-consumer.turn // got the turn while stopping, pass it on
+	dispatcher.handOff()
+	return false
+}
+
+// done passes the turn on after the consumer read from the delivery chan
+func (dispatcher *dispatcher) done(consumer *dispatchConsumer) {
+	if dispatcher.policy == DispatchFirst {
+		return
+	}
+
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.served++
+	consumer.lastServed = dispatcher.served
+	if dispatcher.reader == consumer {
+		dispatcher.handOff()
+	}
+}
+
+// handOff gives the turn to the preferred waiting consumer, must be called
+// with mu locked
+func (dispatcher *dispatcher) handOff() {
+	if len(dispatcher.waiting) == 0 {
+		dispatcher.reader = nil
+		return
+	}
+
+	next := 0
+	for i, consumer := range dispatcher.waiting {
+		if dispatcher.prefer(consumer, dispatcher.waiting[next]) {
+			next = i
+		}
+	}
+	dispatcher.reader = dispatcher.waiting[next]
+	dispatcher.waiting = append(dispatcher.waiting[:next], dispatcher.waiting[next+1:]...)
+	dispatcher.reader.turn <- struct{}{} // never blocks, a consumer gets one turn at a time
+}
+
+// prefer returns whether a should get the turn before b
+func (dispatcher *dispatcher) prefer(a, b *dispatchConsumer) bool {
+	if dispatcher.policy == DispatchLeastLoaded {
+		aLoad, bLoad := atomic.LoadInt64(&a.inFlight), atomic.LoadInt64(&b.inFlight)
+		if aLoad != bLoad {
+			return aLoad < bLoad
+		}
+	}
+	return a.lastServed < b.lastServed
+}
+
+// track counts the delivery as in flight for the consumer until it got handled
+func (dispatcher *dispatcher) track(consumer *dispatchConsumer, delivery Delivery) {
+	redisDelivery, ok := delivery.(*redisDelivery)
+	if !ok {
+		return
+	}
+	atomic.AddInt64(&consumer.inFlight, 1)
+	redisDelivery.dispatched = func() {
+		atomic.AddInt64(&consumer.inFlight, -1)
+	}
+}
+
+func (dispatcher *dispatcher) inFlight() map[string]int64 {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	counts := make(map[string]int64, len(dispatcher.consumers))
+	for name, consumer := range dispatcher.consumers {
+		counts[name] = atomic.LoadInt64(&consumer.inFlight)
+	}
+	return counts
+}
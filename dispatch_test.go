@@ -0,0 +1,110 @@
+package rmq
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchPolicy(t *testing.T) {
+	dispatcher := newDispatcher()
+	a, b, c := dispatcher.add("a"), dispatcher.add("b"), dispatcher.add("c")
+	a.inFlight, b.inFlight, c.inFlight = 2, 1, 1
+	a.lastServed, b.lastServed, c.lastServed = 1, 5, 3
+
+	// round robin prefers the consumer served least recently
+	dispatcher.policy = DispatchRoundRobin
+	dispatcher.waiting = []*dispatchConsumer{b, a, c}
+	dispatcher.handOff()
+	assert.Equal(t, a, dispatcher.reader)
+	assert.Len(t, a.turn, 1)
+	assert.Equal(t, []*dispatchConsumer{b, c}, dispatcher.waiting)
+	<-a.turn
+
+	// least loaded prefers the consumer with the fewest deliveries in flight
+	dispatcher.policy = DispatchLeastLoaded
+	dispatcher.waiting = []*dispatchConsumer{b, a, c}
+	dispatcher.handOff()
+	assert.Equal(t, c, dispatcher.reader)
+	assert.Equal(t, []*dispatchConsumer{b, a}, dispatcher.waiting)
+
+	// consumers stop waiting for their turn once stop gets closed
+	dispatcher.waiting = nil
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, dispatcher.wait(a, stop))
+	assert.Empty(t, dispatcher.waiting)
+
+	// the turn gets passed on after reading
+	<-c.turn
+	dispatcher.waiting = []*dispatchConsumer{b}
+	dispatcher.done(c)
+	assert.Equal(t, b, dispatcher.reader)
+	assert.Equal(t, uint64(1), c.lastServed)
+	assert.True(t, dispatcher.wait(b, nil))
+
+	assert.Equal(t, map[string]int64{"a": 2, "b": 1, "c": 1}, dispatcher.inFlight())
+	dispatcher.remove(a)
+	assert.Equal(t, map[string]int64{"b": 1, "c": 1}, dispatcher.inFlight())
+}
+
+func TestInFlight(t *testing.T) {
+	connection, err := OpenConnection("in-flight-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("in-flight-q", WithDispatchPolicy(DispatchLeastLoaded))
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	// consumers which ack asynchronously
+	var mu sync.Mutex
+	var deliveries []Delivery
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	names := map[string]bool{}
+	for _, tag := range []string{"in-flight-A", "in-flight-B"} {
+		name, err := queue.AddConsumerFunc(tag, func(delivery Delivery) {
+			mu.Lock()
+			defer mu.Unlock()
+			deliveries = append(deliveries, delivery)
+		})
+		assert.NoError(t, err)
+		names[name] = true
+	}
+
+	assert.NoError(t, queue.Publish("in-flight-d1", "in-flight-d2", "in-flight-d3", "in-flight-d4"))
+	for i := 0; ; i++ {
+		mu.Lock()
+		received := len(deliveries)
+		mu.Unlock()
+		if received == 4 {
+			break
+		}
+		require.True(t, i < 100, "received %d deliveries", received)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	inFlight := queue.InFlight()
+	assert.Len(t, inFlight, 2)
+	var total int64
+	for name, count := range inFlight {
+		assert.True(t, names[name], name)
+		total += count
+	}
+	assert.Equal(t, int64(4), total)
+
+	mu.Lock()
+	for _, delivery := range deliveries {
+		assert.NoError(t, delivery.Ack())
+	}
+	mu.Unlock()
+	for _, count := range queue.InFlight() {
+		assert.Equal(t, int64(0), count)
+	}
+
+	<-queue.StopConsuming()
+	assert.Empty(t, queue.InFlight())
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,54 @@
+package rmq
+
+import "time"
+
+// TimeToDrain estimates how long the consumers of the given queue take to
+// consume its backlog (ready and unacked deliveries), based on the number of
+// deliveries they fetched since the previous snapshot. Deliveries published
+// in the meantime aren't taken into account, so the estimate is only
+// accurate while the backlog doesn't grow. Returns false if the queue isn't
+// in both snapshots or its consumers didn't fetch any deliveries in between
+// while it has a backlog.
+func (stats Stats) TimeToDrain(queueName string, previous Stats) (time.Duration, bool) {
+	stat, ok := stats.QueueStats[queueName]
+	if !ok {
+		return 0, false
+	}
+	previousStat, ok := previous.QueueStats[queueName]
+	if !ok {
+		return 0, false
+	}
+
+	rate := 0.0
+	elapsed := stats.collected.Sub(previous.collected).Seconds()
+	if elapsed > 0 && stat.FetchedCount >= previousStat.FetchedCount { // not reset in between
+		rate = float64(stat.FetchedCount-previousStat.FetchedCount) / elapsed
+	}
+	return drainTime(stat.ReadyCount+stat.UnackedCount(), rate)
+}
+
+// TimeToDrain estimates how long the consumers of the given queue take to
+// consume its backlog like Stats.TimeToDrain(), based on the deliveries
+// fetched between the oldest and the latest snapshot of the history
+func (history *StatsHistory) TimeToDrain(queueName string) (time.Duration, bool) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	if len(history.snapshots) < 2 {
+		return 0, false
+	}
+	latest := history.snapshots[len(history.snapshots)-1]
+	return latest.TimeToDrain(queueName, history.snapshots[0])
+}
+
+// drainTime returns how long consuming the backlog takes at the given rate
+// of deliveries per second. Returns false if there is a backlog, but no rate.
+func drainTime(backlog int64, rate float64) (time.Duration, bool) {
+	if backlog <= 0 {
+		return 0, true
+	}
+	if rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(backlog) / rate * float64(time.Second)), true
+}
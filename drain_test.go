@@ -0,0 +1,46 @@
+package rmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeToDrain(t *testing.T) {
+	snapshot := func(collected time.Time, ready, fetched int64) Stats {
+		stats := NewStats()
+		stats.collected = collected
+		stat := NewQueueStat(ready, 0)
+		stat.FetchedCount = fetched
+		stats.QueueStats["drain-q"] = stat
+		return stats
+	}
+	start := time.Now()
+	previous := snapshot(start, 100, 50)
+
+	drain, ok := snapshot(start.Add(10*time.Second), 80, 70).TimeToDrain("drain-q", previous)
+	assert.True(t, ok)
+	assert.Equal(t, 40*time.Second, drain) // 2 per second
+
+	_, ok = snapshot(start.Add(10*time.Second), 80, 50).TimeToDrain("drain-q", previous)
+	assert.False(t, ok) // nothing fetched
+	_, ok = snapshot(start.Add(10*time.Second), 80, 10).TimeToDrain("drain-q", previous)
+	assert.False(t, ok) // stats got reset
+	_, ok = snapshot(start.Add(10*time.Second), 80, 70).TimeToDrain("other-q", previous)
+	assert.False(t, ok)
+
+	drain, ok = snapshot(start.Add(10*time.Second), 0, 50).TimeToDrain("drain-q", previous)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), drain) // empty
+
+	history := NewStatsHistory(3)
+	_, ok = history.TimeToDrain("drain-q")
+	assert.False(t, ok)
+	history.Add(previous)
+	history.Add(snapshot(start.Add(5*time.Second), 90, 55))
+	history.Add(snapshot(start.Add(10*time.Second), 80, 70))
+	drain, ok = history.TimeToDrain("drain-q")
+	assert.True(t, ok)
+	assert.Equal(t, 40*time.Second, drain) // between oldest and latest
+}
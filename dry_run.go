@@ -0,0 +1,25 @@
+package rmq
+
+// WithDryRun makes consumers of this queue simulate consumption: Deliveries
+// get passed to the consumers as usual, but Ack(), Reject(), Push(),
+// AckAndPublish() and delaying them via RetryAfter() return them unchanged
+// to the ready list instead, as does returning from Consume() without
+// handling them. Checkpoint() doesn't write anything. This is useful to try
+// a new consumer against real production deliveries without consuming them.
+// Restored deliveries go to the start of the ready list, so they get
+// consumed after the ones which were ready before. Other side effects of the
+// consumer, like publishing to other queues, are not prevented.
+func WithDryRun() QueueOption {
+	return func(queue *redisQueue) {
+		queue.dryRun = true
+	}
+}
+
+// restore moves the delivery from unacked back to ready without changing
+// it, see WithDryRun()
+func (delivery *redisDelivery) restore() error {
+	delivery.setHandled()
+	return delivery.retry(func() (int64, error) {
+		return delivery.redisClient.LRemLPush(delivery.unackedKey, delivery.payload, delivery.restoreKey, delivery.payload)
+	})
+}
@@ -0,0 +1,101 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	connection, err := OpenConnection("dry-run-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	pushQueue, err := connection.OpenQueue("dry-run-push")
+	require.NoError(t, err)
+	_, err = pushQueue.PurgeReady()
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("dry-run-q", WithDryRun())
+	require.NoError(t, err)
+	queue.SetPushQueue(pushQueue)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("dry-run-d1", "dry-run-d2", "dry-run-d3", "dry-run-d4", "dry-run-d5"))
+	handle := []func(Delivery) error{
+		Delivery.Ack,
+		Delivery.Reject,
+		Delivery.Push,
+		func(delivery Delivery) error { return delivery.AckAndPublish(pushQueue, "follow-up") },
+		func(delivery Delivery) error { return delivery.Checkpoint([]byte("state")) },
+	}
+	for i, handle := range handle {
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		assert.NoError(t, handle(delivery), i)
+	}
+
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count) // checkpointed one is still unacked
+	count, err = queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = pushQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	_, err = queue.ReturnUnacked(1)
+	assert.NoError(t, err)
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 5)
+	for _, message := range messages {
+		assert.Empty(t, message.Header) // restored unchanged
+	}
+
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+}
+
+func TestDryRunConsumer(t *testing.T) {
+	connection, err := OpenConnection("dry-run-cons-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("dry-run-cons-q", WithDryRun())
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+
+	consumed := make(chan string, 10)
+	require.NoError(t, queue.StartConsuming(1, time.Millisecond))
+	_, err = queue.AddConsumerFunc("dry-run-cons", func(delivery Delivery) {
+		consumed <- delivery.Payload() // returns without handling the delivery
+	})
+	require.NoError(t, err)
+	assert.NoError(t, queue.Publish("dry-run-cons-d1"))
+
+	// gets restored and consumed again
+	for i := 0; i < 2; i++ {
+		select {
+		case payload := <-consumed:
+			assert.Equal(t, "dry-run-cons-d1", payload)
+		case <-time.After(time.Second):
+			t.Fatal("delivery not consumed again")
+		}
+	}
+
+	<-queue.StopConsuming()
+	_, err = queue.ReturnUnacked(10) // prefetched one, if any
+	assert.NoError(t, err)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,169 @@
+package rmq
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrorClass is the class of an error returned by a HandlerFunc, see
+// WithErrorPolicy()
+type ErrorClass int
+
+const (
+	TemporaryError ErrorClass = iota // might succeed if retried later
+	PermanentError                   // won't succeed no matter how often it gets retried
+	ThrottledError                   // a downstream service asked to back off, see RetryAfter()
+)
+
+// ErrorClassifier decides which class an error returned by a HandlerFunc
+// belongs to
+type ErrorClassifier func(err error) ErrorClass
+
+// ErrorAction defines what happens to a delivery whose handler returned an
+// error of some class, see ErrorPolicy
+type ErrorAction int
+
+const (
+	DeadLetterOnError ErrorAction = iota // reject the delivery, it goes to the dead letter queue if set (see WithDeadLetter())
+	RequeueOnError                       // requeue the delivery after an exponential backoff
+	ParkOnError                          // publish the delivery to the park queue
+)
+
+// backoff used by RequeueOnError if not set in the ErrorPolicy
+const (
+	defaultMinErrorBackoff = time.Second
+	defaultMaxErrorBackoff = time.Hour
+)
+
+// ErrorPolicy maps the classes of errors returned by a HandlerFunc to the
+// actions taken on their deliveries. The zero value rejects deliveries on all
+// errors.
+type ErrorPolicy struct {
+	Classify ErrorClassifier // classifies errors other than RetryAfter() ones, which are always throttled. If nil all other errors are temporary.
+
+	Temporary ErrorAction
+	Permanent ErrorAction
+	Throttled ErrorAction
+
+	ParkQueue  Queue         // where ParkOnError publishes deliveries to, ParkOnError rejects them if nil
+	MinBackoff time.Duration // delay of the first requeue, doubled for each further requeue (default 1s)
+	MaxBackoff time.Duration // max delay of requeues (default 1h)
+
+	parkKey string
+}
+
+// WithErrorPolicy makes consumers defined as HandlerFunc route deliveries
+// according to the given policy if they return an error, instead of rejecting
+// them. Deliveries requeued because of a RetryAfter() error get delayed by
+// exactly that duration, others by an exponential backoff based on how often
+// they got requeued before (see Header.Attempts()). The park queue must not
+// be opened with WithStream().
+// NOTE: panics if policy.ParkQueue is not opened via a redis connection
+func WithErrorPolicy(policy ErrorPolicy) QueueOption {
+	parkStream := false
+	if policy.ParkQueue != nil {
+		policy.parkKey = policy.ParkQueue.(*redisQueue).readyKey
+		parkStream = isStreamQueue(policy.ParkQueue)
+	}
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = defaultMinErrorBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultMaxErrorBackoff
+	}
+
+	return func(queue *redisQueue) {
+		queue.errorPolicy = &policy
+		queue.streamTargets = queue.streamTargets || parkStream
+	}
+}
+
+// classify returns the class of the given error
+func (policy *ErrorPolicy) classify(err error) ErrorClass {
+	var retryAfter *RetryAfterError
+	switch {
+	case errors.As(err, &retryAfter):
+		return ThrottledError
+	case policy.Classify == nil:
+		return TemporaryError
+	}
+	return policy.Classify(err)
+}
+
+// action returns the action for the given error class
+func (policy *ErrorPolicy) action(class ErrorClass) ErrorAction {
+	switch class {
+	case PermanentError:
+		return policy.Permanent
+	case ThrottledError:
+		return policy.Throttled
+	}
+	return policy.Temporary
+}
+
+// backoff returns the delay of a delivery which got requeued attempts times
+// before
+func (policy *ErrorPolicy) backoff(attempts int) time.Duration {
+	return exponentialBackoff(policy.MinBackoff, policy.MaxBackoff, attempts)
+}
+
+// exponentialBackoff returns min doubled attempts times, at most max
+func exponentialBackoff(min, max time.Duration, attempts int) time.Duration {
+	backoff := min
+	for i := 0; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// handleError routes the delivery according to its error policy if it has
+// one. Otherwise it delays deliveries on RetryAfter() errors and rejects them
+// on others.
+func (delivery *redisDelivery) handleError(err error) error {
+	var retryAfter *RetryAfterError
+	isRetryAfter := errors.As(err, &retryAfter)
+
+	policy := delivery.errorPolicy
+	if policy == nil {
+		if isRetryAfter {
+			return delivery.delay(retryAfter.Delay, false)
+		}
+		return delivery.Reject()
+	}
+
+	class := policy.classify(err)
+	switch policy.action(class) {
+	case RequeueOnError:
+		if isRetryAfter {
+			return delivery.delay(retryAfter.Delay, true)
+		}
+		return delivery.delay(policy.backoff(delivery.header.Attempts()), true)
+
+	case ParkOnError:
+		if policy.parkKey == "" {
+			return delivery.Reject() // fall back to rejecting
+		}
+		delivery.setHandled()
+		return delivery.move(policy.parkKey, TrailParked, false)
+	}
+
+	if class == PermanentError {
+		return delivery.reject() // retrying won't help, see WithRetryPolicy()
+	}
+	return delivery.Reject()
+}
+
+// withAttempt returns the payload with the attempts in its header incremented
+func withAttempt(payload string) string {
+	header, body := decodeHeader(payload)
+	updated := make(Header, len(header)+1)
+	for key, value := range header {
+		updated[key] = value
+	}
+	updated[HeaderAttempts] = strconv.Itoa(header.Attempts() + 1)
+	return encodeHeader(updated, body)
+}
@@ -0,0 +1,123 @@
+package rmq
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errPermanent = errors.New("permanent failure")
+
+func TestErrorPolicy(t *testing.T) {
+	connection, err := OpenConnection("policy-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	parkQueue, err := connection.OpenQueue("policy-park-q")
+	assert.NoError(t, err)
+	_, err = parkQueue.PurgeReady()
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("policy-q", WithAutoAck(), WithErrorPolicy(ErrorPolicy{
+		Classify: func(err error) ErrorClass {
+			if errors.Is(err, errPermanent) {
+				return PermanentError
+			}
+			return TemporaryError
+		},
+		Temporary:  RequeueOnError,
+		Permanent:  ParkOnError,
+		Throttled:  RequeueOnError,
+		ParkQueue:  parkQueue,
+		MinBackoff: 20 * time.Millisecond,
+	}))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+	_, err = connection.(*redisConnection).redisClient.Del(queue.(*redisQueue).delayedKey)
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	attempts := map[string][]int{}
+	handler := func(delivery Delivery) error {
+		mu.Lock()
+		attempts[delivery.Payload()] = append(attempts[delivery.Payload()], delivery.Header().Attempts())
+		count := len(attempts[delivery.Payload()])
+		mu.Unlock()
+
+		switch {
+		case delivery.Payload() == "policy-permanent":
+			return errPermanent
+		case delivery.Payload() == "policy-throttled" && count == 1:
+			return RetryAfter(10 * time.Millisecond)
+		case delivery.Payload() == "policy-temporary" && count <= 2:
+			return errors.New("temporary failure")
+		}
+		return nil
+	}
+
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumer("policy-cons", HandlerFunc(handler))
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("policy-temporary", "policy-permanent", "policy-throttled"))
+
+	// temporary errors get requeued after 20ms and 40ms
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []int{0, 1, 2}, attempts["policy-temporary"])
+	assert.Equal(t, []int{0}, attempts["policy-permanent"])
+	assert.Equal(t, []int{0, 1}, attempts["policy-throttled"])
+	mu.Unlock()
+
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	parked, err := parkQueue.PeekReady(10)
+	assert.NoError(t, err)
+	if assert.Len(t, parked, 1) {
+		assert.Equal(t, "policy-permanent", parked[0].Payload)
+	}
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestErrorPolicyDefaults(t *testing.T) {
+	connection, err := OpenConnection("policy-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("policy-defaults-q", WithErrorPolicy(ErrorPolicy{
+		Permanent: ParkOnError, // without park queue
+	}))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	// all errors are temporary and get rejected by default
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumer("policy-cons", HandlerFunc(func(Delivery) error { return errPermanent }))
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("policy-d1", "policy-d2"))
+	time.Sleep(20 * time.Millisecond)
+	count, err := queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestErrorPolicyBackoff(t *testing.T) {
+	policy := ErrorPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.backoff(0))
+	assert.Equal(t, 2*time.Second, policy.backoff(1))
+	assert.Equal(t, 4*time.Second, policy.backoff(2))
+	assert.Equal(t, 5*time.Second, policy.backoff(3))
+	assert.Equal(t, 5*time.Second, policy.backoff(100))
+}
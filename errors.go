@@ -3,6 +3,7 @@ package rmq
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
@@ -10,6 +11,19 @@ var (
 	ErrorAlreadyConsuming = errors.New("must not call StartConsuming() multiple times")
 	ErrorNotConsuming     = errors.New("must call StartConsuming() before adding consumers")
 	ErrorConsumingStopped = errors.New("consuming stopped")
+	ErrorNoConsumers      = errors.New("must pass at least one consumer")
+	ErrorQueueFrozen      = errors.New("queue is frozen")
+	ErrorNoParkQueue      = errors.New("must pass a park queue to park deliveries")
+	ErrorSingleConsumer   = errors.New("must not add more than one consumer in single active consumer mode")
+	ErrorPrefetchMismatch = errors.New("must not consume a queue with different prefetch limits on one connection")
+	ErrorForbidden        = errors.New("operation forbidden by the connection's operation policy")
+	ErrorShutdown         = errors.New("connection got shut down via ShutdownConnection()")
+	ErrorVersionUnknown   = errors.New("delivery has a payload version without migration")
+	ErrorInvalidPolicy    = errors.New("return rejected policy needs a positive Max and Interval")
+	ErrorPriorityMixed    = errors.New("must not combine WithPriorities() with WithTenantFairness() or WithOldestFirst()")
+	ErrorUnknownDecision  = errors.New("retry classifier returned an unknown RetryDecision")
+	ErrorNoStreams        = errors.New("must use a backend implementing StreamBackend to open queues WithStream()")
+	ErrorStreamMixed      = errors.New("option or operation not supported by queues opened WithStream()")
 )
 
 type ConsumeError struct {
@@ -51,3 +65,201 @@ func (e *DeliveryError) Error() string {
 func (e *DeliveryError) Unwrap() error {
 	return e.RedisErr
 }
+
+// SaturationError gets sent to errChan if the consumers of a queue using
+// NotifyOnOverflow can't keep up with its prefetched deliveries
+type SaturationError struct {
+	Queue    string
+	Buffered int   // number of prefetched deliveries waiting to be consumed
+	Unacked  int64 // number of unacked deliveries, including the buffered ones
+}
+
+func (e *SaturationError) Error() string {
+	return fmt.Sprintf("rmq.SaturationError: queue %s has %d waiting of %d unacked deliveries", e.Queue, e.Buffered, e.Unacked)
+}
+
+// DuplicateConsumerError gets sent to errChan if a consumer gets added to a
+// queue with a tag which is already used by another consumer of that queue in
+// the same process. This is usually unintended, for example if consumers get
+// set up twice.
+type DuplicateConsumerError struct {
+	Queue string
+	Tag   string
+	Count int // number of consumers with that tag
+}
+
+func (e *DuplicateConsumerError) Error() string {
+	return fmt.Sprintf("rmq.DuplicateConsumerError: queue %s has %d consumers with tag %s in this process", e.Queue, e.Count, e.Tag)
+}
+
+// SlowConsumerError gets sent to errChan if a consumer of a queue using
+// WithSlowConsumerDetection() was slow for too many consecutive deliveries
+type SlowConsumerError struct {
+	Queue    string
+	Consumer string
+	Duration time.Duration // how long the last delivery took
+	Count    int           // number of consecutive slow deliveries
+	Evicted  bool          // whether the consumer stopped taking deliveries
+}
+
+func (e *SlowConsumerError) Error() string {
+	return fmt.Sprintf("rmq.SlowConsumerError: consumer %s of queue %s took %s for %d consecutive deliveries (evicted: %t)", e.Consumer, e.Queue, e.Duration, e.Count, e.Evicted)
+}
+
+// HungConsumerError gets sent to errChan if a consumer of a queue using
+// WithShutdownWatchdog() was still consuming a delivery when the watchdog's
+// timeout passed after consuming got stopped
+type HungConsumerError struct {
+	Queue      string
+	Consumer   string
+	DeliveryID string        // see Message.ID()
+	Elapsed    time.Duration // since the consumer started consuming the delivery
+	Returned   bool          // whether the delivery got returned to the ready list
+}
+
+func (e *HungConsumerError) Error() string {
+	return fmt.Sprintf("rmq.HungConsumerError: consumer %s of queue %s still consuming delivery %s after %s (returned: %t)", e.Consumer, e.Queue, e.DeliveryID, e.Elapsed, e.Returned)
+}
+
+// ConsumerPanicError gets sent to errChan if a consumer of a queue using
+// WithPanicQuarantine() panicked
+type ConsumerPanicError struct {
+	Queue       string
+	Consumer    string
+	Panic       interface{} // the recovered value
+	Count       int         // number of panics within the window
+	Quarantined bool        // whether the consumer stopped taking deliveries
+}
+
+func (e *ConsumerPanicError) Error() string {
+	return fmt.Sprintf("rmq.ConsumerPanicError: consumer %s of queue %s panicked %d times: %v (quarantined: %t)", e.Consumer, e.Queue, e.Count, e.Panic, e.Quarantined)
+}
+
+// BatchPublishError gets returned by Queue.PublishBatch() if publishing a
+// chunk failed. The first Published deliveries got published, the others
+// didn't.
+type BatchPublishError struct {
+	Published int
+	Total     int
+	Err       error
+}
+
+func (e *BatchPublishError) Error() string {
+	return fmt.Sprintf("rmq.BatchPublishError: published %d of %d deliveries: %s", e.Published, e.Total, e.Err.Error())
+}
+
+func (e *BatchPublishError) Unwrap() error {
+	return e.Err
+}
+
+// BigKeyError gets reported if publishing makes a list of a queue hold more
+// deliveries than Options.BigKeyLimit, see Options.RefuseBigKeys
+type BigKeyError struct {
+	Queue   string
+	Key     string
+	Length  int64 // of the list including the published deliveries
+	Limit   int64
+	Refused bool // whether the deliveries didn't get published
+}
+
+func (e *BigKeyError) Error() string {
+	return fmt.Sprintf("rmq.BigKeyError: list %s of queue %s would hold %d deliveries, more than the limit of %d (refused: %t), consider sharding the queue", e.Key, e.Queue, e.Length, e.Limit, e.Refused)
+}
+
+// ConnectionBudgetError gets reported if opening a queue makes a connection
+// have more open queues than Options.ConnectionBudget, or returned with
+// Options.RefuseOverBudget
+type ConnectionBudgetError struct {
+	Connection string
+	Queue      string // the queue which got opened
+	Queues     int    // number of open queues including the opened one
+	Budget     int
+	Refused    bool // whether the queue didn't get opened, see Options.RefuseOverBudget
+}
+
+func (e *ConnectionBudgetError) Error() string {
+	return fmt.Sprintf("rmq.ConnectionBudgetError: opening queue %s makes connection %s have %d open queues, more than its budget of %d redis connections (refused: %t)", e.Queue, e.Connection, e.Queues, e.Budget, e.Refused)
+}
+
+// RetryAfterError gets returned by RetryAfter(), see HandlerFunc
+type RetryAfterError struct {
+	Delay time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("rmq.RetryAfterError: retry after %s", e.Delay)
+}
+
+// TaskError gets sent to errChan if a task of a Scheduler failed
+type TaskError struct {
+	Task  string
+	Err   error
+	Count int // number of consecutive errors
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("rmq.TaskError (%d): task %s: %s", e.Count, e.Task, e.Err.Error())
+}
+
+// DecodeError gets sent to errChan if a delivery failed to decode with the
+// codecs of its queue, see WithCodecs(). The delivery got rejected.
+type DecodeError struct {
+	Delivery Delivery
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("rmq.DecodeError: %s", e.Err.Error())
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// AckDeadlineError gets sent to errChan if a delivery of a queue using
+// WithAckDeadline() didn't get acked, rejected or pushed within the warning
+// fraction of its deadline
+type AckDeadlineError struct {
+	Queue    string
+	Delivery Delivery
+	Elapsed  time.Duration // since the delivery got passed to its consumer
+	Deadline time.Duration
+}
+
+func (e *AckDeadlineError) Error() string {
+	return fmt.Sprintf("rmq.AckDeadlineError: delivery of queue %s unhandled after %s of its %s ack deadline", e.Queue, e.Elapsed, e.Deadline)
+}
+
+// CommandError gets returned if redis refused a command rmq needs, because
+// the redis user isn't allowed to run it or access its keys (NOPERM) or the
+// redis provider doesn't support it. Feature names the rmq functionality
+// which needs the command. See RestrictedACL for the commands rmq uses.
+type CommandError struct {
+	Command  string // like "EVALSHA"
+	Feature  string // like "acking"
+	RedisErr error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("rmq.CommandError: %s needs redis command %s: %s", e.Feature, e.Command, e.RedisErr.Error())
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.RedisErr
+}
+
+// WebhookError gets reported if an alert couldn't be posted to a webhook, see
+// NewWebhookAlertFunc()
+type WebhookError struct {
+	URL   string
+	Alert Alert
+	Err   error
+}
+
+func (e *WebhookError) Error() string {
+	return fmt.Sprintf("rmq.WebhookError: failed to post %s alert of queue %s to %s: %s", e.Alert.Alert, e.Alert.Queue, e.URL, e.Err.Error())
+}
+
+func (e *WebhookError) Unwrap() error {
+	return e.Err
+}
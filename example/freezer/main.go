@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/adjust/rmq/v4"
+)
+
+func main() {
+	unfreeze := flag.Bool("unfreeze", false, "unfreeze the queue instead of freezing it")
+	flag.Parse()
+
+	connection, err := rmq.OpenConnection("freezer", "tcp", "localhost:6379", 2, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	queue, err := connection.OpenQueue("things")
+	if err != nil {
+		panic(err)
+	}
+
+	if *unfreeze {
+		if err := queue.Unfreeze(); err != nil {
+			panic(err)
+		}
+		log.Printf("unfroze queue")
+		return
+	}
+
+	if err := queue.Freeze(); err != nil {
+		panic(err)
+	}
+	log.Printf("froze queue")
+}
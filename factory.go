@@ -0,0 +1,120 @@
+package rmq
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QueueFactory opens queues of a family of dynamically created queues, like
+// one queue per customer. All queues of a family share the same options and
+// get tracked in redis, so the whole family can be inspected, purged and
+// destroyed at once, also by processes which didn't open them.
+type QueueFactory struct {
+	connection Connection
+	family     string
+	familyKey  string // key to set of queues of the family
+	options    []QueueOption
+}
+
+// NewQueueFactory returns a factory for the given family of queues. The
+// options get applied to all queues opened via Open().
+// NOTE: panics if connection is not a redis connection
+func NewQueueFactory(connection Connection, family string, options ...QueueOption) *QueueFactory {
+	return &QueueFactory{
+		connection: connection,
+		family:     family,
+		familyKey:  strings.Replace(familyTemplate, phFamily, family, 1),
+		options:    options,
+	}
+}
+
+// QueueName returns the name of the family's queue for the given entity
+func (factory *QueueFactory) QueueName(entity string) string {
+	return fmt.Sprintf("%s-%s", factory.family, entity)
+}
+
+// Open opens the family's queue for the given entity, applying the factory's
+// options before the given ones, and adds it to the family
+func (factory *QueueFactory) Open(entity string, options ...QueueOption) (Queue, error) {
+	name := factory.QueueName(entity)
+	options = append(append([]QueueOption{}, factory.options...), options...)
+	queue, err := factory.connection.OpenQueue(name, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	redisClient := factory.connection.(*redisConnection).redisClient
+	if _, err := redisClient.SAdd(factory.familyKey, name); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// Queues returns the names of all queues of the family, sorted
+func (factory *QueueFactory) Queues() ([]string, error) {
+	names, err := factory.connection.(*redisConnection).redisClient.SMembers(factory.familyKey)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CollectStats returns the stats of all queues of the family
+func (factory *QueueFactory) CollectStats() (Stats, error) {
+	names, err := factory.Queues()
+	if err != nil {
+		return Stats{}, err
+	}
+	return factory.connection.CollectStats(names)
+}
+
+// PurgeReady removes all ready deliveries from all queues of the family and
+// returns how many got removed
+func (factory *QueueFactory) PurgeReady() (int64, error) {
+	return factory.each(func(queue Queue) (int64, error) { return queue.PurgeReady() })
+}
+
+// PurgeRejected removes all rejected deliveries from all queues of the family
+// and returns how many got removed
+func (factory *QueueFactory) PurgeRejected() (int64, error) {
+	return factory.each(func(queue Queue) (int64, error) { return queue.PurgeRejected() })
+}
+
+// Destroy destroys all queues of the family (see Queue.Destroy()) and removes
+// them from the family. Returns the number of purged ready and rejected
+// deliveries.
+func (factory *QueueFactory) Destroy() (readyCount, rejectedCount int64, err error) {
+	redisClient := factory.connection.(*redisConnection).redisClient
+	readyCount, err = factory.each(func(queue Queue) (int64, error) {
+		ready, rejected, err := queue.Destroy()
+		if err == ErrorNotFound { // already destroyed
+			err = nil
+		}
+		rejectedCount += rejected
+		if err == nil {
+			_, err = redisClient.SRem(factory.familyKey, queue.(*redisQueue).name)
+		}
+		return ready, err
+	})
+	return readyCount, rejectedCount, err
+}
+
+// each calls f for all queues of the family and returns the sum of the
+// returned counts
+func (factory *QueueFactory) each(f func(queue Queue) (int64, error)) (total int64, err error) {
+	names, err := factory.Queues()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range names {
+		count, err := f(factory.connection.openQueue(name))
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, nil
+}
@@ -0,0 +1,58 @@
+package rmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueFactory(t *testing.T) {
+	connection, err := OpenConnection("factory-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	factory := NewQueueFactory(connection, "factory-customer", WithPrefetchLimit(7))
+	_, _, err = factory.Destroy() // clean up previous runs
+	assert.NoError(t, err)
+
+	queue1, err := factory.Open("c1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), queue1.(*redisQueue).options.PrefetchLimit)
+	queue2, err := factory.Open("c2", WithRetryInterval(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), queue2.(*redisQueue).options.PrefetchLimit)
+	assert.Equal(t, time.Minute, queue2.(*redisQueue).options.RetryInterval)
+	queue3, err := factory.Open("c2") // opening again doesn't add it twice
+	assert.NoError(t, err)
+	assert.Equal(t, "factory-customer-c2", queue3.(*redisQueue).name)
+
+	names, err := factory.Queues()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"factory-customer-c1", "factory-customer-c2"}, names)
+
+	assert.NoError(t, queue1.Publish("factory-d1", "factory-d2"))
+	assert.NoError(t, queue2.Publish("factory-d3"))
+	stats, err := factory.CollectStats()
+	assert.NoError(t, err)
+	assert.Len(t, stats.QueueStats, 2)
+	assert.Equal(t, int64(2), stats.QueueStats["factory-customer-c1"].ReadyCount)
+	assert.Equal(t, int64(1), stats.QueueStats["factory-customer-c2"].ReadyCount)
+
+	count, err := factory.PurgeReady()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// destroying removes all queues of the family
+	assert.NoError(t, queue2.Publish("factory-d4"))
+	readyCount, rejectedCount, err := factory.Destroy()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), readyCount)
+	assert.Equal(t, int64(0), rejectedCount)
+	names, err = factory.Queues()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+	openQueues, err := connection.GetOpenQueues()
+	assert.NoError(t, err)
+	assert.NotContains(t, openQueues, "factory-customer-c1")
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,49 @@
+package rmq
+
+// WithFallback makes Publish() write deliveries to the ready list of the same
+// queue in the given fallback redis whenever publishing to the queue's redis
+// fails, so producers don't drop deliveries during an outage. Publish() only
+// returns an error if the fallback fails too, in which case it returns the
+// error of the queue's redis. Consumers don't consume the fallback, call
+// ReconcileFallback() once the outage is over, for example via a Scheduler.
+func WithFallback(fallback RedisClient) QueueOption {
+	return func(queue *redisQueue) {
+		queue.fallbackClient = fallback
+	}
+}
+
+// publishFallback publishes the encoded payloads to the fallback after
+// publishing them failed with err
+func (queue *redisQueue) publishFallback(payload []string, err error) error {
+	if _, fallbackErr := queue.fallbackClient.LPush(queue.readyKey, payload...); fallbackErr != nil {
+		return err
+	}
+	queue.options.logf(LogInfo, "rmq queue published to fallback %s %d: %s", queue, len(payload), err)
+	return nil
+}
+
+// ReconcileFallback moves the deliveries published to the fallback (see
+// WithFallback()) to the queue's ready list, oldest first. Returns the number
+// of moved deliveries. If this gets interrupted, the delivery which was being
+// moved might end up in both places and get consumed twice.
+func (queue *redisQueue) ReconcileFallback() (int64, error) {
+	if queue.fallbackClient == nil {
+		return 0, nil
+	}
+
+	for n := int64(0); ; n++ {
+		switch payload, err := queue.fallbackClient.LIndex(queue.readyKey, -1); err {
+		case nil: // move oldest
+			if _, err := queue.redisClient.LPush(queue.readyKey, payload); err != nil {
+				return n, err
+			}
+			if err := queue.fallbackClient.LTrim(queue.readyKey, 0, -2); err != nil {
+				return n, err
+			}
+		case ErrorNotFound: // nothing left
+			return n, nil
+		default: // error
+			return n, err
+		}
+	}
+}
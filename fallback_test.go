@@ -0,0 +1,74 @@
+package rmq
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+// downRedisClient fails to push while down
+type downRedisClient struct {
+	RedisClient
+	down int32 // atomic
+}
+
+func (client *downRedisClient) setDown(down bool) {
+	value := int32(0)
+	if down {
+		value = 1
+	}
+	atomic.StoreInt32(&client.down, value)
+}
+
+func (client *downRedisClient) LPush(key string, value ...string) (int64, error) {
+	if atomic.LoadInt32(&client.down) == 1 {
+		return 0, errors.New("redis down")
+	}
+	return client.RedisClient.LPush(key, value...)
+}
+
+func TestFallback(t *testing.T) {
+	primary := &downRedisClient{RedisClient: NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))}
+	fallback := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 2}))
+	connection, err := OpenConnectionWithRmqRedisClient("fallback-conn", primary, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("fallback-q", WithFallback(fallback))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = fallback.Del(queue.(*redisQueue).readyKey)
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("fallback-d1"))
+	primary.setDown(true)
+	assert.NoError(t, queue.Publish("fallback-d2", "fallback-d3"))
+	count, err := fallback.LLen(queue.(*redisQueue).readyKey)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// reconcile once the outage is over
+	_, err = queue.ReconcileFallback()
+	assert.EqualError(t, err, "redis down")
+	primary.setDown(false)
+	moved, err := queue.ReconcileFallback()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), moved)
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{Payload: "fallback-d1"}, {Payload: "fallback-d2"}, {Payload: "fallback-d3"}}, messages)
+	count, err = fallback.LLen(queue.(*redisQueue).readyKey)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	// without fallback errors get returned
+	primary.setDown(true)
+	plainQueue, err := connection.OpenQueue("fallback-q")
+	assert.NoError(t, err)
+	assert.EqualError(t, plainQueue.Publish("fallback-d4"), "redis down")
+	primary.setDown(false)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,80 @@
+package rmq
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// script is a Lua script the RedisWrapper either evaluates or calls as a
+// function of the library loaded via Options.UseFunctions
+type script struct {
+	*redis.Script
+	name   string // of the function in the library, without its prefix
+	source string
+}
+
+// scripts are all scripts in the order they got declared, see
+// functionLibrary()
+var scripts []*script
+
+func newScript(name, source string) *script {
+	script := &script{Script: redis.NewScript(source), name: name, source: source}
+	scripts = append(scripts, script)
+	return script
+}
+
+// functionLibrary returns the name and code of the function library with all
+// scripts. The name contains a hash of the scripts, so each rmq version
+// which changes them loads its own library and connections of different rmq
+// versions don't call each other's functions.
+func functionLibrary() (name, code string) {
+	hash := sha1.New()
+	for _, script := range scripts {
+		io.WriteString(hash, script.name)
+		io.WriteString(hash, script.source)
+	}
+	name = fmt.Sprintf("rmq_%x", hash.Sum(nil)[:4])
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "#!lua name=%s\n", name)
+	for _, script := range scripts {
+		fmt.Fprintf(&builder, "redis.register_function('%s_%s', function(KEYS, ARGV)%send)\n", name, script.name, script.source)
+	}
+	return name, builder.String()
+}
+
+// withFunctions returns a wrapper which calls the functions of the function
+// library, which gets loaded unless it already is. Returns the wrapper
+// unchanged if redis doesn't support functions or refuses to load them.
+func (wrapper RedisWrapper) withFunctions() (RedisWrapper, error) {
+	name, code := functionLibrary()
+	err := wrapper.rawClient.Do(unusedContext, "FUNCTION", "LOAD", code).Err()
+	switch {
+	case err == nil, strings.Contains(err.Error(), "already exists"):
+		wrapper.library = name
+		return wrapper, nil
+	case strings.HasPrefix(err.Error(), "ERR unknown command"), strings.HasPrefix(err.Error(), "NOPERM"):
+		return wrapper, nil // keep evaluating scripts
+	}
+	return wrapper, err
+}
+
+// run calls the script's function if the function library got loaded,
+// otherwise it evaluates the script
+func (wrapper RedisWrapper) run(script *script, keys []string, args ...interface{}) *redis.Cmd {
+	if wrapper.library == "" {
+		return script.Run(wrapper.commandContext(), wrapper.rawClient, keys, args...)
+	}
+
+	fcall := make([]interface{}, 0, 3+len(keys)+len(args))
+	fcall = append(fcall, "FCALL", wrapper.library+"_"+script.name, len(keys))
+	for _, key := range keys {
+		fcall = append(fcall, key)
+	}
+	fcall = append(fcall, args...)
+	return wrapper.rawClient.Do(wrapper.commandContext(), fcall...)
+}
@@ -0,0 +1,104 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctionLibrary(t *testing.T) {
+	name, code := functionLibrary()
+	assert.Regexp(t, `^rmq_[0-9a-f]{8}$`, name)
+	assert.True(t, strings.HasPrefix(code, "#!lua name="+name+"\n"))
+	assert.Equal(t, len(scripts), strings.Count(code, "redis.register_function("))
+	assert.Contains(t, code, "redis.register_function('"+name+"_lrem_lpush', function(KEYS, ARGV)\n")
+
+	sameName, sameCode := functionLibrary()
+	assert.Equal(t, name, sameName)
+	assert.Equal(t, code, sameCode)
+}
+
+// commandHook records the args of commands and fails them with err
+type commandHook struct {
+	args [][]interface{}
+	err  error
+}
+
+func (hook *commandHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	hook.args = append(hook.args, cmd.Args())
+	return ctx, hook.err
+}
+
+func (hook *commandHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (hook *commandHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (hook *commandHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestWithFunctions(t *testing.T) {
+	name, code := functionLibrary()
+	for _, tc := range []struct {
+		err     error
+		library string
+		failed  bool
+	}{
+		{errors.New("ERR Library '" + name + "' already exists"), name, false},
+		{errors.New("ERR unknown command `FUNCTION`, with args beginning with: `LOAD`"), "", false},
+		{errors.New("NOPERM this user has no permissions to run the 'function|load' command"), "", false},
+		{errors.New("LOADING Redis is loading the dataset in memory"), "", true},
+	} {
+		hook := &commandHook{err: tc.err}
+		rawClient := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+		rawClient.AddHook(hook)
+
+		wrapper, err := NewRedisWrapper(rawClient).withFunctions()
+		assert.Equal(t, tc.failed, err != nil, tc.err)
+		assert.Equal(t, tc.library, wrapper.library, tc.err)
+		assert.Equal(t, [][]interface{}{{"FUNCTION", "LOAD", code}}, hook.args)
+	}
+}
+
+func TestRunFunction(t *testing.T) {
+	hook := &commandHook{err: errors.New("recorded")}
+	rawClient := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	rawClient.AddHook(hook)
+	wrapper := NewRedisWrapper(rawClient)
+	wrapper.library = "rmq_test"
+
+	_, err := wrapper.LRemLPush("remove-key", "value", "push-key", "push-value")
+	assert.EqualError(t, err, "recorded")
+	assert.Equal(t, [][]interface{}{{"FCALL", "rmq_test_lrem_lpush", 2, "remove-key", "push-key", "value", "push-value"}}, hook.args)
+}
+
+func TestUseFunctionsFallback(t *testing.T) {
+	// the test redis doesn't support functions, so scripts get evaluated
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := ProductionOptions
+	options.UseFunctions = true
+	options.LogLevel = LogSilent
+	connection, err := OpenConnectionWithOptions("functions-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	wrapper := connection.(*redisConnection).redisClient.(RedisWrapper)
+	assert.Equal(t, "", wrapper.library)
+
+	_, err = wrapper.Del("functions-from")
+	assert.NoError(t, err)
+	_, err = wrapper.Del("functions-to")
+	assert.NoError(t, err)
+	_, err = wrapper.LPush("functions-from", "functions-value")
+	assert.NoError(t, err)
+	affected, err := wrapper.LRemLPush("functions-from", "functions-value", "functions-to", "functions-pushed")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+	values, err := wrapper.LRange("functions-to", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"functions-pushed"}, values)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,605 @@
+package rmq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GraphQLHandler is a http.Handler serving the state of queues, connections
+// and consumers along with admin mutations via GraphQL, so ops portals can
+// integrate rmq without custom glue. It implements the subset of GraphQL used
+// by typical clients: a single query or mutation with arguments, variables
+// and aliases, but no fragments or directives. The schema is:
+//
+//	type Query {
+//		queues: [Queue!]!                   # all open queues
+//		queue(name: String!): Queue!
+//		connections: [Connection!]!
+//		connection(name: String!): Connection!
+//	}
+//
+//	type Mutation {
+//		freezeQueue(name: String!): Boolean!
+//		unfreezeQueue(name: String!): Boolean!
+//		purgeReady(name: String!): Int!     # number of purged deliveries
+//		purgeRejected(name: String!): Int!
+//		returnRejected(name: String!, max: Int!): Int!
+//		shutdownConnection(name: String!): Boolean!
+//	}
+//
+//	type Queue {
+//		name: String!
+//		ready: Int!
+//		rejected: Int!
+//		unacked: Int!
+//		consumers: Int!
+//		connections: Int!
+//		frozen: Boolean!
+//		feeds: [String!]!
+//		tags: [String!]!                    # as key=value, sorted
+//	}
+//
+//	type Connection {
+//		name: String!
+//		alive: Boolean!
+//		heartbeatTTL: Int!                  # in milliseconds
+//		queues: [ConnectionQueue!]!
+//	}
+//
+//	type ConnectionQueue {
+//		name: String!
+//		unacked: Int!
+//		buffered: Int!
+//		consumers: [String!]!
+//	}
+//
+// Mutations are subject to the operation policy of the connection, see
+// Options.Operations.
+type GraphQLHandler struct {
+	connection Connection
+}
+
+func NewGraphQLHandler(connection Connection) *GraphQLHandler {
+	return &GraphQLHandler{connection: connection}
+}
+
+// graphqlRequest is the body of GraphQL requests sent via POST
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+}
+
+type graphqlResponse struct {
+	Data   interface{}    `json:"data"`
+	Errors []graphqlError `json:"errors,omitempty"`
+}
+
+func (handler *GraphQLHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var graphqlRequest graphqlRequest
+	switch request.Method {
+	case http.MethodGet:
+		graphqlRequest.Query = request.FormValue("query")
+		if variables := request.FormValue("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &graphqlRequest.Variables); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(request.Body).Decode(&graphqlRequest); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := graphqlResponse{}
+	data, err := handler.Execute(graphqlRequest.Query, graphqlRequest.Variables)
+	if err != nil {
+		response.Errors = []graphqlError{{Message: err.Error()}}
+	} else {
+		response.Data = data
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Execute executes the given GraphQL query or mutation and returns its
+// result, which can be encoded as JSON
+func (handler *GraphQLHandler) Execute(query string, variables map[string]interface{}) (interface{}, error) {
+	tokens, err := tokenizeGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+	parser := &graphqlParser{tokens: tokens, variables: variables}
+	operation, selections, err := parser.parseOperation()
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation {
+	case "query":
+		return resolveGraphQL(graphqlObject(handler.resolveQuery), selections)
+	case "mutation":
+		return resolveGraphQL(graphqlObject(handler.resolveMutation), selections)
+	default:
+		return nil, fmt.Errorf("rmq: unsupported graphql operation %q", operation)
+	}
+}
+
+func (handler *GraphQLHandler) resolveQuery(field string, args map[string]interface{}) (interface{}, error) {
+	switch field {
+	case "queues":
+		queueNames, err := handler.connection.GetOpenQueues()
+		if err != nil {
+			return nil, err
+		}
+		return handler.queueObjects(queueNames)
+	case "queue":
+		name, err := stringArgument(args, "name")
+		if err != nil {
+			return nil, err
+		}
+		queues, err := handler.queueObjects([]string{name})
+		if err != nil {
+			return nil, err
+		}
+		return queues[0], nil
+	case "connections":
+		connectionNames, err := handler.connection.getConnections()
+		if err != nil {
+			return nil, err
+		}
+		connections := make([]graphqlObject, 0, len(connectionNames))
+		for _, connectionName := range connectionNames {
+			inspection, err := handler.connection.InspectConnection(connectionName)
+			if err != nil {
+				return nil, err
+			}
+			connections = append(connections, connectionObject(inspection))
+		}
+		return connections, nil
+	case "connection":
+		name, err := stringArgument(args, "name")
+		if err != nil {
+			return nil, err
+		}
+		inspection, err := handler.connection.InspectConnection(name)
+		if err != nil {
+			return nil, err
+		}
+		return connectionObject(inspection), nil
+	}
+	return nil, unknownGraphQLField("Query", field)
+}
+
+func (handler *GraphQLHandler) resolveMutation(field string, args map[string]interface{}) (interface{}, error) {
+	switch field {
+	case "shutdownConnection", "freezeQueue", "unfreezeQueue", "purgeReady", "purgeRejected", "returnRejected":
+	default:
+		return nil, unknownGraphQLField("Mutation", field)
+	}
+
+	name, err := stringArgument(args, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	switch field {
+	case "shutdownConnection":
+		return true, handler.connection.ShutdownConnection(name)
+	case "freezeQueue":
+		return true, handler.connection.openQueue(name).Freeze()
+	case "unfreezeQueue":
+		return true, handler.connection.openQueue(name).Unfreeze()
+	case "purgeReady":
+		return handler.connection.openQueue(name).PurgeReady()
+	case "purgeRejected":
+		return handler.connection.openQueue(name).PurgeRejected()
+	case "returnRejected":
+		max, err := intArgument(args, "max")
+		if err != nil {
+			return nil, err
+		}
+		return handler.connection.openQueue(name).ReturnRejected(max)
+	}
+	return nil, nil // not reached
+}
+
+// queueObjects returns the Queue objects of the given queues
+func (handler *GraphQLHandler) queueObjects(queueNames []string) ([]graphqlObject, error) {
+	stats, err := handler.connection.CollectStats(queueNames)
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make([]graphqlObject, 0, len(queueNames))
+	for _, queueName := range queueNames {
+		queueName, stat := queueName, stats.QueueStats[queueName]
+		queues = append(queues, func(field string, _ map[string]interface{}) (interface{}, error) {
+			switch field {
+			case "name":
+				return queueName, nil
+			case "ready":
+				return stat.ReadyCount, nil
+			case "rejected":
+				return stat.RejectedCount, nil
+			case "unacked":
+				return stat.UnackedCount(), nil
+			case "consumers":
+				return stat.ConsumerCount(), nil
+			case "connections":
+				return stat.ConnectionCount(), nil
+			case "frozen":
+				return handler.connection.openQueue(queueName).IsFrozen()
+			case "feeds":
+				if stat.Feeds == nil {
+					return []string{}, nil
+				}
+				return stat.Feeds, nil
+			case "tags":
+				return sortedTags(stat.Tags), nil
+			}
+			return nil, unknownGraphQLField("Queue", field)
+		})
+	}
+	return queues, nil
+}
+
+// connectionObject returns the Connection object of the given connection
+func connectionObject(inspection ConnectionInspection) graphqlObject {
+	return func(field string, _ map[string]interface{}) (interface{}, error) {
+		switch field {
+		case "name":
+			return inspection.Name, nil
+		case "alive":
+			return inspection.Alive, nil
+		case "heartbeatTTL":
+			return int64(inspection.HeartbeatTTL / time.Millisecond), nil
+		case "queues":
+			queueNames := make([]string, 0, len(inspection.Queues))
+			for queueName := range inspection.Queues {
+				queueNames = append(queueNames, queueName)
+			}
+			sort.Strings(queueNames)
+
+			queues := make([]graphqlObject, 0, len(queueNames))
+			for _, queueName := range queueNames {
+				queueName, queueInspection := queueName, inspection.Queues[queueName]
+				queues = append(queues, func(field string, _ map[string]interface{}) (interface{}, error) {
+					switch field {
+					case "name":
+						return queueName, nil
+					case "unacked":
+						return queueInspection.UnackedCount, nil
+					case "buffered":
+						return queueInspection.BufferedCount, nil
+					case "consumers":
+						return queueInspection.Consumers, nil
+					}
+					return nil, unknownGraphQLField("ConnectionQueue", field)
+				})
+			}
+			return queues, nil
+		}
+		return nil, unknownGraphQLField("Connection", field)
+	}
+}
+
+func unknownGraphQLField(typeName, field string) error {
+	return fmt.Errorf("rmq: unknown graphql field %q on type %s", field, typeName)
+}
+
+func stringArgument(args map[string]interface{}, name string) (string, error) {
+	value, ok := args[name].(string)
+	if !ok {
+		return "", fmt.Errorf("rmq: graphql argument %q must be a string", name)
+	}
+	return value, nil
+}
+
+func intArgument(args map[string]interface{}, name string) (int64, error) {
+	switch value := args[name].(type) {
+	case int64:
+		return value, nil
+	case float64: // from JSON variables
+		return int64(value), nil
+	}
+	return 0, fmt.Errorf("rmq: graphql argument %q must be an integer", name)
+}
+
+// graphqlObject resolves the fields of a GraphQL object. Returned values are
+// scalars, lists of scalars, objects or lists of objects.
+type graphqlObject func(field string, args map[string]interface{}) (interface{}, error)
+
+// graphqlField is a field selected in a GraphQL query
+type graphqlField struct {
+	alias      string // key of the field in the result
+	name       string
+	args       map[string]interface{}
+	selections []graphqlField // nil for scalar fields
+}
+
+// graphqlResult is a resolved GraphQL object, which gets encoded as JSON
+// object keeping the order of the selected fields
+type graphqlResult []graphqlResultField
+
+type graphqlResultField struct {
+	key   string
+	value interface{}
+}
+
+func (result graphqlResult) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString("{")
+	for i, field := range result {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteString(":")
+		buffer.Write(value)
+	}
+	buffer.WriteString("}")
+	return buffer.Bytes(), nil
+}
+
+// resolveGraphQL resolves the given selections of the given value
+func resolveGraphQL(value interface{}, selections []graphqlField) (interface{}, error) {
+	switch value := value.(type) {
+	case graphqlObject:
+		if selections == nil {
+			return nil, fmt.Errorf("rmq: graphql object fields must have selections")
+		}
+		result := make(graphqlResult, 0, len(selections))
+		for _, field := range selections {
+			fieldValue, err := value(field.name, field.args)
+			if err != nil {
+				return nil, err
+			}
+			resolved, err := resolveGraphQL(fieldValue, field.selections)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, graphqlResultField{key: field.alias, value: resolved})
+		}
+		return result, nil
+
+	case []graphqlObject:
+		list := make([]interface{}, 0, len(value))
+		for _, object := range value {
+			resolved, err := resolveGraphQL(object, selections)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, resolved)
+		}
+		return list, nil
+
+	default:
+		if selections != nil {
+			return nil, fmt.Errorf("rmq: graphql scalar fields must not have selections")
+		}
+		return value, nil
+	}
+}
+
+// tokenizeGraphQL splits the given query into punctuators, names, numbers and
+// string literals (which keep their quotes)
+func tokenizeGraphQL(query string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#': // comment until the end of the line
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case strings.IndexByte("{}():!$[]=", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(query) && query[end] != '"' {
+				if query[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(query) {
+				return nil, fmt.Errorf("rmq: unterminated graphql string")
+			}
+			tokens = append(tokens, query[i:end+1])
+			i = end + 1
+		case c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			end := i + 1
+			for end < len(query) && (query[end] == '_' || query[end] == '.' ||
+				query[end] >= '0' && query[end] <= '9' ||
+				query[end] >= 'a' && query[end] <= 'z' ||
+				query[end] >= 'A' && query[end] <= 'Z') {
+				end++
+			}
+			tokens = append(tokens, query[i:end])
+			i = end
+		default:
+			return nil, fmt.Errorf("rmq: unsupported graphql syntax at %q", query[i:])
+		}
+	}
+	return tokens, nil
+}
+
+type graphqlParser struct {
+	tokens    []string
+	pos       int
+	variables map[string]interface{}
+}
+
+func (parser *graphqlParser) peek() string {
+	if parser.pos >= len(parser.tokens) {
+		return ""
+	}
+	return parser.tokens[parser.pos]
+}
+
+func (parser *graphqlParser) next() string {
+	token := parser.peek()
+	parser.pos++
+	return token
+}
+
+func (parser *graphqlParser) expect(token string) error {
+	if next := parser.next(); next != token {
+		return fmt.Errorf("rmq: expected %q in graphql query, got %q", token, next)
+	}
+	return nil
+}
+
+// parseOperation parses a document with a single operation and returns the
+// operation type and its selections
+func (parser *graphqlParser) parseOperation() (operation string, selections []graphqlField, err error) {
+	operation = "query" // shorthand without operation type
+	if parser.peek() != "{" {
+		operation = parser.next()
+		if name := parser.peek(); name != "(" && name != "{" {
+			parser.next() // operation name
+		}
+		if parser.peek() == "(" { // variable definitions, types and defaults are ignored
+			for parser.peek() != ")" && parser.peek() != "" {
+				parser.next()
+			}
+			if err := parser.expect(")"); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	if selections, err = parser.parseSelections(); err != nil {
+		return "", nil, err
+	}
+	if parser.peek() != "" {
+		return "", nil, fmt.Errorf("rmq: graphql query must contain a single operation")
+	}
+	return operation, selections, nil
+}
+
+func (parser *graphqlParser) parseSelections() ([]graphqlField, error) {
+	if err := parser.expect("{"); err != nil {
+		return nil, err
+	}
+	selections := []graphqlField{}
+	for parser.peek() != "}" {
+		field, err := parser.parseField()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, field)
+	}
+	parser.next()
+	return selections, nil
+}
+
+func (parser *graphqlParser) parseField() (field graphqlField, err error) {
+	field.name = parser.next()
+	if !isGraphQLName(field.name) {
+		return field, fmt.Errorf("rmq: expected graphql field, got %q", field.name)
+	}
+	field.alias = field.name
+	if parser.peek() == ":" {
+		parser.next()
+		field.name = parser.next()
+		if !isGraphQLName(field.name) {
+			return field, fmt.Errorf("rmq: expected graphql field, got %q", field.name)
+		}
+	}
+
+	if parser.peek() == "(" {
+		parser.next()
+		field.args = map[string]interface{}{}
+		for parser.peek() != ")" {
+			name := parser.next()
+			if err := parser.expect(":"); err != nil {
+				return field, err
+			}
+			if field.args[name], err = parser.parseValue(); err != nil {
+				return field, err
+			}
+		}
+		parser.next()
+	}
+
+	if parser.peek() == "{" {
+		if field.selections, err = parser.parseSelections(); err != nil {
+			return field, err
+		}
+	}
+	return field, nil
+}
+
+func (parser *graphqlParser) parseValue() (interface{}, error) {
+	token := parser.next()
+	switch {
+	case token == "$":
+		return parser.variables[parser.next()], nil
+	case token == "[":
+		list := []interface{}{}
+		for parser.peek() != "]" {
+			value, err := parser.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		parser.next()
+		return list, nil
+	case strings.HasPrefix(token, `"`):
+		return strconv.Unquote(token)
+	case token == "true" || token == "false":
+		return token == "true", nil
+	case token == "null":
+		return nil, nil
+	case token != "" && (token[0] == '-' || token[0] >= '0' && token[0] <= '9'):
+		if value, err := strconv.ParseInt(token, 10, 64); err == nil {
+			return value, nil
+		}
+		return strconv.ParseFloat(token, 64)
+	case isGraphQLName(token): // enum value
+		return token, nil
+	}
+	return nil, fmt.Errorf("rmq: expected graphql value, got %q", token)
+}
+
+func isGraphQLName(token string) bool {
+	if token == "" || token[0] >= '0' && token[0] <= '9' || token[0] == '-' {
+		return false
+	}
+	for _, c := range token {
+		if c != '_' && !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
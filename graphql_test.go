@@ -0,0 +1,78 @@
+package rmq
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphQLHandler(t *testing.T) {
+	connection, err := OpenConnection("graphql-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("graphql-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("graphql-d1", "graphql-d2"))
+
+	server := httptest.NewServer(NewGraphQLHandler(connection))
+	defer server.Close()
+
+	post := func(query string, variables map[string]interface{}) string {
+		body, err := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+		require.NoError(t, err)
+		response, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		result, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+		return string(result)
+	}
+
+	name := connection.(*redisConnection).Name
+	assert.JSONEq(t,
+		`{"data":{"q":{"name":"graphql-q","ready":2,"rejected":0,"frozen":false,"feeds":[],"tags":[]},"connection":{"name":"`+name+`","alive":true,"queues":[]}}}`,
+		post(`query Overview($queue: String!, $connection: String!) {
+			q: queue(name: $queue) { name ready rejected frozen feeds tags }
+			connection(name: $connection) { name alive queues { name unacked } }
+		}`, map[string]interface{}{"queue": "graphql-q", "connection": name}),
+	)
+
+	assert.JSONEq(t,
+		`{"data":{"freezeQueue":true,"purgeReady":2}}`,
+		post(`mutation { freezeQueue(name: "graphql-q") purgeReady(name: "graphql-q") }`, nil),
+	)
+	frozen, err := queue.IsFrozen()
+	assert.NoError(t, err)
+	assert.True(t, frozen)
+	assert.NoError(t, queue.Unfreeze())
+
+	// fields keep the order of the query
+	response, err := http.Get(server.URL + "?query=" + url.QueryEscape(`{ queue(name: "graphql-q") { ready name } }`))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"data":{"queue":{"ready":0,"name":"graphql-q"}}}`+"\n", string(body))
+
+	assert.JSONEq(t,
+		`{"data":null,"errors":[{"message":"rmq: unknown graphql field \"size\" on type Queue"}]}`,
+		post(`{ queue(name: "graphql-q") { size } }`, nil),
+	)
+	assert.JSONEq(t,
+		`{"data":null,"errors":[{"message":"entity not found"}]}`,
+		post(`{ connection(name: "graphql-missing") { name } }`, nil),
+	)
+	assert.Contains(t, post(`{ queue(name: "graphql-q") { ...fields } }`, nil), "unsupported graphql syntax")
+	assert.Contains(t, post(`{ queue { name } }`, nil), `argument \"name\" must be a string`)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,44 @@
+package rmq
+
+import "time"
+
+// RetryAfter returns an error which makes HandlerFunc delay the delivery by
+// exactly the given duration before it gets consumed again, for example to
+// honor the Retry-After header of a throttled downstream service
+func RetryAfter(delay time.Duration) error {
+	return &RetryAfterError{Delay: delay}
+}
+
+// HandlerFunc is a consumer function which returns an error if consuming the
+// delivery failed. Use it like ConsumerFunc:
+//
+//	queue.AddConsumer("tag", rmq.HandlerFunc(handle))
+//
+// If the function returns an error and didn't ack, reject or push the
+// delivery itself, the delivery gets delayed for errors returned by
+// RetryAfter() (also if wrapped) and rejected otherwise, unless the queue has
+// an error policy (see WithErrorPolicy()). If it returns nil the delivery is
+// left as is, so it gets auto acked if configured (see WithAutoAck()).
+type HandlerFunc func(delivery Delivery) error
+
+func (handlerFunc HandlerFunc) Consume(delivery Delivery) {
+	handleError(delivery, handlerFunc(delivery))
+}
+
+// handleError handles the delivery depending on the error returned by its
+// handler, redis errors get reported by the delivery itself
+func handleError(delivery Delivery, err error) {
+	if err == nil {
+		return
+	}
+
+	redisDelivery, ok := delivery.(*redisDelivery)
+	if !ok {
+		delivery.Reject()
+		return
+	}
+
+	if !redisDelivery.handled() {
+		redisDelivery.handleError(err)
+	}
+}
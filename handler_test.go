@@ -0,0 +1,137 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerFunc(t *testing.T) {
+	connection, err := OpenConnection("handler-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("handler-q", WithAutoAck())
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+	_, err = connection.(*redisConnection).redisClient.Del(queue.(*redisQueue).delayedKey)
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	attempts := map[string][]time.Time{}
+	handler := func(delivery Delivery) error {
+		mu.Lock()
+		attempts[delivery.Payload()] = append(attempts[delivery.Payload()], time.Now())
+		count := len(attempts[delivery.Payload()])
+		mu.Unlock()
+
+		switch {
+		case delivery.Payload() == "handler-fail":
+			return errors.New("permanent failure")
+		case count == 1:
+			return fmt.Errorf("throttled: %w", RetryAfter(100*time.Millisecond))
+		}
+		return nil
+	}
+
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumer("handler-cons", HandlerFunc(handler))
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("handler-d1", "handler-fail"))
+
+	time.Sleep(50 * time.Millisecond)
+	assertHandlerCounts(t, queue, 0, 0, 1)
+	mu.Lock()
+	assert.Len(t, attempts["handler-d1"], 1)
+	mu.Unlock()
+
+	// the delayed delivery gets retried after exactly its delay and acked
+	time.Sleep(150 * time.Millisecond)
+	assertHandlerCounts(t, queue, 0, 0, 1)
+	mu.Lock()
+	if assert.Len(t, attempts["handler-d1"], 2) {
+		assert.True(t, attempts["handler-d1"][1].Sub(attempts["handler-d1"][0]) >= 99*time.Millisecond) // delays have millisecond precision
+	}
+	assert.Len(t, attempts["handler-fail"], 1)
+	mu.Unlock()
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestRetryAfterIdenticalDeliveries(t *testing.T) {
+	connection, err := OpenConnection("handler-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("handler-identical-q", WithPollDuration(time.Millisecond))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = connection.(*redisConnection).redisClient.Del(queue.(*redisQueue).delayedKey)
+	assert.NoError(t, err)
+
+	// identical deliveries can be delayed at the same time
+	assert.NoError(t, queue.Publish("handler-same", "handler-same"))
+	for i := 0; i < 2; i++ {
+		delivery, err := queue.ConsumeOne(context.Background())
+		assert.NoError(t, err)
+		// not due yet when ConsumeOne() promotes due delayed deliveries
+		HandlerFunc(func(Delivery) error { return RetryAfter(20 * time.Millisecond) }).Consume(delivery)
+	}
+	assertHandlerCounts(t, queue, 0, 0, 0)
+
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	consumer := NewTestConsumer("handler-cons")
+	_, err = queue.AddConsumer("handler-cons", consumer)
+	assert.NoError(t, err)
+	time.Sleep(40 * time.Millisecond)
+	assert.Len(t, consumer.LastDeliveries, 2)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func assertHandlerCounts(t *testing.T, queue Queue, ready, unacked, rejected int64) {
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, ready, count)
+	count, err = queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, unacked, count)
+	count, err = queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, rejected, count)
+}
+
+func TestRetryAfterConsumeOne(t *testing.T) {
+	connection, err := OpenConnection("handler-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("handler-pull-q", WithPollDuration(time.Millisecond))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = connection.(*redisConnection).redisClient.Del(queue.(*redisQueue).delayedKey)
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("handler-pull"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	delivery, err := queue.ConsumeOne(ctx)
+	assert.NoError(t, err)
+	retried := time.Now()
+	HandlerFunc(func(Delivery) error { return RetryAfter(20 * time.Millisecond) }).Consume(delivery)
+	assertHandlerCounts(t, queue, 0, 0, 0)
+
+	// pull consumers get the delivery again once the delay passed
+	delivery, err = queue.ConsumeOne(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "handler-pull", delivery.Payload())
+	assert.True(t, time.Since(retried) >= 19*time.Millisecond) // delays have millisecond precision
+	assert.NoError(t, delivery.Ack())
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,241 @@
+package rmq
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Header holds metadata published along with a delivery's payload
+type Header map[string]string
+
+// header keys used by rmq itself
+const (
+	HeaderPublishedAt    = "rmq-published-at"    // unix nanoseconds, see WithPublishTime()
+	HeaderDeadline       = "rmq-deadline"        // unix nanoseconds, see Header.SetDeadline()
+	HeaderIdempotencyKey = "rmq-idempotency-key" // see Mover
+	HeaderTrail          = "rmq-trail"           // JSON encoded breadcrumbs, see WithTrail()
+	HeaderAttempts       = "rmq-attempts"        // number of times the delivery got requeued, see WithErrorPolicy() and WithRetryPolicy()
+	HeaderSchemaID       = "rmq-schema-id"       // see WithSchema()
+	HeaderVersion        = "rmq-version"         // payload version, see WithMigrations()
+	HeaderMarker         = "rmq-marker"          // name of marker deliveries, see Queue.PublishMarker()
+	HeaderTenant         = "rmq-tenant"          // see Queue.PublishForTenant()
+	HeaderPriority       = "rmq-priority"        // see Queue.PublishWithPriority()
+)
+
+// payloads with headers are stored as prefix, JSON encoded header, newline and
+// payload. The prefix can't occur in UTF-8 text, so plain payloads published
+// by older versions or without headers are left untouched.
+const headerPrefix = "\xffrmq:"
+
+// headerEncoder encodes headers into reusable buffers
+type headerEncoder struct {
+	buffer bytes.Buffer
+	keys   []string
+}
+
+// headerEncoders and headerBuffers reduce allocations when encoding and
+// decoding the headers of many deliveries, see BenchmarkEncodeHeader()
+var (
+	headerEncoders = sync.Pool{New: func() interface{} { return &headerEncoder{} }}
+	headerBuffers  = sync.Pool{New: func() interface{} {
+		buffer := make([]byte, 0, 256)
+		return &buffer
+	}}
+)
+
+// encodeHeader returns the payload as stored in redis. The header gets
+// encoded as JSON object with sorted keys, like json.Marshal() would.
+func encodeHeader(header Header, payload string) string {
+	if len(header) == 0 {
+		return payload
+	}
+
+	headerEncoder := headerEncoders.Get().(*headerEncoder)
+	defer headerEncoders.Put(headerEncoder)
+
+	headerEncoder.keys = headerEncoder.keys[:0]
+	for key := range header {
+		headerEncoder.keys = append(headerEncoder.keys, key)
+	}
+	sortStrings(headerEncoder.keys)
+
+	buffer := &headerEncoder.buffer
+	buffer.Reset()
+	buffer.WriteString(headerPrefix)
+	buffer.WriteByte('{')
+	for i, key := range headerEncoder.keys {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		writeJSONString(buffer, key)
+		buffer.WriteByte(':')
+		writeJSONString(buffer, header[key])
+	}
+	buffer.WriteString("}\n")
+	buffer.WriteString(payload)
+	return buffer.String()
+}
+
+// sortStrings sorts the few keys of a header without allocating, unlike
+// sort.Strings()
+func sortStrings(keys []string) {
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+}
+
+// writeJSONString writes s as JSON string. Unlike json.Marshal() it doesn't
+// escape HTML characters and leaves invalid UTF-8 to the decoder, which
+// replaces it like json.Marshal() would have.
+func writeJSONString(buffer *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buffer.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		buffer.WriteString(s[start:i])
+		switch c {
+		case '"', '\\':
+			buffer.WriteByte('\\')
+			buffer.WriteByte(c)
+		case '\n':
+			buffer.WriteString(`\n`)
+		case '\r':
+			buffer.WriteString(`\r`)
+		case '\t':
+			buffer.WriteString(`\t`)
+		default:
+			buffer.WriteString(`\u00`)
+			buffer.WriteByte(hex[c>>4])
+			buffer.WriteByte(hex[c&0xf])
+		}
+		start = i + 1
+	}
+	buffer.WriteString(s[start:])
+	buffer.WriteByte('"')
+}
+
+// decodeHeader splits a payload as stored in redis into header and payload.
+// The header is nil for payloads published without one.
+func decodeHeader(raw string) (Header, string) {
+	if !strings.HasPrefix(raw, headerPrefix) {
+		return nil, raw
+	}
+
+	rest := raw[len(headerPrefix):]
+	i := strings.IndexByte(rest, '\n')
+	if i < 0 {
+		return nil, raw
+	}
+
+	if header, ok := decodeFlatHeader(rest[:i]); ok {
+		return header, rest[i+1:]
+	}
+
+	buffer := headerBuffers.Get().(*[]byte)
+	defer headerBuffers.Put(buffer)
+	*buffer = append((*buffer)[:0], rest[:i]...)
+
+	var header Header
+	if err := json.Unmarshal(*buffer, &header); err != nil {
+		return nil, raw
+	}
+	return header, rest[i+1:]
+}
+
+// decodeFlatHeader decodes headers as written by encodeHeader() without
+// reflection, as long as none of their strings need unescaping. Returns false
+// for anything else, which then gets decoded by json.Unmarshal().
+func decodeFlatHeader(data string) (Header, bool) {
+	if len(data) < 2 || data[0] != '{' || data[len(data)-1] != '}' {
+		return nil, false
+	}
+
+	header := Header{}
+	rest := data[1 : len(data)-1]
+	for rest != "" {
+		key, afterKey, ok := cutFlatString(rest)
+		if !ok || afterKey == "" || afterKey[0] != ':' {
+			return nil, false
+		}
+		value, afterValue, ok := cutFlatString(afterKey[1:])
+		if !ok {
+			return nil, false
+		}
+		header[key] = value
+
+		rest = afterValue
+		if rest != "" {
+			if rest[0] != ',' || len(rest) == 1 {
+				return nil, false
+			}
+			rest = rest[1:]
+		}
+	}
+	return header, true
+}
+
+// cutFlatString cuts a JSON string from the start of s, unless it contains
+// escapes, control characters or invalid UTF-8
+func cutFlatString(s string) (value, rest string, ok bool) {
+	if s == "" || s[0] != '"' {
+		return "", "", false
+	}
+	end := strings.IndexByte(s[1:], '"') + 1
+	if end == 0 {
+		return "", "", false
+	}
+	value = s[1:end]
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] == '\\' {
+			return "", "", false
+		}
+	}
+	if !utf8.ValidString(value) {
+		return "", "", false
+	}
+	return value, s[end+1:], true
+}
+
+// SetDeadline sets the time after which the delivery is useless. Consumers
+// don't get passed deliveries whose deadline passed and the context of
+// deliveries gets canceled once their deadline passes, see Delivery.Context().
+func (header Header) SetDeadline(deadline time.Time) {
+	header[HeaderDeadline] = strconv.FormatInt(deadline.UnixNano(), 10)
+}
+
+// Deadline returns the deadline set via SetDeadline()
+func (header Header) Deadline() (time.Time, bool) {
+	return header.time(HeaderDeadline)
+}
+
+// Attempts returns how often the delivery got requeued because of errors
+// (see WithErrorPolicy() and WithRetryPolicy()), zero if never
+func (header Header) Attempts() int {
+	attempts, _ := strconv.Atoi(header[HeaderAttempts])
+	return attempts
+}
+
+// publishedAt returns when the delivery got published, if it was published
+// with WithPublishTime()
+func (header Header) publishedAt() (time.Time, bool) {
+	return header.time(HeaderPublishedAt)
+}
+
+func (header Header) time(key string) (time.Time, bool) {
+	nanos, err := strconv.ParseInt(header[key], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
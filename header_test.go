@@ -0,0 +1,84 @@
+package rmq
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderEncoding(t *testing.T) {
+	header := Header{"key": "value\nwith newline"}
+	raw := encodeHeader(header, "payload\nwith newline")
+	decodedHeader, payload := decodeHeader(raw)
+	assert.Equal(t, header, decodedHeader)
+	assert.Equal(t, "payload\nwith newline", payload)
+
+	// payloads without header are stored as is
+	assert.Equal(t, "plain", encodeHeader(nil, "plain"))
+	decodedHeader, payload = decodeHeader("plain")
+	assert.Nil(t, decodedHeader)
+	assert.Equal(t, "plain", payload)
+
+	// malformed headers are treated as part of the payload
+	decodedHeader, payload = decodeHeader(headerPrefix + "{broken\npayload")
+	assert.Nil(t, decodedHeader)
+	assert.Equal(t, headerPrefix+"{broken\npayload", payload)
+}
+
+func TestHeaderEncodingCompatible(t *testing.T) {
+	// headers get encoded like json.Marshal() did in earlier versions
+	header := Header{"b": "2", "a": "1", "c": "quote\" backslash\\ tab\t"}
+	marshaled, err := json.Marshal(header)
+	assert.NoError(t, err)
+	assert.Equal(t, headerPrefix+string(marshaled)+"\npayload", encodeHeader(header, "payload"))
+
+	for _, header := range []Header{
+		{"escaped": "quote\" backslash\\", "control": "\x01\r"},
+		{"unicode": "ünïcödé \u2028", "html": "<&>"},
+		{},
+	} {
+		decodedHeader, payload := decodeHeader(encodeHeader(header, "payload"))
+		if len(header) == 0 {
+			assert.Nil(t, decodedHeader)
+		} else {
+			assert.Equal(t, header, decodedHeader)
+		}
+		assert.Equal(t, "payload", payload)
+	}
+
+	// headers written by other JSON encoders get decoded too
+	decodedHeader, payload := decodeHeader(headerPrefix + `{ "key" : "\u0076alue" }` + "\npayload")
+	assert.Equal(t, Header{"key": "value"}, decodedHeader)
+	assert.Equal(t, "payload", payload)
+
+	_, ok := decodeFlatHeader(`{"key":"va\"lue"}`)
+	assert.False(t, ok)
+	_, ok = decodeFlatHeader(`{"key":"value",}`)
+	assert.False(t, ok)
+	flat, ok := decodeFlatHeader(`{"a":"1","b":""}`)
+	assert.True(t, ok)
+	assert.Equal(t, Header{"a": "1", "b": ""}, flat)
+}
+
+// benchmarkHeader is a typical header of deliveries published with
+// WithPublishTime() and tracing. At 50k deliveries per second, each
+// allocation per delivery means 50k allocations per second for the garbage
+// collector to clean up.
+var benchmarkHeader = Header{HeaderPublishedAt: "1600000000000000000", "trace-id": "4bf92f3577b34da6a3ce929d0e0e4736"}
+
+func BenchmarkEncodeHeader(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeHeader(benchmarkHeader, "bench-payload")
+	}
+}
+
+func BenchmarkDecodeHeader(b *testing.B) {
+	raw := encodeHeader(benchmarkHeader, "bench-payload")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeHeader(raw)
+	}
+}
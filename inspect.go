@@ -0,0 +1,80 @@
+package rmq
+
+import (
+	"strings"
+	"time"
+)
+
+// ConnectionInspection describes the state of a connection as seen in redis,
+// see Connection.InspectConnection()
+type ConnectionInspection struct {
+	Name         string                     `json:"name"`
+	Alive        bool                       `json:"alive"`         // whether its heartbeat is fresh
+	HeartbeatTTL time.Duration              `json:"heartbeat_ttl"` // until its heartbeat expires, zero if not alive
+	Queues       map[string]QueueInspection `json:"queues"`        // by name of the queues it consumes
+}
+
+// QueueInspection describes how a connection consumes a queue
+type QueueInspection struct {
+	UnackedCount  int64    `json:"unacked"`
+	BufferedCount int64    `json:"buffered"` // prefetched deliveries waiting for consumers
+	Consumers     []string `json:"consumers"`
+}
+
+// InspectConnection returns the state of the connection with the given name,
+// which can be any connection, not just this one. Use it to find out why a
+// connection holds unacked deliveries: whether its heartbeat is fresh, which
+// queues it consumes and with which consumers. Returns ErrorNotFound if there
+// is no connection with the given name.
+func (connection *redisConnection) InspectConnection(name string) (ConnectionInspection, error) {
+	inspection := ConnectionInspection{Name: name, Queues: map[string]QueueInspection{}}
+
+	connectionNames, err := connection.getConnections()
+	if err != nil {
+		return inspection, err
+	}
+	found := false
+	for _, connectionName := range connectionNames {
+		found = found || connectionName == name
+	}
+	if !found {
+		return inspection, ErrorNotFound
+	}
+
+	heartbeatKey := strings.Replace(connectionHeartbeatTemplate, phConnection, name, 1)
+	ttl, err := connection.redisClient.TTL(heartbeatKey)
+	if err != nil {
+		return inspection, err
+	}
+	if ttl > 0 {
+		inspection.Alive = true
+		inspection.HeartbeatTTL = ttl
+	}
+
+	hijackedConnection := connection.hijackConnection(name)
+	queueNames, err := hijackedConnection.getConsumingQueues()
+	if err != nil {
+		return inspection, err
+	}
+	for _, queueName := range queueNames {
+		queue := hijackedConnection.openQueue(queueName)
+		unackedCount, err := queue.unackedCount()
+		if err != nil {
+			return inspection, err
+		}
+		bufferedCount, _, _, _, err := queue.bufferStat()
+		if err != nil {
+			return inspection, err
+		}
+		consumers, err := queue.getConsumers()
+		if err != nil {
+			return inspection, err
+		}
+		inspection.Queues[queueName] = QueueInspection{
+			UnackedCount:  unackedCount,
+			BufferedCount: bufferedCount,
+			Consumers:     consumers,
+		}
+	}
+	return inspection, nil
+}
@@ -0,0 +1,47 @@
+package rmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectConnection(t *testing.T) {
+	connection, err := OpenConnection("inspect-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("inspect-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	assert.NoError(t, queue.Publish("inspect-d1", "inspect-d2"))
+	time.Sleep(10 * time.Millisecond)
+	consumerName, err := queue.AddConsumerFunc("inspect-cons", func(Delivery) {}) // never acks
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	adminConnection, err := OpenConnection("inspect-admin", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	name := connection.(*redisConnection).Name
+	inspection, err := adminConnection.InspectConnection(name)
+	assert.NoError(t, err)
+	assert.Equal(t, name, inspection.Name)
+	assert.True(t, inspection.Alive)
+	assert.True(t, inspection.HeartbeatTTL > 0)
+	assert.Len(t, inspection.Queues, 1)
+	assert.Equal(t, int64(2), inspection.Queues["inspect-q"].UnackedCount)
+	assert.Equal(t, []string{consumerName}, inspection.Queues["inspect-q"].Consumers)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+	inspection, err = adminConnection.InspectConnection(name)
+	assert.NoError(t, err)
+	assert.False(t, inspection.Alive)
+	assert.Equal(t, time.Duration(0), inspection.HeartbeatTTL)
+
+	_, err = adminConnection.InspectConnection("nope")
+	assert.Equal(t, ErrorNotFound, err)
+
+	assert.NoError(t, adminConnection.stopHeartbeat())
+}
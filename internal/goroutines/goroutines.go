@@ -0,0 +1,45 @@
+// Package goroutines keeps track of the goroutines rmq starts, so tests can
+// detect the ones which outlive their connection, see
+// testsupport.VerifyNoLeaks().
+package goroutines
+
+import "sync"
+
+var (
+	mu      sync.Mutex
+	running = map[string]int{} // by kind
+)
+
+// Go runs f in a new goroutine, which counts as running the given kind of
+// goroutine until f returns
+func Go(kind string, f func()) {
+	mu.Lock()
+	running[kind]++
+	mu.Unlock()
+
+	go func() {
+		defer done(kind)
+		f()
+	}()
+}
+
+func done(kind string) {
+	mu.Lock()
+	defer mu.Unlock()
+	running[kind]--
+	if running[kind] == 0 {
+		delete(running, kind)
+	}
+}
+
+// Running returns the number of goroutines started by Go() which are still
+// running, by kind
+func Running() map[string]int {
+	mu.Lock()
+	defer mu.Unlock()
+	counts := make(map[string]int, len(running))
+	for kind, count := range running {
+		counts[kind] = count
+	}
+	return counts
+}
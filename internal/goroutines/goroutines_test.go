@@ -0,0 +1,25 @@
+package goroutines
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGo(t *testing.T) {
+	stop := make(chan struct{})
+	stopped := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		Go("test", func() {
+			<-stop
+			stopped <- struct{}{}
+		})
+	}
+	assert.Equal(t, map[string]int{"test": 2}, Running())
+
+	close(stop)
+	<-stopped
+	<-stopped
+	assert.Eventually(t, func() bool { return len(Running()) == 0 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,193 @@
+package rmq
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+)
+
+// journalCompactLines is how many lines the buffer journal may have beyond
+// the currently buffered deliveries before it gets rewritten
+const journalCompactLines = 1000
+
+// bufferJournal records which prefetched deliveries haven't been passed to
+// consumers yet, see WithBufferJournal(). It's an append-only file starting
+// with the connection name, followed by lines adding ("+") or removing ("-")
+// JSON encoded payloads.
+type bufferJournal struct {
+	path           string
+	mu             sync.Mutex // protects the fields below
+	connectionName string     // connection consuming the buffered deliveries
+	file           *os.File
+	buffered       map[string]int // number of buffered deliveries by payload
+	lines          int            // number of lines in file
+}
+
+// WithBufferJournal makes consumers record in the file at path which
+// prefetched deliveries haven't been passed to consumers yet. If the process
+// crashes, those deliveries stay unacked in its dead connection until the
+// cleaner returns them. Instead, StartConsuming() reads the journal left by
+// the previous process and immediately reclaims exactly those deliveries, so
+// they get consumed before any ready ones. Deliveries which were being
+// consumed when the process crashed are still left to the cleaner. Use one
+// file per queue and process and don't share it between processes running at
+// the same time. Each prefetched delivery costs two writes to the file.
+func WithBufferJournal(path string) QueueOption {
+	return func(queue *redisQueue) {
+		queue.journal = &bufferJournal{path: path}
+	}
+}
+
+// reclaimJournal hands off the deliveries buffered by the previous process
+// to this connection and starts a new journal. Called by StartConsuming().
+func (queue *redisQueue) reclaimJournal() (reclaimed int64, err error) {
+	journal := queue.journal
+	connectionName, buffered, err := readJournal(journal.path)
+	if err != nil {
+		return 0, err
+	}
+
+	if connectionName != "" && connectionName != queue.connectionName {
+		unackedKey := strings.Replace(connectionQueueUnackedTemplate, phConnection, connectionName, 1)
+		unackedKey = strings.Replace(unackedKey, phQueue, queue.name, 1)
+		for payload, count := range buffered {
+			for i := 0; i < count; i++ {
+				affected, err := queue.redisClient.LRemLPush(unackedKey, payload, queue.handoffKey, payload)
+				if err != nil {
+					return reclaimed, err
+				}
+				reclaimed += affected
+			}
+		}
+	}
+	if reclaimed > 0 {
+		queue.options.logf(LogInfo, "rmq queue reclaimed journaled deliveries %s %s %d", queue, connectionName, reclaimed)
+	}
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	journal.connectionName = queue.connectionName
+	journal.buffered = map[string]int{}
+	return reclaimed, journal.rewrite()
+}
+
+// journalAdd records that the delivery got buffered, see WithBufferJournal()
+func (queue *redisQueue) journalAdd(delivery *redisDelivery) {
+	if queue.journal == nil {
+		return
+	}
+	if err := queue.journal.add(delivery.payload); err != nil {
+		queue.options.logf(LogInfo, "rmq queue failed to write buffer journal %s: %s", queue, err)
+	}
+}
+
+// journalRemove records that the delivery left the buffer, either passed to a
+// consumer or moved out of it (like getting stolen or returned), see
+// WithBufferJournal()
+func (queue *redisQueue) journalRemove(delivery Delivery) {
+	redisDelivery, ok := delivery.(*redisDelivery)
+	if queue.journal == nil || !ok {
+		return
+	}
+	if err := queue.journal.remove(redisDelivery.payload); err != nil {
+		queue.options.logf(LogInfo, "rmq queue failed to write buffer journal %s: %s", queue, err)
+	}
+}
+
+// add records that the delivery with the given payload got buffered
+func (journal *bufferJournal) add(payload string) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	journal.buffered[payload]++
+	return journal.write('+', payload)
+}
+
+// remove records that the delivery with the given payload left the buffer
+func (journal *bufferJournal) remove(payload string) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	if journal.buffered[payload]--; journal.buffered[payload] <= 0 {
+		delete(journal.buffered, payload)
+	}
+	if journal.lines > journalCompactLines+len(journal.buffered) {
+		// keep the file from growing with the lines of long gone deliveries
+		return journal.rewrite()
+	}
+	return journal.write('-', payload)
+}
+
+// write appends a line. The caller must hold the lock.
+func (journal *bufferJournal) write(op byte, payload string) error {
+	line, _ := json.Marshal(payload) // can't fail for strings
+	if _, err := journal.file.Write(append(append([]byte{op}, line...), '\n')); err != nil {
+		return err
+	}
+	journal.lines++
+	return nil
+}
+
+// rewrite replaces the journal by one holding only the buffered deliveries.
+// The caller must hold the lock.
+func (journal *bufferJournal) rewrite() error {
+	if journal.file != nil {
+		journal.file.Close()
+	}
+
+	tmpPath := journal.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	journal.file, journal.lines = file, 0
+	if err := journal.write('=', journal.connectionName); err != nil {
+		return err
+	}
+	for payload, count := range journal.buffered {
+		for i := 0; i < count; i++ {
+			if err := journal.write('+', payload); err != nil {
+				return err
+			}
+		}
+	}
+	// file stays open for appending after getting renamed
+	return os.Rename(tmpPath, journal.path)
+}
+
+// readJournal returns the connection name and buffered deliveries recorded
+// in the journal file
+func readJournal(path string) (connectionName string, buffered map[string]int, err error) {
+	buffered = map[string]int{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", buffered, nil
+	}
+	if err != nil {
+		return "", buffered, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<30) // allow large payloads
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var value string
+		if len(line) == 0 || json.Unmarshal(line[1:], &value) != nil {
+			continue // partially written line of a crashed process
+		}
+		switch line[0] {
+		case '=':
+			connectionName = value
+		case '+':
+			buffered[value]++
+		case '-':
+			if buffered[value]--; buffered[value] <= 0 {
+				delete(buffered, value)
+			}
+		}
+	}
+	return connectionName, buffered, scanner.Err()
+}
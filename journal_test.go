@@ -0,0 +1,130 @@
+package rmq
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmq-journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal-q")
+
+	crashedConn, err := OpenConnection("journal-crashed", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	crashedQueue, err := crashedConn.OpenQueue("journal-q", WithBufferJournal(path))
+	assert.NoError(t, err)
+	_, err = crashedQueue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, crashedQueue.StartConsuming(3, time.Millisecond))
+	block := make(chan struct{})
+	consumed := make(chan string, 10)
+	_, err = crashedQueue.AddConsumerFunc("journal-cons", func(delivery Delivery) {
+		consumed <- delivery.Payload()
+		<-block
+	})
+	assert.NoError(t, err)
+
+	// first delivery gets consumed (not acked), the others stay buffered
+	assert.NoError(t, crashedQueue.Publish("journal-d1", "journal-d2", "journal-d3"))
+	time.Sleep(10 * time.Millisecond)
+	finished := crashedQueue.StopConsuming() // leaves deliveries unacked like a crash
+	close(block)
+	<-finished
+	assert.NoError(t, crashedConn.stopHeartbeat())
+	assert.Equal(t, "journal-d1", <-consumed)
+	assert.Len(t, consumed, 0)
+	_, buffered, err := readJournal(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"journal-d2": 1, "journal-d3": 1}, buffered)
+
+	// restarted process reclaims buffered deliveries and consumes them first
+	assert.NoError(t, crashedQueue.Publish("journal-d4"))
+	restartedConn, err := OpenConnection("journal-restarted", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	restartedQueue, err := restartedConn.OpenQueue("journal-q", WithBufferJournal(path))
+	assert.NoError(t, err)
+	assert.NoError(t, restartedQueue.StartConsuming(10, time.Millisecond))
+	restartedConsumer := NewTestConsumer("journal-cons")
+	_, err = restartedQueue.AddConsumer("journal-cons", restartedConsumer)
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	require.Len(t, restartedConsumer.LastDeliveries, 3)
+	assert.Equal(t, "journal-d4", restartedConsumer.LastDeliveries[2].Payload())
+	count, err := crashedQueue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count) // consumed one is left to the cleaner
+	_, buffered, err = readJournal(path)
+	assert.NoError(t, err)
+	assert.Empty(t, buffered)
+
+	<-restartedQueue.StopConsuming()
+	assert.NoError(t, restartedConn.stopHeartbeat())
+	_, err = NewCleaner(restartedConn).Clean()
+	assert.NoError(t, err)
+}
+
+func TestBufferJournalReturned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmq-journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal-returned-q")
+
+	connection, err := OpenConnection("journal-returned", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("journal-returned-q", WithBufferJournal(path), WithStopPolicy(ReturnOnStop))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	// one delivery gets consumed, the others stay buffered
+	assert.NoError(t, queue.Publish("journal-r1", "journal-r2", "journal-r3", "journal-r4"))
+	// returned deliveries don't get fetched again before consuming stops
+	assert.NoError(t, queue.StartConsuming(4, time.Hour))
+	block := make(chan struct{})
+	_, err = queue.AddConsumerFunc("journal-cons", func(delivery Delivery) {
+		<-block
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, buffered, err := readJournal(path)
+	assert.NoError(t, err)
+	assert.Len(t, buffered, 3)
+
+	// returning buffered deliveries removes them from the journal
+	returned, err := queue.(*redisQueue).returnBuffered(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, returned)
+	_, buffered, err = readJournal(path)
+	assert.NoError(t, err)
+	assert.Len(t, buffered, 2)
+
+	// and handing them off to an idle connection
+	handoffKey := queueHandoffKey("journal-idle", "journal-returned-q")
+	assert.NoError(t, queue.(*redisQueue).handoffBuffered(handoffKey, 1))
+	_, buffered, err = readJournal(path)
+	assert.NoError(t, err)
+	assert.Len(t, buffered, 1)
+	_, err = connection.(*redisConnection).redisClient.Del(handoffKey)
+	assert.NoError(t, err)
+
+	// and returning them when consuming stops
+	finished := queue.StopConsuming()
+	close(block)
+	<-finished
+	_, buffered, err = readJournal(path)
+	assert.NoError(t, err)
+	assert.Empty(t, buffered)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,176 @@
+package rmq
+
+import (
+	"strings"
+	"time"
+)
+
+// markerCheckInterval is the min duration between two loads of the unacked
+// deliveries while markers are held back, at least the poll duration
+const markerCheckInterval = 100 * time.Millisecond
+
+// heldMarker is a marker delivery a consumer fetched, but holds back until
+// the deliveries which were unacked when it got fetched are handled
+type heldMarker struct {
+	delivery *redisDelivery
+	earlier  map[string]int // counts by payload, nil until the unacked deliveries got loaded
+}
+
+// PublishMarker publishes a marker delivery with the given name to the
+// queue. Consumers added after StartConsuming() only get it after all
+// deliveries published before it got acked, rejected or pushed, so handling
+// it can signal downstream batch jobs that everything up to the marker has
+// been processed (like "day N fully processed"). Read the name via
+// Header.Marker(), the payload of markers is their name too. Markers bypass
+// the frozen policy and the codecs of the queue.
+// NOTE: deliveries which got returned to ready (for example by the cleaner
+// after their connection died) or delayed meanwhile don't hold a marker
+// back. ConsumeOne() and Deliveries() don't hold markers back either.
+func (queue *redisQueue) PublishMarker(name string) error {
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+	_, err := queue.redisClient.LPush(queue.readyKey, encodeHeader(Header{HeaderMarker: name}, name))
+	return err
+}
+
+// Marker returns the name of the marker if the header belongs to a marker
+// delivery, see Queue.PublishMarker()
+func (header Header) Marker() (name string, ok bool) {
+	name, ok = header[HeaderMarker]
+	return name, ok
+}
+
+// holdMarker holds the delivery back if it's a marker and returns whether
+// it did, see releaseMarkers()
+func (queue *redisQueue) holdMarker(delivery *redisDelivery) bool {
+	if _, ok := delivery.header.Marker(); !ok {
+		return false
+	}
+
+	marker := &heldMarker{delivery: delivery}
+	// the marker just got fetched, so all other unacked ones are earlier. If
+	// loading them fails releaseMarkers() tries again, then later deliveries
+	// might hold the marker back too.
+	if unacked, err := queue.unackedPayloads(); err == nil {
+		marker.setEarlier(unacked)
+	}
+	queue.markers = append(queue.markers, marker)
+	queue.journalAdd(delivery) // held markers count as buffered
+	return true
+}
+
+func (marker *heldMarker) setEarlier(unacked map[string]int) {
+	marker.earlier = make(map[string]int, len(unacked))
+	for payload, count := range unacked {
+		marker.earlier[payload] = count
+	}
+	marker.earlier[marker.delivery.payload]--
+}
+
+// releaseMarkers passes the held markers on to the consumers once none of
+// the deliveries which were unacked when they got fetched are unacked
+// anymore. Loading the unacked deliveries reads the unacked lists of all
+// connections, so it happens at most once per markerCheckInterval and only
+// while some marker still waits for them. Only called by the consume
+// goroutine.
+func (queue *redisQueue) releaseMarkers() error {
+	if len(queue.markers) == 0 {
+		return nil
+	}
+
+	var unacked map[string]int
+	if queue.markersWaiting() && time.Since(queue.markersChecked) >= queue.markerCheckInterval() {
+		var err error
+		if unacked, err = queue.unackedPayloads(); err != nil {
+			return err
+		}
+		queue.markersChecked = time.Now()
+	}
+
+	held := queue.markers[:0]
+	for _, marker := range queue.markers {
+		if unacked != nil {
+			if marker.earlier == nil {
+				marker.setEarlier(unacked)
+			}
+			for payload, count := range marker.earlier {
+				if unacked[payload] < count {
+					count = unacked[payload]
+				}
+				if count <= 0 {
+					delete(marker.earlier, payload)
+				} else {
+					marker.earlier[payload] = count
+				}
+			}
+		}
+
+		if marker.earlier == nil || len(marker.earlier) > 0 {
+			held = append(held, marker)
+			continue
+		}
+		select {
+		case queue.deliveryChan <- marker.delivery:
+		default: // buffer full, try again later
+			held = append(held, marker)
+		}
+	}
+	queue.markers = held
+	return nil
+}
+
+// markersWaiting returns whether some held marker still waits for earlier
+// deliveries, or for them to get loaded
+func (queue *redisQueue) markersWaiting() bool {
+	for _, marker := range queue.markers {
+		if marker.earlier == nil || len(marker.earlier) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (queue *redisQueue) markerCheckInterval() time.Duration {
+	if queue.pollDuration > markerCheckInterval {
+		return queue.pollDuration
+	}
+	return markerCheckInterval
+}
+
+// unackedPayloads returns the deliveries of this queue which are unacked or
+// handed off on any connection, as counts by payload
+func (queue *redisQueue) unackedPayloads() (map[string]int, error) {
+	connectionNames, err := scanMembers(queue.redisClient, connectionsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := map[string]int{}
+	for _, connectionName := range connectionNames {
+		unackedKey := strings.Replace(connectionQueueUnackedTemplate, phConnection, connectionName, 1)
+		unackedKey = strings.Replace(unackedKey, phQueue, queue.name, 1)
+		for _, key := range []string{unackedKey, queueHandoffKey(connectionName, queue.name)} {
+			values, err := queue.redisClient.LRange(key, 0, -1)
+			if err != nil {
+				return nil, err
+			}
+			for _, payload := range values {
+				payloads[payload]++
+			}
+		}
+	}
+	return payloads, nil
+}
+
+// returnMarkers returns the held markers to ready, see ReturnOnStop
+func (queue *redisQueue) returnMarkers() error {
+	for len(queue.markers) > 0 {
+		if err := queue.returnDelivery(queue.markers[0].delivery); err != nil {
+			return err
+		}
+		queue.journalRemove(queue.markers[0].delivery)
+		queue.markers = queue.markers[1:]
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package rmq
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishMarker(t *testing.T) {
+	otherConnection, err := OpenConnection("marker-other-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queueName := "marker-q-" + RandomString(6) // unacked deliveries of earlier runs would hold markers back
+	otherQueue, err := otherConnection.OpenQueue(queueName)
+	require.NoError(t, err)
+
+	// an earlier delivery being consumed on another connection
+	assert.NoError(t, otherQueue.Publish("marker-e0"))
+	earlier, err := otherQueue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+
+	connection, err := OpenConnection("marker-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue(queueName, WithStopPolicy(ReturnOnStop))
+	require.NoError(t, err)
+	assert.NoError(t, queue.Publish("marker-d1"))
+	assert.NoError(t, queue.PublishMarker("day-1"))
+	assert.NoError(t, queue.Publish("marker-d2"))
+
+	deliveries := make(chan Delivery, 10)
+	require.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumerFunc("marker-cons", func(delivery Delivery) {
+		deliveries <- delivery // acked by the test below
+	})
+	require.NoError(t, err)
+
+	next := func() Delivery {
+		select {
+		case delivery := <-deliveries:
+			return delivery
+		case <-time.After(time.Second):
+			t.Fatal("no delivery")
+			return nil
+		}
+	}
+	none := func() {
+		select {
+		case delivery := <-deliveries:
+			t.Fatalf("unexpected delivery %s", delivery.Payload())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	d1, d2 := next(), next()
+	assert.Equal(t, "marker-d1", d1.Payload())
+	assert.Equal(t, "marker-d2", d2.Payload()) // later deliveries don't wait
+	none()
+
+	assert.NoError(t, d1.Ack())
+	none() // still waiting for the one on the other connection
+	assert.NoError(t, earlier.Reject())
+
+	marker := next()
+	name, ok := marker.Header().Marker()
+	assert.True(t, ok)
+	assert.Equal(t, "day-1", name)
+	assert.Equal(t, "day-1", marker.Payload())
+	assert.NoError(t, marker.Ack())
+	assert.NoError(t, d2.Ack())
+
+	_, ok = d2.Header().Marker()
+	assert.False(t, ok)
+
+	// held markers get returned on stop
+	assert.NoError(t, queue.Publish("marker-d3"))
+	assert.NoError(t, queue.PublishMarker("day-2"))
+	d3 := next()
+	none()
+	<-queue.StopConsuming()
+	assert.NoError(t, d3.Ack())
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "day-2", messages[0].Payload)
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+}
+
+func TestTestQueuePublishMarker(t *testing.T) {
+	queue := NewTestQueue("marker-test-q")
+	assert.NoError(t, queue.PublishMarker("day-1"))
+	assert.Equal(t, []string{"day-1"}, queue.LastDeliveries)
+	name, ok := queue.LastHeaders[0].Marker()
+	assert.True(t, ok)
+	assert.Equal(t, "day-1", name)
+}
+
+func TestMarkerJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmq-journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "marker-journal-q")
+
+	connection, err := OpenConnection("marker-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("marker-journal-q-"+RandomString(6), WithBufferJournal(path))
+	require.NoError(t, err)
+	redisQueue := queue.(*redisQueue)
+	_, err = redisQueue.reclaimJournal() // starts the journal
+	require.NoError(t, err)
+
+	redisQueue.deliveryChan = make(chan Delivery, 1)
+	redisQueue.deliveryChan <- redisQueue.newDelivery("marker-j1") // buffer full
+	require.True(t, redisQueue.holdMarker(redisQueue.newDelivery(encodeHeader(Header{HeaderMarker: "j"}, "j"))))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, redisQueue.releaseMarkers())
+	}
+	assert.Len(t, redisQueue.markers, 1) // held while the buffer is full
+
+	<-redisQueue.deliveryChan
+	assert.NoError(t, redisQueue.releaseMarkers())
+	assert.Empty(t, redisQueue.markers)
+	redisQueue.journalRemove(<-redisQueue.deliveryChan) // passed to a consumer
+
+	// the marker got journaled once despite the retries
+	_, buffered, err := readJournal(path)
+	assert.NoError(t, err)
+	assert.Empty(t, buffered)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+// scanCountingClient counts the scans of the connections set
+type scanCountingClient struct {
+	RedisClient
+	scans *int
+}
+
+func (client scanCountingClient) SScan(key string, cursor uint64, count int64) ([]string, uint64, error) {
+	if key == connectionsKey {
+		*client.scans++
+	}
+	return client.RedisClient.SScan(key, cursor, count)
+}
+
+func TestMarkerChecks(t *testing.T) {
+	var scans int
+	redisClient := scanCountingClient{RedisClient: NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})), scans: &scans}
+	connection, err := OpenConnectionWithOptions("marker-checks-conn", redisClient, nil, TestOptions)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("marker-checks-q-" + RandomString(6))
+	require.NoError(t, err)
+	redisQueue := queue.(*redisQueue)
+	redisQueue.pollDuration = time.Millisecond
+	redisQueue.deliveryChan = make(chan Delivery, 1)
+
+	assert.NoError(t, queue.Publish("marker-c1"))
+	earlier, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	require.True(t, redisQueue.holdMarker(redisQueue.newDelivery(encodeHeader(Header{HeaderMarker: "c"}, "c"))))
+
+	// the unacked deliveries get loaded at most once per check interval
+	scans = 0
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, redisQueue.releaseMarkers())
+	}
+	assert.Equal(t, 1, scans)
+	assert.Len(t, redisQueue.markers, 1)
+
+	assert.NoError(t, earlier.Ack())
+	time.Sleep(markerCheckInterval)
+	assert.NoError(t, redisQueue.releaseMarkers())
+	assert.Equal(t, 2, scans)
+	assert.Empty(t, redisQueue.markers)
+	assert.Len(t, redisQueue.deliveryChan, 1)
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,55 @@
+package rmq
+
+import "strconv"
+
+// WithMigrations makes consumers upgrade deliveries to the latest payload
+// version before consuming them. Each migration upgrades payloads of one
+// version to the next: the first one from version 1 to 2, the second one from
+// 2 to 3 and so on. The version is taken from HeaderVersion, deliveries
+// without it are version 1. Publish() sets the header to the latest version,
+// so producers must produce payloads of that version. This way consumers can
+// get upgraded before producers, without checking versions in their handlers.
+// Deliveries of versions newer than the latest get rejected and reported as
+// DecodeError, see WithCodecs().
+func WithMigrations(migrations ...Transform) QueueOption {
+	latest := len(migrations) + 1
+	return WithCodecs(Codec{
+		Encode: func(header Header, payload string) (Header, string, error) {
+			header[HeaderVersion] = strconv.Itoa(latest)
+			return header, payload, nil
+		},
+		Decode: func(header Header, payload string) (Header, string, error) {
+			version, err := header.version()
+			if err != nil {
+				return header, payload, err
+			}
+			if version > latest {
+				return header, payload, ErrorVersionUnknown
+			}
+
+			for ; version < latest; version++ {
+				if header, payload, err = migrations[version-1](header, payload); err != nil {
+					return header, payload, err
+				}
+			}
+			if header == nil {
+				header = Header{}
+			}
+			header[HeaderVersion] = strconv.Itoa(latest)
+			return header, payload, nil
+		},
+	})
+}
+
+// version returns the payload version, see WithMigrations()
+func (header Header) version() (int, error) {
+	value, ok := header[HeaderVersion]
+	if !ok {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, ErrorVersionUnknown
+	}
+	return version, nil
+}
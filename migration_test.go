@@ -0,0 +1,64 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("migration-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+	upgradeV1 := func(header Header, payload string) (Header, string, error) {
+		return header, strings.ToUpper(payload), nil // v2 is upper case
+	}
+	upgradeV2 := func(header Header, payload string) (Header, string, error) {
+		return header, payload + "!", nil // v3 is exclaimed
+	}
+	oldQueue, err := connection.OpenQueue("migration-q", WithMigrations(upgradeV1))
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("migration-q", WithMigrations(upgradeV1, upgradeV2))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	plainQueue, err := connection.OpenQueue("migration-q")
+	assert.NoError(t, err)
+	assert.NoError(t, plainQueue.Publish("v1"))
+	assert.NoError(t, oldQueue.Publish("V2"))
+	assert.NoError(t, queue.Publish("V3!"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, expected := range []string{"V1!", "V2!", "V3!"} {
+		delivery, err := queue.ConsumeOne(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, expected, delivery.Payload())
+		assert.Equal(t, "3", delivery.Header()[HeaderVersion])
+		assert.NoError(t, delivery.Ack())
+	}
+
+	// old consumers reject newer versions
+	assert.NoError(t, queue.Publish("V3!"))
+	assert.NoError(t, plainQueue.Publish("done"))
+	delivery, err := oldQueue.ConsumeOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "DONE", delivery.Payload())
+	assert.NoError(t, delivery.Ack())
+	decodeErr := &DecodeError{}
+	require.True(t, errors.As(<-errChan, &decodeErr))
+	assert.Equal(t, ErrorVersionUnknown, decodeErr.Err)
+	count, err := queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
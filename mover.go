@@ -0,0 +1,55 @@
+package rmq
+
+import "time"
+
+// MoveFunc transforms the payload of a delivery for the next queue. If it
+// returns an error the delivery gets rejected.
+type MoveFunc func(delivery Delivery) (payload string, err error)
+
+// Mover is a Consumer which moves deliveries to another queue after
+// transforming them, for building pipelines of queues. Acking a delivery and
+// publishing its transformed payload happen atomically, so no delivery gets
+// lost or duplicated if the process crashes in between. If a delivery has an
+// idempotency key in its header (see HeaderIdempotencyKey) only the first
+// delivery with that key within the idempotency TTL gets published, later ones
+// just get acked. Moving to queues opened with WithStream(), or moving
+// deliveries with idempotency key from them, isn't supported, such deliveries
+// get rejected.
+type Mover struct {
+	to             *redisQueue
+	move           MoveFunc
+	idempotencyTTL time.Duration
+}
+
+// NewMover returns a mover publishing to the given queue. Idempotency keys
+// are remembered for idempotencyTTL, zero means forever.
+// NOTE: panics if to is not opened via a redis connection, in a redis cluster
+// the consumed queue and to must live on the same node
+func NewMover(to Queue, move MoveFunc, idempotencyTTL time.Duration) *Mover {
+	return &Mover{
+		to:             to.(*redisQueue),
+		move:           move,
+		idempotencyTTL: idempotencyTTL,
+	}
+}
+
+// Consume moves the delivery, redis errors get reported to the error channel
+// of the connection (see Delivery.Ack())
+func (mover *Mover) Consume(delivery Delivery) {
+	payload, err := mover.move(delivery)
+	if err != nil {
+		delivery.Reject()
+		return
+	}
+
+	redisDelivery, ok := delivery.(*redisDelivery)
+	idempotencyKey := delivery.Header()[HeaderIdempotencyKey]
+	if !ok || idempotencyKey == "" {
+		err = delivery.AckAndPublish(mover.to, payload)
+	} else {
+		err = redisDelivery.ackAndPublishOnce(mover.to, payload, idempotencyKey, mover.idempotencyTTL)
+	}
+	if err == ErrorStreamMixed {
+		delivery.Reject()
+	}
+}
@@ -0,0 +1,66 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMover(t *testing.T) {
+	connection, err := OpenConnection("mover-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	from, err := connection.OpenQueue("mover-from")
+	assert.NoError(t, err)
+	to, err := connection.OpenQueue("mover-to")
+	assert.NoError(t, err)
+	for _, q := range []Queue{from, to} {
+		_, err = q.PurgeReady()
+		assert.NoError(t, err)
+		_, err = q.PurgeRejected()
+		assert.NoError(t, err)
+	}
+
+	mover := NewMover(to, func(delivery Delivery) (string, error) {
+		if delivery.Payload() == "fail" {
+			return "", errors.New("can't move")
+		}
+		return strings.ToUpper(delivery.Payload()), nil
+	}, time.Minute)
+
+	header := Header{HeaderIdempotencyKey: RandomString(8)}
+	assert.NoError(t, from.PublishWithHeader(header, "a"))
+	assert.NoError(t, from.PublishWithHeader(header, "a")) // duplicate
+	assert.NoError(t, from.Publish("b", "fail"))
+
+	assert.NoError(t, from.StartConsuming(10, time.Millisecond))
+	_, err = from.AddConsumer("mover-cons", mover)
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	<-from.StopConsuming()
+
+	count, err := from.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = from.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	count, err = to.readyCount()
+	assert.NoError(t, err)
+	require.Equal(t, int64(2), count)
+	delivery, err := to.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "A", delivery.Payload())
+	assert.Equal(t, header, delivery.Header()) // idempotency key gets passed on
+	delivery, err = to.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "B", delivery.Payload())
+	assert.Nil(t, delivery.Header())
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,262 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+var ErrorUnknownProfile = errors.New("unknown profile")
+
+// Options configure a connection and the queues opened on it. Use one of the
+// profiles below as a starting point and adjust as needed.
+type Options struct {
+	PrefetchLimit int64         // used by StartConsuming() if zero is passed
+	PollDuration  time.Duration // used by StartConsuming() if zero is passed
+	RetryInterval time.Duration // how long Ack() and similar wait before retrying after redis errors
+
+	// MaxConcurrency limits how many deliveries (or batches) get consumed at
+	// the same time across all queues of the connection, zero means no limit.
+	// This bounds the resources of a service consuming many queues, no matter
+	// how many consumers each queue has.
+	MaxConcurrency int
+	concurrency    *slots // shared by all queues of the connection, nil without limit
+
+	// NOTE: Be careful when changing any of these values. By default we update
+	// the heartbeat every second with a TTL of a minute. This means that if we
+	// fail to update the heartbeat 60 times in a row the connection might get
+	// cleaned up by a cleaner. So we want to set the error limit to a value
+	// lower like this (like 45) to make sure we stop all consuming before that
+	// happens.
+	HeartbeatDuration   time.Duration // TTL of heartbeat key
+	HeartbeatInterval   time.Duration // how often we update the heartbeat key
+	HeartbeatErrorLimit int           // stop consuming after this many heartbeat errors
+
+	// ShutdownTimeout is how long RunUntilSignal() waits for consumers to
+	// finish their current deliveries before giving up
+	ShutdownTimeout time.Duration
+
+	LogLevel LogLevel
+	Logger   Logger // used if LogLevel is not LogSilent, defaults to stderr
+
+	// Redact gets applied to the payloads returned by PeekReady() and
+	// PeekRejected(), so tools browsing queues don't expose sensitive data
+	// like PII to operators. Nil returns payloads as they are. See
+	// RedactPayload().
+	Redact func(payload string) string
+
+	// Operations restricts which destructive operations queues opened on the
+	// connection may perform, see OperationPolicy
+	Operations OperationPolicy
+
+	// RestrictedCommands makes the connection avoid commands and deletions
+	// of shared keys which a redis user limited to RestrictedACL can't or
+	// shouldn't run. Operations which would need them return a
+	// RestrictedCommandError instead.
+	RestrictedCommands bool
+
+	// CheckCapabilities makes opening the connection run each redis
+	// operation rmq uses once on probe keys, so redis users lacking
+	// permissions or providers lacking commands (like Lua scripts) fail
+	// right away with a CapabilityError listing all refused commands,
+	// instead of at the first publish, consume or ack
+	CheckCapabilities bool
+
+	// UseFunctions makes connections opened with a redis client (not a
+	// custom RedisClient) load rmq's atomic operations as a function library
+	// once per rmq version and call them via FCALL instead of evaluating Lua
+	// scripts. Falls back to scripts if redis doesn't support functions
+	// (before 7.0) or the user isn't allowed to load them.
+	UseFunctions bool
+
+	// BigKeyLimit is the max number of deliveries a single list of a queue
+	// (like its ready list) should hold, 0 means no limit. Huge keys make
+	// failovers and RDB saves slow and risky, so publishing beyond the limit
+	// reports a *BigKeyError to errChan and logs it, once until the list got
+	// short enough again. Stats list such keys in QueueStat.BigKeys. Spread
+	// the deliveries over several queues instead, see WithOldestFirst().
+	// NOTE: costs an extra LLEN per publish
+	BigKeyLimit int64
+
+	// RefuseBigKeys makes publishing beyond BigKeyLimit return the
+	// *BigKeyError instead of publishing the deliveries
+	RefuseBigKeys bool
+
+	// ConnectionBudget is the max number of redis connections the queues of
+	// the connection keep busy at the same time, 0 means no budget. Usually
+	// the PoolSize of the redis client. The consume loops of all queues take
+	// turns polling redis, at most ConnectionBudget at a time, so consuming
+	// many queues doesn't need a redis connection per queue. As the queues
+	// beyond the budget wait for each other's polls, OpenQueue() reports a
+	// *ConnectionBudgetError to errChan and logs it once more queues are open
+	// than the budget.
+	ConnectionBudget int
+	polls            *slots // turns of the consume loops, nil without budget
+
+	// RefuseOverBudget makes OpenQueue() return the *ConnectionBudgetError
+	// instead of opening more queues than ConnectionBudget
+	RefuseOverBudget bool
+
+	// Context makes the connection stop consuming on all its queues (see
+	// Connection.StopAllConsuming()) once it's done, so consumers exit
+	// promptly. The heartbeat keeps running until the connection gets closed,
+	// so in-flight deliveries can still be acked. The application may stop
+	// consuming concurrently, both wait for the same consumers to finish.
+	Context context.Context
+
+	// QueueOptions get applied to all queues opened on the connection, before
+	// the options passed to OpenQueue()
+	QueueOptions []QueueOption
+}
+
+// Profile is the name of a set of options
+type Profile string
+
+const (
+	ProfileDevelopment Profile = "development"
+	ProfileProduction  Profile = "production"
+	ProfileTest        Profile = "test"
+)
+
+var (
+	// ProductionOptions are used by all OpenConnection() functions which don't
+	// take options
+	ProductionOptions = Options{
+		PrefetchLimit:       100,
+		PollDuration:        time.Second,
+		RetryInterval:       time.Second,
+		HeartbeatDuration:   heartbeatDuration,
+		HeartbeatInterval:   heartbeatInterval,
+		HeartbeatErrorLimit: HeartbeatErrorLimit,
+		ShutdownTimeout:     30 * time.Second,
+		LogLevel:            LogSilent,
+	}
+
+	// DevelopmentOptions use small buffers and log lifecycle events
+	DevelopmentOptions = Options{
+		PrefetchLimit:       10,
+		PollDuration:        100 * time.Millisecond,
+		RetryInterval:       time.Second,
+		HeartbeatDuration:   heartbeatDuration,
+		HeartbeatInterval:   heartbeatInterval,
+		HeartbeatErrorLimit: HeartbeatErrorLimit,
+		ShutdownTimeout:     5 * time.Second,
+		LogLevel:            LogDebug,
+	}
+
+	// TestOptions poll and retry quickly to keep tests fast
+	TestOptions = Options{
+		PrefetchLimit:       10,
+		PollDuration:        time.Millisecond,
+		RetryInterval:       10 * time.Millisecond,
+		HeartbeatDuration:   heartbeatDuration,
+		HeartbeatInterval:   heartbeatInterval,
+		HeartbeatErrorLimit: HeartbeatErrorLimit,
+		ShutdownTimeout:     time.Second,
+		LogLevel:            LogSilent,
+	}
+)
+
+// ProfileOptions returns the options of the given profile. This is useful to
+// select the options via configuration, like an environment variable.
+func ProfileOptions(profile Profile) (Options, error) {
+	switch profile {
+	case ProfileDevelopment:
+		return DevelopmentOptions, nil
+	case ProfileProduction:
+		return ProductionOptions, nil
+	case ProfileTest:
+		return TestOptions, nil
+	default:
+		return Options{}, ErrorUnknownProfile
+	}
+}
+
+// LogLevel defines how much rmq logs
+type LogLevel int
+
+const (
+	LogSilent LogLevel = iota // don't log at all
+	LogInfo                   // log noteworthy events, like reaching the heartbeat error limit
+	LogDebug                  // also log lifecycle events, like starting to consume
+)
+
+// Logger is implemented by *log.Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+var defaultLogger = log.New(os.Stderr, "", log.LstdFlags)
+
+func (options Options) logf(level LogLevel, format string, v ...interface{}) {
+	if level > options.LogLevel {
+		return
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+	logger.Printf(format, v...)
+}
+
+// withDefaults returns a copy of the options with all unset fields set to the
+// production defaults
+func (options Options) withDefaults() Options {
+	if options.PrefetchLimit == 0 {
+		options.PrefetchLimit = ProductionOptions.PrefetchLimit
+	}
+	if options.PollDuration == 0 {
+		options.PollDuration = ProductionOptions.PollDuration
+	}
+	if options.RetryInterval == 0 {
+		options.RetryInterval = ProductionOptions.RetryInterval
+	}
+	if options.HeartbeatDuration == 0 {
+		options.HeartbeatDuration = ProductionOptions.HeartbeatDuration
+	}
+	if options.HeartbeatInterval == 0 {
+		options.HeartbeatInterval = ProductionOptions.HeartbeatInterval
+	}
+	if options.HeartbeatErrorLimit == 0 {
+		options.HeartbeatErrorLimit = ProductionOptions.HeartbeatErrorLimit
+	}
+	if options.ShutdownTimeout == 0 {
+		options.ShutdownTimeout = ProductionOptions.ShutdownTimeout
+	}
+	return options
+}
+
+// OperationPolicy forbids destructive operations on all queues of a
+// connection. Forbidden operations return ErrorForbidden without touching
+// redis. Use it for connections of services which only publish or consume,
+// so they can't wipe queues, even by accident. The zero value allows all
+// operations.
+type OperationPolicy struct {
+	ForbidPurgeReady    bool // Queue.PurgeReady()
+	ForbidPurgeRejected bool // Queue.PurgeRejected()
+	ForbidDestroy       bool // Queue.Destroy()
+}
+
+// ForbidDestructive forbids all destructive operations
+var ForbidDestructive = OperationPolicy{
+	ForbidPurgeReady:    true,
+	ForbidPurgeRejected: true,
+	ForbidDestroy:       true,
+}
+
+// RedactPayload replaces the payload by a placeholder only mentioning its
+// size. Use it as Options.Redact to hide all payloads.
+func RedactPayload(payload string) string {
+	return fmt.Sprintf("[redacted %d bytes]", len(payload))
+}
+
+// redact applies Redact to the payload, if set
+func (options Options) redact(payload string) string {
+	if options.Redact == nil {
+		return payload
+	}
+	return options.Redact(payload)
+}
@@ -0,0 +1,274 @@
+package rmq
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileOptions(t *testing.T) {
+	options, err := ProfileOptions(ProfileTest)
+	assert.NoError(t, err)
+	assert.Equal(t, TestOptions, options)
+	options, err = ProfileOptions(ProfileDevelopment)
+	assert.NoError(t, err)
+	assert.Equal(t, DevelopmentOptions, options)
+	options, err = ProfileOptions(ProfileProduction)
+	assert.NoError(t, err)
+	assert.Equal(t, ProductionOptions, options)
+	_, err = ProfileOptions("staging")
+	assert.Equal(t, ErrorUnknownProfile, err)
+
+	options = Options{PollDuration: time.Millisecond}.withDefaults()
+	assert.Equal(t, time.Millisecond, options.PollDuration)
+	assert.Equal(t, ProductionOptions.PrefetchLimit, options.PrefetchLimit)
+	assert.Equal(t, ProductionOptions.HeartbeatInterval, options.HeartbeatInterval)
+}
+
+func TestOpenConnectionWithOptions(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.PrefetchLimit = 3
+	connection, err := OpenConnectionWithOptions("options-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("options-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.StartConsuming(0, 0)) // use connection options
+	redisQueue := queue.(*redisQueue)
+	assert.Equal(t, int64(3), redisQueue.prefetchLimit)
+	assert.Equal(t, time.Millisecond, redisQueue.pollDuration)
+
+	assert.NoError(t, queue.Publish("options-d1", "options-d2", "options-d3", "options-d4"))
+	time.Sleep(10 * time.Millisecond)
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	consumer := NewTestConsumer("options-cons")
+	_, err = queue.AddConsumer("options-cons", consumer)
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	require.Len(t, consumer.LastDeliveries, 4)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.MaxConcurrency = 2
+	connection, err := OpenConnectionWithOptions("concurrency-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	running, maxRunning, consumed := 0, 0, 0
+	consume := func(delivery Delivery) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		consumed++
+		mu.Unlock()
+		assert.NoError(t, delivery.Ack())
+	}
+
+	// three queues with two consumers each, but at most two consume at a time
+	queues := []Queue{}
+	for _, name := range []string{"concurrency-q1", "concurrency-q2", "concurrency-q3"} {
+		queue, err := connection.OpenQueue(name)
+		assert.NoError(t, err)
+		_, err = queue.PurgeReady()
+		assert.NoError(t, err)
+		assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+		for i := 0; i < 2; i++ {
+			_, err = queue.AddConsumerFunc("concurrency-cons", consume)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, queue.Publish("c1", "c2", "c3", "c4"))
+		queues = append(queues, queue)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 12, consumed)
+	assert.Equal(t, 2, maxRunning)
+	mu.Unlock()
+
+	for _, queue := range queues {
+		<-queue.StopConsuming()
+	}
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestConnectionBudget(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.ConnectionBudget = 2
+	errChan := make(chan error, 10)
+	connection, err := OpenConnectionWithOptions("budget-conn", redisClient, errChan, options)
+	assert.NoError(t, err)
+
+	for _, name := range []string{"budget-q1", "budget-q2"} {
+		_, err := connection.OpenQueue(name)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, errChan, 0)
+
+	// opening beyond the budget still works, but gets reported
+	_, err = connection.OpenQueue("budget-q3")
+	assert.NoError(t, err)
+	require.Len(t, errChan, 1)
+	budgetErr, ok := (<-errChan).(*ConnectionBudgetError)
+	require.True(t, ok)
+	assert.Equal(t, "budget-q3", budgetErr.Queue)
+	assert.Equal(t, 3, budgetErr.Queues)
+	assert.Equal(t, 2, budgetErr.Budget)
+	assert.False(t, budgetErr.Refused)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestRefuseOverBudget(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.ConnectionBudget = 1
+	options.RefuseOverBudget = true
+	errChan := make(chan error, 10)
+	connection, err := OpenConnectionWithOptions("budget-refuse-conn", redisClient, errChan, options)
+	require.NoError(t, err)
+
+	_, err = connection.OpenQueue("budget-refuse-q1")
+	assert.NoError(t, err)
+	queueName := "budget-refuse-q2-" + RandomString(6)
+	_, err = connection.OpenQueue(queueName)
+	budgetErr, ok := err.(*ConnectionBudgetError)
+	require.True(t, ok, "%v", err)
+	assert.True(t, budgetErr.Refused)
+	assert.Equal(t, 2, budgetErr.Queues)
+	assert.Len(t, errChan, 0)
+
+	queueNames, err := connection.GetOpenQueues()
+	assert.NoError(t, err)
+	assert.NotContains(t, queueNames, queueName)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+// slowFetchClient makes fetching from ready lists slow and records how many
+// fetches were running at most at the same time
+type slowFetchClient struct {
+	RedisClient
+	mu      *sync.Mutex
+	running *int
+	max     *int
+}
+
+func (client slowFetchClient) RPopLPush(source, destination string) (string, error) {
+	client.mu.Lock()
+	*client.running++
+	if *client.running > *client.max {
+		*client.max = *client.running
+	}
+	client.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+	defer func() {
+		client.mu.Lock()
+		*client.running--
+		client.mu.Unlock()
+	}()
+	return client.RedisClient.RPopLPush(source, destination)
+}
+
+func TestConnectionBudgetTurns(t *testing.T) {
+	var mu sync.Mutex
+	var running, max int
+	redisClient := slowFetchClient{
+		RedisClient: NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})),
+		mu:          &mu,
+		running:     &running,
+		max:         &max,
+	}
+	options := TestOptions
+	options.ConnectionBudget = 2
+	connection, err := OpenConnectionWithOptions("budget-turns-conn", redisClient, nil, options)
+	require.NoError(t, err)
+
+	// the consume loops of five queues poll at most two at a time, all of
+	// them get their deliveries
+	consumed := make(chan string, 10)
+	for i := 0; i < 5; i++ {
+		queue, err := connection.OpenQueue(fmt.Sprintf("budget-turns-q%d-%s", i, RandomString(6)))
+		require.NoError(t, err)
+		require.NoError(t, queue.StartConsuming(10, time.Millisecond))
+		_, err = queue.AddConsumerFunc("budget-turns-cons", func(delivery Delivery) {
+			consumed <- delivery.Payload()
+			assert.NoError(t, delivery.Ack())
+		})
+		require.NoError(t, err)
+		assert.NoError(t, queue.Publish(fmt.Sprintf("budget-turns-d%d", i)))
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case <-consumed:
+		case <-time.After(time.Second):
+			t.Fatal("not consumed")
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	<-connection.StopAllConsuming()
+
+	mu.Lock()
+	assert.True(t, max > 0)
+	assert.True(t, max <= 2, "%d fetches at the same time", max)
+	mu.Unlock()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestOperationPolicy(t *testing.T) {
+	redisClient := NewTestRedisClient()
+	options := TestOptions
+	options.Operations = OperationPolicy{ForbidPurgeReady: true, ForbidDestroy: true}
+	connection, err := OpenConnectionWithOptions("operations-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("operations-q")
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("operations-d1"))
+
+	_, err = queue.PurgeReady()
+	assert.Equal(t, ErrorForbidden, err)
+	_, _, err = queue.Destroy()
+	assert.Equal(t, ErrorForbidden, err)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	_, err = queue.PurgeRejected() // not forbidden
+	assert.NoError(t, err)
+
+	// other connections aren't restricted
+	adminConnection, err := OpenConnectionWithRmqRedisClient("operations-admin", redisClient, nil)
+	assert.NoError(t, err)
+	adminQueue, err := adminConnection.OpenQueue("operations-q")
+	assert.NoError(t, err)
+	readyCount, _, err := adminQueue.Destroy()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), readyCount)
+
+	assert.NoError(t, connection.stopHeartbeat())
+	assert.NoError(t, adminConnection.stopHeartbeat())
+}
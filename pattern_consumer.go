@@ -0,0 +1,173 @@
+package rmq
+
+import (
+	"context"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PatternConsumer consumes all queues whose names match a pattern, like
+// "exports-*", including queues which get created while it's running. This
+// way workers pick up new per-tenant queues (see QueueFactory) without
+// getting redeployed. Patterns use the syntax of path.Match().
+type PatternConsumer struct {
+	connection Connection
+	pattern    string
+	start      func(queue Queue) error
+	options    []QueueOption
+	mu         sync.Mutex       // protects queues
+	queues     map[string]Queue // queues consumed so far by name
+}
+
+// NewPatternConsumer returns a pattern consumer which opens matching queues
+// with the given options and passes them to start, which should call
+// StartConsuming() and add consumers.
+func NewPatternConsumer(connection Connection, pattern string, start func(queue Queue) error, options ...QueueOption) *PatternConsumer {
+	return &PatternConsumer{
+		connection: connection,
+		pattern:    pattern,
+		start:      start,
+		options:    options,
+		queues:     map[string]Queue{},
+	}
+}
+
+// Discover starts consuming all open queues which match the pattern and
+// aren't consumed yet. Returns the names of the newly consumed queues.
+// Returns path.ErrBadPattern if the pattern is malformed.
+func (consumer *PatternConsumer) Discover() (added []string, err error) {
+	names, err := consumer.connection.GetOpenQueues()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := consumer.queues[name]; ok {
+			continue
+		}
+		matched, err := path.Match(consumer.pattern, name)
+		if err != nil {
+			return added, err
+		}
+		if !matched {
+			continue
+		}
+
+		queue, err := consumer.connection.OpenQueue(name, consumer.options...)
+		if err != nil {
+			return added, err
+		}
+		if err := consumer.start(queue); err != nil {
+			return added, err
+		}
+		consumer.queues[name] = queue
+		added = append(added, name)
+	}
+
+	return added, nil
+}
+
+// Run calls Discover() once per interval until the context is done, then it
+// stops consuming all queues it started and waits for their consumers to
+// finish. In between it listens to queue events (see SubscribeQueueEvents()),
+// so it picks up new matching queues right away and stops consuming matching
+// queues once they got destroyed. Returns the context's error or the first
+// error of Discover().
+func (consumer *PatternConsumer) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var events <-chan QueueEvent // nil without redis connection
+	if _, ok := consumer.connection.(*redisConnection); ok {
+		subscribed, unsubscribe, err := SubscribeQueueEvents(consumer.connection)
+		if err != nil {
+			return err
+		}
+		defer unsubscribe()
+		events = subscribed
+	}
+
+	for {
+		if _, err := consumer.Discover(); err != nil {
+			return err
+		}
+
+		if !consumer.wait(ctx, ticker.C, &events) {
+			<-consumer.StopConsuming()
+			return ctx.Err()
+		}
+	}
+}
+
+// wait blocks until the next tick or until a matching queue got opened.
+// Matching queues which got destroyed in the meantime get forgotten. Returns
+// false once ctx is done.
+func (consumer *PatternConsumer) wait(ctx context.Context, ticks <-chan time.Time, events *<-chan QueueEvent) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticks:
+			return true
+		case event, ok := <-*events:
+			if !ok { // subscription closed, keep polling
+				*events = nil
+				continue
+			}
+			if matched, _ := path.Match(consumer.pattern, event.Queue); !matched {
+				continue
+			}
+			switch event.Event {
+			case QueueOpened:
+				return true
+			case QueueDestroyed:
+				consumer.forget(event.Queue)
+			}
+		}
+	}
+}
+
+// forget stops consuming the given queue, so it gets discovered again if it
+// gets reopened
+func (consumer *PatternConsumer) forget(name string) {
+	consumer.mu.Lock()
+	queue, ok := consumer.queues[name]
+	delete(consumer.queues, name)
+	consumer.mu.Unlock()
+
+	if ok {
+		<-queue.StopConsuming()
+	}
+}
+
+// Queues returns the names of the queues consumed so far, sorted
+func (consumer *PatternConsumer) Queues() []string {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	names := make([]string, 0, len(consumer.queues))
+	for name := range consumer.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StopConsuming stops consuming all queues started so far. The returned
+// channel gets closed once all their consumers finished.
+func (consumer *PatternConsumer) StopConsuming() <-chan struct{} {
+	consumer.mu.Lock()
+	queues := make([]Queue, 0, len(consumer.queues))
+	for _, queue := range consumer.queues {
+		queues = append(queues, queue)
+	}
+	consumer.mu.Unlock()
+
+	return stopQueues(queues)
+}
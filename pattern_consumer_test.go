@@ -0,0 +1,132 @@
+package rmq
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternConsumer(t *testing.T) {
+	connection, err := OpenConnection("pattern-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	for _, name := range []string{"pattern-exports-a", "pattern-exports-b", "pattern-imports-a"} {
+		queue, err := connection.OpenQueue(name)
+		assert.NoError(t, err)
+		_, _, err = queue.Destroy()
+		assert.NoError(t, err)
+	}
+
+	queueA, err := connection.OpenQueue("pattern-exports-a")
+	assert.NoError(t, err)
+	imports, err := connection.OpenQueue("pattern-imports-a")
+	assert.NoError(t, err)
+
+	consumer := NewTestConsumer("pattern-cons")
+	patternConsumer := NewPatternConsumer(connection, "pattern-exports-*", func(queue Queue) error {
+		if err := queue.StartConsuming(10, time.Millisecond); err != nil {
+			return err
+		}
+		_, err := queue.AddConsumer("pattern-cons", consumer)
+		return err
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- patternConsumer.Run(ctx, 5*time.Millisecond) }()
+
+	assert.NoError(t, queueA.Publish("pattern-d1"))
+	assert.NoError(t, imports.Publish("pattern-d2"))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, []string{"pattern-exports-a"}, patternConsumer.Queues())
+
+	// new matching queues get discovered at runtime
+	queueB, err := connection.OpenQueue("pattern-exports-b")
+	assert.NoError(t, err)
+	assert.NoError(t, queueB.Publish("pattern-d3"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"pattern-exports-a", "pattern-exports-b"}, patternConsumer.Queues())
+	assert.Len(t, consumer.LastDeliveries, 2)
+	count, err := imports.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	_, err = NewPatternConsumer(connection, "[", nil).Discover()
+	assert.Equal(t, path.ErrBadPattern, err)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestPatternConsumerEvents(t *testing.T) {
+	connection, err := OpenConnection("pattern-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("pattern-events-a")
+	assert.NoError(t, err)
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+
+	patternConsumer := NewPatternConsumer(connection, "pattern-events-*", func(queue Queue) error {
+		return queue.StartConsuming(10, time.Millisecond)
+	})
+
+	// long interval, so queues only get discovered via events
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- patternConsumer.Run(ctx, time.Hour) }()
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, patternConsumer.Queues())
+
+	queue, err = connection.OpenQueue("pattern-events-a")
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"pattern-events-a"}, patternConsumer.Queues())
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, patternConsumer.Queues())
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestPatternConsumerRediscoverOpenQueues(t *testing.T) {
+	connection, err := OpenConnection("pattern-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	patternConsumer := NewPatternConsumer(connection, "pattern-rediscover-*", func(queue Queue) error {
+		return queue.StartConsuming(10, time.Millisecond)
+	})
+
+	countHandles := func() int {
+		redisConnection := connection.(*redisConnection)
+		redisConnection.openQueuesMu.Lock()
+		defer redisConnection.openQueuesMu.Unlock()
+		count := 0
+		for _, queue := range redisConnection.openQueues {
+			if queue.(*redisQueue).name == "pattern-rediscover-a" {
+				count++
+			}
+		}
+		return count
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = connection.(*redisConnection).redisClient.SAdd(queuesKey, "pattern-rediscover-a")
+		assert.NoError(t, err)
+		added, err := patternConsumer.Discover()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"pattern-rediscover-a"}, added)
+		patternConsumer.forget("pattern-rediscover-a")
+	}
+	// stopped handles got replaced instead of piling up
+	assert.Equal(t, 1, countHandles())
+
+	<-connection.StopAllConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,184 @@
+package rmq
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithPriorities makes consumers of this queue fetch deliveries published
+// with a higher priority (see PublishWithPriority()) before the ones with a
+// lower priority. Deliveries published otherwise have priority 0.
+// NOTE: deliveries published with a priority other than 0 only get consumed
+// by consumers of queues opened with this option, which can't be combined
+// with WithTenantFairness() or WithOldestFirst(), OpenQueue() returns
+// ErrorPriorityMixed then
+func WithPriorities() QueueOption {
+	return func(queue *redisQueue) {
+		queue.priorities = &readyBands{}
+	}
+}
+
+// checkOptions returns an error if the queue got opened with options which
+// can't be combined
+func (queue *redisQueue) checkOptions() error {
+	if queue.priorities != nil && (queue.tenants != nil || len(queue.siblingReadyKeys) > 0) {
+		return ErrorPriorityMixed
+	}
+	return queue.checkStreamOptions()
+}
+
+// readyBands are the ready lists by priority of a queue opened with
+// WithPriorities()
+type readyBands struct {
+	mu        sync.Mutex
+	readyKeys []string  // highest priority first
+	loaded    time.Time // when readyKeys got loaded, zero to load them again
+}
+
+// PublishWithPriority adds deliveries with the given priority to the queue,
+// see WithPriorities(). Their priority is available via Header.Priority().
+// Like markers they bypass the frozen policy, the spool and the fallback.
+func (queue *redisQueue) PublishWithPriority(priority int, payload ...string) error {
+	if priority == 0 {
+		return queue.Publish(payload...)
+	}
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+	payload, err := queue.encode(Header{HeaderPriority: strconv.Itoa(priority)}, payload)
+	if err != nil {
+		return err
+	}
+	if err := queue.checkBigKey(queue.redisClient, queuePriorityKey(queue.name, priority), len(payload)); err != nil {
+		return err
+	}
+
+	if _, err := queue.redisClient.LPush(queuePriorityKey(queue.name, priority), payload...); err != nil {
+		return err
+	}
+	_, err = queue.redisClient.SAdd(queue.bandsKey, strconv.Itoa(priority))
+	return err
+}
+
+// Priority returns the priority of the delivery, 0 unless it got published
+// via Queue.PublishWithPriority()
+func (header Header) Priority() int {
+	priority, _ := strconv.Atoi(header[HeaderPriority])
+	return priority
+}
+
+func queuePriorityKey(queueName string, priority int) string {
+	if priority == 0 {
+		return strings.Replace(queueReadyTemplate, phQueue, queueName, 1)
+	}
+	bandKey := strings.Replace(queueBandTemplate, phQueue, queueName, 1)
+	return strings.Replace(bandKey, phPriority, strconv.Itoa(priority), 1)
+}
+
+// queueDelayedKey returns the sorted set holding the delayed deliveries of
+// the given priority, so they return to the ready list of their priority
+func queueDelayedKey(queueName string, priority int) string {
+	if priority == 0 {
+		return strings.Replace(queueDelayedTemplate, phQueue, queueName, 1)
+	}
+	delayedKey := strings.Replace(queueBandDelayedTemplate, phQueue, queueName, 1)
+	return strings.Replace(delayedKey, phPriority, strconv.Itoa(priority), 1)
+}
+
+// delayedLists returns the sorted sets of the queue's delayed deliveries,
+// one per priority, along with the ready lists they return to
+func (queue *redisQueue) delayedLists() (delayedKeys, readyKeys []string, err error) {
+	priorities, err := queue.readPriorities()
+	if err != nil {
+		return nil, nil, err
+	}
+	delayedKeys, readyKeys = []string{queue.delayedKey}, []string{queue.readyKey}
+	for _, priority := range priorities {
+		delayedKeys = append(delayedKeys, queueDelayedKey(queue.name, priority))
+		readyKeys = append(readyKeys, queuePriorityKey(queue.name, priority))
+	}
+	return delayedKeys, readyKeys, nil
+}
+
+// readPriorities returns the priorities with ready lists other than 0,
+// highest first
+func (queue *redisQueue) readPriorities() ([]int, error) {
+	members, err := queue.redisClient.SMembers(queue.bandsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	priorities := make([]int, 0, len(members))
+	for _, member := range members {
+		if priority, err := strconv.Atoi(member); err == nil && priority != 0 {
+			priorities = append(priorities, priority)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+	return priorities, nil
+}
+
+// fetchPriority moves the next ready delivery with the highest priority to
+// the unacked list and returns it. Returns ErrorNotFound if there is none.
+// The priorities get loaded again at most once per poll duration, so
+// deliveries published with a new priority might wait for that long.
+func (queue *redisQueue) fetchPriority() (string, error) {
+	bands := queue.priorities
+	bands.mu.Lock()
+	defer bands.mu.Unlock()
+
+	if time.Since(bands.loaded) >= queue.pollDuration {
+		priorities, err := queue.readPriorities()
+		if err != nil {
+			return "", err
+		}
+		priorities = append(priorities, 0)
+		sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+		readyKeys := make([]string, len(priorities))
+		for i, priority := range priorities {
+			readyKeys[i] = queuePriorityKey(queue.name, priority)
+		}
+		bands.readyKeys, bands.loaded = readyKeys, time.Now()
+	}
+
+	for _, readyKey := range bands.readyKeys {
+		payload, err := queue.redisClient.RPopLPush(readyKey, queue.unackedKey)
+		if err != ErrorNotFound {
+			return payload, err
+		}
+	}
+	bands.loaded = time.Time{} // all empty, check for new priorities next time
+	return "", ErrorNotFound
+}
+
+// readyKeyOf returns the ready list of the delivery's priority, so returned
+// deliveries keep their priority, see PublishWithPriority()
+func (queue *redisQueue) readyKeyOf(payload string) string {
+	header, _ := decodeHeader(payload)
+	if header.Priority() == 0 {
+		return queue.readyKey
+	}
+	return queuePriorityKey(queue.name, header.Priority())
+}
+
+// priorityCounts returns the number of ready deliveries per priority other
+// than 0, nil if there are none, see PublishWithPriority()
+func (queue *redisQueue) priorityCounts() (map[int]int64, error) {
+	priorities, err := queue.readPriorities()
+	if err != nil || len(priorities) == 0 {
+		return nil, err
+	}
+
+	counts := make(map[int]int64, len(priorities))
+	for _, priority := range priorities {
+		count, err := queue.redisClient.LLen(queuePriorityKey(queue.name, priority))
+		if err != nil {
+			return nil, err
+		}
+		counts[priority] = count
+	}
+	return counts, nil
+}
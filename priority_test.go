@@ -0,0 +1,212 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorities(t *testing.T) {
+	connection, err := OpenConnection("priority-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("priority-q", WithPriorities())
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.NoError(t, err)
+	queue, err = connection.OpenQueue("priority-q", WithPriorities())
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("default1"))
+	assert.NoError(t, queue.PublishWithPriority(-1, "lowest1"))
+	assert.NoError(t, queue.PublishWithPriority(5, "high1", "high2"))
+	assert.NoError(t, queue.PublishWithPriority(2, "mid1"))
+	assert.NoError(t, queue.PublishWithPriority(0, "default2"))
+
+	counts, err := queue.priorityCounts()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int64{-1: 1, 2: 1, 5: 2}, counts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var payloads []string
+	returned := false
+	for i := 0; i < 6; i++ {
+		delivery, err := queue.ConsumeOne(ctx)
+		require.NoError(t, err)
+		payloads = append(payloads, delivery.Payload())
+		if delivery.Payload() == "high1" && !returned {
+			returned = true
+			assert.Equal(t, 5, delivery.Header().Priority())
+			// returned deliveries keep their priority
+			assert.NoError(t, queue.(*redisQueue).returnDelivery(delivery.(*redisDelivery)))
+			continue
+		}
+		assert.NoError(t, delivery.Ack())
+	}
+	assert.Equal(t, []string{"high1", "high1", "high2", "mid1", "default1", "default2"}, payloads)
+
+	assert.NoError(t, queue.PublishWithPriority(3, "mid2"))
+	readyCount, _, err := queue.Destroy()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), readyCount) // lowest1 and mid2
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestPrioritiesCleaned(t *testing.T) {
+	connection, err := OpenConnection("priority-dead-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("priority-cleaned-q", WithPriorities())
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.NoError(t, err)
+	queue, err = connection.OpenQueue("priority-cleaned-q", WithPriorities())
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.PublishWithPriority(5, "high1"))
+	assert.NoError(t, queue.Publish("default1"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		_, err := queue.ConsumeOne(ctx)
+		require.NoError(t, err)
+	}
+
+	// the connection dies with both deliveries unacked
+	assert.NoError(t, connection.stopHeartbeat())
+	cleaner := NewCleaner(connection)
+	_, err = cleaner.Clean()
+	assert.NoError(t, err)
+
+	counts, err := queue.priorityCounts()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int64{5: 1}, counts)
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "default1", messages[0].Payload)
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+}
+
+func TestPrioritiesCounted(t *testing.T) {
+	connection, err := OpenConnection("priority-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("priority-counted-q", WithPriorities())
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.NoError(t, err)
+	queue, err = connection.OpenQueue("priority-counted-q", WithPriorities())
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.PublishWithPriority(5, "high1", "high2"))
+	assert.NoError(t, queue.Publish("default1"))
+
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	empty, err := queue.(*redisQueue).isEmpty()
+	assert.NoError(t, err)
+	assert.False(t, empty)
+	stats, err := connection.CollectStats([]string{"priority-counted-q"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stats.QueueStats["priority-counted-q"].ReadyCount)
+	assert.Equal(t, map[int]int64{5: 2}, stats.QueueStats["priority-counted-q"].Priorities)
+
+	purged, err := queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+	empty, err = queue.(*redisQueue).isEmpty()
+	assert.NoError(t, err)
+	assert.True(t, empty)
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestPrioritiesMixed(t *testing.T) {
+	connection, err := OpenConnection("priority-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	sibling, err := connection.OpenQueue("priority-sibling-q")
+	require.NoError(t, err)
+
+	_, err = connection.OpenQueue("priority-mixed-q", WithPriorities(), WithTenantFairness())
+	assert.Equal(t, ErrorPriorityMixed, err)
+	_, err = connection.OpenQueue("priority-mixed-q", WithOldestFirst(sibling), WithPriorities())
+	assert.Equal(t, ErrorPriorityMixed, err)
+	queues, err := connection.GetOpenQueues()
+	assert.NoError(t, err)
+	assert.NotContains(t, queues, "priority-mixed-q")
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestPrioritiesReturned(t *testing.T) {
+	connection, err := OpenConnection("priority-returned-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("priority-returned-q", WithPriorities(), WithRetryPolicy(RetryPolicy{MaxRetries: 1, MinBackoff: time.Millisecond}))
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.NoError(t, err)
+	queue, err = connection.OpenQueue("priority-returned-q", WithPriorities(), WithRetryPolicy(RetryPolicy{MaxRetries: 1, MinBackoff: time.Millisecond}))
+	require.NoError(t, err)
+	redisQueue := queue.(*redisQueue)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	consume := func() Delivery {
+		delivery, err := queue.ConsumeOne(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "high1", delivery.Payload())
+		return delivery
+	}
+	assertHigh := func(path string) {
+		counts, err := queue.priorityCounts()
+		assert.NoError(t, err)
+		assert.Equal(t, map[int]int64{5: 1}, counts, path)
+		messages, err := queue.PeekReady(10)
+		assert.NoError(t, err)
+		assert.Empty(t, messages, path)
+	}
+
+	assert.NoError(t, queue.PublishWithPriority(5, "high1"))
+	consume()
+	_, err = queue.ReturnUnacked(10)
+	assert.NoError(t, err)
+	assertHigh("ReturnUnacked")
+
+	assert.NoError(t, queue.SetRedeliveryOrder(RedeliverFirst))
+	consume()
+	_, err = queue.ReturnUnacked(10)
+	assert.NoError(t, err)
+	assertHigh("ReturnUnacked first")
+	assert.NoError(t, queue.SetRedeliveryOrder(RedeliverLast))
+
+	// the retry policy delays the first rejection, the second one rejects
+	assert.NoError(t, consume().Reject())
+	_, err = redisQueue.promoteDue(time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assertHigh("retry policy")
+	assert.NoError(t, consume().Reject())
+	_, err = queue.ReturnRejected(10)
+	assert.NoError(t, err)
+	assertHigh("ReturnRejected")
+
+	assert.NoError(t, consume().(*redisDelivery).delay(time.Millisecond, false))
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, redisQueue.promoteDelayed(0, 10))
+	assertHigh("RetryAfter")
+
+	consume()
+	_, err = connection.ReturnAllUnacked()
+	assert.NoError(t, err)
+	assertHigh("ReturnAllUnacked")
+
+	readyCount, _, err := queue.Destroy()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), readyCount)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,26 @@
+module github.com/adjust/rmq/v4/proto
+
+go 1.21
+
+replace github.com/adjust/rmq/v4 => ../
+
+require (
+	github.com/adjust/rmq/v4 v4.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.6.1
+	google.golang.org/grpc v1.66.3
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/go-redis/redis/v8 v8.3.2 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	go.opentelemetry.io/otel v0.13.0 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
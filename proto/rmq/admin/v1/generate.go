@@ -0,0 +1,6 @@
+// Package adminv1 contains the generated gRPC stubs of the Admin service
+// defined in admin.proto, and a Server which implements it on top of
+// rmq.AdminService.
+package adminv1
+
+//go:generate sh -c "cd ../../.. && buf generate"
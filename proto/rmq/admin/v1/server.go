@@ -0,0 +1,112 @@
+package adminv1
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/adjust/rmq/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements AdminServer by converting between the messages and the
+// types of rmq.AdminService
+type Server struct {
+	UnimplementedAdminServer
+	service *rmq.AdminService
+}
+
+// NewServer returns a server for the given service, register it via
+// RegisterAdminServer()
+func NewServer(service *rmq.AdminService) *Server {
+	return &Server{service: service}
+}
+
+func (server *Server) ListQueues(context.Context, *ListQueuesRequest) (*ListQueuesResponse, error) {
+	queues, err := server.service.ListQueues()
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &ListQueuesResponse{Queues: queueMessages(queues)}, nil
+}
+
+func (server *Server) PurgeReady(_ context.Context, request *PurgeRequest) (*PurgeResponse, error) {
+	purged, err := server.service.PurgeReady(request.GetQueue())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &PurgeResponse{Purged: purged}, nil
+}
+
+func (server *Server) PurgeRejected(_ context.Context, request *PurgeRequest) (*PurgeResponse, error) {
+	purged, err := server.service.PurgeRejected(request.GetQueue())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &PurgeResponse{Purged: purged}, nil
+}
+
+func (server *Server) ReturnRejected(_ context.Context, request *ReturnRejectedRequest) (*ReturnRejectedResponse, error) {
+	returned, err := server.service.ReturnRejected(request.GetQueue(), request.GetMax())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &ReturnRejectedResponse{Returned: returned}, nil
+}
+
+func (server *Server) DestroyQueue(_ context.Context, request *DestroyQueueRequest) (*DestroyQueueResponse, error) {
+	ready, rejected, err := server.service.DestroyQueue(request.GetQueue())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &DestroyQueueResponse{Ready: ready, Rejected: rejected}, nil
+}
+
+// WatchStats streams the snapshots until the client cancels the stream
+func (server *Server) WatchStats(request *WatchStatsRequest, stream Admin_WatchStatsServer) error {
+	if request.GetIntervalMs() <= 0 {
+		return status.Error(codes.InvalidArgument, "interval_ms must be positive")
+	}
+
+	interval := time.Duration(request.GetIntervalMs()) * time.Millisecond
+	err := server.service.WatchStats(stream.Context(), request.GetQueues(), interval, func(snapshot rmq.AdminSnapshot) error {
+		return stream.Send(&StatsSnapshot{
+			TimeUnixMs: snapshot.Time.UnixNano() / int64(time.Millisecond),
+			Queues:     queueMessages(snapshot.Queues),
+		})
+	})
+	if err == stream.Context().Err() {
+		return status.FromContextError(err).Err()
+	}
+	return statusError(err)
+}
+
+func queueMessages(queues []rmq.AdminQueue) []*Queue {
+	messages := make([]*Queue, 0, len(queues))
+	for _, queue := range queues {
+		messages = append(messages, &Queue{
+			Name:        queue.Name,
+			Ready:       queue.Ready,
+			Rejected:    queue.Rejected,
+			Unacked:     queue.Unacked,
+			Consumers:   queue.Consumers,
+			Connections: queue.Connections,
+		})
+	}
+	return messages
+}
+
+// statusError returns the gRPC status error for the given error of
+// rmq.AdminService
+func statusError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, rmq.ErrorNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, rmq.ErrorForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
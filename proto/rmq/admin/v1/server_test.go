@@ -0,0 +1,79 @@
+package adminv1
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/adjust/rmq/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	connection, err := rmq.OpenConnection("admin-grpc-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("admin-grpc-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("admin-grpc-d1", "admin-grpc-d2"))
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	RegisterAdminServer(grpcServer, NewServer(rmq.NewAdminService(connection)))
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	clientConn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer clientConn.Close()
+	client := NewAdminClient(clientConn)
+	ctx := context.Background()
+
+	list, err := client.ListQueues(ctx, &ListQueuesRequest{})
+	require.NoError(t, err)
+	var found *Queue
+	for _, queue := range list.GetQueues() {
+		if queue.GetName() == "admin-grpc-q" {
+			found = queue
+		}
+	}
+	if assert.NotNil(t, found) {
+		assert.Equal(t, int64(2), found.GetReady())
+	}
+
+	stream, err := client.WatchStats(ctx, &WatchStatsRequest{Queues: []string{"admin-grpc-q"}, IntervalMs: 1})
+	require.NoError(t, err)
+	snapshot, err := stream.Recv()
+	require.NoError(t, err)
+	if assert.Len(t, snapshot.GetQueues(), 1) {
+		assert.Equal(t, int64(2), snapshot.GetQueues()[0].GetReady())
+	}
+
+	purged, err := client.PurgeReady(ctx, &PurgeRequest{Queue: "admin-grpc-q"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged.GetPurged())
+
+	// unknown queues return NotFound
+	_, err = client.PurgeReady(ctx, &PurgeRequest{Queue: "admin-grpc-typo-q"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.ReturnRejected(ctx, &ReturnRejectedRequest{Queue: "admin-grpc-typo-q", Max: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	destroyed, err := client.DestroyQueue(ctx, &DestroyQueueRequest{Queue: "admin-grpc-q"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), destroyed.GetReady())
+	_, err = client.DestroyQueue(ctx, &DestroyQueueRequest{Queue: "admin-grpc-q"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	<-connection.StopAllConsuming()
+}
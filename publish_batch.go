@@ -0,0 +1,34 @@
+package rmq
+
+// publishBatchSize is how many deliveries PublishBatch() publishes per LPUSH,
+// so huge batches don't block redis for long
+const publishBatchSize = 1000
+
+// PublishBatch publishes the given payloads like Publish(), but in chunks of
+// up to 1000 deliveries, each of them with a single LPUSH. Returns how many
+// deliveries got published. If a chunk fails it stops and returns a
+// *BatchPublishError: The deliveries before the failed chunk got published,
+// the ones of the failed chunk and after it didn't, so publishing
+// payloads[published:] again publishes each delivery exactly once.
+func (queue *redisQueue) PublishBatch(payloads []string) (published int, err error) {
+	for published < len(payloads) {
+		end := published + publishBatchSize
+		if end > len(payloads) {
+			end = len(payloads)
+		}
+		if err := queue.Publish(payloads[published:end]...); err != nil {
+			return published, &BatchPublishError{Published: published, Total: len(payloads), Err: err}
+		}
+		published = end
+	}
+	return published, nil
+}
+
+// PublishBatchBytes just casts the bytes and calls PublishBatch
+func (queue *redisQueue) PublishBatchBytes(payloads [][]byte) (int, error) {
+	stringifiedBytes := make([]string, len(payloads))
+	for i, b := range payloads {
+		stringifiedBytes[i] = string(b)
+	}
+	return queue.PublishBatch(stringifiedBytes)
+}
@@ -0,0 +1,86 @@
+package rmq
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishBatch(t *testing.T) {
+	connection, err := OpenConnection("batch-publish-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("batch-publish-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	payloads := make([]string, 2500)
+	for i := range payloads {
+		payloads[i] = "batch-publish-d" + strconv.Itoa(i)
+	}
+	published, err := queue.PublishBatch(payloads)
+	assert.NoError(t, err)
+	assert.Equal(t, 2500, published)
+
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2500), count)
+	ready, err := queue.PeekReady(2)
+	assert.NoError(t, err)
+	if assert.Len(t, ready, 2) { // oldest first
+		assert.Equal(t, "batch-publish-d0", ready[0].Payload)
+		assert.Equal(t, "batch-publish-d1", ready[1].Payload)
+	}
+
+	published, err = queue.PublishBatchBytes([][]byte{[]byte("batch-publish-b1")})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, published)
+	published, err = queue.PublishBatch(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, published)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+// failingLPushClient fails LPUSH after the given number of calls
+type failingLPushClient struct {
+	RedisClient
+	calls *int
+	limit int
+}
+
+func (client failingLPushClient) LPush(key string, value ...string) (int64, error) {
+	if *client.calls++; *client.calls > client.limit {
+		return 0, errors.New("connection reset")
+	}
+	return client.RedisClient.LPush(key, value...)
+}
+
+func TestPublishBatchPartialFailure(t *testing.T) {
+	calls := 0
+	redisClient := failingLPushClient{RedisClient: NewTestRedisClient(), calls: &calls, limit: 2}
+	connection, err := OpenConnectionWithOptions("batch-publish-conn", redisClient, nil, TestOptions)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("batch-publish-failing-q")
+	assert.NoError(t, err)
+
+	published, err := queue.PublishBatch(make([]string, 2500))
+	assert.Equal(t, 2000, published)
+	require.IsType(t, &BatchPublishError{}, err)
+	assert.Equal(t, 2000, err.(*BatchPublishError).Published)
+	assert.Equal(t, "rmq.BatchPublishError: published 2000 of 2500 deliveries: connection reset", err.Error())
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), count)
+
+	queue.SetFrozenPolicy(RejectWhileFrozen, 0)
+	assert.NoError(t, queue.Freeze())
+	published, err = queue.PublishBatch([]string{"batch-publish-frozen"})
+	assert.Equal(t, 0, published)
+	assert.True(t, errors.Is(err, ErrorQueueFrozen))
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
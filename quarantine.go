@@ -0,0 +1,87 @@
+package rmq
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WithPanicQuarantine recovers panics of the consumers of this queue and
+// rejects the delivery which caused the panic, unless the consumer already
+// acked, rejected or pushed it. Each panic gets reported to errChan as a
+// ConsumerPanicError. If a consumer panics strikes times within window it
+// gets quarantined: It stops taking deliveries and a Quarantined queue event
+// gets published, so a crash-looping consumer doesn't reject every delivery
+// it touches. Other consumers of the connection take over the prefetched
+// deliveries. If the last consumer got quarantined they stay unacked until
+// consuming gets stopped or the connection dies.
+// NOTE: doesn't apply to batch consumers
+func WithPanicQuarantine(strikes int, window time.Duration) QueueOption {
+	return func(queue *redisQueue) {
+		queue.panicStrikes = strikes
+		queue.panicWindow = window
+	}
+}
+
+// panicTracker recovers the panics of a single consumer, see
+// WithPanicQuarantine()
+type panicTracker struct {
+	recovered interface{} // recovered from consuming the latest delivery, nil if it didn't panic
+	times     []time.Time // of the panics within the window
+}
+
+// recovering returns a consumer which passes deliveries on to consumer and
+// rejects them if it panics
+func (tracker *panicTracker) recovering(consumer Consumer) Consumer {
+	return ConsumerFunc(func(delivery Delivery) {
+		tracker.recovered = nil
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			tracker.recovered = recovered
+			if redisDelivery, ok := delivery.(*redisDelivery); !ok || !redisDelivery.handled() {
+				delivery.Reject()
+			}
+		}()
+		consumer.Consume(delivery)
+	})
+}
+
+// checkPanics reports the panic of the given consumer if consuming the latest
+// delivery panicked. Returns whether the consumer got quarantined and must
+// stop consuming.
+func (queue *redisQueue) checkPanics(consumerName string, tracker *panicTracker) bool {
+	if tracker.recovered == nil {
+		return false
+	}
+
+	now := time.Now()
+	times := tracker.times[:0]
+	for _, panicked := range tracker.times {
+		if queue.panicWindow <= 0 || now.Sub(panicked) < queue.panicWindow {
+			times = append(times, panicked)
+		}
+	}
+	tracker.times = append(times, now)
+
+	quarantined := len(tracker.times) >= queue.panicStrikes
+	if quarantined {
+		queue.quarantineConsumer(consumerName, len(tracker.times))
+	}
+	select { // try to add error to channel, but don't block
+	case queue.errChan <- &ConsumerPanicError{Queue: queue.name, Consumer: consumerName, Panic: tracker.recovered, Count: len(tracker.times), Quarantined: quarantined}:
+	default:
+	}
+	return quarantined
+}
+
+// quarantineConsumer removes the given consumer, even if it's the last one
+// taking deliveries on this connection
+func (queue *redisQueue) quarantineConsumer(consumerName string, count int) {
+	atomic.AddInt32(&queue.runningCount, -1)
+	atomic.AddInt32(&queue.consumerCount, -1)
+	atomic.AddInt64(&queue.concurrency, -1)
+	queue.options.logf(LogInfo, "rmq queue quarantining panicking consumer %s %s", queue, consumerName)
+	queue.removeConsumer(QueueEvent{Event: Quarantined, Queue: queue.name, Connection: queue.connectionName, Consumer: consumerName, Count: int64(count)})
+}
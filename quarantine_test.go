@@ -0,0 +1,84 @@
+package rmq
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPanicQuarantine(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("quarantine-conn", "tcp", "localhost:6379", 1, errChan)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("quarantine-q", WithPanicQuarantine(2, time.Minute))
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	require.NoError(t, err)
+
+	var consumed int32
+	assert.NoError(t, queue.StartConsuming(1, time.Millisecond))
+	name, err := queue.AddConsumerFunc("quarantine-cons", func(delivery Delivery) {
+		atomic.AddInt32(&consumed, 1)
+		if strings.HasPrefix(delivery.Payload(), "boom") {
+			panic("boom")
+		}
+		assert.NoError(t, delivery.Ack())
+	})
+	require.NoError(t, err)
+
+	nextPanicError := func() *ConsumerPanicError {
+		select {
+		case err := <-errChan:
+			require.IsType(t, &ConsumerPanicError{}, err)
+			return err.(*ConsumerPanicError)
+		case <-time.After(time.Second):
+			t.Fatal("no consumer panic error")
+			return nil
+		}
+	}
+
+	// the first panic only rejects the delivery
+	assert.NoError(t, queue.Publish("boom1", "ok1"))
+	panicErr := nextPanicError()
+	assert.Equal(t, name, panicErr.Consumer)
+	assert.Equal(t, "boom", panicErr.Panic)
+	assert.Equal(t, 1, panicErr.Count)
+	assert.False(t, panicErr.Quarantined)
+
+	// the second one within the window quarantines the consumer
+	assert.NoError(t, queue.Publish("boom2"))
+	panicErr = nextPanicError()
+	assert.Equal(t, 2, panicErr.Count)
+	assert.True(t, panicErr.Quarantined)
+
+	assert.NoError(t, queue.Publish("ok2"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&consumed))
+	messages, err := queue.PeekRejected(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "boom1", messages[0].Payload)
+	assert.Equal(t, "boom2", messages[1].Payload)
+
+	consumers, err := queue.getConsumers()
+	assert.NoError(t, err)
+	assert.Empty(t, consumers)
+	redisQueue := queue.(*redisQueue)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&redisQueue.consumerCount))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&redisQueue.concurrency)) // reported in the stats
+
+	<-queue.StopConsuming()
+	_, err = queue.ReturnUnacked(10) // ok2 got prefetched for the quarantined consumer
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
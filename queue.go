@@ -2,40 +2,149 @@ package rmq
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/rand"
+	"runtime/pprof"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/adjust/rmq/v4/internal/goroutines"
 )
 
 const (
 	defaultBatchTimeout = time.Second
 	purgeBatchSize      = int64(100)
+
+	// WaitUntilEmpty() polls with exponential backoff between these durations
+	minEmptyPollDuration = 10 * time.Millisecond
+	maxEmptyPollDuration = time.Second
+)
+
+// FrozenPolicy defines how Publish() behaves while a queue is frozen
+type FrozenPolicy int
+
+const (
+	PublishWhileFrozen FrozenPolicy = iota // publish as usual (default)
+	RejectWhileFrozen                      // return ErrorQueueFrozen
+	BufferWhileFrozen                      // buffer locally and publish once unfrozen
+)
+
+// OverflowPolicy defines what happens when the consumers can't keep up, so the
+// prefetch limit is reached while prefetched deliveries are still waiting to
+// be consumed, see WithOverflowPolicy()
+type OverflowPolicy int
+
+const (
+	BlockOnOverflow  OverflowPolicy = iota // stop prefetching until consumers catch up (default)
+	ReturnOnOverflow                       // return waiting deliveries to ready, for other connections to consume
+	NotifyOnOverflow                       // stop prefetching and send SaturationError to errChan
+)
+
+// StopPolicy defines what happens to prefetched deliveries which haven't been
+// passed to a consumer yet when consuming gets stopped, see WithStopPolicy()
+type StopPolicy int
+
+const (
+	LeaveOnStop  StopPolicy = iota // leave them unacked, the cleaner returns them once the connection died (default)
+	ReturnOnStop                   // return them to ready immediately, to be consumed next
+	DrainOnStop                    // pass them to the consumers before finishing
 )
 
 type Queue interface {
 	Publish(payload ...string) error
 	PublishBytes(payload ...[]byte) error
+	PublishContext(ctx context.Context, payload ...string) error
+	PublishBatch(payloads []string) (int, error)
+	PublishBatchBytes(payloads [][]byte) (int, error)
+	PublishWithHeader(header Header, payload ...string) error
+	PublishMarker(name string) error
+	PublishForTenant(tenant string, payload ...string) error
+	PublishWithPriority(priority int, payload ...string) error
+	PublishDelayed(delay time.Duration, payload ...string) error
+	PublishAt(at time.Time, payload ...string) error
+	SetFrozenPolicy(policy FrozenPolicy, bufferLimit int)
+	FlushFrozenBuffer() error
 	SetPushQueue(pushQueue Queue)
+	EnableWorkStealing()
 	StartConsuming(prefetchLimit int64, pollDuration time.Duration) error
+	ConsumeOne(ctx context.Context) (Delivery, error)
+	Deliveries(ctx context.Context) <-chan Delivery
 	StopConsuming() <-chan struct{}
+	StopConsumingContext(ctx context.Context) error
 	AddConsumer(tag string, consumer Consumer) (string, error)
 	AddConsumerFunc(tag string, consumerFunc ConsumerFunc) (string, error)
 	AddBatchConsumer(tag string, batchSize int64, timeout time.Duration, consumer BatchConsumer) (string, error)
+	AddAffinityConsumers(tag string, affinity AffinityFunc, consumers ...Consumer) ([]string, error)
+	Freeze() error
+	Unfreeze() error
+	IsFrozen() (bool, error)
 	PurgeReady() (int64, error)
 	PurgeRejected() (int64, error)
+	UndoPurge() (int64, error)
 	ReturnUnacked(max int64) (int64, error)
+	ReturnUnackedWithProgress(ctx context.Context, max, chunkSize int64, progress func(returned int64)) (int64, error)
 	ReturnRejected(max int64) (int64, error)
+	ReplayRejected(max int64) (int64, error)
+	ReconcileFallback() (int64, error)
+	HandoffUnacked(connectionName string, max int64) (int64, error)
 	Destroy() (readyCount, rejectedCount int64, err error)
+	WaitUntilEmpty(ctx context.Context) error
+	PeekReady(max int64) ([]Message, error)
+	PeekRejected(max int64) ([]Message, error)
+	RejectedClasses() ([]string, error)
+	ReturnRejectedClass(class string, max int64) (int64, error)
+	PurgeRejectedClass(class string) (int64, error)
+	PeekRejectedClass(class string, max int64) ([]Message, error)
+	RepublishRejected(id string, transform func([]byte) []byte) error
+	PeekRepublished(max int64) ([]Message, error)
+	Tenants() ([]string, error)
+	DeclareFeeds(downstream ...Queue) error
+	Feeds() ([]string, error)
+	SetRetention(policy RetentionPolicy) error
+	Retention() (RetentionPolicy, error)
+	SetReturnRejectedPolicy(policy ReturnRejectedPolicy) error
+	ReturnRejectedPolicy() (ReturnRejectedPolicy, error)
+	SetAgingPolicy(policy AgingPolicy) error
+	AgingPolicy() (AgingPolicy, error)
+	SetTags(tags map[string]string) error
+	SetRedeliveryOrder(order RedeliveryOrder) error
+	RedeliveryOrder() (RedeliveryOrder, error)
+	Tags() (map[string]string, error)
+	ResetStats() error
+	StatsResets() ([]StatsReset, error)
+	InFlight() map[string]int64
 
 	// internals
 	// used in cleaner
 	closeInStaleConnection() error
+	returnCleaned() (int64, error)
+	returnHandoff() (int64, error)
+	countCleaned(count int64) error
+	// used in janitor
+	enforceRetention(now time.Time) (int64, error)
+	enforceReturnPolicy(now time.Time) (int64, error)
+	enforceAging(now time.Time) (int64, error)
+	promoteDue(now time.Time) (int64, error)
 	// used for stats
 	readyCount() (int64, error)
 	unackedCount() (int64, error)
 	rejectedCount() (int64, error)
 	getConsumers() ([]string, error)
+	bufferStat() (buffered, size int64, blocked time.Duration, concurrency int64, err error)
+	durationsStat() (*durationSketch, error)
+	fetchedCount() (int64, error)
+	cleanedCount() (int64, error)
+	lostAckCount() (int64, error)
+	rejectedClassCounts() (map[string]int64, error)
+	tenantCounts() (map[string]int64, error)
+	priorityCounts() (map[int]int64, error)
+	streamCount() (int64, error)
+	bigKeys(stat QueueStat) []string
 }
 
 type redisQueue struct {
@@ -45,14 +154,111 @@ type redisQueue struct {
 	consumersKey     string // key to set of consumers using this connection
 	readyKey         string // key to list of ready deliveries
 	rejectedKey      string // key to list of rejected deliveries
+	classesKey       string // key to set of error classes of rejected deliveries, see Delivery.RejectAs()
+	tenantsKey       string // key to set of tenants with ready deliveries, see PublishForTenant()
+	bandsKey         string // key to set of priorities with ready lists, see PublishWithPriority()
 	unackedKey       string // key to list of currently consuming deliveries
+	handoffKey       string // key to list of deliveries handed off to this connection
+	bufferKey        string // key to prefetch buffer stats of this connection
+	durationsKey     string // key to handler duration sketch of this connection
+	idleKey          string // key to set of connections with idle consumers
+	stealKey         string // key to lock work stealing
+	frozenKey        string // key to flag whether the queue is frozen
+	activeKey        string // key to lock of the single active connection
+	retentionKey     string // key to retention policy of the queue
+	fetchedKey       string // key to number of deliveries fetched from the queue
+	feedsKey         string // key to set of queues this queue feeds into
+	tagsKey          string // key to tags of the queue, see SetTags()
+	redeliveryKey    string // key to flag whether returned deliveries go first, see SetRedeliveryOrder()
+	requeueKey       string // key to return rejected policy of the queue
+	agingKey         string // key to aging policy of the queue
+	returnedKey      string // key to lock returning rejected deliveries per policy interval
+	delayedKey       string // key to sorted set of delayed deliveries, see RetryAfter()
+	purgedKey        string // key to list of purged ready deliveries, see WithPurgeUndo()
+	cleanedKey       string // key to number of deliveries returned by cleaners
+	lostAcksKey      string // key to number of acks which found their delivery gone
+	republishedKey   string // key to list of original deliveries, see RepublishRejected()
+	resetsKey        string // key to list of stats resets, see ResetStats()
 	pushKey          string // key to list of pushed deliveries
+	deadLetterKey    string // key to list of rejected deliveries if a dead letter queue is set
+	streamKey        string // key to stream of ready and unacked deliveries, empty unless opened with WithStream()
 	redisClient      RedisClient
+	streams          StreamBackend // redisClient if the queue keeps its deliveries in its stream, nil otherwise, see loadStream()
+	streamLoaded     bool          // whether loadStream() found out if the queue keeps its deliveries in its stream
+	streamTargets    bool          // set by options naming queues opened with WithStream() where lists are needed, see checkStreamOptions()
+	pushStream       bool          // whether the push queue got opened with WithStream(), see SetPushQueue()
 	errChan          chan<- error
+	siblingReadyKeys []string // keys to ready lists of sibling queues, see WithOldestFirst()
+	options          Options
+	frozenPolicy     FrozenPolicy
+	frozenLimit      int           // max number of deliveries to buffer while frozen
+	frozenBuffer     []string      // deliveries published while frozen
+	frozenMu         sync.Mutex    // protects frozenBuffer
 	deliveryChan     chan Delivery // nil for publish channels, not nil for consuming channels
 	prefetchLimit    int64         // max number of prefetched deliveries number of unacked can go up to prefetchLimit + numConsumers
+	maxUnacked       int64         // max number of unacked deliveries, zero if not capped, see WithMaxUnacked()
 	pollDuration     time.Duration
-	consumingStopped chan struct{} // this chan gets closed when consuming on this queue got stopped
+	autoAck          bool          // ack deliveries after Consume() returned
+	weight           int           // share of the connection's concurrency slots, see WithWeight()
+	pollTurn         func()        // ends the consume loop's turn polling redis, nil if it has none, see takeTurn()
+	markers          []*heldMarker // marker deliveries held back by the consume goroutine, see PublishMarker()
+	markersChecked   time.Time     // when releaseMarkers() last loaded the unacked deliveries
+	dryRun           bool          // restore deliveries instead of handling them, see WithDryRun()
+	tenants          *tenantRing   // nil unless WithTenantFairness()
+	priorities       *readyBands   // nil unless WithPriorities()
+	publishTime      bool          // add publish time to headers
+	trail            bool          // add trail of breadcrumbs to headers, see WithTrail()
+	rateInterval     time.Duration // min duration between fetching two deliveries (rate limit)
+	rateNext         time.Time     // when the next delivery may be fetched (rate limit)
+	startDelay       time.Duration // min duration before fetching the first deliveries
+	startJitter      time.Duration // max random duration added to startDelay
+	purgeUndo        time.Duration // how long purged ready deliveries can be restored, see WithPurgeUndo()
+	workStealing     bool          // share prefetched deliveries with idle connections
+	singleActive     bool          // only consume while holding the single active lock
+	active           bool          // whether this connection holds the single active lock, guarded by activeMu
+	activeMu         sync.Mutex
+	activeStop       func()        // stops refreshing the single active lock, see startActiveRefresh()
+	activeRefreshed  time.Time     // when the single active lock was last refreshed
+	idle             bool          // whether this connection is listed as idle
+	consumerCount    int32         // number of consumers added on this connection (atomic)
+	concurrency      int64         // number of deliveries the consumers on this connection can consume at once (atomic)
+	consumerTags     []string      // tags of the consumers added on this connection, see registerConsumer()
+	runningCount     int32         // number of consumers taking deliveries on this connection (atomic)
+	slowThreshold    time.Duration // min duration of consuming a delivery which counts as slow
+	slowStrikes      int           // number of consecutive slow deliveries which make a consumer slow
+	slowEvict        bool          // stop slow consumers from taking deliveries
+	panicStrikes     int           // number of panics within panicWindow which quarantine a consumer, see WithPanicQuarantine()
+	panicWindow      time.Duration
+	ackDeadline      time.Duration // see WithAckDeadline()
+	ackWarnAfter     time.Duration // when to warn about unhandled deliveries, see WithAckDeadline()
+	overflowPolicy   OverflowPolicy
+	overflowed       bool          // whether the prefetch buffer is currently saturated
+	overflowUnacked  int64         // unacked count after returning deliveries on overflow
+	blockedDuration  time.Duration // time spent waiting for consumers to take prefetched deliveries
+	bufferUpdated    time.Time     // when the prefetch buffer stats were last written
+	fetched          int64         // deliveries fetched since the buffer stats were last written
+	delayedPromoted  int64         // UnixNano of when due delayed deliveries were last returned to ready (atomic)
+	statsReset       int32         // set by ResetStats() until blockedDuration got reset (atomic)
+	stopPolicy       StopPolicy
+	consumingStopped chan struct{}   // this chan gets closed when consuming on this queue got stopped
+	consumerStop     <-chan struct{} // consumers stop once this chan gets closed, nil if they drain deliveryChan
+	stopOnce         sync.Once       // closes consumingStopped once, StopConsuming() can get called concurrently
+	stopFinished     chan struct{}   // gets closed once all consumers finished after consuming got stopped
+	ready            <-chan struct{} // no deliveries get fetched until this chan gets closed, nil if not waiting, see WithReadySignal()
+	durations        *durationSketch // how long consumers took to consume deliveries on this connection
+	semaphore        *Semaphore      // acquired before consuming each delivery, see WithSemaphore()
+	errorPolicy      *ErrorPolicy    // see WithErrorPolicy(), nil if not set
+	retryPolicy      *RetryPolicy    // see WithRetryPolicy(), nil if not set
+	codecs           []Codec         // see WithCodecs()
+	fallbackClient   RedisClient     // publishes there if redisClient fails, see WithFallback()
+	spool            *spool          // publishes there if redisClient and fallbackClient fail, see WithSpool()
+	journal          *bufferJournal  // records prefetched deliveries, see WithBufferJournal()
+	publishRate      *publishRate    // see WithPublishRateThreshold(), nil if not set
+	dispatcher       *dispatcher     // decides which consumer gets the next delivery, see WithDispatchPolicy()
+	bigKeyWarned     sync.Map        // keys reported as big until they got short enough again, see Options.BigKeyLimit
+	watchdogAfter    time.Duration   // see WithShutdownWatchdog()
+	watchdogReturn   bool            // return deliveries of hung consumers, see WithShutdownWatchdog()
+	consuming        sync.Map        // *consumingDelivery by consumer name, see WithShutdownWatchdog()
 	stopWg           sync.WaitGroup
 	ackCtx           context.Context
 	ackCancel        context.CancelFunc
@@ -64,6 +270,7 @@ func newQueue(
 	queuesKey string,
 	redisClient RedisClient,
 	errChan chan<- error,
+	options Options,
 ) *redisQueue {
 
 	consumersKey := strings.Replace(connectionQueueConsumersTemplate, phConnection, connectionName, 1)
@@ -75,6 +282,33 @@ func newQueue(
 	unackedKey := strings.Replace(connectionQueueUnackedTemplate, phConnection, connectionName, 1)
 	unackedKey = strings.Replace(unackedKey, phQueue, name, 1)
 
+	handoffKey := queueHandoffKey(connectionName, name)
+	bufferKey := strings.Replace(connectionQueueBufferTemplate, phConnection, connectionName, 1)
+	bufferKey = strings.Replace(bufferKey, phQueue, name, 1)
+	durationsKey := strings.Replace(connectionQueueDurationsTemplate, phConnection, connectionName, 1)
+	durationsKey = strings.Replace(durationsKey, phQueue, name, 1)
+	classesKey := strings.Replace(queueClassesTemplate, phQueue, name, 1)
+	tenantsKey := strings.Replace(queueTenantsTemplate, phQueue, name, 1)
+	bandsKey := strings.Replace(queueBandsTemplate, phQueue, name, 1)
+	idleKey := strings.Replace(queueIdleTemplate, phQueue, name, 1)
+	stealKey := strings.Replace(queueStealTemplate, phQueue, name, 1)
+	frozenKey := strings.Replace(queueFrozenTemplate, phQueue, name, 1)
+	activeKey := strings.Replace(queueActiveTemplate, phQueue, name, 1)
+	retentionKey := strings.Replace(queueRetentionTemplate, phQueue, name, 1)
+	fetchedKey := strings.Replace(queueFetchedTemplate, phQueue, name, 1)
+	feedsKey := strings.Replace(queueFeedsTemplate, phQueue, name, 1)
+	tagsKey := strings.Replace(queueTagsTemplate, phQueue, name, 1)
+	redeliveryKey := strings.Replace(queueRedeliveryTemplate, phQueue, name, 1)
+	requeueKey := strings.Replace(queueRequeueTemplate, phQueue, name, 1)
+	agingKey := strings.Replace(queueAgingTemplate, phQueue, name, 1)
+	returnedKey := strings.Replace(queueReturnedTemplate, phQueue, name, 1)
+	delayedKey := strings.Replace(queueDelayedTemplate, phQueue, name, 1)
+	purgedKey := strings.Replace(queuePurgedTemplate, phQueue, name, 1)
+	cleanedKey := strings.Replace(queueCleanedTemplate, phQueue, name, 1)
+	lostAcksKey := strings.Replace(queueLostAcksTemplate, phQueue, name, 1)
+	republishedKey := strings.Replace(queueRepublishedTemplate, phQueue, name, 1)
+	resetsKey := strings.Replace(queueResetsTemplate, phQueue, name, 1)
+
 	queue := &redisQueue{
 		name:           name,
 		connectionName: connectionName,
@@ -82,13 +316,45 @@ func newQueue(
 		consumersKey:   consumersKey,
 		readyKey:       readyKey,
 		rejectedKey:    rejectedKey,
+		classesKey:     classesKey,
+		tenantsKey:     tenantsKey,
+		bandsKey:       bandsKey,
 		unackedKey:     unackedKey,
+		handoffKey:     handoffKey,
+		bufferKey:      bufferKey,
+		durationsKey:   durationsKey,
+		idleKey:        idleKey,
+		stealKey:       stealKey,
+		frozenKey:      frozenKey,
+		activeKey:      activeKey,
+		retentionKey:   retentionKey,
+		fetchedKey:     fetchedKey,
+		feedsKey:       feedsKey,
+		tagsKey:        tagsKey,
+		redeliveryKey:  redeliveryKey,
+		requeueKey:     requeueKey,
+		agingKey:       agingKey,
+		returnedKey:    returnedKey,
+		delayedKey:     delayedKey,
+		purgedKey:      purgedKey,
+		cleanedKey:     cleanedKey,
+		lostAcksKey:    lostAcksKey,
+		republishedKey: republishedKey,
+		resetsKey:      resetsKey,
 		redisClient:    redisClient,
 		errChan:        errChan,
+		options:        options,
+		durations:      newDurationSketch(),
+		dispatcher:     newDispatcher(),
 	}
 	return queue
 }
 
+func queueHandoffKey(connectionName, queueName string) string {
+	handoffKey := strings.Replace(connectionQueueHandoffTemplate, phConnection, connectionName, 1)
+	return strings.Replace(handoffKey, phQueue, queueName, 1)
+}
+
 func (queue *redisQueue) String() string {
 	return fmt.Sprintf("[%s conn:%s]", queue.name, queue.connectionName)
 }
@@ -96,10 +362,162 @@ func (queue *redisQueue) String() string {
 // Publish adds a delivery with the given payload to the queue
 // returns how many deliveries are in the queue afterwards
 func (queue *redisQueue) Publish(payload ...string) error {
-	_, err := queue.redisClient.LPush(queue.readyKey, payload...)
+	return queue.PublishWithHeader(nil, payload...)
+}
+
+// PublishWithHeader publishes the given payloads along with the header, which
+// consumers can read via Delivery.Header()
+func (queue *redisQueue) PublishWithHeader(header Header, payload ...string) error {
+	return queue.publishContext(unusedContext, header, payload)
+}
+
+// publishContext publishes like PublishWithHeader(), passing ctx to the redis
+// commands, see PublishContext()
+func (queue *redisQueue) publishContext(ctx context.Context, header Header, payload []string) error {
+	payload, err := queue.encode(header, payload)
+	if err != nil {
+		return err
+	}
+	redisClient := queue.redisClientFor(ctx)
+	if err := queue.checkBigKey(redisClient, queue.readyKey, len(payload)); err != nil {
+		return err
+	}
+	if queue.publishRate != nil {
+		queue.trackPublishRate(len(payload))
+	}
+
+	if queue.spool != nil {
+		if spooled, err := queue.spoolPending(payload); spooled || err != nil {
+			return err
+		}
+	}
+
+	err = queue.publishEncoded(ctx, payload)
+	if err != nil && err != ErrorQueueFrozen && ctx.Err() == nil && queue.spool != nil {
+		return queue.publishSpool(payload, err)
+	}
+	return err
+}
+
+// publishEncoded publishes the encoded payloads to the ready list, applying
+// the frozen policy and publishing to the fallback if redis fails (see
+// WithFallback()). Once ctx is done they don't go to the fallback anymore.
+func (queue *redisQueue) publishEncoded(ctx context.Context, payload []string) (err error) {
+	redisClient := queue.redisClientFor(ctx)
+	if queue.frozenPolicy != PublishWhileFrozen {
+		err = queue.publishUnlessFrozen(redisClient, payload)
+	} else {
+		err = queue.pushReady(redisClient, payload)
+	}
+	if err != nil && err != ErrorQueueFrozen && ctx.Err() == nil && queue.fallbackClient != nil {
+		err = queue.publishFallback(payload, err)
+	}
+	return err
+}
+
+// redisClientFor returns the queue's redis client, passing ctx to its
+// commands if it supports that (like RedisWrapper does)
+func (queue *redisQueue) redisClientFor(ctx context.Context) RedisClient {
+	if client, ok := queue.redisClient.(contextClient); ok && ctx != unusedContext {
+		return client.withContext(ctx)
+	}
+	return queue.redisClient
+}
+
+// encode returns the payloads as stored in redis, along with the header and
+// the publish time (see WithPublishTime()), encoded by the codecs (see
+// WithCodecs())
+func (queue *redisQueue) encode(header Header, payload []string) ([]string, error) {
+	if queue.publishTime || queue.trail {
+		now := time.Now()
+		extended := Header{}
+		if queue.publishTime {
+			extended[HeaderPublishedAt] = strconv.FormatInt(now.UnixNano(), 10)
+		}
+		if queue.trail {
+			extended[HeaderTrail] = encodeTrail([]Breadcrumb{{Event: TrailPublished, Connection: queue.connectionName, Time: now}})
+		}
+		for key, value := range header {
+			extended[key] = value
+		}
+		header = extended
+	}
+
+	if len(queue.codecs) > 0 {
+		return queue.encodeCodecs(header, payload)
+	}
+	if len(header) == 0 {
+		return payload, nil
+	}
+
+	encoded := make([]string, len(payload))
+	for i, p := range payload {
+		encoded[i] = encodeHeader(header, p)
+	}
+	return encoded, nil
+}
+
+// publishUnlessFrozen publishes the given payloads via redisClient if the
+// queue is not frozen, otherwise it applies the frozen policy
+func (queue *redisQueue) publishUnlessFrozen(redisClient RedisClient, payload []string) error {
+	frozen, err := queue.isFrozen(redisClient)
+	if err != nil {
+		return err
+	}
+
+	queue.frozenMu.Lock()
+	defer queue.frozenMu.Unlock()
+
+	if frozen {
+		if queue.frozenPolicy == RejectWhileFrozen || len(queue.frozenBuffer)+len(payload) > queue.frozenLimit {
+			return ErrorQueueFrozen
+		}
+		queue.frozenBuffer = append(queue.frozenBuffer, payload...)
+		return nil
+	}
+
+	// publish buffered deliveries first to preserve order
+	payload = append(queue.frozenBuffer, payload...)
+	if len(payload) == 0 {
+		return nil
+	}
+	if err := queue.pushReady(redisClient, payload); err != nil {
+		return err
+	}
+	queue.frozenBuffer = nil
+	return nil
+}
+
+// pushReady adds the encoded payloads to the ready list, or to the stream if
+// the queue keeps its deliveries there, see WithStream()
+func (queue *redisQueue) pushReady(redisClient RedisClient, payload []string) error {
+	if queue.streams != nil {
+		return redisClient.(StreamBackend).XAdd(queue.streamKey, payload...)
+	}
+	_, err := redisClient.LPush(queue.readyKey, payload...)
 	return err
 }
 
+// PublishContext publishes like Publish(), but passes ctx to the redis
+// commands, so it returns the context's error once it's done, for example
+// because redis is slow to respond. In that case the deliveries might still
+// have been published, if redis received them already, but they don't go to
+// the fallback or spool anymore. Redis clients other than RedisWrapper don't
+// take a context, with them ctx only gets checked before publishing.
+func (queue *redisQueue) PublishContext(ctx context.Context, payload ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := queue.publishContext(ctx, nil, payload); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	return nil
+}
+
 // PublishBytes just casts the bytes and calls Publish
 func (queue *redisQueue) PublishBytes(payload ...[]byte) error {
 	stringifiedBytes := make([]string, len(payload))
@@ -109,6 +527,33 @@ func (queue *redisQueue) PublishBytes(payload ...[]byte) error {
 	return queue.Publish(stringifiedBytes...)
 }
 
+// SetFrozenPolicy defines how Publish() behaves while the queue is frozen
+// (see Freeze()). By default the frozen flag is ignored when publishing. With
+// RejectWhileFrozen Publish() returns ErrorQueueFrozen while the queue is
+// frozen. With BufferWhileFrozen up to bufferLimit deliveries get buffered
+// locally, they get published with the next Publish() or FlushFrozenBuffer()
+// call after the queue got unfrozen. Once the buffer is full Publish() returns
+// ErrorQueueFrozen. Note that buffered deliveries get lost if the process
+// exits before they could be published.
+func (queue *redisQueue) SetFrozenPolicy(policy FrozenPolicy, bufferLimit int) {
+	queue.frozenPolicy = policy
+	queue.frozenLimit = bufferLimit
+}
+
+// FlushFrozenBuffer publishes deliveries which got buffered while the queue
+// was frozen (see SetFrozenPolicy()). Returns ErrorQueueFrozen if the queue is
+// still frozen.
+func (queue *redisQueue) FlushFrozenBuffer() error {
+	frozen, err := queue.IsFrozen()
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return ErrorQueueFrozen
+	}
+	return queue.publishUnlessFrozen(queue.redisClient, nil)
+}
+
 // SetPushQueue sets a push queue. In the consumer function you can call
 // delivery.Push(). If a push queue is set the delivery then gets moved from
 // the original queue to the push queue. If no push queue is set it's
@@ -116,36 +561,92 @@ func (queue *redisQueue) PublishBytes(payload ...[]byte) error {
 // NOTE: panics if pushQueue is not a *redisQueue
 func (queue *redisQueue) SetPushQueue(pushQueue Queue) {
 	queue.pushKey = pushQueue.(*redisQueue).readyKey
+	queue.pushStream = isStreamQueue(pushQueue)
 }
 
 // StartConsuming starts consuming into a channel of size prefetchLimit
 // must be called before consumers can be added!
 // pollDuration is the duration the queue sleeps before checking for new deliveries
+// if zero is passed for either of them the connection's options are used
 func (queue *redisQueue) StartConsuming(prefetchLimit int64, pollDuration time.Duration) error {
 	if queue.deliveryChan != nil {
 		return ErrorAlreadyConsuming
 	}
+	if queue.streams != nil && queue.workStealing {
+		return ErrorStreamMixed
+	}
+
+	if prefetchLimit == 0 {
+		prefetchLimit = queue.options.PrefetchLimit
+	}
+	if pollDuration == 0 {
+		pollDuration = queue.options.PollDuration
+	}
+
+	if err := queue.registerConsuming(prefetchLimit); err != nil {
+		return err
+	}
 
 	// add queue to list of queues consumed on this connection
 	if _, err := queue.redisClient.SAdd(queue.queuesKey, queue.name); err != nil {
+		queue.unregister()
 		return err
 	}
 
+	if queue.journal != nil {
+		if _, err := queue.reclaimJournal(); err != nil {
+			queue.unregister()
+			return err
+		}
+	}
+
 	queue.prefetchLimit = prefetchLimit
 	queue.pollDuration = pollDuration
+	atomic.StoreInt64(&queue.delayedPromoted, time.Now().UnixNano()) // fetch ready deliveries first
 	queue.deliveryChan = make(chan Delivery, prefetchLimit)
 	queue.consumingStopped = make(chan struct{})
+	if queue.stopPolicy != DrainOnStop {
+		queue.consumerStop = queue.consumingStopped
+	}
 	queue.ackCtx, queue.ackCancel = context.WithCancel(context.Background())
-	// log.Printf("rmq queue started consuming %s %d %s", queue, prefetchLimit, pollDuration)
-	go queue.consume()
+	queue.options.logf(LogDebug, "rmq queue started consuming %s %d %s", queue, prefetchLimit, pollDuration)
+	if queue.singleActive {
+		queue.activeStop = queue.startActiveRefresh()
+	}
+	queue.stopWg.Add(1)
+	goroutines.Go("consume", func() { queue.withLabels("", queue.consume) })
 	return nil
 }
 
+// withLabels runs f with pprof labels identifying this queue and the given
+// consumer (if any), so CPU and goroutine profiles of processes consuming
+// many queues can attribute time to specific queues and consumers
+func (queue *redisQueue) withLabels(consumerName string, f func()) {
+	labels := pprof.Labels("rmq_queue", queue.name)
+	if consumerName != "" {
+		labels = pprof.Labels("rmq_queue", queue.name, "rmq_consumer", consumerName)
+	}
+	pprof.Do(context.Background(), labels, func(context.Context) { f() })
+}
+
 func (queue *redisQueue) consume() {
+	defer queue.stopWg.Done()
 	errorCount := 0 // number of consecutive batch errors
 
+	if !queue.waitReady() || !queue.waitStartDelay() {
+		close(queue.deliveryChan)
+		return
+	}
+
 	for {
-		switch err := queue.consumeBatch(); err {
+		queue.takeTurn()
+		err := queue.consumeBatch()
+		if err == nil {
+			err = queue.updateBufferStat()
+		}
+		queue.endTurn()
+
+		switch err {
 		case nil: // success
 			errorCount = 0
 
@@ -159,11 +660,22 @@ func (queue *redisQueue) consume() {
 			case queue.errChan <- &ConsumeError{RedisErr: err, Count: errorCount}:
 			default:
 			}
-			time.Sleep(queue.pollDuration) // sleep before retry
+			queue.sleep() // sleep before retry
 		}
 	}
 }
 
+// sleep waits for the poll duration, or until consuming gets stopped
+func (queue *redisQueue) sleep() {
+	queue.endTurn()
+	timer := time.NewTimer(queue.pollDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-queue.consumingStopped:
+	}
+}
+
 func (queue *redisQueue) consumeBatch() error {
 	select {
 	case <-queue.consumingStopped:
@@ -171,19 +683,81 @@ func (queue *redisQueue) consumeBatch() error {
 	default:
 	}
 
+	if err := queue.releaseMarkers(); err != nil {
+		return err
+	}
+
+	switch frozen, err := queue.IsFrozen(); {
+	case err != nil:
+		return err
+	case frozen:
+		// don't fetch new deliveries while frozen
+		queue.sleep()
+		return nil
+	}
+
+	if queue.singleActive {
+		switch active, err := queue.refreshActive(); {
+		case err != nil:
+			return err
+		case !active:
+			// another connection is consuming, wait for it to stop or die
+			queue.sleep()
+			return nil
+		}
+	}
+
+	if err := queue.promoteDelayed(queue.pollDuration, queue.prefetchLimit); err != nil {
+		return err
+	}
+
 	// unackedCount == <deliveries in deliveryChan> + <deliveries in Consume()>
 	unackedCount, err := queue.unackedCount()
 	if err != nil {
 		return err
 	}
 
+	if queue.maxUnacked > 0 && unackedCount >= queue.maxUnacked {
+		// leave the backlog in ready until consumers handle some deliveries
+		queue.sleep()
+		return nil
+	}
+
 	batchSize := queue.prefetchLimit - unackedCount
+	if queue.maxUnacked > 0 && queue.maxUnacked-unackedCount < batchSize {
+		batchSize = queue.maxUnacked - unackedCount
+	}
 	if batchSize <= 0 {
 		// already at prefetch limit, wait for consumers to finish
-		time.Sleep(queue.pollDuration) // sleep before retry
+		if queue.workStealing {
+			if err := queue.shareWork(); err != nil {
+				return err
+			}
+		}
+		if err := queue.handleOverflow(unackedCount); err != nil {
+			return err
+		}
+		queue.sleep() // sleep before retry
+		queue.blockedDuration += queue.pollDuration
 		return nil
 	}
 
+	if queue.overflowed {
+		if err := queue.handleOverflow(unackedCount); err != nil {
+			return err
+		}
+		if queue.overflowed && queue.overflowPolicy == ReturnOnOverflow {
+			queue.sleep() // wait for consumers to catch up
+			return nil
+		}
+	}
+
+	if queue.streams != nil {
+		return queue.consumeStream(batchSize)
+	}
+
+	// deliveries handed off by other connections are consumed before ready ones
+	fromHandoff := true
 	for i := int64(0); i < batchSize; i++ {
 		select {
 		case <-queue.consumingStopped:
@@ -191,10 +765,29 @@ func (queue *redisQueue) consumeBatch() error {
 		default:
 		}
 
-		payload, err := queue.redisClient.RPopLPush(queue.readyKey, queue.unackedKey)
+		if !queue.waitForRateLimit() {
+			return ErrorConsumingStopped
+		}
+
+		var payload string
+		if fromHandoff {
+			payload, err = queue.redisClient.RPopLPush(queue.handoffKey, queue.unackedKey)
+			if err == ErrorNotFound {
+				// no (more) handed off deliveries, continue with ready ones
+				fromHandoff = false
+			}
+		}
+		if !fromHandoff {
+			payload, err = queue.fetchReady()
+		}
 		if err == ErrorNotFound {
 			// ready list currently empty, wait for new deliveries
-			time.Sleep(queue.pollDuration)
+			if queue.workStealing && len(queue.deliveryChan) == 0 {
+				if err := queue.setIdle(true); err != nil {
+					return err
+				}
+			}
+			queue.sleep()
 			return nil
 		}
 
@@ -202,120 +795,772 @@ func (queue *redisQueue) consumeBatch() error {
 			return err
 		}
 
-		queue.deliveryChan <- queue.newDelivery(payload)
+		queue.fetched++
+		if queue.idle {
+			if err := queue.setIdle(false); err != nil {
+				return err
+			}
+		}
+
+		delivery := queue.newDelivery(payload)
+		if queue.holdMarker(delivery) || !queue.decode(delivery) {
+			continue
+		}
+		queue.journalAdd(delivery)
+		select {
+		case queue.deliveryChan <- delivery:
+			continue
+		default: // buffer full, wait for consumers below
+		}
+
+		blockedSince := time.Now()
+		queue.endTurn()
+		select {
+		case queue.deliveryChan <- delivery:
+			queue.blockedDuration += time.Since(blockedSince)
+			queue.takeTurn()
+		case <-queue.consumingStopped:
+			queue.takeTurn()
+			// with LeaveOnStop the delivery remains unacked, the cleaner will return it
+			if queue.stopPolicy != LeaveOnStop {
+				if err := queue.returnDelivery(delivery); err != nil {
+					return err
+				}
+			}
+			return ErrorConsumingStopped
+		}
 	}
 
 	return nil
 }
 
-func (queue *redisQueue) newDelivery(payload string) Delivery {
-	return newDelivery(
-		queue.ackCtx,
-		payload,
-		queue.unackedKey,
-		queue.rejectedKey,
-		queue.pushKey,
-		queue.redisClient,
-		queue.errChan,
-	)
-}
-
-// StopConsuming can be used to stop all consumers on this queue. It returns a
-// channel which can be used to wait for all active consumers to finish their
-// current Consume() call. This is useful to implement graceful shutdown.
-func (queue *redisQueue) StopConsuming() <-chan struct{} {
-	finishedChan := make(chan struct{})
-
-	if queue.deliveryChan == nil { // not consuming
-		close(finishedChan)
-		return finishedChan
+// waitReady waits until the application signaled readiness, see
+// WithReadySignal(). Returns false if consuming got stopped in the meantime.
+func (queue *redisQueue) waitReady() bool {
+	if queue.ready == nil {
+		return true
 	}
 
 	select {
-	case <-queue.consumingStopped: // already stopped
-		close(finishedChan)
-		return finishedChan
-	default:
+	case <-queue.ready:
+		queue.options.logf(LogDebug, "rmq queue ready to consume %s", queue)
+		return true
+	case <-queue.consumingStopped:
+		return false
 	}
-
-	// log.Printf("rmq queue stopping %s", queue)
-	close(queue.consumingStopped)
-	go func() {
-		queue.ackCancel()
-		queue.stopWg.Wait()
-		close(finishedChan)
-		// log.Printf("rmq queue stopped consuming %s", queue)
-	}()
-
-	return finishedChan
 }
 
-// AddConsumer adds a consumer to the queue and returns its internal name
-func (queue *redisQueue) AddConsumer(tag string, consumer Consumer) (name string, err error) {
-	queue.stopWg.Add(1)
-	name, err = queue.addConsumer(tag)
-	if err != nil {
-		return "", err
+// waitStartDelay waits for the start delay plus a random jitter before the
+// first deliveries get fetched, see WithStartDelay(). Returns false if
+// consuming got stopped in the meantime.
+func (queue *redisQueue) waitStartDelay() bool {
+	delay := queue.startDelay
+	if queue.startJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(queue.startJitter)))
+	}
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-queue.consumingStopped:
+		return false
 	}
-	go queue.consumerConsume(consumer)
-	return name, nil
 }
 
-func (queue *redisQueue) consumerConsume(consumer Consumer) {
-	defer queue.stopWg.Done()
-	for {
-		select {
-		case <-queue.consumingStopped: // prefer this case
-			return
-		default:
-		}
+// updateBufferStat writes the prefetch buffer stats and the consumer
+// concurrency of this connection to redis once per heartbeat interval, see
+// QueueStat.BufferFillRatio() and QueueStat.Concurrency(). It also
+// adds the number of fetched deliveries to the queue's counter, which is used
+// by ScalerHandler to derive the processing rate.
+func (queue *redisQueue) updateBufferStat() error {
+	if atomic.CompareAndSwapInt32(&queue.statsReset, 1, 0) {
+		queue.blockedDuration = 0
+		queue.durations.reset()
+		queue.bufferUpdated = time.Time{} // write reset stats immediately
+	}
 
-		select {
-		case <-queue.consumingStopped:
-			return
+	now := time.Now()
+	if now.Sub(queue.bufferUpdated) < queue.options.HeartbeatInterval {
+		return nil
+	}
 
-		case delivery, ok := <-queue.deliveryChan:
-			if !ok { // deliveryChan closed
-				return
-			}
+	if queue.fetched > 0 {
+		if _, err := queue.redisClient.IncrBy(queue.fetchedKey, queue.fetched); err != nil {
+			return err
+		}
+		queue.fetched = 0
+	}
 
-			consumer.Consume(delivery)
+	stat := fmt.Sprintf("%d %d %d %d", len(queue.deliveryChan), cap(queue.deliveryChan), queue.blockedDuration, atomic.LoadInt64(&queue.concurrency))
+	if err := queue.redisClient.Set(queue.bufferKey, stat, queue.options.HeartbeatDuration); err != nil {
+		return err
+	}
+	if queue.durations.len() > 0 {
+		if err := queue.redisClient.Set(queue.durationsKey, queue.durations.encode(), queue.options.HeartbeatDuration); err != nil {
+			return err
 		}
 	}
+	queue.bufferUpdated = now
+	return nil
 }
 
-// AddConsumerFunc adds a consumer which is defined only by a function. This is
-// similar to http.HandlerFunc and useful if your consumers don't need any
-// state.
-func (queue *redisQueue) AddConsumerFunc(tag string, consumerFunc ConsumerFunc) (string, error) {
-	return queue.AddConsumer(tag, consumerFunc)
+// bufferStat reads the prefetch buffer stats and the consumer concurrency of
+// this connection written by updateBufferStat(). Returns zeros if there are
+// none. Stats written by older versions have no concurrency.
+func (queue *redisQueue) bufferStat() (buffered, size int64, blocked time.Duration, concurrency int64, err error) {
+	stat, err := queue.redisClient.Get(queue.bufferKey)
+	if err == ErrorNotFound {
+		return 0, 0, 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if n, err := fmt.Sscanf(stat, "%d %d %d %d", &buffered, &size, &blocked, &concurrency); err != nil && n < 3 {
+		return 0, 0, 0, 0, err
+	}
+	return buffered, size, blocked, concurrency, nil
 }
 
-// AddBatchConsumer is similar to AddConsumer, but for batches of deliveries
-// timeout limits the amount of time waiting to fill an entire batch
-// The timer is only started when the first message in a batch is received
-func (queue *redisQueue) AddBatchConsumer(tag string, batchSize int64, timeout time.Duration, consumer BatchConsumer) (string, error) {
-	queue.stopWg.Add(1)
-	name, err := queue.addConsumer(tag)
+// durationsStat reads the handler duration sketch of this connection written
+// by updateBufferStat(). Returns an empty sketch if there is none.
+func (queue *redisQueue) durationsStat() (*durationSketch, error) {
+	encoded, err := queue.redisClient.Get(queue.durationsKey)
+	if err == ErrorNotFound {
+		return newDurationSketch(), nil
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	go queue.consumerBatchConsume(batchSize, timeout, consumer)
-	return name, nil
+	return decodeDurationSketch(encoded)
 }
 
-func (queue *redisQueue) consumerBatchConsume(batchSize int64, timeout time.Duration, consumer BatchConsumer) {
-	defer queue.stopWg.Done()
-	batch := []Delivery{}
-	for {
-		select {
-		case <-queue.consumingStopped: // prefer this case
-			return
-		default:
-		}
+// ResetStats resets the accumulated counters of this queue, so dashboards can
+// be re-baselined after incidents. These are the number of deliveries fetched
+// by all connections (see ScalerHandler) and the time this connection spent
+// waiting for its consumers (see QueueStat.BlockedDuration()) along with the
+// durations its consumers took (see QueueStat.HandlerDuration()). Resets get
+// logged at LogInfo level and recorded in redis, see StatsResets().
+func (queue *redisQueue) ResetStats() error {
+	atomic.StoreInt32(&queue.statsReset, 1)
+	if _, err := queue.redisClient.Del(queue.fetchedKey); err != nil {
+		return err
+	}
+	if _, err := queue.redisClient.Del(queue.bufferKey); err != nil {
+		return err
+	}
+	if _, err := queue.redisClient.Del(queue.durationsKey); err != nil {
+		return err
+	}
+	queue.options.logf(LogInfo, "rmq queue reset stats %s", queue)
+	return queue.recordReset(time.Now())
+}
+
+// only the latest resets are kept, see StatsResets()
+const maxStatsResets = 100
+
+// StatsReset records a call of Queue.ResetStats()
+type StatsReset struct {
+	Connection string    `json:"connection"` // connection which reset the stats
+	Time       time.Time `json:"time"`
+}
+
+// recordReset adds a reset done by this connection at now to the queue's
+// resets, dropping the oldest ones beyond maxStatsResets
+func (queue *redisQueue) recordReset(now time.Time) error {
+	encoded, err := json.Marshal(StatsReset{Connection: queue.connectionName, Time: now})
+	if err != nil {
+		return err
+	}
+	if _, err := queue.redisClient.LPush(queue.resetsKey, string(encoded)); err != nil {
+		return err
+	}
+	return queue.redisClient.LTrim(queue.resetsKey, 0, maxStatsResets-1)
+}
+
+// StatsResets returns the latest resets of this queue's stats by any
+// connection, newest first, so dashboards can tell when their counters got
+// re-baselined. Only the latest 100 resets are kept.
+func (queue *redisQueue) StatsResets() ([]StatsReset, error) {
+	values, err := queue.redisClient.LRange(queue.resetsKey, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	resets := make([]StatsReset, 0, len(values))
+	for _, value := range values {
+		var reset StatsReset
+		if err := json.Unmarshal([]byte(value), &reset); err != nil {
+			return nil, err
+		}
+		resets = append(resets, reset)
+	}
+	return resets, nil
+}
+
+// fetchedCount returns the total number of deliveries fetched from this
+// queue by consumers of all connections, see updateBufferStat()
+func (queue *redisQueue) fetchedCount() (int64, error) {
+	count, err := queue.redisClient.Get(queue.fetchedKey)
+	if err == ErrorNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(count, 10, 64)
+}
+
+// handleOverflow applies the overflow policy if the consumers can't keep up
+// with the prefetched deliveries. The queue counts as saturated until the
+// consumers took all the waiting deliveries. With ReturnOnOverflow no new
+// deliveries get prefetched while saturated, that is until one of the
+// consumers finished a delivery.
+func (queue *redisQueue) handleOverflow(unackedCount int64) error {
+	buffered := len(queue.deliveryChan)
+	if queue.overflowed {
+		if queue.overflowPolicy == ReturnOnOverflow {
+			queue.overflowed = unackedCount >= queue.overflowUnacked
+		} else {
+			queue.overflowed = buffered > 0
+		}
+		return nil
+	}
+	if buffered == 0 {
+		return nil // consumers are keeping up
+	}
+	queue.overflowed = true
+
+	switch queue.overflowPolicy {
+	case ReturnOnOverflow:
+		returned, err := queue.returnBuffered(buffered)
+		queue.overflowUnacked = unackedCount - int64(returned)
+		return err
 
+	case NotifyOnOverflow:
+		select { // try to add error to channel, but don't block
+		case queue.errChan <- &SaturationError{Queue: queue.name, Buffered: buffered, Unacked: unackedCount}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// returnBuffered returns up to n prefetched deliveries to the ready list and
+// returns how many it returned
+func (queue *redisQueue) returnBuffered(n int) (int, error) {
+	for i := 0; i < n; i++ {
+		var delivery *redisDelivery
 		select {
-		case <-queue.consumingStopped:
+		case d := <-queue.deliveryChan:
+			delivery = d.(*redisDelivery)
+		default: // consumers took the rest
+			return i, nil
+		}
+
+		// push before removing from unacked, see returnDelivery()
+		if _, err := queue.redisClient.RPush(queue.readyKeyOf(delivery.payload), delivery.payload); err != nil {
+			queue.deliveryChan <- delivery // we just made room for it
+			return i, err
+		}
+		queue.journalRemove(delivery)
+		if _, err := queue.redisClient.LRem(queue.unackedKey, 1, delivery.payload); err != nil {
+			return i + 1, err
+		}
+	}
+	return n, nil
+}
+
+// fetchReady moves the next ready delivery to the unacked list and returns it.
+// Returns ErrorNotFound if there is none.
+func (queue *redisQueue) fetchReady() (string, error) {
+	if queue.tenants != nil {
+		return queue.fetchTenant()
+	}
+	if queue.priorities != nil {
+		return queue.fetchPriority()
+	}
+
+	readyKey := queue.readyKey
+	if len(queue.siblingReadyKeys) > 0 {
+		oldestKey, err := queue.oldestReadyKey()
+		if err != nil {
+			return "", err
+		}
+		if oldestKey == "" {
+			return "", ErrorNotFound
+		}
+		readyKey = oldestKey
+	}
+
+	return queue.redisClient.RPopLPush(readyKey, queue.unackedKey)
+}
+
+// oldestReadyKey returns the key of the ready list (of this queue or one of
+// its siblings) whose next delivery got published first, empty if all ready
+// lists are empty
+func (queue *redisQueue) oldestReadyKey() (string, error) {
+	oldestKey := ""
+	var oldestTime time.Time
+	for _, readyKey := range append([]string{queue.readyKey}, queue.siblingReadyKeys...) {
+		payload, err := queue.redisClient.LIndex(readyKey, -1) // next one to be consumed
+		if err == ErrorNotFound {
+			continue // empty
+		}
+		if err != nil {
+			return "", err
+		}
+
+		header, _ := decodeHeader(payload)
+		publishedAt, ok := header.publishedAt()
+		if !ok {
+			return readyKey, nil // unknown publish time counts as oldest
+		}
+		if oldestKey == "" || publishedAt.Before(oldestTime) {
+			oldestKey, oldestTime = readyKey, publishedAt
+		}
+	}
+	return oldestKey, nil
+}
+
+func (queue *redisQueue) newDelivery(payload string) *redisDelivery {
+	rejectedKey := queue.rejectedKey
+	if queue.deadLetterKey != "" {
+		rejectedKey = queue.deadLetterKey
+	}
+
+	// deliveries fetched without StartConsuming() can't be stopped
+	ackCtx := queue.ackCtx
+	if ackCtx == nil {
+		ackCtx = context.Background()
+	}
+
+	delivery := newDelivery(
+		ackCtx,
+		payload,
+		queue.unackedKey,
+		rejectedKey,
+		queue.pushKey,
+		strings.Replace(queueCheckpointTemplate, phQueue, queue.name, 1),
+		queue.delayedKey,
+		queue.connectionName,
+		queue.redisClient,
+		queue.errChan,
+		queue.options.RetryInterval,
+	)
+	delivery.errorPolicy = queue.errorPolicy
+	delivery.retryPolicy = queue.retryPolicy
+	delivery.lostAcksKey = queue.lostAcksKey
+	delivery.queueName = queue.name
+	delivery.pushStream = queue.pushStream
+	if queue.dryRun {
+		delivery.restoreKey = queue.readyKey
+	}
+	if queue.deadLetterKey == "" {
+		delivery.classesKey = queue.classesKey
+	}
+	return delivery
+}
+
+// ConsumeOne fetches a single delivery from the queue without the need to
+// call StartConsuming() and add consumers. If the queue is empty (or frozen,
+// or at its unacked cap, see WithMaxUnacked()) it polls until a delivery is available or the context is done, in which
+// case the context's error is returned. Deliveries whose deadline passed get
+// dropped (see Header.SetDeadline()). The caller must call Ack(), Reject()
+// or Push() on the returned delivery. This is useful for tools, cron jobs and
+// tests which prefer pulling deliveries over the consumer callbacks.
+func (queue *redisQueue) ConsumeOne(ctx context.Context) (Delivery, error) {
+	// add queue to list of queues consumed on this connection, so the cleaner
+	// can return the delivery if this process dies before acking it
+	if _, err := queue.redisClient.SAdd(queue.queuesKey, queue.name); err != nil {
+		return nil, err
+	}
+
+	for {
+		frozen, err := queue.IsFrozen()
+		if err != nil {
+			return nil, err
+		}
+
+		capped := false
+		if !frozen && queue.maxUnacked > 0 {
+			unackedCount, err := queue.unackedCount()
+			if err != nil {
+				return nil, err
+			}
+			capped = unackedCount >= queue.maxUnacked
+		}
+
+		if !frozen && !capped {
+			if err := queue.promoteDelayed(queue.options.PollDuration, promoteBatchSize); err != nil {
+				return nil, err
+			}
+			delivery, err := queue.fetchDelivery()
+			if err == nil {
+				if !queue.decode(delivery) || queue.dropExpired(delivery) {
+					continue
+				}
+				return delivery, nil
+			}
+			if err != ErrorNotFound {
+				return nil, err
+			}
+		}
+
+		// wait for new deliveries
+		timer := time.NewTimer(queue.options.PollDuration)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// fetchDelivery moves the next ready delivery to unacked and returns it, see
+// ConsumeOne(). Returns ErrorNotFound if there is none.
+func (queue *redisQueue) fetchDelivery() (*redisDelivery, error) {
+	if queue.streams != nil {
+		return queue.fetchStream()
+	}
+	payload, err := queue.fetchReady()
+	if err != nil {
+		return nil, err
+	}
+	return queue.newDelivery(payload), nil
+}
+
+// Deliveries returns a channel of deliveries fetched from the queue one at a
+// time (see ConsumeOne()). The next delivery only gets fetched once the
+// previous one has been received from the channel. The caller must call
+// Ack(), Reject() or Push() on each received delivery, which gives full
+// control over concurrency and batching. Once the context is done the channel
+// gets closed. Redis errors get sent to the connection's error channel as
+// ConsumeError.
+func (queue *redisQueue) Deliveries(ctx context.Context) <-chan Delivery {
+	deliveries := make(chan Delivery)
+	goroutines.Go("deliveries", func() {
+		queue.withLabels("", func() { queue.fetchDeliveries(ctx, deliveries) })
+	})
+	return deliveries
+}
+
+func (queue *redisQueue) fetchDeliveries(ctx context.Context, deliveries chan<- Delivery) {
+	defer close(deliveries)
+	errorCount := 0 // number of consecutive errors
+
+	for {
+		delivery, err := queue.ConsumeOne(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			errorCount++
+			select { // try to add error to channel, but don't block
+			case queue.errChan <- &ConsumeError{RedisErr: err, Count: errorCount}:
+			default:
+			}
+			select { // sleep before retry
+			case <-time.After(queue.options.PollDuration):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		errorCount = 0
+
+		select {
+		case deliveries <- delivery:
+		case <-ctx.Done():
+			// nobody took it, make it available for other consumers again
+			if err := queue.returnDelivery(delivery.(*redisDelivery)); err != nil {
+				select { // try to add error to channel, but don't block
+				case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+				default:
+				}
+			}
+			return
+		}
+	}
+}
+
+// returnDelivery moves the unacked delivery back to the ready list, to be
+// consumed next. Deliveries read from the stream get appended to it again
+// instead, see WithStream().
+func (queue *redisQueue) returnDelivery(delivery *redisDelivery) error {
+	if delivery.streams != nil {
+		_, err := delivery.streams.XReturn(delivery.streamKey, streamGroup, delivery.streamID)
+		return err
+	}
+	// push before removing from unacked, so a crash in between leads to double
+	// delivery instead of a lost delivery
+	payload := delivery.payload
+	if _, err := queue.redisClient.RPush(queue.readyKeyOf(payload), payload); err != nil {
+		return err
+	}
+	_, err := queue.redisClient.LRem(queue.unackedKey, 1, payload)
+	return err
+}
+
+// stoppedConsuming returns whether consuming on this queue got stopped
+func (queue *redisQueue) stoppedConsuming() bool {
+	if queue.consumingStopped == nil { // never started
+		return false
+	}
+	select {
+	case <-queue.consumingStopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// StopConsuming can be used to stop all consumers on this queue. It returns a
+// channel which can be used to wait for all active consumers to finish their
+// current Consume() call. This is useful to implement graceful shutdown.
+// What happens to prefetched deliveries which haven't been consumed yet
+// depends on the stop policy, see WithStopPolicy(). It's safe to call more
+// than once and concurrently, all calls return the same channel.
+func (queue *redisQueue) StopConsuming() <-chan struct{} {
+	finishedChan := make(chan struct{})
+
+	if queue.deliveryChan == nil { // not consuming
+		close(finishedChan)
+		return finishedChan
+	}
+
+	// the heartbeat and Options.Context might stop consuming while the
+	// application does, all of them wait for the same consumers to finish
+	queue.stopOnce.Do(func() {
+		queue.stopFinished = finishedChan
+		queue.stop(finishedChan)
+	})
+	return queue.stopFinished
+}
+
+// stop stops consuming and closes finishedChan once all consumers finished,
+// see StopConsuming()
+func (queue *redisQueue) stop(finishedChan chan struct{}) {
+	queue.options.logf(LogDebug, "rmq queue stopping %s", queue)
+	close(queue.consumingStopped)
+	goroutines.Go("stop", func() {
+		queue.ackCancel()
+		stopWatchdog := queue.startWatchdog()
+		queue.stopWg.Wait()
+		stopWatchdog()
+		if queue.stopPolicy == ReturnOnStop {
+			queue.returnStopped()
+		}
+		if queue.activeStop != nil {
+			queue.activeStop()
+			queue.releaseActive()
+		}
+		queue.unregister()
+		close(finishedChan)
+		queue.options.logf(LogDebug, "rmq queue stopped consuming %s", queue)
+	})
+}
+
+// StopConsumingContext stops consuming like StopConsuming() and waits for
+// the consumers to finish their current Consume() call. Returns the
+// context's error if it's done before they finished.
+func (queue *redisQueue) StopConsumingContext(ctx context.Context) error {
+	select {
+	case <-queue.StopConsuming():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddConsumer adds a consumer to the queue and returns its internal name
+func (queue *redisQueue) AddConsumer(tag string, consumer Consumer) (name string, err error) {
+	queue.stopWg.Add(1)
+	name, err = queue.addConsumer(tag, 1)
+	if err != nil {
+		queue.stopWg.Done() // consumer didn't start
+		return "", err
+	}
+	goroutines.Go("consumer", func() {
+		queue.withLabels(name, func() { queue.consumerConsume(name, consumer) })
+	})
+	return name, nil
+}
+
+// returnStopped returns the prefetched deliveries left in the closed delivery
+// channel to ready after consuming stopped (see ReturnOnStop)
+func (queue *redisQueue) returnStopped() {
+	if err := queue.returnMarkers(); err != nil {
+		select { // try to add error to channel, but don't block
+		case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+		default:
+		}
+		return // the cleaner will return the rest
+	}
+	for delivery := range queue.deliveryChan {
+		if err := queue.returnDelivery(delivery.(*redisDelivery)); err != nil {
+			select { // try to add error to channel, but don't block
+			case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+			default:
+			}
+			return // the cleaner will return the rest
+		}
+		queue.journalRemove(delivery)
+	}
+}
+
+func (queue *redisQueue) consumerConsume(name string, consumer Consumer) {
+	defer queue.stopWg.Done()
+	state := queue.newConsumerState(name, consumer)
+	defer queue.dispatcher.remove(state.dispatch)
+	for {
+		select {
+		case <-queue.consumerStop: // prefer this case
+			return
+		default:
+		}
+
+		if !queue.dispatcher.wait(state.dispatch, queue.consumerStop) {
+			return
+		}
+
+		select {
+		case <-queue.consumerStop:
+			queue.dispatcher.done(state.dispatch)
+			return
+
+		case delivery, ok := <-queue.deliveryChan:
+			queue.dispatcher.done(state.dispatch)
+			if !ok { // deliveryChan closed
+				return
+			}
+			if queue.consumeTracked(state, delivery) {
+				return // evicted or quarantined
+			}
+		}
+	}
+}
+
+// consumerState is what a consumer's loop keeps track of between deliveries
+type consumerState struct {
+	name      string
+	consumer  Consumer
+	dispatch  *dispatchConsumer
+	slowCount int // number of consecutive slow deliveries, see checkSlow()
+	panics    *panicTracker
+}
+
+// newConsumerState registers the consumer with the dispatcher, call
+// dispatcher.remove() once it stopped consuming
+func (queue *redisQueue) newConsumerState(name string, consumer Consumer) *consumerState {
+	state := &consumerState{name: name, consumer: consumer, dispatch: queue.dispatcher.add(name), panics: &panicTracker{}}
+	if queue.panicStrikes > 0 {
+		state.consumer = state.panics.recovering(consumer)
+	}
+	return state
+}
+
+// consumeTracked consumes a delivery the consumer took, tracking it for the
+// dispatcher and the shutdown watchdog. Returns whether the consumer got
+// evicted or quarantined and must stop consuming.
+func (queue *redisQueue) consumeTracked(state *consumerState, delivery Delivery) bool {
+	queue.dispatcher.track(state.dispatch, delivery)
+	consumed := queue.trackConsuming(state.name, delivery)
+	duration := queue.consumeDelivery(state.consumer, delivery)
+	consumed()
+	if queue.checkSlow(state.name, duration, &state.slowCount) {
+		return true // evicted
+	}
+	return queue.checkPanics(state.name, state.panics) // quarantined
+}
+
+// consumeDelivery passes the delivery to the consumer and auto acks it if
+// configured (see WithAutoAck()). Returns how long the consumer took, which
+// gets added to the handler duration stats.
+func (queue *redisQueue) consumeDelivery(consumer Consumer, delivery Delivery) time.Duration {
+	queue.journalRemove(delivery)
+	if queue.dropExpired(delivery) {
+		return 0
+	}
+	if redisDelivery, ok := delivery.(*redisDelivery); ok && queue.semaphore != nil {
+		if !queue.acquireSemaphore(redisDelivery) {
+			return 0
+		}
+	}
+	release := queue.acquire()
+	consumed := queue.watchAckDeadline(delivery)
+	start := time.Now()
+	consumer.Consume(delivery)
+	duration := time.Since(start)
+	release()
+	queue.durations.add(duration)
+	if queue.autoAck || queue.dryRun {
+		autoAck(delivery)
+	}
+	consumed()
+	return duration
+}
+
+// dropExpired acks the delivery without consuming it if its deadline passed
+// (see Header.SetDeadline()) and returns whether it did so
+func (queue *redisQueue) dropExpired(delivery Delivery) bool {
+	redisDelivery, ok := delivery.(*redisDelivery)
+	if !ok || !redisDelivery.expired() {
+		return false
+	}
+
+	queue.options.logf(LogDebug, "rmq queue dropping expired delivery %s %s", queue, redisDelivery)
+	redisDelivery.Ack() // redis errors get reported by Ack() itself
+	return true
+}
+
+// autoAck acks the delivery unless it has already been acked, rejected or pushed
+// redis errors get reported by Ack() itself
+func autoAck(delivery Delivery) {
+	if delivery, ok := delivery.(*redisDelivery); ok && !delivery.handled() {
+		delivery.Ack()
+	}
+}
+
+// AddConsumerFunc adds a consumer which is defined only by a function. This is
+// similar to http.HandlerFunc and useful if your consumers don't need any
+// state.
+func (queue *redisQueue) AddConsumerFunc(tag string, consumerFunc ConsumerFunc) (string, error) {
+	return queue.AddConsumer(tag, consumerFunc)
+}
+
+// AddBatchConsumer is similar to AddConsumer, but for batches of deliveries
+// timeout limits the amount of time waiting to fill an entire batch
+// The timer is only started when the first message in a batch is received
+func (queue *redisQueue) AddBatchConsumer(tag string, batchSize int64, timeout time.Duration, consumer BatchConsumer) (string, error) {
+	queue.stopWg.Add(1)
+	name, err := queue.addConsumer(tag, batchSize)
+	if err != nil {
+		queue.stopWg.Done() // consumer didn't start
+		return "", err
+	}
+	goroutines.Go("batch consumer", func() {
+		queue.withLabels(name, func() { queue.consumerBatchConsume(batchSize, timeout, consumer) })
+	})
+	return name, nil
+}
+
+func (queue *redisQueue) consumerBatchConsume(batchSize int64, timeout time.Duration, consumer BatchConsumer) {
+	defer queue.stopWg.Done()
+	batch := []Delivery{}
+	for {
+		select {
+		case <-queue.consumerStop: // prefer this case
+			return
+		default:
+		}
+
+		select {
+		case <-queue.consumerStop:
 			return
 
 		case delivery, ok := <-queue.deliveryChan: // Wait for first delivery
@@ -329,7 +1574,27 @@ func (queue *redisQueue) consumerBatchConsume(batchSize int64, timeout time.Dura
 				return
 			}
 
+			unexpired := batch[:0]
+			for _, delivery := range batch {
+				queue.journalRemove(delivery)
+				if !queue.dropExpired(delivery) {
+					unexpired = append(unexpired, delivery)
+				}
+			}
+			if batch = unexpired; len(batch) == 0 {
+				continue
+			}
+
+			release := queue.acquire()
+			start := time.Now()
 			consumer.Consume(batch)
+			queue.durations.add(time.Since(start))
+			release()
+			if queue.autoAck || queue.dryRun {
+				for _, delivery := range batch {
+					autoAck(delivery)
+				}
+			}
 			batch = batch[:0] // reset batch
 		}
 	}
@@ -340,21 +1605,21 @@ func (queue *redisQueue) batchTimeout(batchSize int64, batch []Delivery, timeout
 	defer timer.Stop()
 	for {
 		select {
-		case <-queue.consumingStopped: // prefer this case
+		case <-queue.consumerStop: // prefer this case
 			return nil, false
 		default:
 		}
 
 		select {
-		case <-queue.consumingStopped: // consuming stopped: abort batch
+		case <-queue.consumerStop: // consuming stopped: abort batch
 			return nil, false
 
 		case <-timer.C: // timeout: submit batch
 			return batch, true
 
 		case delivery, ok := <-queue.deliveryChan:
-			if !ok { // deliveryChan closed: abort batch
-				return nil, false
+			if !ok { // deliveryChan closed: abort batch, unless draining
+				return batch, queue.consumerStop == nil
 			}
 
 			batch = append(batch, delivery)
@@ -365,10 +1630,15 @@ func (queue *redisQueue) batchTimeout(batchSize int64, batch []Delivery, timeout
 	}
 }
 
-func (queue *redisQueue) addConsumer(tag string) (name string, err error) {
+// addConsumer registers a consumer which consumes up to concurrency
+// deliveries at once, see QueueStat.Concurrency()
+func (queue *redisQueue) addConsumer(tag string, concurrency int64) (name string, err error) {
 	if queue.deliveryChan == nil {
 		return "", ErrorNotConsuming
 	}
+	if queue.singleActive && atomic.LoadInt32(&queue.consumerCount) > 0 {
+		return "", ErrorSingleConsumer
+	}
 
 	name = fmt.Sprintf("%s-%s", tag, RandomString(6))
 
@@ -376,18 +1646,97 @@ func (queue *redisQueue) addConsumer(tag string) (name string, err error) {
 	if _, err := queue.redisClient.SAdd(queue.consumersKey, name); err != nil {
 		return "", err
 	}
+	if err := publishQueueEvent(queue.redisClient, QueueEvent{Event: ConsumerAdded, Queue: queue.name, Connection: queue.connectionName, Consumer: name}); err != nil {
+		return "", err
+	}
 
-	// log.Printf("rmq queue added consumer %s %s", queue, name)
+	atomic.AddInt32(&queue.consumerCount, 1)
+	atomic.AddInt64(&queue.concurrency, concurrency)
+	atomic.AddInt32(&queue.runningCount, 1)
+	queue.registerConsumer(tag)
+	queue.options.logf(LogDebug, "rmq queue added consumer %s %s", queue, name)
 	return name, nil
 }
 
-// PurgeReady removes all ready deliveries from the queue and returns the number of purged deliveries
+// Freeze freezes the queue globally: All connections consuming this queue
+// stop fetching ready deliveries until the queue gets unfrozen. Deliveries
+// which have already been fetched still get consumed.
+func (queue *redisQueue) Freeze() error {
+	return queue.redisClient.Set(queue.frozenKey, "1", 0)
+}
+
+// Unfreeze unfreezes the queue, see Freeze()
+func (queue *redisQueue) Unfreeze() error {
+	_, err := queue.redisClient.Del(queue.frozenKey)
+	return err
+}
+
+// IsFrozen returns whether the queue is currently frozen, see Freeze()
+func (queue *redisQueue) IsFrozen() (bool, error) {
+	return queue.isFrozen(queue.redisClient)
+}
+
+func (queue *redisQueue) isFrozen(redisClient RedisClient) (bool, error) {
+	switch _, err := redisClient.Get(queue.frozenKey); err {
+	case nil:
+		return true, nil
+	case ErrorNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// PurgeReady removes all ready deliveries from the queue, including the ones
+// published with a priority or for a tenant, and returns the number of
+// purged deliveries.
+// With WithPurgeUndo() they can be restored via UndoPurge() for a while.
 func (queue *redisQueue) PurgeReady() (int64, error) {
-	return queue.deleteRedisList(queue.readyKey)
+	if queue.options.Operations.ForbidPurgeReady {
+		return 0, ErrorForbidden
+	}
+	if err := queue.checkList(); err != nil {
+		return 0, err
+	}
+	readyKeys, err := queue.readyKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, readyKey := range readyKeys {
+		var count int64
+		if queue.purgeUndo > 0 {
+			count, err = queue.redisClient.LPushAllExpire(readyKey, queue.purgedKey, queue.purgeUndo)
+		} else {
+			count, err = queue.deleteRedisList(readyKey)
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged += count
+	}
+	return purged, nil
+}
+
+// UndoPurge returns the deliveries purged via PurgeReady() within the undo
+// window of WithPurgeUndo() to ready, in front of all deliveries published
+// since. Returns the number of restored deliveries. They all get restored to
+// the queue's own ready list, so ones published with a priority other than 0
+// get consumed with priority 0 and ones published for a tenant take turns
+// with the ones published without tenant.
+func (queue *redisQueue) UndoPurge() (int64, error) {
+	if err := queue.checkList(); err != nil {
+		return 0, err
+	}
+	return queue.redisClient.RPushAll(queue.purgedKey, queue.readyKey)
 }
 
 // PurgeRejected removes all rejected deliveries from the queue and returns the number of purged deliveries
 func (queue *redisQueue) PurgeRejected() (int64, error) {
+	if queue.options.Operations.ForbidPurgeRejected {
+		return 0, ErrorForbidden
+	}
 	return queue.deleteRedisList(queue.rejectedKey)
 }
 
@@ -407,32 +1756,104 @@ func (queue *redisQueue) deleteRedisList(key string) (int64, error) {
 			batchSize = todo
 		}
 
-		// remove one batch
-		err := queue.redisClient.LTrim(key, 0, -1-batchSize)
+		// remove one batch
+		err := queue.redisClient.LTrim(key, 0, -1-batchSize)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return total, nil
+}
+
+// ReturnUnacked tries to return max unacked deliveries back to
+// the ready queue and returns the number of returned deliveries, see
+// SetRedeliveryOrder()
+func (queue *redisQueue) ReturnUnacked(max int64) (count int64, error error) {
+	if err := queue.loadStream(); err != nil {
+		return 0, err
+	}
+	if queue.streams != nil {
+		return queue.returnStream(max, "")
+	}
+	order, err := queue.RedeliveryOrder()
+	if err != nil {
+		return 0, err
+	}
+	return queue.redeliver(queue.unackedKey, max, order)
+}
+
+// ReturnUnackedWithProgress is like ReturnUnacked(), but returns the
+// deliveries in chunks of chunkSize and calls progress with the total number
+// of returned deliveries after each chunk. Stops before the next chunk once
+// ctx is done and returns the context's error along with the number of
+// deliveries returned so far. This is useful to return huge unacked lists,
+// which would otherwise block without any visibility.
+func (queue *redisQueue) ReturnUnackedWithProgress(ctx context.Context, max, chunkSize int64, progress func(returned int64)) (total int64, err error) {
+	if err := queue.checkList(); err != nil {
+		return 0, err
+	}
+	if chunkSize <= 0 {
+		chunkSize = max
+	}
+	order, err := queue.RedeliveryOrder()
+	if err != nil {
+		return 0, err
+	}
+
+	for total < max {
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		default:
+		}
+
+		chunk := chunkSize
+		if max-total < chunk {
+			chunk = max - total
+		}
+		n, err := queue.redeliver(queue.unackedKey, chunk, order)
+		total += n
 		if err != nil {
-			return 0, err
+			return total, err
+		}
+		if progress != nil && n > 0 {
+			progress(total)
+		}
+		if n < chunk { // nothing left
+			break
 		}
 	}
-
 	return total, nil
 }
 
-// ReturnUnacked tries to return max unacked deliveries back to
-// the ready queue and returns the number of returned deliveries
-func (queue *redisQueue) ReturnUnacked(max int64) (count int64, error error) {
-	return queue.move(queue.unackedKey, queue.readyKey, max)
+// ReturnRejected tries to return max rejected deliveries back to the ready
+// list of their priority and returns the number of returned deliveries
+func (queue *redisQueue) ReturnRejected(max int64) (count int64, err error) {
+	if err := queue.loadStream(); err != nil {
+		return 0, err
+	}
+	if queue.streams != nil {
+		return queue.returnRejectedStream(max)
+	}
+	return queue.moveReady(queue.redisClient.RPopLPush, queue.rejectedKey, max, TrailReturned, false)
 }
 
-// ReturnRejected tries to return max rejected deliveries back to
-// the ready queue and returns the number of returned deliveries
-func (queue *redisQueue) ReturnRejected(max int64) (count int64, err error) {
-	return queue.move(queue.rejectedKey, queue.readyKey, max)
+// move moves up to max deliveries from the end of one list to the start of
+// another. Deliveries with trail get the given event added to it.
+func (queue *redisQueue) move(from, to string, max int64, event string) (n int64, error error) {
+	return queue.moveWith(queue.redisClient.RPopLPush, from, to, max, event, false)
 }
 
-func (queue *redisQueue) move(from, to string, max int64) (n int64, error error) {
+// moveWith is like move(), but moves each delivery with the given function.
+// If tail is set it moves them to the end of the other list.
+func (queue *redisQueue) moveWith(pop func(from, to string) (string, error), from, to string, max int64, event string, tail bool) (n int64, error error) {
 	for n = 0; n < max; n++ {
-		switch _, err := queue.redisClient.RPopLPush(from, to); err {
+		switch payload, err := pop(from, to); err {
 		case nil: // moved one
+			if err := queue.addBreadcrumb(to, payload, event, tail); err != nil {
+				return n, err
+			}
 			continue
 		case ErrorNotFound: // nothing left
 			return n, nil
@@ -443,16 +1864,202 @@ func (queue *redisQueue) move(from, to string, max int64) (n int64, error error)
 	return n, nil
 }
 
+// moveReady is like moveWith(), but moves each delivery to the ready list of
+// its priority, see PublishWithPriority()
+func (queue *redisQueue) moveReady(pop func(from, to string) (string, error), from string, max int64, event string, tail bool) (n int64, err error) {
+	priorities, err := queue.readPriorities()
+	if err != nil {
+		return 0, err
+	}
+	if len(priorities) == 0 {
+		return queue.moveWith(pop, from, queue.readyKey, max, event, tail)
+	}
+
+	next := int64(-1) // pop takes the last delivery unless moving to the tail
+	if tail {
+		next = 0
+	}
+	for n = 0; n < max; n++ {
+		// look at the next delivery first, so it keeps its priority
+		payload, err := queue.redisClient.LIndex(from, next)
+		if err == ErrorNotFound { // nothing left
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		readyKey := queue.readyKeyOf(payload)
+
+		switch payload, err := pop(from, readyKey); err {
+		case nil: // moved one
+			if err := queue.addBreadcrumb(readyKey, payload, event, tail); err != nil {
+				return n, err
+			}
+		case ErrorNotFound: // nothing left
+			return n, nil
+		default: // error
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// HandoffUnacked tries to hand off max unacked deliveries to the consumers of
+// the same queue in the connection with the given name and returns the number
+// of handed off deliveries. This is useful for rolling restarts: Instead of
+// returning in-flight deliveries to the ready list (where they would be
+// consumed after all other ready deliveries) they get consumed by the given
+// connection before any ready deliveries, preserving their order.
+// The other connection must be alive and consuming this queue, otherwise
+// ErrorNotFound is returned.
+// NOTE: Only call this after StopConsuming() finished, otherwise prefetched
+// deliveries might get consumed twice.
+func (queue *redisQueue) HandoffUnacked(connectionName string, max int64) (int64, error) {
+	if err := queue.checkList(); err != nil {
+		return 0, err
+	}
+	if err := queue.checkHandoffTarget(connectionName); err != nil {
+		return 0, err
+	}
+	return queue.move(queue.unackedKey, queueHandoffKey(connectionName, queue.name), max, TrailHandedOff)
+}
+
+// checkHandoffTarget returns ErrorNotFound if the connection with the given
+// name is not alive or doesn't consume this queue
+func (queue *redisQueue) checkHandoffTarget(connectionName string) error {
+	heartbeatKey := strings.Replace(connectionHeartbeatTemplate, phConnection, connectionName, 1)
+	ttl, err := queue.redisClient.TTL(heartbeatKey)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return ErrorNotFound
+	}
+
+	consumingQueuesKey := strings.Replace(connectionQueuesTemplate, phConnection, connectionName, 1)
+	queueNames, err := queue.redisClient.SMembers(consumingQueuesKey)
+	if err != nil {
+		return err
+	}
+	for _, queueName := range queueNames {
+		if queueName == queue.name {
+			return nil
+		}
+	}
+
+	return ErrorNotFound
+}
+
 // Destroy purges and removes the queue from the list of queues
 func (queue *redisQueue) Destroy() (readyCount, rejectedCount int64, err error) {
-	readyCount, err = queue.PurgeReady()
+	if queue.options.Operations.ForbidDestroy {
+		return 0, 0, ErrorForbidden
+	}
+
+	readyCount, err = queue.deleteRedisList(queue.readyKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	streamCount, err := queue.destroyStream()
+	if err != nil {
+		return 0, 0, err
+	}
+	readyCount += streamCount
+	rejectedCount, err = queue.deleteRedisList(queue.rejectedKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	classes, err := queue.RejectedClasses()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, class := range classes {
+		count, err := queue.deleteRedisList(queueRejectedClassKey(queue.name, class))
+		if err != nil {
+			return 0, 0, err
+		}
+		rejectedCount += count
+	}
+
+	if _, err := queue.redisClient.Del(queue.classesKey); err != nil {
+		return 0, 0, err
+	}
+	tenants, err := queue.Tenants()
 	if err != nil {
 		return 0, 0, err
 	}
-	rejectedCount, err = queue.PurgeRejected()
+	for _, tenant := range tenants {
+		count, err := queue.deleteRedisList(queueTenantKey(queue.name, tenant))
+		if err != nil {
+			return 0, 0, err
+		}
+		readyCount += count
+	}
+	if _, err := queue.redisClient.Del(queue.tenantsKey); err != nil {
+		return 0, 0, err
+	}
+	priorities, err := queue.readPriorities()
 	if err != nil {
 		return 0, 0, err
 	}
+	for _, priority := range priorities {
+		count, err := queue.deleteRedisList(queuePriorityKey(queue.name, priority))
+		if err != nil {
+			return 0, 0, err
+		}
+		readyCount += count
+		if _, err := queue.redisClient.Del(queueDelayedKey(queue.name, priority)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err := queue.redisClient.Del(queue.bandsKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.feedsKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.tagsKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.redeliveryKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.delayedKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.purgedKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.deleteRedisList(queue.republishedKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.frozenKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.retentionKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.requeueKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.returnedKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.agingKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.cleanedKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.lostAcksKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.fetchedKey); err != nil {
+		return 0, 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.resetsKey); err != nil {
+		return 0, 0, err
+	}
 
 	count, err := queue.redisClient.SRem(queuesKey, queue.name)
 	if err != nil {
@@ -461,19 +2068,169 @@ func (queue *redisQueue) Destroy() (readyCount, rejectedCount int64, err error)
 	if count == 0 {
 		return 0, 0, ErrorNotFound
 	}
+	if err := publishQueueEvent(queue.redisClient, QueueEvent{Event: QueueDestroyed, Queue: queue.name, Connection: queue.connectionName}); err != nil {
+		return readyCount, rejectedCount, err
+	}
 
 	return readyCount, rejectedCount, nil
 }
 
+// WaitUntilEmpty blocks until the queue has neither ready, delayed nor unacked
+// deliveries (across all connections) or until the context is done, in which
+// case the context's error is returned. This is useful to wait for a pipeline
+// stage to be fully drained.
+func (queue *redisQueue) WaitUntilEmpty(ctx context.Context) error {
+	pollDuration := minEmptyPollDuration
+	for {
+		empty, err := queue.isEmpty()
+		if err != nil {
+			return err
+		}
+		if empty {
+			return nil
+		}
+
+		timer := time.NewTimer(pollDuration)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		if pollDuration *= 2; pollDuration > maxEmptyPollDuration {
+			pollDuration = maxEmptyPollDuration
+		}
+	}
+}
+
+// Message is a delivery as stored in a list of a queue, see PeekReady()
+type Message struct {
+	Header  Header
+	Payload string
+}
+
+// ID returns the ID of the delivery, see Queue.RepublishRejected(). It's only
+// valid if the payload didn't get redacted, see Options.Redact.
+func (message Message) ID() string {
+	return deliveryID(encodeHeader(message.Header, message.Payload))
+}
+
+// PeekReady returns up to max of the oldest ready deliveries without
+// consuming them, oldest first. This is useful to inspect parked deliveries
+// (see NewRetrier()), for example their trail (see WithTrail()).
+func (queue *redisQueue) PeekReady(max int64) ([]Message, error) {
+	if err := queue.checkList(); err != nil {
+		return nil, err
+	}
+	return queue.peek(queue.readyKey, max)
+}
+
+// PeekRejected returns up to max of the oldest rejected deliveries without
+// returning them, oldest first
+func (queue *redisQueue) PeekRejected(max int64) ([]Message, error) {
+	return queue.peek(queue.rejectedKey, max)
+}
+
+func (queue *redisQueue) peek(key string, max int64) ([]Message, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	payloads, err := queue.redisClient.LRange(key, -max, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, len(payloads))
+	for i, payload := range payloads {
+		message := &messages[len(payloads)-1-i] // oldest is last
+		message.Header, message.Payload = decodeHeader(payload)
+		message.Payload = queue.options.redact(message.Payload)
+	}
+	return messages, nil
+}
+
+// isEmpty returns whether the queue has neither ready nor unacked deliveries
+// in any connection
+func (queue *redisQueue) isEmpty() (bool, error) {
+	readyCount, err := queue.readyCount()
+	if err != nil || readyCount > 0 {
+		return false, err
+	}
+	delayedKeys, _, err := queue.delayedLists()
+	if err != nil {
+		return false, err
+	}
+	for _, delayedKey := range delayedKeys {
+		delayedCount, err := queue.redisClient.ZCard(delayedKey)
+		if err != nil || delayedCount > 0 {
+			return false, err
+		}
+	}
+
+	connectionNames, err := scanMembers(queue.redisClient, connectionsKey)
+	if err != nil {
+		return false, err
+	}
+
+	if queue.streams != nil { // loaded by readyCount()
+		pending, err := queue.streams.XPendingCount(queue.streamKey, streamGroup, "")
+		if err != nil || pending > 0 {
+			return false, err
+		}
+	}
+	for _, connectionName := range connectionNames {
+		unackedKey := strings.Replace(connectionQueueUnackedTemplate, phConnection, connectionName, 1)
+		unackedKey = strings.Replace(unackedKey, phQueue, queue.name, 1)
+		for _, key := range []string{unackedKey, queueHandoffKey(connectionName, queue.name)} {
+			count, err := queue.redisClient.LLen(key)
+			if err != nil || count > 0 {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
 // closeInStaleConnection closes the queue in the associated connection by removing all related keys
 // not supposed to be called on queues in active sessions
 func (queue *redisQueue) closeInStaleConnection() error {
 	if _, err := queue.redisClient.Del(queue.unackedKey); err != nil {
 		return err
 	}
+	if err := queue.loadStream(); err != nil {
+		return err
+	}
+	if queue.streams != nil {
+		if err := queue.streams.XDelConsumer(queue.streamKey, streamGroup, queue.connectionName); err != nil {
+			return err
+		}
+	}
+	if _, err := queue.redisClient.Del(queue.bufferKey); err != nil {
+		return err
+	}
+	if _, err := queue.redisClient.Del(queue.durationsKey); err != nil {
+		return err
+	}
+	if _, err := queue.redisClient.Del(queue.handoffKey); err != nil {
+		return err
+	}
+	if _, err := queue.redisClient.SRem(queue.idleKey, queue.connectionName); err != nil {
+		return err
+	}
+	consumers, err := queue.getConsumers()
+	if err != nil {
+		return err
+	}
 	if _, err := queue.redisClient.Del(queue.consumersKey); err != nil {
 		return err
 	}
+	for _, consumer := range consumers {
+		if err := publishQueueEvent(queue.redisClient, QueueEvent{Event: ConsumerRemoved, Queue: queue.name, Connection: queue.connectionName, Consumer: consumer}); err != nil {
+			return err
+		}
+	}
 
 	count, err := queue.redisClient.SRem(queue.queuesKey, queue.name)
 	if err != nil {
@@ -487,10 +2244,187 @@ func (queue *redisQueue) closeInStaleConnection() error {
 }
 
 func (queue *redisQueue) readyCount() (int64, error) {
-	return queue.redisClient.LLen(queue.readyKey)
+	readyKeys, err := queue.readyKeys()
+	if err != nil {
+		return 0, err
+	}
+	lengths, err := queue.redisClient.LLens(readyKeys...)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := queue.streamCount()
+	if err != nil {
+		return 0, err
+	}
+	for _, length := range lengths {
+		count += length
+	}
+	return count, nil
+}
+
+// readyKeys returns all ready lists of the queue: its own one first, then the
+// ones of priorities other than 0 and the ones of tenants, see
+// PublishWithPriority() and PublishForTenant()
+func (queue *redisQueue) readyKeys() ([]string, error) {
+	priorities, err := queue.readPriorities()
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := queue.Tenants()
+	if err != nil {
+		return nil, err
+	}
+
+	readyKeys := make([]string, 0, 1+len(priorities)+len(tenants))
+	readyKeys = append(readyKeys, queue.readyKey)
+	for _, priority := range priorities {
+		readyKeys = append(readyKeys, queuePriorityKey(queue.name, priority))
+	}
+	for _, tenant := range tenants {
+		readyKeys = append(readyKeys, queueTenantKey(queue.name, tenant))
+	}
+	return readyKeys, nil
+}
+
+// returnCleaned returns the unacked deliveries of this connection back to the
+// ready list, used by the cleaner
+func (queue *redisQueue) returnCleaned() (int64, error) {
+	if err := queue.loadStream(); err != nil {
+		return 0, err
+	}
+	if queue.streams != nil {
+		heartbeatKey := strings.Replace(connectionHeartbeatTemplate, phConnection, queue.connectionName, 1)
+		return queue.returnStream(math.MaxInt64, heartbeatKey)
+	}
+	return queue.moveCleaned(queue.unackedKey)
+}
+
+// returnHandoff returns deliveries handed off to this connection back to the
+// ready list, used by the cleaner
+func (queue *redisQueue) returnHandoff() (int64, error) {
+	return queue.moveCleaned(queue.handoffKey)
+}
+
+// moveCleaned moves all deliveries from the given list to the ready list like
+// move(), but each move atomically checks that the heartbeat of the queue's
+// connection is still missing. If the connection came back (for example after
+// a heartbeat blip) it stops and returns errorConnectionAlive along with the
+// number of moved deliveries, so the cleaner doesn't return deliveries which
+// the connection is still consuming.
+// The deliveries go to the ready list of their priority (see
+// PublishWithPriority()) in the queue's redelivery order, see
+// SetRedeliveryOrder().
+func (queue *redisQueue) moveCleaned(from string) (n int64, err error) {
+	heartbeatKey := strings.Replace(connectionHeartbeatTemplate, phConnection, queue.connectionName, 1)
+	order, err := queue.RedeliveryOrder()
+	if err != nil {
+		return 0, err
+	}
+	pop, next := queue.redisClient.RPopLPushUnless, int64(-1)
+	if order == RedeliverFirst {
+		pop, next = queue.redisClient.LPopRPushUnless, 0
+	}
+	priorities, err := queue.readPriorities()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		readyKey := queue.readyKey
+		if len(priorities) > 0 {
+			// look at the next delivery first, so it keeps its priority
+			payload, err := queue.redisClient.LIndex(from, next)
+			if err == ErrorNotFound { // nothing left
+				return n, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			readyKey = queue.readyKeyOf(payload)
+		}
+
+		payload, guarded, err := pop(from, readyKey, heartbeatKey)
+		switch {
+		case err == ErrorNotFound: // nothing left
+			return n, nil
+		case err != nil:
+			return 0, err
+		case guarded:
+			return n, errorConnectionAlive
+		}
+		n++
+		if err := queue.addBreadcrumb(readyKey, payload, TrailCleaned, order == RedeliverFirst); err != nil {
+			return n, err
+		}
+	}
+}
+
+// countCleaned adds to the number of deliveries which cleaners returned from
+// dead connections to the ready list of this queue and notifies subscribers
+// of SubscribeQueueEvents()
+func (queue *redisQueue) countCleaned(count int64) error {
+	if _, err := queue.redisClient.IncrBy(queue.cleanedKey, count); err != nil {
+		return err
+	}
+	return publishQueueEvent(queue.redisClient, QueueEvent{Event: QueueCleaned, Queue: queue.name, Connection: queue.connectionName, Count: count})
+}
+
+// cleanedCount returns the total number of deliveries which cleaners returned
+// to the ready list of this queue, see countCleaned()
+func (queue *redisQueue) cleanedCount() (int64, error) {
+	count, err := queue.redisClient.Get(queue.cleanedKey)
+	if err == ErrorNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(count, 10, 64)
+}
+
+// lostAckCount returns the total number of acks of deliveries of this queue
+// which weren't unacked anymore, see Delivery.Ack()
+func (queue *redisQueue) lostAckCount() (int64, error) {
+	count, err := queue.redisClient.Get(queue.lostAcksKey)
+	if err == ErrorNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(count, 10, 64)
+}
+
+// addBreadcrumb adds the given event to the trail of a delivery which just got
+// moved to the start (or the end if tail is set) of the given list, if it has
+// a trail. The updated delivery gets pushed before the original one gets
+// removed, so a crash in between duplicates the delivery instead of losing it.
+func (queue *redisQueue) addBreadcrumb(key, payload, event string, tail bool) error {
+	updated, ok := addBreadcrumb(payload, event, queue.connectionName)
+	if !ok {
+		return nil
+	}
+	if tail {
+		if _, err := queue.redisClient.RPush(key, updated); err != nil {
+			return err
+		}
+		_, err := queue.redisClient.LRem(key, -1, payload)
+		return err
+	}
+	if _, err := queue.redisClient.LPush(key, updated); err != nil {
+		return err
+	}
+	_, err := queue.redisClient.LRem(key, 1, payload)
+	return err
 }
 
 func (queue *redisQueue) unackedCount() (int64, error) {
+	if err := queue.loadStream(); err != nil {
+		return 0, err
+	}
+	if queue.streams != nil {
+		return queue.streams.XPendingCount(queue.streamKey, streamGroup, queue.connectionName)
+	}
 	return queue.redisClient.LLen(queue.unackedKey)
 }
 
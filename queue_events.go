@@ -0,0 +1,206 @@
+package rmq
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/adjust/rmq/v4/internal/goroutines"
+)
+
+// events published about the lifecycle of queues, see SubscribeQueueEvents()
+const (
+	QueueOpened      = "opened"           // Connection.OpenQueue() got called
+	QueueDestroyed   = "destroyed"        // Queue.Destroy() got called
+	QueueCleaned     = "cleaned"          // a cleaner returned Count deliveries of a dead connection
+	ConsumerAdded    = "consumer_added"   // a consumer got added, see Queue.AddConsumer()
+	ConsumerRemoved  = "consumer_removed" // a consumer got evicted or cleaned with its dead connection
+	Quarantined      = "quarantined"      // a consumer panicked Count times and stopped taking deliveries, see WithPanicQuarantine()
+	PublishRateAbove = "rate_above"       // Count deliveries per second got published, crossing the threshold
+	PublishRateBelow = "rate_below"       // Count deliveries per second got published, dropping below the threshold
+)
+
+// number of events buffered for subscribers which don't keep up
+const queueEventsBufferSize = 100
+
+// QueueEvent notifies about a change in the lifecycle of a queue
+type QueueEvent struct {
+	Event      string `json:"event"` // QueueOpened, QueueDestroyed etc.
+	Queue      string `json:"queue"`
+	Connection string `json:"connection"`         // connection which caused the event, the dead one for QueueCleaned
+	Consumer   string `json:"consumer,omitempty"` // for ConsumerAdded, ConsumerRemoved and Quarantined
+	Count      int64  `json:"count,omitempty"`    // for QueueCleaned, Quarantined, PublishRateAbove and PublishRateBelow
+}
+
+// SubscribeQueueEvents returns the events published whenever any connection
+// opens or destroys a queue, adds or removes consumers, crosses its publish
+// rate threshold (see WithPublishRateThreshold()) or a cleaner returns
+// deliveries. This way components like dashboards or pattern consumers learn
+// about changes right away instead of polling. Note that queues get opened
+// whenever a process starts publishing or consuming, so the same queue can be
+// reported as opened many times. Events
+// don't get stored, so subscribers miss the ones published while they're
+// not subscribed. The events chan gets closed after calling unsubscribe.
+// All subscribers of a connection share a single subscription, so they hold
+// a single redis connection no matter how many there are.
+// NOTE: panics if connection is not a redis connection
+func SubscribeQueueEvents(connection Connection) (events <-chan QueueEvent, unsubscribe func() error, err error) {
+	redisConnection := connection.(*redisConnection)
+	return redisConnection.queueEvents.subscribe(redisConnection.redisClient)
+}
+
+// queueEventHub shares a subscription to the queue events among the
+// subscribers of a connection, see SubscribeQueueEvents()
+type queueEventHub struct {
+	mu      sync.Mutex
+	current *queueEventSubscription // nil if there are no subscribers
+}
+
+// queueEventSubscription is a subscription to the queue events and the
+// subscribers it passes them on to
+type queueEventSubscription struct {
+	subscribers map[chan QueueEvent]struct{}
+	unsubscribe func() error
+}
+
+// subscribe adds a subscriber, subscribing to the queue events if it's the
+// first one
+func (hub *queueEventHub) subscribe(redisClient RedisClient) (<-chan QueueEvent, func() error, error) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.current == nil {
+		messages, unsubscribe, err := redisClient.Subscribe(queueEventsChannel)
+		if err != nil {
+			return nil, nil, err
+		}
+		subscription := &queueEventSubscription{subscribers: map[chan QueueEvent]struct{}{}, unsubscribe: unsubscribe}
+		goroutines.Go("queue events", func() { hub.fanOut(subscription, messages) })
+		hub.current = subscription
+	}
+
+	subscription := hub.current
+	decoded := make(chan QueueEvent, queueEventsBufferSize)
+	subscription.subscribers[decoded] = struct{}{}
+	return decoded, func() error { return hub.unsubscribe(subscription, decoded) }, nil
+}
+
+// fanOut passes the decoded messages on to the subscribers of the
+// subscription until it gets closed
+func (hub *queueEventHub) fanOut(subscription *queueEventSubscription, messages <-chan string) {
+	for message := range messages {
+		var event QueueEvent
+		if err := json.Unmarshal([]byte(message), &event); err != nil {
+			continue // not published by rmq
+		}
+		hub.mu.Lock()
+		for decoded := range subscription.subscribers {
+			select { // drop events if the subscriber doesn't keep up
+			case decoded <- event:
+			default:
+			}
+		}
+		hub.mu.Unlock()
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for decoded := range subscription.subscribers {
+		close(decoded)
+		delete(subscription.subscribers, decoded)
+	}
+	if hub.current == subscription {
+		hub.current = nil
+	}
+}
+
+// unsubscribe removes the subscriber, unsubscribing from the queue events if
+// it was the last one
+func (hub *queueEventHub) unsubscribe(subscription *queueEventSubscription, decoded chan QueueEvent) error {
+	hub.mu.Lock()
+	if _, ok := subscription.subscribers[decoded]; !ok { // already unsubscribed
+		hub.mu.Unlock()
+		return nil
+	}
+	close(decoded)
+	delete(subscription.subscribers, decoded)
+	last := len(subscription.subscribers) == 0
+	if last && hub.current == subscription {
+		hub.current = nil
+	}
+	hub.mu.Unlock()
+
+	if !last {
+		return nil
+	}
+	return subscription.unsubscribe() // not holding mu, fanOut might need it to drain the messages
+}
+
+// publishQueueEvent notifies subscribers of SubscribeQueueEvents()
+func publishQueueEvent(redisClient RedisClient, event QueueEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil { // can't happen for strings and ints
+		return err
+	}
+	return redisClient.Publish(queueEventsChannel, string(message))
+}
+
+// WithPublishRateThreshold makes the queue publish a PublishRateAbove event
+// once this process published more than threshold deliveries per second to
+// it, and a PublishRateBelow event once the rate dropped to threshold or
+// below again. The rate gets measured in windows of one second, which end on
+// the first publish after a second passed. So a queue which doesn't get
+// published to at all reports PublishRateBelow only once publishing resumes.
+func WithPublishRateThreshold(threshold int64) QueueOption {
+	return func(queue *redisQueue) {
+		queue.publishRate = &publishRate{threshold: threshold}
+	}
+}
+
+// publishRate tracks how many deliveries per second this process publishes
+// to a queue, see WithPublishRateThreshold()
+type publishRate struct {
+	mu        sync.Mutex
+	threshold int64
+	start     time.Time // of the current window
+	count     int64     // deliveries published in the current window
+	above     bool      // whether the rate of the previous window exceeded the threshold
+}
+
+// track counts the given number of published deliveries. Returns the event
+// to publish if the previous window just ended with the rate crossing the
+// threshold.
+func (rate *publishRate) track(now time.Time, published int) (event QueueEvent, crossed bool) {
+	rate.mu.Lock()
+	defer rate.mu.Unlock()
+
+	if rate.start.IsZero() {
+		rate.start = now
+	}
+	if elapsed := now.Sub(rate.start); elapsed >= time.Second {
+		perSecond := rate.count * int64(time.Second) / int64(elapsed)
+		if above := perSecond > rate.threshold; above != rate.above {
+			rate.above = above
+			event, crossed = QueueEvent{Event: PublishRateBelow, Count: perSecond}, true
+			if above {
+				event.Event = PublishRateAbove
+			}
+		}
+		rate.start, rate.count = now, 0
+	}
+	rate.count += int64(published)
+	return event, crossed
+}
+
+// trackPublishRate publishes an event if the publish rate of this process
+// crossed the threshold, see WithPublishRateThreshold()
+func (queue *redisQueue) trackPublishRate(published int) {
+	event, crossed := queue.publishRate.track(time.Now(), published)
+	if !crossed {
+		return
+	}
+	event.Queue, event.Connection = queue.name, queue.connectionName
+	if err := publishQueueEvent(queue.redisClient, event); err != nil {
+		queue.options.logf(LogInfo, "rmq queue failed to publish rate event %s: %s", queue, err)
+	}
+}
@@ -0,0 +1,180 @@
+package rmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueEvents(t *testing.T) {
+	connection, err := OpenConnection("events-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+
+	name := connection.(*redisConnection).Name
+
+	events, unsubscribe, err := SubscribeQueueEvents(connection)
+	require.NoError(t, err)
+
+	queue, err := connection.OpenQueue("events-q")
+	assert.NoError(t, err)
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+
+	for _, expected := range []QueueEvent{
+		{Event: QueueOpened, Queue: "events-q", Connection: name},
+		{Event: QueueDestroyed, Queue: "events-q", Connection: name},
+	} {
+		select {
+		case event := <-events:
+			assert.Equal(t, expected, event)
+		case <-time.After(time.Second):
+			t.Fatalf("missing event %v", expected)
+		}
+	}
+
+	assert.NoError(t, unsubscribe())
+	for range events { // gets closed
+	}
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestQueueLifecycleEvents(t *testing.T) {
+	connection, err := OpenConnection("events-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	name := connection.(*redisConnection).Name
+	queue, err := connection.OpenQueue("events-lifecycle-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	events, unsubscribe, err := SubscribeQueueEvents(connection)
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	consumerName, err := queue.AddConsumerFunc("events-cons", func(Delivery) {}) // never acks
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("events-d1"))
+	time.Sleep(10 * time.Millisecond)
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+
+	cleanerConnection, err := OpenConnection("events-cleaner", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	_, err = NewCleaner(cleanerConnection).Clean()
+	assert.NoError(t, err)
+
+	for _, expected := range []QueueEvent{
+		{Event: ConsumerAdded, Queue: "events-lifecycle-q", Connection: name, Consumer: consumerName},
+		{Event: QueueCleaned, Queue: "events-lifecycle-q", Connection: name, Count: 1},
+		{Event: ConsumerRemoved, Queue: "events-lifecycle-q", Connection: name, Consumer: consumerName},
+	} {
+		for waiting := true; waiting; {
+			select {
+			case event := <-events:
+				if event.Queue != expected.Queue || event.Event == QueueOpened {
+					continue // from other tests or reopened by the cleaner
+				}
+				assert.Equal(t, expected, event)
+				waiting = false
+			case <-time.After(time.Second):
+				t.Fatalf("missing event %v", expected)
+			}
+		}
+	}
+
+	assert.NoError(t, unsubscribe())
+	assert.NoError(t, cleanerConnection.stopHeartbeat())
+}
+
+func TestPublishRate(t *testing.T) {
+	rate := &publishRate{threshold: 10}
+	start := time.Now()
+
+	_, crossed := rate.track(start, 8)
+	assert.False(t, crossed)
+	_, crossed = rate.track(start.Add(500*time.Millisecond), 8)
+	assert.False(t, crossed) // window not over yet
+
+	event, crossed := rate.track(start.Add(time.Second), 1)
+	assert.True(t, crossed)
+	assert.Equal(t, QueueEvent{Event: PublishRateAbove, Count: 16}, event)
+
+	event, crossed = rate.track(start.Add(2*time.Second), 20)
+	assert.True(t, crossed) // 1 per second in the previous window
+	assert.Equal(t, QueueEvent{Event: PublishRateBelow, Count: 1}, event)
+
+	event, crossed = rate.track(start.Add(3*time.Second), 1)
+	assert.True(t, crossed)
+	assert.Equal(t, QueueEvent{Event: PublishRateAbove, Count: 20}, event)
+	_, crossed = rate.track(start.Add(3500*time.Millisecond), 1)
+	assert.False(t, crossed)
+
+	event, crossed = rate.track(start.Add(5*time.Second), 1)
+	assert.True(t, crossed)
+	assert.Equal(t, QueueEvent{Event: PublishRateBelow, Count: 1}, event) // 2 in 2 seconds
+}
+
+// subscribeCountingClient counts the subscriptions of the client
+type subscribeCountingClient struct {
+	RedisClient
+	subscriptions *int
+}
+
+func (client subscribeCountingClient) Subscribe(channel string) (<-chan string, func() error, error) {
+	*client.subscriptions++
+	return client.RedisClient.Subscribe(channel)
+}
+
+func TestQueueEventsSharedSubscription(t *testing.T) {
+	subscriptions := 0
+	redisClient := subscribeCountingClient{RedisClient: NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})), subscriptions: &subscriptions}
+	connection, err := OpenConnectionWithOptions("events-shared-conn", redisClient, nil, TestOptions)
+	require.NoError(t, err)
+
+	events1, unsubscribe1, err := SubscribeQueueEvents(connection)
+	require.NoError(t, err)
+	events2, unsubscribe2, err := SubscribeQueueEvents(connection)
+	require.NoError(t, err)
+	assert.Equal(t, 1, subscriptions)
+
+	receive := func(events <-chan QueueEvent) {
+		select {
+		case event := <-events:
+			assert.Equal(t, QueueOpened, event.Event)
+			assert.Equal(t, "events-shared-q", event.Queue)
+		case <-time.After(time.Second):
+			t.Fatal("missing event")
+		}
+	}
+	_, err = connection.OpenQueue("events-shared-q")
+	require.NoError(t, err)
+	receive(events1)
+	receive(events2)
+
+	// the others keep receiving events
+	assert.NoError(t, unsubscribe1())
+	assert.NoError(t, unsubscribe1())
+	for range events1 { // gets closed
+	}
+	_, err = connection.OpenQueue("events-shared-q")
+	require.NoError(t, err)
+	receive(events2)
+
+	// the last one unsubscribes
+	assert.NoError(t, unsubscribe2())
+	for range events2 { // gets closed
+	}
+	events3, unsubscribe3, err := SubscribeQueueEvents(connection)
+	require.NoError(t, err)
+	assert.Equal(t, 2, subscriptions)
+	_, err = connection.OpenQueue("events-shared-q")
+	require.NoError(t, err)
+	receive(events3)
+	assert.NoError(t, unsubscribe3())
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
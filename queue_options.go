@@ -0,0 +1,181 @@
+package rmq
+
+import "time"
+
+// QueueOption configures a queue when opening it, see Connection.OpenQueue()
+type QueueOption func(*redisQueue)
+
+// WithPrefetchLimit sets the prefetch limit used if zero is passed to
+// StartConsuming()
+func WithPrefetchLimit(prefetchLimit int64) QueueOption {
+	return func(queue *redisQueue) {
+		queue.options.PrefetchLimit = prefetchLimit
+	}
+}
+
+// WithPollDuration sets the poll duration used if zero is passed to
+// StartConsuming()
+func WithPollDuration(pollDuration time.Duration) QueueOption {
+	return func(queue *redisQueue) {
+		queue.options.PollDuration = pollDuration
+	}
+}
+
+// WithRetryInterval sets how long Ack() and similar wait before retrying
+// after redis errors
+func WithRetryInterval(retryInterval time.Duration) QueueOption {
+	return func(queue *redisQueue) {
+		queue.options.RetryInterval = retryInterval
+	}
+}
+
+// WithLogger sets the logger and log level used by this queue
+func WithLogger(logger Logger, level LogLevel) QueueOption {
+	return func(queue *redisQueue) {
+		queue.options.Logger = logger
+		queue.options.LogLevel = level
+	}
+}
+
+// WithAutoAck makes consumers ack their deliveries automatically once their
+// Consume() call returns, unless they already called Ack(), Reject() or
+// Push() on them
+func WithAutoAck() QueueOption {
+	return func(queue *redisQueue) {
+		queue.autoAck = true
+	}
+}
+
+// WithDeadLetter makes rejected deliveries get published to the given dead
+// letter queue instead of the rejected list of this queue. The dead letter
+// queue must not be opened with WithStream().
+// NOTE: panics if deadLetterQueue is not a *redisQueue
+func WithDeadLetter(deadLetterQueue Queue) QueueOption {
+	return func(queue *redisQueue) {
+		queue.deadLetterKey = deadLetterQueue.(*redisQueue).readyKey
+		queue.streamTargets = queue.streamTargets || isStreamQueue(deadLetterQueue)
+	}
+}
+
+// WithOverflowPolicy sets what happens when the consumers of this queue can't
+// keep up with its prefetched deliveries
+func WithOverflowPolicy(policy OverflowPolicy) QueueOption {
+	return func(queue *redisQueue) {
+		queue.overflowPolicy = policy
+	}
+}
+
+// WithStopPolicy sets what happens to prefetched deliveries which haven't been
+// consumed yet when consuming on this queue gets stopped
+func WithStopPolicy(policy StopPolicy) QueueOption {
+	return func(queue *redisQueue) {
+		queue.stopPolicy = policy
+	}
+}
+
+// WithMaxUnacked caps the number of unacked deliveries of this queue on this
+// connection, including the ones consumers got but didn't ack, reject or
+// push yet. Unlike the prefetch limit it also counts deliveries whose
+// handlers stalled after accepting them. Once the cap is reached no more
+// deliveries get fetched, so the backlog stays in the ready list where
+// ready count alerts see it.
+func WithMaxUnacked(limit int64) QueueOption {
+	return func(queue *redisQueue) {
+		queue.maxUnacked = limit
+	}
+}
+
+// WithStartDelay makes consumers wait for delay plus a random duration of up
+// to jitter after StartConsuming() before fetching the first deliveries, so a
+// fleet of restarted workers doesn't hit redis and downstream systems all at
+// once
+func WithStartDelay(delay, jitter time.Duration) QueueOption {
+	return func(queue *redisQueue) {
+		queue.startDelay = delay
+		queue.startJitter = jitter
+	}
+}
+
+// WithReadySignal makes consumers wait until ready gets closed before
+// fetching the first deliveries, so StartConsuming() can be called early
+// during startup without pulling deliveries into unacked while the
+// application is still warming caches or connecting to its database. The
+// start delay (see WithStartDelay()) begins once ready got closed.
+func WithReadySignal(ready <-chan struct{}) QueueOption {
+	return func(queue *redisQueue) {
+		queue.ready = ready
+	}
+}
+
+// WithPublishTime makes Publish() add the current time to the header of each
+// delivery (see HeaderPublishedAt), which WithOldestFirst() relies on
+func WithPublishTime() QueueOption {
+	return func(queue *redisQueue) {
+		queue.publishTime = true
+	}
+}
+
+// WithOldestFirst makes this queue consume the oldest ready delivery across
+// itself and the given sibling queues, for example shards or priority levels
+// of the same workload. This approximates global FIFO order and bounds the
+// latency of cold siblings while others are hot. The deliveries must have been
+// published with WithPublishTime(), deliveries without publish time count as
+// oldest. Fetched deliveries are acked, rejected and pushed as deliveries of
+// this queue. The siblings must not be opened with WithStream().
+// NOTE: panics if the siblings are not *redisQueue
+func WithOldestFirst(siblings ...Queue) QueueOption {
+	return func(queue *redisQueue) {
+		for _, sibling := range siblings {
+			queue.siblingReadyKeys = append(queue.siblingReadyKeys, sibling.(*redisQueue).readyKey)
+			queue.streamTargets = queue.streamTargets || isStreamQueue(sibling)
+		}
+	}
+}
+
+// WithPurgeUndo makes PurgeReady() keep the purged deliveries for the given
+// undo window, during which UndoPurge() restores them. Purging again within
+// the window adds to the kept deliveries and restarts the window.
+func WithPurgeUndo(window time.Duration) QueueOption {
+	return func(queue *redisQueue) {
+		queue.purgeUndo = window
+	}
+}
+
+// WithRateLimit limits the consumption of this queue to limit deliveries per
+// interval for this connection
+func WithRateLimit(limit int, interval time.Duration) QueueOption {
+	return func(queue *redisQueue) {
+		if limit > 0 {
+			queue.rateInterval = interval / time.Duration(limit)
+		}
+	}
+}
+
+// waitForRateLimit blocks until the next delivery may be fetched according to
+// the rate limit. Returns false if consuming got stopped while waiting.
+func (queue *redisQueue) waitForRateLimit() bool {
+	if queue.rateInterval <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if queue.rateNext.Before(now) {
+		queue.rateNext = now
+	}
+	wait := queue.rateNext.Sub(now)
+	queue.rateNext = queue.rateNext.Add(queue.rateInterval)
+	if wait <= 0 {
+		return true
+	}
+
+	queue.endTurn()
+	defer queue.takeTurn()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-queue.consumingStopped:
+		return false
+	}
+}
@@ -0,0 +1,433 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueOptions(t *testing.T) {
+	connection, err := OpenConnection("queue-opts-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+
+	deadLetterQueue, err := connection.OpenQueue("queue-opts-dlq")
+	assert.NoError(t, err)
+	_, err = deadLetterQueue.PurgeReady()
+	assert.NoError(t, err)
+
+	queue, err := connection.OpenQueue("queue-opts-q",
+		WithPrefetchLimit(5),
+		WithPollDuration(time.Millisecond),
+		WithAutoAck(),
+		WithDeadLetter(deadLetterQueue),
+	)
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.StartConsuming(0, 0))
+	assert.Equal(t, int64(5), queue.(*redisQueue).prefetchLimit)
+	assert.Equal(t, time.Millisecond, queue.(*redisQueue).pollDuration)
+
+	_, err = queue.AddConsumerFunc("queue-opts-cons", func(delivery Delivery) {
+		if delivery.Payload() == "reject" {
+			assert.NoError(t, delivery.Reject())
+		}
+		// other deliveries get acked automatically
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("ack", "reject", "ack"))
+	time.Sleep(10 * time.Millisecond)
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = deadLetterQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestRateLimit(t *testing.T) {
+	connection, err := OpenConnection("rate-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("rate-q", WithRateLimit(10, 100*time.Millisecond))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, queue.Publish("rate-d"))
+	}
+
+	consumer := NewTestConsumer("rate-cons")
+	assert.NoError(t, queue.StartConsuming(20, time.Millisecond))
+	_, err = queue.AddConsumer("rate-cons", consumer)
+	assert.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	consumed := len(consumer.LastDeliveries)
+	require.True(t, consumed >= 4 && consumed <= 7, "consumed %d", consumed)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestStartDelay(t *testing.T) {
+	connection, err := OpenConnection("start-delay-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("start-delay-q", WithStartDelay(30*time.Millisecond, 20*time.Millisecond))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("start-delay-d"))
+
+	consumer := NewTestConsumer("start-delay-cons")
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumer("start-delay-cons", consumer)
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, consumer.LastDeliveries, 0)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, consumer.LastDeliveries, 1)
+	<-queue.StopConsuming()
+
+	// stopping while waiting doesn't fetch anything
+	queue, err = connection.OpenQueue("start-delay-q", WithStartDelay(time.Hour, 0))
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("start-delay-d"))
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	<-queue.StopConsuming()
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestReadySignal(t *testing.T) {
+	connection, err := OpenConnection("ready-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	ready := make(chan struct{})
+	queue, err := connection.OpenQueue("ready-q", WithReadySignal(ready))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("ready-d"))
+
+	consumer := NewTestConsumer("ready-cons")
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumer("ready-cons", consumer)
+	assert.NoError(t, err)
+
+	// nothing gets fetched before the application is ready
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, consumer.LastDeliveries, 0)
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	close(ready)
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, consumer.LastDeliveries, 1)
+	<-queue.StopConsuming()
+
+	// stopping while waiting doesn't fetch anything
+	queue, err = connection.OpenQueue("ready-q", WithReadySignal(make(chan struct{})))
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("ready-d"))
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	<-queue.StopConsuming()
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestConnectionQueueOptions(t *testing.T) {
+	options := TestOptions
+	options.QueueOptions = []QueueOption{
+		WithPrefetchLimit(7),
+		WithRetryInterval(time.Minute),
+	}
+	connection, err := OpenConnectionWithOptions("conn-queue-opts", NewTestRedisClient(), nil, options)
+	assert.NoError(t, err)
+
+	queue, err := connection.OpenQueue("conn-queue-opts-q1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), queue.(*redisQueue).options.PrefetchLimit)
+	assert.Equal(t, time.Minute, queue.(*redisQueue).options.RetryInterval)
+	assert.Equal(t, time.Millisecond, queue.(*redisQueue).options.PollDuration)
+
+	// queue options override connection options
+	queue, err = connection.OpenQueue("conn-queue-opts-q2", WithPrefetchLimit(3))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), queue.(*redisQueue).options.PrefetchLimit)
+	assert.Equal(t, time.Minute, queue.(*redisQueue).options.RetryInterval)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("overflow-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+
+	for _, policy := range []OverflowPolicy{ReturnOnOverflow, NotifyOnOverflow} {
+		queue, err := connection.OpenQueue(fmt.Sprintf("overflow-q-%d", policy),
+			WithPollDuration(time.Millisecond),
+			WithOverflowPolicy(policy),
+		)
+		assert.NoError(t, err)
+		_, err = queue.PurgeReady()
+		assert.NoError(t, err)
+
+		assert.NoError(t, queue.StartConsuming(3, 0))
+		release := make(chan struct{})
+		_, err = queue.AddConsumerFunc("overflow-cons", func(delivery Delivery) {
+			<-release
+			assert.NoError(t, delivery.Ack())
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Publish("d1", "d2", "d3", "d4", "d5", "d6"))
+		time.Sleep(10 * time.Millisecond)
+
+		switch policy {
+		case ReturnOnOverflow:
+			// waiting deliveries got returned, only the consumed one is unacked
+			count, err := queue.unackedCount()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), count)
+			count, err = queue.readyCount()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), count)
+
+		case NotifyOnOverflow:
+			count, err := queue.unackedCount()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(3), count)
+			select {
+			case err := <-errChan:
+				var saturationErr *SaturationError
+				require.True(t, errors.As(err, &saturationErr))
+				assert.Equal(t, 2, saturationErr.Buffered)
+				assert.Equal(t, int64(3), saturationErr.Unacked)
+			default:
+				t.Error("expected SaturationError")
+			}
+		}
+
+		close(release)
+		finishedChan := queue.StopConsuming()
+		_, err = queue.PurgeReady()
+		assert.NoError(t, err)
+		<-finishedChan
+	}
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestStopPolicy(t *testing.T) {
+	connection, err := OpenConnection("stop-policy-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		policy        StopPolicy
+		expectedReady int64
+		expectedUnack int64
+		expectedAcked int64
+	}{
+		{LeaveOnStop, 2, 2, 1},
+		{ReturnOnStop, 4, 0, 1},
+		{DrainOnStop, 2, 0, 3},
+	}
+
+	for _, tc := range tests {
+		queue, err := connection.OpenQueue(fmt.Sprintf("stop-policy-q-%d", tc.policy), WithStopPolicy(tc.policy))
+		assert.NoError(t, err)
+		_, err = queue.PurgeReady()
+		assert.NoError(t, err)
+
+		assert.NoError(t, queue.StartConsuming(3, time.Millisecond))
+		release := make(chan struct{})
+		var acked int64
+		_, err = queue.AddConsumerFunc("stop-policy-cons", func(delivery Delivery) {
+			<-release
+			assert.NoError(t, delivery.Ack())
+			atomic.AddInt64(&acked, 1)
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Publish("d1", "d2", "d3", "d4", "d5"))
+		time.Sleep(10 * time.Millisecond)
+
+		finishedChan := queue.StopConsuming()
+		close(release)
+		<-finishedChan
+
+		count, err := queue.readyCount()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedReady, count, "policy %d", tc.policy)
+		count, err = queue.unackedCount()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedUnack, count, "policy %d", tc.policy)
+		assert.Equal(t, tc.expectedAcked, atomic.LoadInt64(&acked), "policy %d", tc.policy)
+	}
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestOldestFirst(t *testing.T) {
+	connection, err := OpenConnection("oldest-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	coldQueue, err := connection.OpenQueue("oldest-cold", WithPublishTime())
+	assert.NoError(t, err)
+	hotQueue, err := connection.OpenQueue("oldest-hot", WithPublishTime(), WithOldestFirst(coldQueue))
+	assert.NoError(t, err)
+	for _, q := range []Queue{coldQueue, hotQueue} {
+		_, err = q.PurgeReady()
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, coldQueue.Publish("cold-1"))
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, hotQueue.Publish("hot-1", "hot-2", "hot-3"))
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, coldQueue.Publish("cold-2"))
+
+	for _, expected := range []string{"cold-1", "hot-1", "hot-2", "hot-3", "cold-2"} {
+		delivery, err := hotQueue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, expected, delivery.Payload())
+		assert.NotEmpty(t, delivery.Header()[HeaderPublishedAt])
+		assert.NoError(t, delivery.Ack())
+	}
+
+	count, err := hotQueue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestPurgeUndo(t *testing.T) {
+	connection, err := OpenConnection("undo-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("undo-q", WithPurgeUndo(time.Minute))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.UndoPurge() // forget backups of previous runs
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("undo-d1", "undo-d2"))
+	count, err := queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.NoError(t, queue.Publish("undo-d3"))
+	count, err = queue.PurgeReady() // adds to the backup
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	ttl, err := connection.(*redisConnection).redisClient.TTL(queue.(*redisQueue).purgedKey)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+
+	assert.NoError(t, queue.Publish("undo-d4"))
+	count, err = queue.UndoPurge()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	payloads := []string{}
+	for _, message := range messages {
+		payloads = append(payloads, message.Payload)
+	}
+	assert.Equal(t, []string{"undo-d1", "undo-d2", "undo-d3", "undo-d4"}, payloads)
+
+	count, err = queue.UndoPurge() // nothing left to undo
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	// destroying the queue drops the backup too
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	count, err = queue.UndoPurge()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	// without undo window purged deliveries are gone
+	plainQueue, err := connection.OpenQueue("undo-q")
+	assert.NoError(t, err)
+	_, err = plainQueue.PurgeReady()
+	assert.NoError(t, err)
+	count, err = plainQueue.UndoPurge()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestMaxUnacked(t *testing.T) {
+	connection, err := OpenConnection("max-unacked-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("max-unacked-q", WithMaxUnacked(2))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	// handlers accept deliveries but stall acking them
+	var mu sync.Mutex
+	var deliveries []Delivery
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumerFunc("max-unacked-cons", func(delivery Delivery) {
+		mu.Lock()
+		defer mu.Unlock()
+		deliveries = append(deliveries, delivery)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("max-unacked-d1", "max-unacked-d2", "max-unacked-d3", "max-unacked-d4", "max-unacked-d5"))
+	time.Sleep(20 * time.Millisecond)
+
+	unackedCount, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), unackedCount)
+	readyCount, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), readyCount)
+
+	mu.Lock()
+	for _, delivery := range deliveries {
+		assert.NoError(t, delivery.Ack())
+	}
+	mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	readyCount, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), readyCount)
+
+	<-queue.StopConsuming()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = queue.ConsumeOne(ctx) // still capped
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -1,12 +1,15 @@
 package rmq
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -549,7 +552,7 @@ func TestReturnRejected(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(4), count) // delivery 0, 2, 3, 5
 
-	queue.StopConsuming()
+	<-queue.StopConsuming()
 
 	n, err := queue.ReturnRejected(2)
 	assert.NoError(t, err)
@@ -742,6 +745,563 @@ func TestStopConsuming_BatchConsumer(t *testing.T) {
 	assert.NoError(t, connection.stopHeartbeat())
 }
 
+func TestStopConsumingConcurrently(t *testing.T) {
+	connection, err := OpenConnection("stop-concurrently", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("stop-concurrently-q")
+	assert.NoError(t, err)
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	release := make(chan struct{})
+	_, err = queue.AddConsumerFunc("stop-concurrently-cons", func(delivery Delivery) {
+		<-release
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("stop-concurrently-d1"))
+	time.Sleep(10 * time.Millisecond)
+
+	// like the heartbeat stopping all consuming while the application stops
+	var wg sync.WaitGroup
+	finishedChans := make([]<-chan struct{}, 10)
+	for i := range finishedChans {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				finishedChans[i] = queue.StopConsuming()
+				return
+			}
+			finishedChans[i] = connection.StopAllConsuming()
+		}()
+	}
+	wg.Wait()
+
+	// all of them wait for the busy consumer
+	for _, finishedChan := range finishedChans {
+		select {
+		case <-finishedChan:
+			t.Fatal("finished before the consumer")
+		default:
+		}
+	}
+	close(release)
+	for _, finishedChan := range finishedChans {
+		select {
+		case <-finishedChan:
+		case <-time.After(time.Second):
+			t.Fatal("consuming didn't stop")
+		}
+	}
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestHandoffUnacked(t *testing.T) {
+	dyingConn, err := OpenConnection("handoff-dying", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	dyingQueue, err := dyingConn.OpenQueue("handoff-q")
+	assert.NoError(t, err)
+	_, err = dyingQueue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, dyingQueue.StartConsuming(10, time.Millisecond))
+
+	assert.NoError(t, dyingQueue.Publish("handoff-d1", "handoff-d2", "handoff-d3"))
+	time.Sleep(10 * time.Millisecond)
+	<-dyingQueue.StopConsuming()
+	count, err := dyingQueue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	liveConn, err := OpenConnection("handoff-live", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	liveQueue, err := liveConn.OpenQueue("handoff-q")
+	assert.NoError(t, err)
+
+	// live connection is not consuming yet
+	_, err = dyingQueue.HandoffUnacked(liveConn.(*redisConnection).Name, 10)
+	assert.Equal(t, ErrorNotFound, err)
+	_, err = dyingQueue.HandoffUnacked("nope", 10)
+	assert.Equal(t, ErrorNotFound, err)
+
+	assert.NoError(t, liveQueue.StartConsuming(10, time.Millisecond))
+	count, err = dyingQueue.HandoffUnacked(liveConn.(*redisConnection).Name, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.NoError(t, liveQueue.Publish("handoff-d4"))
+	count, err = dyingQueue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	consumer := NewTestConsumer("handoff-cons")
+	_, err = liveQueue.AddConsumer("handoff-cons", consumer)
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	require.Len(t, consumer.LastDeliveries, 4)
+	// handed off deliveries come first, in their original order
+	assert.Equal(t, "handoff-d1", consumer.LastDeliveries[0].Payload())
+	assert.Equal(t, "handoff-d2", consumer.LastDeliveries[1].Payload())
+	assert.Equal(t, "handoff-d3", consumer.LastDeliveries[2].Payload())
+	assert.Equal(t, "handoff-d4", consumer.LastDeliveries[3].Payload())
+
+	<-liveQueue.StopConsuming()
+	assert.NoError(t, dyingConn.stopHeartbeat())
+	assert.NoError(t, liveConn.stopHeartbeat())
+}
+
+func TestReturnAllUnacked(t *testing.T) {
+	connection, err := OpenConnection("return-all-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue1, err := connection.OpenQueue("return-all-q1")
+	assert.NoError(t, err)
+	queue2, err := connection.OpenQueue("return-all-q2")
+	assert.NoError(t, err)
+	for _, queue := range []Queue{queue1, queue2} {
+		_, err = queue.PurgeReady()
+		assert.NoError(t, err)
+		assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	}
+
+	assert.NoError(t, queue1.Publish("return-all-d1", "return-all-d2", "return-all-d3"))
+	assert.NoError(t, queue2.Publish("return-all-d4"))
+	time.Sleep(10 * time.Millisecond)
+	count, err := queue1.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	count, err = connection.ReturnAllUnacked()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+	for queue, expected := range map[Queue]int64{queue1: 3, queue2: 1} {
+		count, err = queue.readyCount()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, count)
+		count, err = queue.unackedCount()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+	}
+
+	// connection stays alive, but doesn't consume anymore
+	assert.NoError(t, connection.checkHeartbeat())
+	assert.NoError(t, queue1.Publish("return-all-d5"))
+	time.Sleep(10 * time.Millisecond)
+	count, err = queue1.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestShutdownConnection(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.HeartbeatInterval = 10 * time.Millisecond
+	errChan := make(chan error, 10)
+	connection, err := OpenConnectionWithOptions("shutdown-conn", redisClient, errChan, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("shutdown-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	assert.NoError(t, queue.Publish("shutdown-d1", "shutdown-d2"))
+	time.Sleep(10 * time.Millisecond)
+
+	adminConnection, err := OpenConnection("shutdown-admin", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrorNotFound, adminConnection.ShutdownConnection("nope"))
+	assert.NoError(t, adminConnection.ShutdownConnection(connection.(*redisConnection).Name))
+
+	select {
+	case err := <-errChan:
+		assert.Equal(t, ErrorShutdown, err)
+	case <-time.After(time.Second):
+		t.Fatal("connection didn't shut down")
+	}
+	time.Sleep(10 * time.Millisecond)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	count, err = queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, connection.checkHeartbeat()) // still alive
+
+	assert.NoError(t, connection.stopHeartbeat())
+	assert.NoError(t, adminConnection.stopHeartbeat())
+}
+
+func TestFreeze(t *testing.T) {
+	connection, err := OpenConnection("freeze-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("freeze-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	frozen, err := queue.IsFrozen()
+	assert.NoError(t, err)
+	assert.False(t, frozen)
+	assert.NoError(t, queue.Freeze())
+	frozen, err = queue.IsFrozen()
+	assert.NoError(t, err)
+	assert.True(t, frozen)
+
+	consumer := NewTestConsumer("freeze-cons")
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumer("freeze-cons", consumer)
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("freeze-d1"))
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, consumer.LastDeliveries, 0)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// is frozen for other connections too
+	otherConnection, err := OpenConnection("freeze-other", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	otherQueue, err := otherConnection.OpenQueue("freeze-q")
+	assert.NoError(t, err)
+	frozen, err = otherQueue.IsFrozen()
+	assert.NoError(t, err)
+	assert.True(t, frozen)
+
+	assert.NoError(t, otherQueue.Unfreeze())
+	time.Sleep(10 * time.Millisecond)
+	require.Len(t, consumer.LastDeliveries, 1)
+	assert.Equal(t, "freeze-d1", consumer.LastDelivery.Payload())
+
+	<-queue.StopConsuming()
+
+	// destroying the queue unfreezes it
+	assert.NoError(t, queue.Freeze())
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	frozen, err = otherQueue.IsFrozen()
+	assert.NoError(t, err)
+	assert.False(t, frozen)
+
+	assert.NoError(t, connection.stopHeartbeat())
+	assert.NoError(t, otherConnection.stopHeartbeat())
+}
+
+func TestFrozenPolicy(t *testing.T) {
+	connection, err := OpenConnection("frozen-policy-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("frozen-policy-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Freeze())
+
+	// frozen flag is ignored by default
+	assert.NoError(t, queue.Publish("frozen-d1"))
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	queue.SetFrozenPolicy(RejectWhileFrozen, 0)
+	assert.Equal(t, ErrorQueueFrozen, queue.Publish("frozen-d2"))
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	queue.SetFrozenPolicy(BufferWhileFrozen, 2)
+	assert.NoError(t, queue.Publish("frozen-d3"))
+	assert.NoError(t, queue.Publish("frozen-d4"))
+	assert.Equal(t, ErrorQueueFrozen, queue.Publish("frozen-d5")) // buffer full
+	assert.Equal(t, ErrorQueueFrozen, queue.FlushFrozenBuffer())
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, queue.Unfreeze())
+	assert.NoError(t, queue.FlushFrozenBuffer())
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.NoError(t, queue.Publish("frozen-d6"))
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestWaitUntilEmpty(t *testing.T) {
+	connection, err := OpenConnection("empty-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("empty-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.WaitUntilEmpty(context.Background()))
+
+	assert.NoError(t, queue.Publish("empty-d1", "empty-d2"))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, queue.WaitUntilEmpty(ctx))
+	cancel()
+
+	consumer := NewTestConsumer("empty-cons")
+	consumer.SleepDuration = 10 * time.Millisecond
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumer("empty-cons", consumer)
+	assert.NoError(t, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	assert.NoError(t, queue.WaitUntilEmpty(ctx))
+	cancel()
+	assert.Len(t, consumer.LastDeliveries, 2)
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestConsumeOne(t *testing.T) {
+	connection, err := OpenConnection("consume-one-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("consume-one-q", WithPollDuration(time.Millisecond))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err = queue.ConsumeOne(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	cancel()
+
+	assert.NoError(t, queue.Publish("consume-one-d1", "consume-one-d2"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	assert.NoError(t, err)
+	require.NotNil(t, delivery)
+	assert.Equal(t, "consume-one-d1", delivery.Payload())
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	queues, err := connection.getConsumingQueues()
+	assert.NoError(t, err)
+	assert.Contains(t, queues, "consume-one-q")
+
+	assert.NoError(t, delivery.Ack())
+	count, err = queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestDeliveries(t *testing.T) {
+	connection, err := OpenConnection("deliveries-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("deliveries-q", WithPollDuration(time.Millisecond))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("deliveries-d1", "deliveries-d2", "deliveries-d3"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deliveries := queue.Deliveries(ctx)
+	for _, payload := range []string{"deliveries-d1", "deliveries-d2"} {
+		delivery := <-deliveries
+		assert.Equal(t, payload, delivery.Payload())
+		assert.NoError(t, delivery.Ack())
+	}
+
+	time.Sleep(5 * time.Millisecond) // third delivery is waiting to be received
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	cancel()
+	for range deliveries { // wait for channel to be closed
+	}
+	count, err = queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count) // returned
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestDeadline(t *testing.T) {
+	connection, err := OpenConnection("deadline-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("deadline-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	expired := Header{}
+	expired.SetDeadline(time.Now().Add(-time.Second))
+	assert.NoError(t, queue.PublishWithHeader(expired, "deadline-expired"))
+	deadline := time.Now().Add(time.Minute)
+	header := Header{"key": "value"}
+	header.SetDeadline(deadline)
+	assert.NoError(t, queue.PublishWithHeader(header, "deadline-d1"))
+
+	// expired delivery gets dropped
+	delivery, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "deadline-d1", delivery.Payload())
+	assert.Equal(t, "value", delivery.Header()["key"])
+
+	ctx := delivery.Context()
+	ctxDeadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, deadline.UnixNano(), ctxDeadline.UnixNano())
+	assert.NoError(t, ctx.Err())
+	assert.NoError(t, delivery.Ack())
+	assert.Equal(t, context.Canceled, ctx.Err())
+
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestAckAndPublish(t *testing.T) {
+	connection, err := OpenConnection("ack-publish-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("ack-publish-q1")
+	assert.NoError(t, err)
+	nextQueue, err := connection.OpenQueue("ack-publish-q2")
+	assert.NoError(t, err)
+	for _, q := range []Queue{queue, nextQueue} {
+		_, err = q.PurgeReady()
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, queue.Publish("ack-publish-d1"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, delivery.AckAndPublish(nextQueue, "ack-publish-d2"))
+
+	count, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	next, err := nextQueue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ack-publish-d2", next.Payload())
+	assert.NoError(t, next.Ack())
+
+	// nothing gets published if the delivery was acked already
+	assert.Equal(t, ErrorNotFound, delivery.AckAndPublish(nextQueue, "ack-publish-d3"))
+	count, err = nextQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestCheckpoint(t *testing.T) {
+	connection, err := OpenConnection("checkpoint-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("checkpoint-q", WithPollDuration(time.Millisecond))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("checkpoint-d1"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	assert.NoError(t, err)
+	_, err = delivery.LastCheckpoint()
+	assert.Equal(t, ErrorNotFound, err)
+	assert.NoError(t, delivery.Checkpoint([]byte("step 1")))
+	assert.NoError(t, delivery.Checkpoint([]byte("step 2")))
+	assert.NoError(t, delivery.Reject())
+
+	// redelivered deliveries resume from their latest checkpoint
+	count, err := queue.ReturnRejected(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	delivery, err = queue.ConsumeOne(context.Background())
+	assert.NoError(t, err)
+	state, err := delivery.LastCheckpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("step 2"), state)
+
+	// acking removes the checkpoint
+	assert.NoError(t, delivery.Ack())
+	assert.NoError(t, queue.Publish("checkpoint-d1"))
+	delivery, err = queue.ConsumeOne(context.Background())
+	assert.NoError(t, err)
+	_, err = delivery.LastCheckpoint()
+	assert.Equal(t, ErrorNotFound, err)
+	assert.NoError(t, delivery.Ack())
+
+	// deliveries moved to other queues don't leave their checkpoint behind
+	pushQueue, err := connection.OpenQueue("checkpoint-push-q")
+	assert.NoError(t, err)
+	_, err = pushQueue.PurgeReady()
+	assert.NoError(t, err)
+	queue.SetPushQueue(pushQueue)
+	redisClient := connection.(*redisConnection).redisClient
+	for _, handle := range []func(Delivery) error{
+		func(delivery Delivery) error { return delivery.Push() },
+		func(delivery Delivery) error { return delivery.AckAndPublish(pushQueue, "checkpoint-d2") },
+	} {
+		assert.NoError(t, queue.Publish("checkpoint-d1"))
+		delivery, err = queue.ConsumeOne(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, delivery.Checkpoint([]byte("step 1")))
+		assert.NoError(t, handle(delivery))
+		_, err = redisClient.Get(delivery.(*redisDelivery).stateKey())
+		assert.Equal(t, ErrorNotFound, err)
+	}
+	_, err = pushQueue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestDuplicateConsumers(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("duplicate-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+	queue1, err := connection.OpenQueue("duplicate-q")
+	assert.NoError(t, err)
+	queue2, err := connection.OpenQueue("duplicate-q")
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue1.StartConsuming(10, time.Millisecond))
+	assert.Equal(t, ErrorPrefetchMismatch, queue2.StartConsuming(20, time.Millisecond))
+	assert.NoError(t, queue2.StartConsuming(10, time.Millisecond))
+
+	_, err = queue1.AddConsumer("duplicate-cons", NewTestConsumer("duplicate-a"))
+	assert.NoError(t, err)
+	_, err = queue1.AddConsumer("other-cons", NewTestConsumer("duplicate-b"))
+	assert.NoError(t, err)
+	assert.Len(t, errChan, 0)
+	_, err = queue2.AddConsumer("duplicate-cons", NewTestConsumer("duplicate-c"))
+	assert.NoError(t, err) // only warns
+	require.Len(t, errChan, 1)
+	assert.Equal(t, &DuplicateConsumerError{Queue: "duplicate-q", Tag: "duplicate-cons", Count: 2}, <-errChan)
+
+	// tags and prefetch limits can be reused after consuming stopped
+	<-queue1.StopConsuming()
+	<-queue2.StopConsuming()
+	queue3, err := connection.OpenQueue("duplicate-q")
+	assert.NoError(t, err)
+	assert.NoError(t, queue3.StartConsuming(20, time.Millisecond))
+	_, err = queue3.AddConsumer("duplicate-cons", NewTestConsumer("duplicate-d"))
+	assert.NoError(t, err)
+	assert.Len(t, errChan, 0)
+
+	<-queue3.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
 func BenchmarkQueue(b *testing.B) {
 	// open queue
 	connection, err := OpenConnection("bench-conn", "tcp", "localhost:6379", 1, nil)
@@ -791,3 +1351,80 @@ func BenchmarkQueue(b *testing.B) {
 
 	assert.NoError(b, connection.stopHeartbeat())
 }
+
+func TestReturnUnackedWithProgress(t *testing.T) {
+	connection, err := OpenConnection("return-progress-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("return-progress-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	redisQueue := queue.(*redisQueue)
+	for i := 0; i < 7; i++ {
+		_, err = redisQueue.redisClient.LPush(redisQueue.unackedKey, fmt.Sprintf("return-progress-d%d", i))
+		assert.NoError(t, err)
+	}
+
+	var progress []int64
+	returned, err := queue.ReturnUnackedWithProgress(context.Background(), 5, 2, func(returned int64) {
+		progress = append(progress, returned)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), returned)
+	assert.Equal(t, []int64{2, 4, 5}, progress)
+
+	// stops before the next chunk once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	returned, err = queue.ReturnUnackedWithProgress(ctx, math.MaxInt64, 1, func(int64) { cancel() })
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, int64(1), returned)
+
+	progress = nil
+	returned, err = queue.ReturnUnackedWithProgress(context.Background(), math.MaxInt64, 10, func(returned int64) {
+		progress = append(progress, returned)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), returned)
+	assert.Equal(t, []int64{1}, progress)
+	readyCount, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), readyCount)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestLostAcks(t *testing.T) {
+	connection, err := OpenConnection("lost-acks-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("lost-acks-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.(*redisQueue).redisClient.Del(queue.(*redisQueue).lostAcksKey)
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("lost-acks-d1", "lost-acks-d2"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, delivery.Ack())
+
+	// returned while being consumed, like a cleaner does after a heartbeat blip
+	delivery, err = queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	returned, err := queue.ReturnUnacked(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), returned)
+	assert.Equal(t, ErrorNotFound, delivery.Ack())
+
+	stats, err := connection.CollectStats([]string{"lost-acks-q"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.QueueStats["lost-acks-q"].LostAckCount)
+
+	// destroying the queue resets its count
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	count, err := queue.(*redisQueue).lostAckCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,142 @@
+package rmq
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordedDelivery is a line of a recording, see Recorder
+type recordedDelivery struct {
+	Time    int64  `json:"time"` // unix nanoseconds when it got consumed
+	Header  Header `json:"header,omitempty"`
+	Payload string `json:"payload"`
+}
+
+// Recorder copies a sample of consumed deliveries to an archive, so their
+// traffic pattern can be reproduced later via ReplayRecording(), for example
+// in load tests against a staging queue. The archive has one JSON encoded
+// delivery per line, with its header, payload and the time it got consumed.
+type Recorder struct {
+	mu      sync.Mutex // protects writer
+	writer  io.Writer
+	sample  float64
+	errChan chan<- error
+}
+
+// NewRecorder returns a recorder which writes the given fraction of
+// deliveries (between 0 and 1) to writer. Errors writing them get sent to
+// errChan without blocking, see Consumer().
+func NewRecorder(writer io.Writer, sample float64, errChan chan<- error) *Recorder {
+	return &Recorder{writer: writer, sample: sample, errChan: errChan}
+}
+
+// Consumer returns a consumer which records the sampled deliveries before
+// passing all of them on to consumer
+func (recorder *Recorder) Consumer(consumer Consumer) Consumer {
+	return ConsumerFunc(func(delivery Delivery) {
+		if err := recorder.Record(delivery); err != nil {
+			select { // try to add error to channel, but don't block
+			case recorder.errChan <- err:
+			default:
+			}
+		}
+		consumer.Consume(delivery)
+	})
+}
+
+// Record writes the delivery to the archive if it's part of the sample
+func (recorder *Recorder) Record(delivery Delivery) error {
+	if recorder.sample < 1 && rand.Float64() >= recorder.sample {
+		return nil
+	}
+
+	line, err := json.Marshal(recordedDelivery{
+		Time:    time.Now().UnixNano(),
+		Header:  delivery.Header(),
+		Payload: delivery.Payload(),
+	})
+	if err != nil {
+		return err
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	_, err = recorder.writer.Write(append(line, '\n'))
+	return err
+}
+
+// ReplayRecording publishes the deliveries recorded by a Recorder to queue,
+// keeping the intervals between them divided by speed: 1 replays at the
+// original pace, 10 ten times as fast and 0 as fast as possible. Header
+// fields set by rmq (like the publish time and the trail) get dropped, the
+// others are published along with the payloads. Returns the number of
+// published deliveries once the recording is done or the context's error if
+// it is done before.
+func ReplayRecording(ctx context.Context, reader io.Reader, queue Queue, speed float64) (replayed int64, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 64*1024*1024) // support large payloads
+
+	var start time.Time // when the first recorded delivery got replayed
+	var first int64     // when the first recorded delivery got consumed
+	for scanner.Scan() {
+		var recorded recordedDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &recorded); err != nil {
+			return replayed, err
+		}
+
+		if start.IsZero() {
+			start, first = time.Now(), recorded.Time
+		} else if speed > 0 {
+			due := start.Add(time.Duration(float64(recorded.Time-first) / speed))
+			if err := sleepUntil(ctx, due); err != nil {
+				return replayed, err
+			}
+		}
+
+		if err := queue.PublishWithHeader(userHeader(recorded.Header), recorded.Payload); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, scanner.Err()
+}
+
+// sleepUntil blocks until due or until the context is done, in which case it
+// returns the context's error
+func sleepUntil(ctx context.Context, due time.Time) error {
+	wait := time.Until(due)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// userHeader returns the header without the fields set by rmq, nil if none
+// are left
+func userHeader(header Header) Header {
+	var user Header
+	for key, value := range header {
+		if strings.HasPrefix(key, "rmq-") {
+			continue
+		}
+		if user == nil {
+			user = Header{}
+		}
+		user[key] = value
+	}
+	return user
+}
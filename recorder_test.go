@@ -0,0 +1,96 @@
+package rmq
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	connection, err := OpenConnection("recorder-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("recorder-q", WithPublishTime())
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+	stagingQueue, err := connection.OpenQueue("recorder-staging")
+	require.NoError(t, err)
+	_, err = stagingQueue.PurgeReady()
+	require.NoError(t, err)
+
+	var archive bytes.Buffer
+	recorder := NewRecorder(&archive, 1, nil)
+	var consumed []string
+	consumer := recorder.Consumer(ConsumerFunc(func(delivery Delivery) {
+		consumed = append(consumed, delivery.Payload())
+		assert.NoError(t, delivery.Ack())
+	}))
+
+	assert.NoError(t, queue.PublishWithHeader(Header{"key": "value"}, "recorder-d1"))
+	assert.NoError(t, queue.Publish("recorder-d2"))
+	for i := 0; i < 2; i++ {
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		consumer.Consume(delivery)
+	}
+	assert.Equal(t, []string{"recorder-d1", "recorder-d2"}, consumed)
+	assert.Equal(t, 2, strings.Count(archive.String(), "\n"))
+
+	replayed, err := ReplayRecording(context.Background(), &archive, stagingQueue, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), replayed)
+	messages, err := stagingQueue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, Message{Header: Header{"key": "value"}, Payload: "recorder-d1"}, messages[0]) // no publish time
+	assert.Equal(t, Message{Payload: "recorder-d2"}, messages[1])
+
+	_, err = stagingQueue.PurgeReady()
+	assert.NoError(t, err)
+}
+
+func TestRecorderSample(t *testing.T) {
+	var archive bytes.Buffer
+	recorder := NewRecorder(&archive, 0, nil)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, recorder.Record(NewTestDeliveryString("d")))
+	}
+	assert.Empty(t, archive.String())
+}
+
+func TestReplayRecordingPace(t *testing.T) {
+	connection, err := OpenConnection("recorder-pace-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("recorder-pace-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+
+	recording := `{"time":1000000000,"payload":"d1"}
+{"time":1200000000,"payload":"d2"}
+{"time":1400000000,"payload":"d3"}
+`
+	// 400ms recorded, replayed twice as fast
+	start := time.Now()
+	replayed, err := ReplayRecording(context.Background(), strings.NewReader(recording), queue, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), replayed)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	replayed, err = ReplayRecording(ctx, strings.NewReader(recording), queue, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int64(1), replayed)
+
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+}
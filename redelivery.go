@@ -0,0 +1,53 @@
+package rmq
+
+// RedeliveryOrder defines where deliveries go which get returned to the ready
+// list by Queue.ReturnUnacked(), Connection.ReturnAllUnacked() or the cleaner,
+// see Queue.SetRedeliveryOrder()
+type RedeliveryOrder int
+
+const (
+	RedeliverLast  RedeliveryOrder = iota // behind all ready deliveries, so they don't starve fresh work (default)
+	RedeliverFirst                        // ahead of all ready deliveries, so they keep their priority
+)
+
+// SetRedeliveryOrder sets where returned unacked deliveries of this queue go.
+// By default they get consumed after all deliveries which are ready already.
+// With RedeliverFirst they get consumed before those, in the order they got
+// consumed before. The order gets stored in redis, so it also applies to
+// cleaners returning the unacked deliveries of dead connections.
+func (queue *redisQueue) SetRedeliveryOrder(order RedeliveryOrder) error {
+	if order == RedeliverLast {
+		_, err := queue.redisClient.Del(queue.redeliveryKey)
+		return err
+	}
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+	return queue.redisClient.Set(queue.redeliveryKey, "first", 0)
+}
+
+// RedeliveryOrder returns where returned unacked deliveries of this queue go,
+// see SetRedeliveryOrder()
+func (queue *redisQueue) RedeliveryOrder() (RedeliveryOrder, error) {
+	_, err := queue.redisClient.Get(queue.redeliveryKey)
+	switch err {
+	case nil:
+		return RedeliverFirst, nil
+	case ErrorNotFound:
+		return RedeliverLast, nil
+	default:
+		return RedeliverLast, err
+	}
+}
+
+// redeliver returns up to max deliveries from the given list to the ready
+// list of their priority in the queue's redelivery order. With
+// RedeliverFirst they get taken from the head of the list (the latest
+// consumed first), so the ones which got consumed first end up at the tail
+// of the ready list.
+func (queue *redisQueue) redeliver(from string, max int64, order RedeliveryOrder) (n int64, err error) {
+	if order == RedeliverFirst {
+		return queue.moveReady(queue.redisClient.LPopRPush, from, max, TrailReturned, true)
+	}
+	return queue.moveReady(queue.redisClient.RPopLPush, from, max, TrailReturned, false)
+}
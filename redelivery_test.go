@@ -0,0 +1,84 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedeliveryOrder(t *testing.T) {
+	connection, err := OpenConnection("redelivery-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("redelivery-q", WithTrail())
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+	require.NoError(t, queue.SetRedeliveryOrder(RedeliverLast))
+
+	order, err := queue.RedeliveryOrder()
+	assert.NoError(t, err)
+	assert.Equal(t, RedeliverLast, order)
+
+	// consumes the given number of deliveries without acking them
+	consume := func(queue Queue, n int) {
+		for i := 0; i < n; i++ {
+			_, err := queue.ConsumeOne(context.Background())
+			require.NoError(t, err)
+		}
+	}
+	readyPayloads := func() []string {
+		messages, err := queue.PeekReady(10)
+		require.NoError(t, err)
+		payloads := []string{}
+		for _, message := range messages {
+			payloads = append(payloads, message.Payload)
+		}
+		return payloads
+	}
+
+	// by default returned deliveries go last
+	assert.NoError(t, queue.Publish("d1", "d2", "d3", "d4"))
+	consume(queue, 2)
+	returned, err := queue.ReturnUnacked(10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), returned)
+	assert.Equal(t, []string{"d3", "d4", "d1", "d2"}, readyPayloads())
+
+	// with RedeliverFirst they go first, keeping their order
+	assert.NoError(t, queue.SetRedeliveryOrder(RedeliverFirst))
+	order, err = queue.RedeliveryOrder()
+	assert.NoError(t, err)
+	assert.Equal(t, RedeliverFirst, order)
+	consume(queue, 2)
+	returned, err = queue.ReturnUnackedWithProgress(context.Background(), 10, 1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), returned)
+	assert.Equal(t, []string{"d3", "d4", "d1", "d2"}, readyPayloads())
+
+	// also when the cleaner returns them
+	consumerConnection, err := OpenConnection("redelivery-consumer-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	consumerQueue, err := consumerConnection.OpenQueue("redelivery-q")
+	require.NoError(t, err)
+	consume(consumerQueue, 3)
+	assert.NoError(t, consumerConnection.stopHeartbeat())
+	_, err = NewCleaner(connection).Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d3", "d4", "d1", "d2"}, readyPayloads())
+
+	messages, err := queue.PeekReady(1)
+	require.NoError(t, err)
+	trail := messages[0].Header.Trail()
+	require.Len(t, trail, 3)
+	assert.Equal(t, TrailReturned, trail[1].Event)
+	assert.Equal(t, TrailCleaned, trail[2].Event)
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	order, err = queue.RedeliveryOrder()
+	assert.NoError(t, err)
+	assert.Equal(t, RedeliverLast, order)
+	assert.NoError(t, connection.stopHeartbeat())
+}
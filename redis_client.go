@@ -5,21 +5,84 @@ import "time"
 type RedisClient interface {
 	// simple keys
 	Set(key string, value string, expiration time.Duration) error
+	SetNX(key string, value string, expiration time.Duration) (set bool, err error)
+	// ExpireIfEqual atomically sets the expiration of key if it holds value,
+	// like to refresh a lock only while still holding it. Returns whether the
+	// expiration got set.
+	ExpireIfEqual(key, value string, expiration time.Duration) (refreshed bool, err error)
+	Get(key string) (value string, err error)
 	Del(key string) (affected int64, err error)
 	TTL(key string) (ttl time.Duration, err error)
+	IncrBy(key string, value int64) (total int64, err error)
 
 	// lists
 	LPush(key string, value ...string) (total int64, err error)
+	RPush(key string, value ...string) (total int64, err error)
 	LLen(key string) (affected int64, err error)
+	// LLens returns the lengths of the lists stored at keys, in the same
+	// order, using a single pipelined round trip
+	LLens(keys ...string) (lengths []int64, err error)
+	LIndex(key string, index int64) (value string, err error)
+	LRange(key string, start, stop int64) (values []string, err error)
 	LRem(key string, count int64, value string) (affected int64, err error)
 	LTrim(key string, start, stop int64) error
 	RPopLPush(source, destination string) (value string, err error)
+	// RPopLPushUnless is like RPopLPush, but atomically checks that guardKey
+	// doesn't exist first. If it does nothing gets moved and guarded is true.
+	RPopLPushUnless(source, destination, guardKey string) (value string, guarded bool, err error)
+	// LPopRPush atomically removes the first value (head) of source and
+	// appends it to destination. Returns ErrorNotFound if source is empty.
+	LPopRPush(source, destination string) (value string, err error)
+	// LPopRPushUnless is like LPopRPush, but atomically checks that guardKey
+	// doesn't exist first. If it does nothing gets moved and guarded is true.
+	LPopRPushUnless(source, destination, guardKey string) (value string, guarded bool, err error)
+	// LPushAllExpire atomically moves all values of key to the left of
+	// pushKey, keeping their order, and makes pushKey expire after
+	// expiration. Returns the number of moved values.
+	LPushAllExpire(key, pushKey string, expiration time.Duration) (moved int64, err error)
+	// RPushAll atomically moves all values of key to the right of pushKey,
+	// keeping their order. Returns the number of moved values.
+	RPushAll(key, pushKey string) (moved int64, err error)
+	// LRemLPush atomically removes value from removeKey and pushes pushValue
+	// to pushKey if value was removed. Returns the number of removed values.
+	LRemLPush(removeKey, value, pushKey, pushValue string) (affected int64, err error)
+	// LRemLPushNX is like LRemLPush, but only pushes if onceKey doesn't exist
+	// yet, in which case it also sets onceKey with the given expiration
+	LRemLPushNX(removeKey, value, pushKey, pushValue, onceKey string, expiration time.Duration) (affected int64, pushed bool, err error)
 
 	// sets
 	SAdd(key, value string) (total int64, err error)
 	SMembers(key string) (members []string, err error)
+	// SScan returns about count members of the set stored at key, starting at
+	// cursor, and the cursor to continue with. The returned cursor is 0 once
+	// the whole set was iterated. Members may be returned more than once.
+	SScan(key string, cursor uint64, count int64) (members []string, next uint64, err error)
 	SRem(key, value string) (affected int64, err error)
 
+	// sorted sets
+	// ZAddLimit atomically removes all members with a score up to
+	// expiredScore and then adds member with score if fewer than limit
+	// members are left. Returns whether member got added.
+	ZAddLimit(key, member string, score, expiredScore float64, limit int64) (added bool, err error)
+	ZAdd(key, member string, score float64) (added int64, err error)
+	ZRem(key, member string) (affected int64, err error)
+	ZCard(key string) (count int64, err error)
+	// LRemZAdd atomically removes value from removeKey and adds member with
+	// score to the sorted set zsetKey if value was removed. Returns the
+	// number of removed values.
+	LRemZAdd(removeKey, value, zsetKey, member string, score float64) (affected int64, err error)
+	// ZPopRPush atomically removes up to count members with a score up to
+	// maxScore from the sorted set key, lowest scores first, and pushes them
+	// without their first trim bytes to the right of pushKey. Returns the
+	// number of moved members.
+	ZPopRPush(key string, maxScore float64, count int64, trim int, pushKey string) (moved int64, err error)
+
+	// pub/sub
+	Publish(channel, message string) error
+	// Subscribe subscribes to channel and returns the received messages.
+	// The messages chan gets closed after calling unsubscribe.
+	Subscribe(channel string) (messages <-chan string, unsubscribe func() error, err error)
+
 	// special
 	FlushDb() error
 }
@@ -3,15 +3,69 @@ package rmq
 const (
 	connectionsKey                   = "rmq::connections"                                           // Set of connection names
 	connectionHeartbeatTemplate      = "rmq::connection::{connection}::heartbeat"                   // expires after {connection} died
+	connectionShutdownTemplate       = "rmq::connection::{connection}::shutdown"                    // exists while {connection} is asked to stop consuming, see Connection.ShutdownConnection()
 	connectionQueuesTemplate         = "rmq::connection::{connection}::queues"                      // Set of queues consumers of {connection} are consuming
 	connectionQueueConsumersTemplate = "rmq::connection::{connection}::queue::[{queue}]::consumers" // Set of all consumers from {connection} consuming from {queue}
 	connectionQueueUnackedTemplate   = "rmq::connection::{connection}::queue::[{queue}]::unacked"   // List of deliveries consumers of {connection} are currently consuming
+	connectionQueueHandoffTemplate   = "rmq::connection::{connection}::queue::[{queue}]::handoff"   // List of deliveries handed off to {connection} by other connections
+	connectionQueueBufferTemplate    = "rmq::connection::{connection}::queue::[{queue}]::buffer"    // expires after {connection} stopped reporting prefetch buffer stats of {queue}
+	connectionQueueDurationsTemplate = "rmq::connection::{connection}::queue::[{queue}]::durations" // expires after {connection} stopped reporting handler durations of {queue}
+	connectionProbeTemplate          = "rmq::connection::{connection}::probe"                       // prefix of keys {connection} probes redis with, see Options.CheckCapabilities
 
-	queuesKey             = "rmq::queues"                     // Set of all open queues
-	queueReadyTemplate    = "rmq::queue::[{queue}]::ready"    // List of deliveries in that {queue} (right is first and oldest, left is last and youngest)
-	queueRejectedTemplate = "rmq::queue::[{queue}]::rejected" // List of rejected deliveries from that {queue}
+	queuesKey                = "rmq::queues"                               // Set of all open queues
+	queueEventsChannel       = "rmq::queues::events"                       // Pub/Sub channel of QueueEvents about queues getting opened or destroyed
+	queueReadyTemplate       = "rmq::queue::[{queue}]::ready"              // List of deliveries in that {queue} (right is first and oldest, left is last and youngest)
+	queueRejectedTemplate    = "rmq::queue::[{queue}]::rejected"           // List of rejected deliveries from that {queue}
+	queueClassTemplate       = "rmq::queue::[{queue}]::rejected::{class}"  // List of deliveries from that {queue} rejected as error {class}, see Delivery.RejectAs()
+	queueClassesTemplate     = "rmq::queue::[{queue}]::rejected_classes"   // Set of error classes deliveries from {queue} got rejected as
+	queueBandTemplate        = "rmq::queue::[{queue}]::ready::{priority}"  // List of ready deliveries with {priority} in {queue}, see Queue.PublishWithPriority()
+	queueBandsTemplate       = "rmq::queue::[{queue}]::priorities"         // Set of priorities with ready lists in {queue}
+	queueTenantTemplate      = "rmq::queue::[{queue}]::tenant::{tenant}"   // List of ready deliveries of {tenant} in {queue}, see Queue.PublishForTenant()
+	queueTenantsTemplate     = "rmq::queue::[{queue}]::tenants"            // Set of tenants with ready deliveries in {queue}
+	queueIdleTemplate        = "rmq::queue::[{queue}]::idle"               // Set of connections whose consumers of {queue} are idle (used for work stealing)
+	queueFrozenTemplate      = "rmq::queue::[{queue}]::frozen"             // exists while {queue} is frozen
+	queueStealTemplate       = "rmq::queue::[{queue}]::steal"              // expires after work stealing on {queue} finished
+	queueActiveTemplate      = "rmq::queue::[{queue}]::active"             // expires after the single active connection consuming {queue} stopped refreshing it
+	queueRetentionTemplate   = "rmq::queue::[{queue}]::retention"          // JSON encoded RetentionPolicy of {queue}
+	queueIdempotencyTemplate = "rmq::queue::[{queue}]::idempotency::{key}" // exists while deliveries with idempotency {key} get ignored by Movers publishing to {queue}
+	queueFetchedTemplate     = "rmq::queue::[{queue}]::fetched"            // number of deliveries fetched from {queue} by consumers
+	queueCheckpointTemplate  = "rmq::queue::[{queue}]::checkpoint::{key}"  // state of the latest Delivery.Checkpoint() of the delivery with ID {key} from {queue}
+	queueFeedsTemplate       = "rmq::queue::[{queue}]::feeds"              // Set of queues {queue} feeds into, see Queue.DeclareFeeds()
+	queueTagsTemplate        = "rmq::queue::[{queue}]::tags"               // JSON encoded tags of {queue}, see Queue.SetTags()
+	queueRedeliveryTemplate  = "rmq::queue::[{queue}]::redelivery"         // exists while returned deliveries of {queue} get redelivered first, see Queue.SetRedeliveryOrder()
+	queueRequeueTemplate     = "rmq::queue::[{queue}]::return_policy"      // JSON encoded ReturnRejectedPolicy of {queue}
+	queueAgingTemplate       = "rmq::queue::[{queue}]::aging"              // JSON encoded AgingPolicy of {queue}
+	queueReturnedTemplate    = "rmq::queue::[{queue}]::returned"           // expires after the interval of the ReturnRejectedPolicy of {queue} in which rejected deliveries got returned
+	queueDelayedTemplate     = "rmq::queue::[{queue}]::delayed"            // Sorted set of deliveries delayed via RetryAfter() before returning to ready of {queue}, scored by when they are due
+	queuePurgedTemplate      = "rmq::queue::[{queue}]::purged"             // List of ready deliveries of {queue} purged with WithPurgeUndo(), expires after the undo window
+	queueCleanedTemplate     = "rmq::queue::[{queue}]::cleaned"            // number of deliveries of {queue} returned to ready by cleaners
+	queueLostAcksTemplate    = "rmq::queue::[{queue}]::lost_acks"          // number of acks of {queue} deliveries which weren't unacked anymore
+	queueRepublishedTemplate = "rmq::queue::[{queue}]::republished"        // List of original deliveries of {queue} replaced via Queue.RepublishRejected()
+	queueResetsTemplate      = "rmq::queue::[{queue}]::resets"             // List of JSON encoded StatsResets of {queue}, newest first, see Queue.ResetStats()
+	queueStreamTemplate      = "rmq::queue::[{queue}]::stream"             // Stream of ready and unacked deliveries of {queue} if opened with WithStream(), unacked ones are pending in the group "rmq"
+	streamQueuesKey          = "rmq::streams"                              // Set of queues opened with WithStream()
+
+	queueBandDelayedTemplate = "rmq::queue::[{queue}]::delayed::{priority}" // Sorted set of delayed deliveries with {priority} of {queue}, see queueDelayedTemplate
+
+	semaphoreTemplate  = "rmq::semaphore::{semaphore}" // Sorted set of holders of {semaphore} scored by when their slots expire
+	schedulerLeaderKey = "rmq::scheduler::leader"      // expires after the connection running leader only tasks of the Scheduler stopped refreshing it
+	familyTemplate     = "rmq::family::{family}"       // Set of queues opened by the QueueFactory of {family}
+	retryingTemplate   = "{source}::retrying"          // List of deliveries from the list at {source} currently being classified by a Retrier
+
+	cleanerRunsKey     = "rmq::cleaner::runs"     // number of Cleaner.Clean() runs
+	cleanerFailuresKey = "rmq::cleaner::failures" // number of Cleaner.Clean() runs which returned an error
+	cleanerDeadKey     = "rmq::cleaner::dead"     // number of dead connections cleaned by cleaners
+	cleanerDurationKey = "rmq::cleaner::duration" // duration of the latest Cleaner.Clean() run in nanoseconds
+	cleanerSuccessKey  = "rmq::cleaner::success"  // unix time in nanoseconds of the latest successful Cleaner.Clean() run
 
 	phConnection = "{connection}" // connection name
 	phQueue      = "{queue}"      // queue name
+	phSource     = "{source}"     // key of the list a Retrier classifies
 	phConsumer   = "{consumer}"   // consumer name (consisting of tag and token)
+	phKey        = "{key}"        // idempotency key or delivery ID
+	phSemaphore  = "{semaphore}"  // semaphore name
+	phFamily     = "{family}"     // queue family name, see QueueFactory
+	phClass      = "{class}"      // error class of rejected deliveries
+	phTenant     = "{tenant}"     // tenant of ready deliveries, see Queue.PublishForTenant()
+	phPriority   = "{priority}"   // priority of ready deliveries, see Queue.PublishWithPriority()
 )
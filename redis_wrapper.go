@@ -2,8 +2,10 @@ package rmq
 
 import (
 	"context"
+	"strings"
 	"time"
 
+	"github.com/adjust/rmq/v4/internal/goroutines"
 	"github.com/go-redis/redis/v8"
 )
 
@@ -11,40 +13,146 @@ var unusedContext = context.TODO()
 
 type RedisWrapper struct {
 	rawClient *redis.Client
+	library   string          // name of the loaded function library, empty if scripts get evaluated, see Options.UseFunctions
+	ctx       context.Context // passed to the commands, nil for unusedContext, see withContext()
 }
 
-func (wrapper RedisWrapper) Set(key string, value string, expiration time.Duration) error {
+// NewRedisWrapper returns a RedisClient backed by the given redis client
+func NewRedisWrapper(rawClient *redis.Client) RedisWrapper {
+	return RedisWrapper{rawClient: rawClient}
+}
+
+// contextClient is implemented by redis clients which can pass a context to
+// their commands, see Queue.PublishContext()
+type contextClient interface {
+	withContext(ctx context.Context) RedisClient
+}
+
+// withContext returns a wrapper which passes ctx to its commands, so they
+// return early once ctx is done
+func (wrapper RedisWrapper) withContext(ctx context.Context) RedisClient {
+	wrapper.ctx = ctx
+	return wrapper
+}
+
+func (wrapper RedisWrapper) commandContext() context.Context {
+	if wrapper.ctx == nil {
+		return unusedContext
+	}
+	return wrapper.ctx
+}
+
+func (wrapper RedisWrapper) Set(key string, value string, expiration time.Duration) (err error) {
+	defer checkCommand("Set", &err)
 	// NOTE: using Err() here because Result() string is always "OK"
-	return wrapper.rawClient.Set(unusedContext, key, value, expiration).Err()
+	return wrapper.rawClient.Set(wrapper.commandContext(), key, value, expiration).Err()
+}
+
+func (wrapper RedisWrapper) SetNX(key string, value string, expiration time.Duration) (set bool, err error) {
+	defer checkCommand("SetNX", &err)
+	return wrapper.rawClient.SetNX(wrapper.commandContext(), key, value, expiration).Result()
+}
+
+var expireIfEqualScript = newScript("expire_if_equal", `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+func (wrapper RedisWrapper) ExpireIfEqual(key, value string, expiration time.Duration) (refreshed bool, err error) {
+	defer checkCommand("ExpireIfEqual", &err)
+	result, err := wrapper.run(expireIfEqualScript, []string{key}, value, expiration.Milliseconds()).Int64()
+	return result == 1, err
+}
+
+func (wrapper RedisWrapper) Get(key string) (value string, err error) {
+	defer checkCommand("Get", &err)
+	value, err = wrapper.rawClient.Get(wrapper.commandContext(), key).Result()
+	if err == redis.Nil {
+		return "", ErrorNotFound
+	}
+	return value, err
 }
 
 func (wrapper RedisWrapper) Del(key string) (affected int64, err error) {
-	return wrapper.rawClient.Del(unusedContext, key).Result()
+	defer checkCommand("Del", &err)
+	return wrapper.rawClient.Del(wrapper.commandContext(), key).Result()
 }
 
 func (wrapper RedisWrapper) TTL(key string) (ttl time.Duration, err error) {
-	return wrapper.rawClient.TTL(unusedContext, key).Result()
+	defer checkCommand("TTL", &err)
+	return wrapper.rawClient.TTL(wrapper.commandContext(), key).Result()
+}
+
+func (wrapper RedisWrapper) IncrBy(key string, value int64) (total int64, err error) {
+	defer checkCommand("IncrBy", &err)
+	return wrapper.rawClient.IncrBy(wrapper.commandContext(), key, value).Result()
 }
 
 func (wrapper RedisWrapper) LPush(key string, value ...string) (total int64, err error) {
-	return wrapper.rawClient.LPush(unusedContext, key, value).Result()
+	defer checkCommand("LPush", &err)
+	return wrapper.rawClient.LPush(wrapper.commandContext(), key, value).Result()
+}
+
+func (wrapper RedisWrapper) RPush(key string, value ...string) (total int64, err error) {
+	defer checkCommand("RPush", &err)
+	return wrapper.rawClient.RPush(wrapper.commandContext(), key, value).Result()
 }
 
 func (wrapper RedisWrapper) LLen(key string) (affected int64, err error) {
-	return wrapper.rawClient.LLen(unusedContext, key).Result()
+	defer checkCommand("LLen", &err)
+	return wrapper.rawClient.LLen(wrapper.commandContext(), key).Result()
+}
+
+func (wrapper RedisWrapper) LLens(keys ...string) (lengths []int64, err error) {
+	defer checkCommand("LLens", &err)
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err = wrapper.rawClient.Pipelined(wrapper.commandContext(), func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.LLen(wrapper.commandContext(), key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lengths = make([]int64, len(keys))
+	for i, cmd := range cmds {
+		lengths[i] = cmd.Val()
+	}
+	return lengths, nil
+}
+
+func (wrapper RedisWrapper) LIndex(key string, index int64) (value string, err error) {
+	defer checkCommand("LIndex", &err)
+	value, err = wrapper.rawClient.LIndex(wrapper.commandContext(), key, index).Result()
+	if err == redis.Nil {
+		return "", ErrorNotFound
+	}
+	return value, err
+}
+
+func (wrapper RedisWrapper) LRange(key string, start, stop int64) (values []string, err error) {
+	defer checkCommand("LRange", &err)
+	return wrapper.rawClient.LRange(wrapper.commandContext(), key, start, stop).Result()
 }
 
 func (wrapper RedisWrapper) LRem(key string, count int64, value string) (affected int64, err error) {
-	return wrapper.rawClient.LRem(unusedContext, key, int64(count), value).Result()
+	defer checkCommand("LRem", &err)
+	return wrapper.rawClient.LRem(wrapper.commandContext(), key, int64(count), value).Result()
 }
 
-func (wrapper RedisWrapper) LTrim(key string, start, stop int64) error {
+func (wrapper RedisWrapper) LTrim(key string, start, stop int64) (err error) {
+	defer checkCommand("LTrim", &err)
 	// NOTE: using Err() here because Result() string is always "OK"
-	return wrapper.rawClient.LTrim(unusedContext, key, int64(start), int64(stop)).Err()
+	return wrapper.rawClient.LTrim(wrapper.commandContext(), key, int64(start), int64(stop)).Err()
 }
 
 func (wrapper RedisWrapper) RPopLPush(source, destination string) (value string, err error) {
-	value, err = wrapper.rawClient.RPopLPush(unusedContext, source, destination).Result()
+	defer checkCommand("RPopLPush", &err)
+	value, err = wrapper.rawClient.RPopLPush(wrapper.commandContext(), source, destination).Result()
 	// println("RPopLPush", source, destination, value, err)
 	switch err {
 	case nil:
@@ -56,19 +164,530 @@ func (wrapper RedisWrapper) RPopLPush(source, destination string) (value string,
 	}
 }
 
+var rpoplpushUnlessScript = newScript("rpoplpush_unless", `
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 1
+end
+return redis.call('RPOPLPUSH', KEYS[1], KEYS[2])
+`)
+
+func (wrapper RedisWrapper) RPopLPushUnless(source, destination, guardKey string) (value string, guarded bool, err error) {
+	defer checkCommand("RPopLPushUnless", &err)
+	result, err := wrapper.run(rpoplpushUnlessScript, []string{source, destination, guardKey}).Result()
+	switch err {
+	case nil:
+	case redis.Nil:
+		return "", false, ErrorNotFound
+	default:
+		return "", false, err
+	}
+	if value, ok := result.(string); ok {
+		return value, false, nil
+	}
+	return "", true, nil
+}
+
+var lpoprpushScript = newScript("lpoprpush", `
+local value = redis.call('LPOP', KEYS[1])
+if value then
+	redis.call('RPUSH', KEYS[2], value)
+end
+return value
+`)
+
+func (wrapper RedisWrapper) LPopRPush(source, destination string) (value string, err error) {
+	defer checkCommand("LPopRPush", &err)
+	value, err = wrapper.run(lpoprpushScript, []string{source, destination}).Text()
+	if err == redis.Nil {
+		return "", ErrorNotFound
+	}
+	return value, err
+}
+
+var lpoprpushUnlessScript = newScript("lpoprpush_unless", `
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 1
+end
+local value = redis.call('LPOP', KEYS[1])
+if value then
+	redis.call('RPUSH', KEYS[2], value)
+end
+return value
+`)
+
+func (wrapper RedisWrapper) LPopRPushUnless(source, destination, guardKey string) (value string, guarded bool, err error) {
+	defer checkCommand("LPopRPushUnless", &err)
+	result, err := wrapper.run(lpoprpushUnlessScript, []string{source, destination, guardKey}).Result()
+	switch err {
+	case nil:
+	case redis.Nil:
+		return "", false, ErrorNotFound
+	default:
+		return "", false, err
+	}
+	if value, ok := result.(string); ok {
+		return value, false, nil
+	}
+	return "", true, nil
+}
+
+var lremLPushScript = newScript("lrem_lpush", `
+local affected = redis.call('LREM', KEYS[1], 1, ARGV[1])
+if affected > 0 then
+	redis.call('LPUSH', KEYS[2], ARGV[2])
+end
+return affected
+`)
+
+func (wrapper RedisWrapper) LRemLPush(removeKey, value, pushKey, pushValue string) (affected int64, err error) {
+	defer checkCommand("LRemLPush", &err)
+	return wrapper.run(lremLPushScript, []string{removeKey, pushKey}, value, pushValue).Int64()
+}
+
+// returns 0 if nothing was removed, 1 if removed but not pushed and 2 if
+// removed and pushed
+var lremLPushNXScript = newScript("lrem_lpush_nx", `
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+local set
+if tonumber(ARGV[3]) > 0 then
+	set = redis.call('SET', KEYS[3], '1', 'PX', ARGV[3], 'NX')
+else
+	set = redis.call('SET', KEYS[3], '1', 'NX')
+end
+if not set then
+	return 1
+end
+redis.call('LPUSH', KEYS[2], ARGV[2])
+return 2
+`)
+
+func (wrapper RedisWrapper) LRemLPushNX(removeKey, value, pushKey, pushValue, onceKey string, expiration time.Duration) (affected int64, pushed bool, err error) {
+	defer checkCommand("LRemLPushNX", &err)
+	keys := []string{removeKey, pushKey, onceKey}
+	result, err := wrapper.run(lremLPushNXScript, keys, value, pushValue, expiration.Milliseconds()).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	if result == 0 {
+		return 0, false, nil
+	}
+	return 1, result == 2, nil
+}
+
 func (wrapper RedisWrapper) SAdd(key, value string) (total int64, err error) {
-	return wrapper.rawClient.SAdd(unusedContext, key, value).Result()
+	defer checkCommand("SAdd", &err)
+	return wrapper.rawClient.SAdd(wrapper.commandContext(), key, value).Result()
 }
 
 func (wrapper RedisWrapper) SMembers(key string) (members []string, err error) {
-	return wrapper.rawClient.SMembers(unusedContext, key).Result()
+	defer checkCommand("SMembers", &err)
+	return wrapper.rawClient.SMembers(wrapper.commandContext(), key).Result()
+}
+
+func (wrapper RedisWrapper) SScan(key string, cursor uint64, count int64) (members []string, next uint64, err error) {
+	defer checkCommand("SScan", &err)
+	return wrapper.rawClient.SScan(wrapper.commandContext(), key, cursor, "", count).Result()
 }
 
 func (wrapper RedisWrapper) SRem(key, value string) (affected int64, err error) {
-	return wrapper.rawClient.SRem(unusedContext, key, value).Result()
+	defer checkCommand("SRem", &err)
+	return wrapper.rawClient.SRem(wrapper.commandContext(), key, value).Result()
+}
+
+var zaddLimitScript = newScript("zadd_limit", `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[4])
+return 1
+`)
+
+func (wrapper RedisWrapper) ZAddLimit(key, member string, score, expiredScore float64, limit int64) (added bool, err error) {
+	defer checkCommand("ZAddLimit", &err)
+	result, err := wrapper.run(zaddLimitScript, []string{key}, score, expiredScore, limit, member).Int64()
+	return result == 1, err
+}
+
+func (wrapper RedisWrapper) ZAdd(key, member string, score float64) (added int64, err error) {
+	defer checkCommand("ZAdd", &err)
+	return wrapper.rawClient.ZAdd(wrapper.commandContext(), key, &redis.Z{Score: score, Member: member}).Result()
 }
 
-func (wrapper RedisWrapper) FlushDb() error {
+func (wrapper RedisWrapper) ZRem(key, member string) (affected int64, err error) {
+	defer checkCommand("ZRem", &err)
+	return wrapper.rawClient.ZRem(wrapper.commandContext(), key, member).Result()
+}
+
+func (wrapper RedisWrapper) ZCard(key string) (count int64, err error) {
+	defer checkCommand("ZCard", &err)
+	return wrapper.rawClient.ZCard(wrapper.commandContext(), key).Result()
+}
+
+var lremZAddScript = newScript("lrem_zadd", `
+local affected = redis.call('LREM', KEYS[1], 1, ARGV[1])
+if affected > 0 then
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+end
+return affected
+`)
+
+func (wrapper RedisWrapper) LRemZAdd(removeKey, value, zsetKey, member string, score float64) (affected int64, err error) {
+	defer checkCommand("LRemZAdd", &err)
+	return wrapper.run(lremZAddScript, []string{removeKey, zsetKey}, value, member, score).Int64()
+}
+
+var zpopRPushScript = newScript("zpop_rpush", `
+local members = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, member in ipairs(members) do
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('RPUSH', KEYS[2], string.sub(member, tonumber(ARGV[3]) + 1))
+end
+return #members
+`)
+
+func (wrapper RedisWrapper) ZPopRPush(key string, maxScore float64, count int64, trim int, pushKey string) (moved int64, err error) {
+	defer checkCommand("ZPopRPush", &err)
+	return wrapper.run(zpopRPushScript, []string{key, pushKey}, maxScore, count, trim).Int64()
+}
+
+// rename if possible, it's O(1)
+var lpushAllExpireScript = newScript("lpush_all_expire", `
+local count = redis.call('LLEN', KEYS[1])
+if count == 0 then
+	return 0
+end
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	redis.call('RENAME', KEYS[1], KEYS[2])
+else
+	local values = redis.call('LRANGE', KEYS[1], 0, -1)
+	for i = #values, 1, -1 do
+		redis.call('LPUSH', KEYS[2], values[i])
+	end
+	redis.call('DEL', KEYS[1])
+end
+redis.call('PEXPIRE', KEYS[2], ARGV[1])
+return count
+`)
+
+func (wrapper RedisWrapper) LPushAllExpire(key, pushKey string, expiration time.Duration) (moved int64, err error) {
+	defer checkCommand("LPushAllExpire", &err)
+	return wrapper.run(lpushAllExpireScript, []string{key, pushKey}, expiration.Milliseconds()).Int64()
+}
+
+var rpushAllScript = newScript("rpush_all", `
+local values = redis.call('LRANGE', KEYS[1], 0, -1)
+for _, value in ipairs(values) do
+	redis.call('RPUSH', KEYS[2], value)
+end
+redis.call('DEL', KEYS[1])
+return #values
+`)
+
+func (wrapper RedisWrapper) RPushAll(key, pushKey string) (moved int64, err error) {
+	defer checkCommand("RPushAll", &err)
+	return wrapper.run(rpushAllScript, []string{key, pushKey}).Int64()
+}
+
+var xaddAllScript = newScript("xadd_all", `
+for _, value in ipairs(ARGV) do
+	redis.call('XADD', KEYS[1], '*', 'v', value)
+end
+return #ARGV
+`)
+
+func (wrapper RedisWrapper) XAdd(key string, value ...string) (err error) {
+	defer checkCommand("XAdd", &err)
+	args := make([]interface{}, 0, len(value))
+	for _, v := range value {
+		args = append(args, v)
+	}
+	return wrapper.run(xaddAllScript, []string{key}, args...).Err()
+}
+
+func (wrapper RedisWrapper) XLen(key string) (length int64, err error) {
+	defer checkCommand("XLen", &err)
+	return wrapper.rawClient.XLen(wrapper.commandContext(), key).Result()
+}
+
+func (wrapper RedisWrapper) XReadGroup(key, group, consumer string, count int64) (entries []StreamEntry, err error) {
+	defer checkCommand("XReadGroup", &err)
+	args := &redis.XReadGroupArgs{Group: group, Consumer: consumer, Streams: []string{key, ">"}, Count: count, Block: -1}
+	streams, err := wrapper.rawClient.XReadGroup(wrapper.commandContext(), args).Result()
+	if isNoGroup(err) {
+		// first read of the group, create it (and the stream) and read again
+		err = wrapper.rawClient.XGroupCreateMkStream(wrapper.commandContext(), key, group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, err
+		}
+		streams, err = wrapper.rawClient.XReadGroup(wrapper.commandContext(), args).Result()
+	}
+	switch err {
+	case nil:
+	case redis.Nil:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			value, _ := message.Values["v"].(string)
+			entries = append(entries, StreamEntry{ID: message.ID, Value: value})
+		}
+	}
+	return entries, nil
+}
+
+func (wrapper RedisWrapper) XPending(key, group, consumer string, count int64) (ids []string, err error) {
+	defer checkCommand("XPending", &err)
+	args := &redis.XPendingExtArgs{Stream: key, Group: group, Start: "-", End: "+", Count: count, Consumer: consumer}
+	pending, err := wrapper.rawClient.XPendingExt(wrapper.commandContext(), args).Result()
+	if isNoGroup(err) || err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range pending {
+		ids = append(ids, entry.ID)
+	}
+	return ids, nil
+}
+
+func (wrapper RedisWrapper) XPendingCount(key, group, consumer string) (count int64, err error) {
+	defer checkCommand("XPendingCount", &err)
+	pending, err := wrapper.rawClient.XPending(wrapper.commandContext(), key, group).Result()
+	if isNoGroup(err) || err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if consumer == "" {
+		return pending.Count, nil
+	}
+	return pending.Consumers[consumer], nil
+}
+
+var xackDelScript = newScript("xack_del", `
+local affected = redis.call('XACK', KEYS[1], ARGV[1], ARGV[2])
+if affected > 0 then
+	redis.call('XDEL', KEYS[1], ARGV[2])
+end
+return affected
+`)
+
+func (wrapper RedisWrapper) XAckDel(key, group, id string) (affected int64, err error) {
+	defer checkCommand("XAckDel", &err)
+	return wrapper.run(xackDelScript, []string{key}, group, id).Int64()
+}
+
+var xackDelLPushScript = newScript("xack_del_lpush", `
+local affected = redis.call('XACK', KEYS[1], ARGV[1], ARGV[2])
+if affected > 0 then
+	redis.call('XDEL', KEYS[1], ARGV[2])
+	redis.call('LPUSH', KEYS[2], ARGV[3])
+end
+return affected
+`)
+
+func (wrapper RedisWrapper) XAckDelLPush(key, group, id, pushKey, pushValue string) (affected int64, err error) {
+	defer checkCommand("XAckDelLPush", &err)
+	return wrapper.run(xackDelLPushScript, []string{key, pushKey}, group, id, pushValue).Int64()
+}
+
+var rpopXAddScript = newScript("rpop_xadd", `
+local value = redis.call('RPOP', KEYS[1])
+if value then
+	redis.call('XADD', KEYS[2], '*', 'v', value)
+end
+return value
+`)
+
+func (wrapper RedisWrapper) RPopXAdd(source, key string) (value string, err error) {
+	defer checkCommand("RPopXAdd", &err)
+	value, err = wrapper.run(rpopXAddScript, []string{source, key}).Text()
+	if err == redis.Nil {
+		return "", ErrorNotFound
+	}
+	return value, err
+}
+
+// returns -1 if the guard key exists, the number of returned entries otherwise
+var xreturnScript = newScript("xreturn", `
+if KEYS[2] and redis.call('EXISTS', KEYS[2]) == 1 then
+	return -1
+end
+local returned = 0
+for i = 2, #ARGV do
+	local entries = redis.call('XRANGE', KEYS[1], ARGV[i], ARGV[i])
+	if redis.call('XACK', KEYS[1], ARGV[1], ARGV[i]) > 0 and entries[1] then
+		redis.call('XADD', KEYS[1], '*', unpack(entries[1][2]))
+		redis.call('XDEL', KEYS[1], ARGV[i])
+		returned = returned + 1
+	end
+end
+return returned
+`)
+
+func (wrapper RedisWrapper) XReturn(key, group string, ids ...string) (returned int64, err error) {
+	defer checkCommand("XReturn", &err)
+	return wrapper.run(xreturnScript, []string{key}, xreturnArgs(group, ids)...).Int64()
+}
+
+func (wrapper RedisWrapper) XReturnUnless(key, group, guardKey string, ids ...string) (returned int64, guarded bool, err error) {
+	defer checkCommand("XReturnUnless", &err)
+	returned, err = wrapper.run(xreturnScript, []string{key, guardKey}, xreturnArgs(group, ids)...).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	if returned < 0 {
+		return 0, true, nil
+	}
+	return returned, false, nil
+}
+
+func xreturnArgs(group string, ids []string) []interface{} {
+	args := make([]interface{}, 0, 1+len(ids))
+	args = append(args, group)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return args
+}
+
+func (wrapper RedisWrapper) XDelConsumer(key, group, consumer string) (err error) {
+	defer checkCommand("XDelConsumer", &err)
+	err = wrapper.rawClient.XGroupDelConsumer(wrapper.commandContext(), key, group, consumer).Err()
+	if isNoGroup(err) {
+		return nil
+	}
+	return err
+}
+
+// isNoGroup returns whether err is the redis error for reading or changing a
+// consumer group or stream which doesn't exist
+func isNoGroup(err error) bool {
+	return err != nil && (strings.HasPrefix(err.Error(), "NOGROUP") ||
+		strings.HasPrefix(err.Error(), "ERR no such key"))
+}
+
+func (wrapper RedisWrapper) Publish(channel, message string) (err error) {
+	defer checkCommand("Publish", &err)
+	return wrapper.rawClient.Publish(wrapper.commandContext(), channel, message).Err()
+}
+
+func (wrapper RedisWrapper) Subscribe(channel string) (messages <-chan string, unsubscribe func() error, err error) {
+	defer checkCommand("Subscribe", &err)
+	pubSub := wrapper.rawClient.Subscribe(wrapper.commandContext(), channel)
+	// wait for the subscription to be confirmed, so no messages get missed
+	if _, err := pubSub.Receive(unusedContext); err != nil {
+		pubSub.Close()
+		return nil, nil, err
+	}
+
+	received := make(chan string)
+	goroutines.Go("subscription", func() {
+		defer close(received)
+		for message := range pubSub.Channel() {
+			received <- message.Payload
+		}
+	})
+	return received, pubSub.Close, nil
+}
+
+func (wrapper RedisWrapper) FlushDb() (err error) {
+	defer checkCommand("FlushDb", &err)
 	// NOTE: using Err() here because Result() string is always "OK"
-	return wrapper.rawClient.FlushDB(unusedContext).Err()
+	return wrapper.rawClient.FlushDB(wrapper.commandContext()).Err()
+}
+
+// wrapperCommands are the redis commands the RedisWrapper methods run (the
+// scripts call more, and run via FCALL with Options.UseFunctions) and the rmq
+// features using the methods, by method name
+var wrapperCommands = map[string]struct{ command, feature string }{
+	"Set":             {"SET", "heartbeats and queue state"},
+	"SetNX":           {"SET", "locks of schedulers, single active consumers and work stealing"},
+	"ExpireIfEqual":   {"EVALSHA", "refreshing locks of schedulers and single active consumers"},
+	"Get":             {"GET", "queue state"},
+	"Del":             {"DEL", "cleaning up queue and connection state"},
+	"TTL":             {"TTL", "heartbeat checks"},
+	"IncrBy":          {"INCRBY", "queue and cleaner counters"},
+	"LPush":           {"LPUSH", "publishing"},
+	"RPush":           {"RPUSH", "returning deliveries"},
+	"LLen":            {"LLEN", "queue counts"},
+	"LLens":           {"LLEN", "stats"},
+	"LIndex":          {"LINDEX", "peeking, retention and fallback reconciling"},
+	"LRange":          {"LRANGE", "peeking and returning deliveries"},
+	"LRem":            {"LREM", "acking"},
+	"LTrim":           {"LTRIM", "purging"},
+	"RPopLPush":       {"RPOPLPUSH", "consuming"},
+	"RPopLPushUnless": {"EVALSHA", "the cleaner"},
+	"LPopRPush":       {"EVALSHA", "redelivering first"},
+	"LPopRPushUnless": {"EVALSHA", "the cleaner redelivering first"},
+	"LPushAllExpire":  {"EVALSHA", "purging with undo"},
+	"RPushAll":        {"EVALSHA", "undoing purges"},
+	"LRemLPush":       {"EVALSHA", "rejecting and handing off deliveries"},
+	"LRemLPushNX":     {"EVALSHA", "returning deliveries once"},
+	"SAdd":            {"SADD", "opening queues and connections"},
+	"SMembers":        {"SMEMBERS", "listing queues and consumers"},
+	"SScan":           {"SSCAN", "stats and the cleaner"},
+	"SRem":            {"SREM", "closing queues and consumers"},
+	"ZAddLimit":       {"EVALSHA", "semaphores"},
+	"ZAdd":            {"ZADD", "publishing delayed deliveries"},
+	"ZRem":            {"ZREM", "semaphores"},
+	"ZCard":           {"ZCARD", "waiting until queues are empty"},
+	"LRemZAdd":        {"EVALSHA", "delaying deliveries"},
+	"ZPopRPush":       {"EVALSHA", "returning delayed deliveries"},
+	"XAdd":            {"EVALSHA", "publishing to streams"},
+	"XLen":            {"XLEN", "stream counts"},
+	"XReadGroup":      {"XREADGROUP", "consuming streams"},
+	"XPending":        {"XPENDING", "returning unacked stream deliveries"},
+	"XPendingCount":   {"XPENDING", "stream counts"},
+	"XAckDel":         {"EVALSHA", "acking stream deliveries"},
+	"XAckDelLPush":    {"EVALSHA", "rejecting stream deliveries"},
+	"RPopXAdd":        {"EVALSHA", "returning rejected stream deliveries"},
+	"XReturn":         {"EVALSHA", "returning unacked stream deliveries"},
+	"XReturnUnless":   {"EVALSHA", "the cleaner returning stream deliveries"},
+	"XDelConsumer":    {"XGROUP", "closing stream consumers"},
+	"Publish":         {"PUBLISH", "queue events and signals"},
+	"Subscribe":       {"SUBSCRIBE", "queue events and signals"},
+	"FlushDb":         {"FLUSHDB", "flushing the database"},
+}
+
+// checkCommand turns *err into a CommandError if redis refused the command
+// of the given RedisWrapper method
+func checkCommand(method string, err *error) {
+	if *err == nil {
+		return
+	}
+	message := (*err).Error()
+	if !strings.HasPrefix(message, "NOPERM") && !strings.HasPrefix(message, "ERR unknown command") {
+		return
+	}
+
+	command := refusedCommand(message)
+	if command == "" {
+		command = wrapperCommands[method].command
+	}
+	*err = &CommandError{Command: command, Feature: wrapperCommands[method].feature, RedisErr: *err}
+}
+
+// refusedCommand returns the command named in a redis error message like
+// "NOPERM this user has no permissions to run the 'eval' command" or
+// "ERR unknown command `evalsha`, with args beginning with: ...". Returns ""
+// if the message doesn't name one, like if a key wasn't accessible.
+func refusedCommand(message string) string {
+	start := strings.IndexAny(message, "'`")
+	if start < 0 {
+		return ""
+	}
+	end := strings.IndexAny(message[start+1:], "'`")
+	if end < 0 {
+		return ""
+	}
+	return strings.ToUpper(message[start+1 : start+1+end])
 }
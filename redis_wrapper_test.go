@@ -0,0 +1,47 @@
+package rmq
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCommand(t *testing.T) {
+	err := errors.New("NOPERM this user has no permissions to run the 'evalsha' command or its subcommand")
+	checkCommand("LRemLPush", &err)
+	var commandErr *CommandError
+	require.True(t, errors.As(err, &commandErr))
+	assert.Equal(t, "EVALSHA", commandErr.Command)
+	assert.Equal(t, "rejecting and handing off deliveries", commandErr.Feature)
+	assert.EqualError(t, err, "rmq.CommandError: rejecting and handing off deliveries needs redis command EVALSHA: "+
+		"NOPERM this user has no permissions to run the 'evalsha' command or its subcommand")
+
+	// commands called by scripts
+	err = errors.New("NOPERM this user has no permissions to run the 'rename' command or its subcommand script: 1234")
+	checkCommand("LPushAllExpire", &err)
+	require.True(t, errors.As(err, &commandErr))
+	assert.Equal(t, "RENAME", commandErr.Command)
+
+	// the message doesn't name the command
+	err = errors.New("NOPERM this user has no permissions to access one of the keys used as arguments")
+	checkCommand("LPush", &err)
+	require.True(t, errors.As(err, &commandErr))
+	assert.Equal(t, "LPUSH", commandErr.Command)
+	assert.Equal(t, "publishing", commandErr.Feature)
+
+	err = errors.New("ERR unknown command `sscan`, with args beginning with: `rmq::queues`, `0`, ")
+	checkCommand("SScan", &err)
+	require.True(t, errors.As(err, &commandErr))
+	assert.Equal(t, "SSCAN", commandErr.Command)
+
+	// other errors stay as they are
+	original := errors.New("ERR value is not an integer or out of range")
+	err = original
+	checkCommand("IncrBy", &err)
+	assert.Equal(t, original, err)
+	err = nil
+	checkCommand("IncrBy", &err)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,78 @@
+package rmq
+
+import "sync"
+
+// registry keeps track of what's being consumed in this process to detect
+// unintentionally duplicated consumers
+var registry = struct {
+	sync.Mutex
+	consuming map[string]*registryEntry // by unacked key, see registerConsuming()
+	tags      map[string]int            // number of consumers by queue and tag, see registerConsumer()
+}{
+	consuming: map[string]*registryEntry{},
+	tags:      map[string]int{},
+}
+
+type registryEntry struct {
+	prefetchLimit int64
+	count         int // number of queue handles consuming with that limit
+}
+
+// registerConsuming registers that this queue handle started consuming.
+// Different handles of the same queue and connection share their unacked
+// deliveries, so they must use the same prefetch limit. Otherwise returns
+// ErrorPrefetchMismatch.
+func (queue *redisQueue) registerConsuming(prefetchLimit int64) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	entry, found := registry.consuming[queue.unackedKey]
+	if !found {
+		registry.consuming[queue.unackedKey] = &registryEntry{prefetchLimit: prefetchLimit, count: 1}
+		return nil
+	}
+	if entry.prefetchLimit != prefetchLimit {
+		return ErrorPrefetchMismatch
+	}
+	entry.count++
+	return nil
+}
+
+// registerConsumer registers a consumer with the given tag. If the queue is
+// already consumed with that tag in this process, a DuplicateConsumerError
+// gets sent to errChan. The consumer gets added anyway.
+func (queue *redisQueue) registerConsumer(tag string) {
+	registry.Lock()
+	key := queue.name + "\x00" + tag
+	registry.tags[key]++
+	count := registry.tags[key]
+	registry.Unlock()
+
+	queue.consumerTags = append(queue.consumerTags, tag)
+	if count > 1 {
+		select { // try to add error to channel, but don't block
+		case queue.errChan <- &DuplicateConsumerError{Queue: queue.name, Tag: tag, Count: count}:
+		default:
+		}
+	}
+}
+
+// unregister removes the registrations of this queue handle and its
+// consumers once it stopped consuming
+func (queue *redisQueue) unregister() {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if entry, found := registry.consuming[queue.unackedKey]; found {
+		if entry.count--; entry.count <= 0 {
+			delete(registry.consuming, queue.unackedKey)
+		}
+	}
+	for _, tag := range queue.consumerTags {
+		key := queue.name + "\x00" + tag
+		if registry.tags[key]--; registry.tags[key] <= 0 {
+			delete(registry.tags, key)
+		}
+	}
+	queue.consumerTags = nil
+}
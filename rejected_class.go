@@ -0,0 +1,90 @@
+package rmq
+
+import (
+	"sort"
+	"strings"
+)
+
+// RejectAs is like Reject(), but moves the delivery to the rejected list of
+// the given error class (like "transient", "permanent" or "malformed")
+// instead of the queue's main rejected list. This keeps deliveries which
+// failed for different reasons apart, so they can be inspected, returned and
+// purged per class. An empty class rejects to the main rejected list. If the
+// queue has a dead letter queue (see WithDeadLetter()) deliveries get moved
+// there regardless of their class.
+func (delivery *redisDelivery) RejectAs(class string) error {
+	if class == "" || delivery.classesKey == "" || delivery.restoreKey != "" {
+		return delivery.Reject()
+	}
+	delivery.setHandled()
+
+	// register the class before the list gets written, so RejectedClasses()
+	// never misses a non-empty list
+	if err := delivery.retry(func() (int64, error) {
+		_, err := delivery.redisClient.SAdd(delivery.classesKey, class)
+		return 1, err
+	}); err != nil {
+		return err
+	}
+	return delivery.move(queueRejectedClassKey(delivery.queueName, class), TrailRejected, true)
+}
+
+func queueRejectedClassKey(queueName, class string) string {
+	classKey := strings.Replace(queueClassTemplate, phQueue, queueName, 1)
+	return strings.Replace(classKey, phClass, class, 1)
+}
+
+// RejectedClasses returns the error classes deliveries of this queue got
+// rejected as, in sorted order, see Delivery.RejectAs(). Classes whose
+// rejected lists got purged or returned are still included.
+func (queue *redisQueue) RejectedClasses() ([]string, error) {
+	classes, err := queue.redisClient.SMembers(queue.classesKey)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(classes)
+	return classes, nil
+}
+
+// ReturnRejectedClass is like ReturnRejected(), but returns deliveries from
+// the rejected list of the given error class
+func (queue *redisQueue) ReturnRejectedClass(class string, max int64) (int64, error) {
+	if err := queue.checkList(); err != nil {
+		return 0, err
+	}
+	return queue.move(queueRejectedClassKey(queue.name, class), queue.readyKey, max, TrailReturned)
+}
+
+// PurgeRejectedClass is like PurgeRejected(), but removes the deliveries from
+// the rejected list of the given error class
+func (queue *redisQueue) PurgeRejectedClass(class string) (int64, error) {
+	if queue.options.Operations.ForbidPurgeRejected {
+		return 0, ErrorForbidden
+	}
+	return queue.deleteRedisList(queueRejectedClassKey(queue.name, class))
+}
+
+// PeekRejectedClass is like PeekRejected(), but returns deliveries from the
+// rejected list of the given error class
+func (queue *redisQueue) PeekRejectedClass(class string, max int64) ([]Message, error) {
+	return queue.peek(queueRejectedClassKey(queue.name, class), max)
+}
+
+// rejectedClassCounts returns the number of rejected deliveries per error
+// class, nil if no delivery got rejected with a class
+func (queue *redisQueue) rejectedClassCounts() (map[string]int64, error) {
+	classes, err := queue.RejectedClasses()
+	if err != nil || len(classes) == 0 {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(classes))
+	for _, class := range classes {
+		count, err := queue.redisClient.LLen(queueRejectedClassKey(queue.name, class))
+		if err != nil {
+			return nil, err
+		}
+		counts[class] = count
+	}
+	return counts, nil
+}
@@ -0,0 +1,140 @@
+package rmq
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectAs(t *testing.T) {
+	connection, err := OpenConnection("class-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("class-q")
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.Contains(t, []error{nil, ErrorNotFound}, err)
+	queue, err = connection.OpenQueue("class-q")
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("class-d1", "class-d2", "class-d3", "class-d4"))
+	for _, class := range []string{"transient", "permanent", "transient", ""} {
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		assert.NoError(t, delivery.RejectAs(class))
+	}
+
+	classes, err := queue.RejectedClasses()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"permanent", "transient"}, classes)
+	count, err := queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count) // empty class
+
+	messages, err := queue.PeekRejectedClass("transient", 10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "class-d1", messages[0].Payload) // oldest first
+	assert.Equal(t, "class-d3", messages[1].Payload)
+
+	stats, err := connection.CollectStats([]string{"class-q"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"permanent": 1, "transient": 2}, stats.QueueStats["class-q"].RejectedClasses)
+	assert.Equal(t, int64(1), stats.QueueStats["class-q"].RejectedCount)
+
+	returned, err := queue.ReturnRejectedClass("transient", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), returned)
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	purged, err := queue.PurgeRejectedClass("permanent")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	counts, err := queue.rejectedClassCounts()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"permanent": 0, "transient": 1}, counts)
+
+	readyCount, rejectedCount, err := queue.Destroy()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), readyCount)
+	assert.Equal(t, int64(2), rejectedCount) // empty class and transient
+	classes, err = queue.RejectedClasses()
+	assert.NoError(t, err)
+	assert.Empty(t, classes)
+}
+
+func TestRejectAsDeadLetter(t *testing.T) {
+	connection, err := OpenConnection("class-dead-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	deadLetterQueue, err := connection.OpenQueue("class-dead-dlq")
+	require.NoError(t, err)
+	_, err = deadLetterQueue.PurgeReady()
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("class-dead-q", WithDeadLetter(deadLetterQueue))
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("class-dead-d1"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, delivery.RejectAs("permanent"))
+
+	count, err := deadLetterQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	classes, err := queue.RejectedClasses()
+	assert.NoError(t, err)
+	assert.Empty(t, classes)
+}
+
+func TestRejectedClassRetention(t *testing.T) {
+	connection, err := OpenConnection("class-retention-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("class-retention-q")
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.Contains(t, []error{nil, ErrorNotFound}, err)
+	queue, err = connection.OpenQueue("class-retention-q")
+	require.NoError(t, err)
+
+	header := Header{HeaderPublishedAt: strconv.FormatInt(time.Now().Add(-2*time.Hour).UnixNano(), 10)}
+	assert.NoError(t, queue.PublishWithHeader(header, "class-retention-d1", "class-retention-d2", "class-retention-d3"))
+	for _, class := range []string{"transient", "permanent", "malformed"} {
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		assert.NoError(t, delivery.RejectAs(class))
+	}
+
+	// transient ones expire after an hour, malformed ones never, the others
+	// after the default of three hours
+	assert.NoError(t, queue.SetRetention(RetentionPolicy{
+		RejectedMaxAge: 3 * time.Hour,
+		ClassMaxAge:    map[string]time.Duration{"transient": time.Hour, "malformed": 0},
+	}))
+	dropped, err := NewJanitor(connection).Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), dropped)
+
+	assert.NoError(t, queue.SetRetention(RetentionPolicy{RejectedMaxAge: time.Hour}))
+	dropped, err = NewJanitor(connection).Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), dropped)
+
+	assert.NoError(t, queue.SetRetention(RetentionPolicy{}))
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+}
+
+func TestTestDeliveryRejectAs(t *testing.T) {
+	delivery := NewTestDeliveryString("p")
+	assert.NoError(t, delivery.RejectAs("malformed"))
+	assert.Equal(t, Rejected, delivery.State)
+	assert.Equal(t, "malformed", delivery.Class)
+	assert.Equal(t, ErrorNotFound, delivery.RejectAs("transient"))
+}
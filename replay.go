@@ -0,0 +1,128 @@
+package rmq
+
+import "strings"
+
+// ReplaySource selects which deliveries of a queue in a backup get replayed,
+// see Replayer.Replay()
+type ReplaySource int
+
+const (
+	ReplayReady    ReplaySource = 1 << iota // ready deliveries
+	ReplayUnacked                           // deliveries connections were consuming or got handed off
+	ReplayRejected                          // rejected deliveries
+
+	ReplayAll = ReplayReady | ReplayUnacked | ReplayRejected
+)
+
+// number of deliveries read from the backup and published per round trip
+const replayChunkSize = 1000
+
+// Replayer recovers deliveries lost in a catastrophic redis failure. Restore
+// a backup (RDB or AOF file) into a scratch redis instance or database, then
+// use a replayer to pick deliveries from the restored rmq keys and publish
+// them to the live queues. The backup doesn't get modified, so replaying the
+// same deliveries twice publishes them twice.
+type Replayer struct {
+	backup RedisClient
+	live   Connection
+}
+
+// NewReplayer returns a replayer which reads from the backup and publishes
+// via the live connection
+func NewReplayer(backup RedisClient, live Connection) *Replayer {
+	return &Replayer{backup: backup, live: live}
+}
+
+// Queues returns the names of all queues in the backup
+func (replayer *Replayer) Queues() ([]string, error) {
+	return scanMembers(replayer.backup, queuesKey)
+}
+
+// Replay publishes the deliveries of the given queue in the backup to the
+// ready list of the live queue with the same name, if filter returns true for
+// them (all of them if filter is nil). The deliveries keep their headers and
+// get published oldest first, per source list. Returns the number of replayed
+// deliveries.
+// NOTE: panics if the live connection is not a redis connection
+func (replayer *Replayer) Replay(queueName string, sources ReplaySource, filter func(Message) bool) (replayed int64, err error) {
+	queue, err := replayer.live.OpenQueue(queueName)
+	if err != nil {
+		return 0, err
+	}
+	liveQueue := queue.(*redisQueue)
+
+	keys, err := replayer.keys(queueName, sources)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		n, err := replayer.replayList(key, liveQueue, filter)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+// keys returns the keys of the backup lists of the given queue to replay
+func (replayer *Replayer) keys(queueName string, sources ReplaySource) ([]string, error) {
+	var keys []string
+	if sources&ReplayReady != 0 {
+		keys = append(keys, strings.Replace(queueReadyTemplate, phQueue, queueName, 1))
+	}
+	if sources&ReplayUnacked != 0 {
+		connectionNames, err := scanMembers(replayer.backup, connectionsKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, connectionName := range connectionNames {
+			keys = append(keys, queueHandoffKey(connectionName, queueName))
+			unackedKey := strings.Replace(connectionQueueUnackedTemplate, phConnection, connectionName, 1)
+			keys = append(keys, strings.Replace(unackedKey, phQueue, queueName, 1))
+		}
+	}
+	if sources&ReplayRejected != 0 {
+		keys = append(keys, strings.Replace(queueRejectedTemplate, phQueue, queueName, 1))
+	}
+	return keys, nil
+}
+
+// replayList publishes the matching deliveries of the given backup list,
+// oldest (rightmost) first, in chunks
+func (replayer *Replayer) replayList(key string, queue *redisQueue, filter func(Message) bool) (replayed int64, err error) {
+	length, err := replayer.backup.LLen(key)
+	if err != nil {
+		return 0, err
+	}
+
+	for stop := length - 1; stop >= 0; stop -= replayChunkSize {
+		start := stop - replayChunkSize + 1
+		if start < 0 {
+			start = 0
+		}
+		payloads, err := replayer.backup.LRange(key, start, stop)
+		if err != nil {
+			return replayed, err
+		}
+
+		matching := make([]string, 0, len(payloads))
+		for i := len(payloads) - 1; i >= 0; i-- {
+			if filter != nil {
+				header, body := decodeHeader(payloads[i])
+				if !filter(Message{Header: header, Payload: body}) {
+					continue
+				}
+			}
+			matching = append(matching, payloads[i])
+		}
+		if len(matching) == 0 {
+			continue
+		}
+		if _, err := queue.redisClient.LPush(queue.readyKey, matching...); err != nil {
+			return replayed, err
+		}
+		replayed += int64(len(matching))
+	}
+	return replayed, nil
+}
@@ -0,0 +1,62 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayer(t *testing.T) {
+	// state of the backup, restored into a scratch database
+	backupClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 3}))
+	assert.NoError(t, backupClient.FlushDb())
+	backupConn, err := OpenConnectionWithRmqRedisClient("replay-backup-conn", backupClient, nil)
+	require.NoError(t, err)
+	backupQueue, err := backupConn.OpenQueue("replay-q")
+	require.NoError(t, err)
+	assert.NoError(t, backupQueue.Publish("replay-unacked"))
+	delivery, err := backupQueue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "replay-unacked", delivery.Payload())
+	assert.NoError(t, backupQueue.Publish("replay-d1", "replay-skipped", "replay-d2"))
+	assert.NoError(t, backupQueue.PublishWithHeader(Header{"key": "value"}, "replay-d3"))
+	assert.NoError(t, backupConn.stopHeartbeat())
+
+	liveConn, err := OpenConnection("replay-live-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	liveQueue, err := liveConn.OpenQueue("replay-q")
+	require.NoError(t, err)
+	_, err = liveQueue.PurgeReady()
+	assert.NoError(t, err)
+
+	replayer := NewReplayer(backupClient, liveConn)
+	queues, err := replayer.Queues()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"replay-q"}, queues)
+
+	replayed, err := replayer.Replay("replay-q", ReplayAll, func(message Message) bool {
+		return message.Payload != "replay-skipped"
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), replayed)
+
+	messages, err := liveQueue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 4)
+	assert.Equal(t, "replay-d1", messages[0].Payload)
+	assert.Equal(t, "replay-d2", messages[1].Payload)
+	assert.Equal(t, "replay-d3", messages[2].Payload)
+	assert.Equal(t, Header{"key": "value"}, messages[2].Header)
+	assert.Equal(t, "replay-unacked", messages[3].Payload)
+
+	// the backup doesn't change
+	replayed, err = replayer.Replay("replay-q", ReplayUnacked, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), replayed)
+
+	assert.NoError(t, backupClient.FlushDb())
+	assert.NoError(t, liveConn.stopHeartbeat())
+}
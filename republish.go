@@ -0,0 +1,71 @@
+package rmq
+
+// number of rejected deliveries read per round trip while looking for the
+// one to republish
+const republishChunkSize = 100
+
+// RepublishRejected replaces the payload of the rejected delivery with the
+// given ID (see Message.ID()) with the result of transform and returns it to
+// the ready list. Removing it from the rejected list and publishing the new
+// payload happens atomically, the header is kept. The original delivery gets
+// kept in an audit list, see PeekRepublished(). This is useful to repair
+// deliveries which got rejected because of a malformed payload. Returns
+// ErrorNotFound if there is no such rejected delivery.
+func (queue *redisQueue) RepublishRejected(id string, transform func([]byte) []byte) error {
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+	payload, err := queue.findRejected(id)
+	if err != nil {
+		return err
+	}
+
+	header, body := decodeHeader(payload)
+	republished := encodeHeader(header, string(transform([]byte(body))))
+	if updated, ok := addBreadcrumb(republished, TrailRepaired, queue.connectionName); ok {
+		republished = updated
+	}
+
+	// keep the original before it leaves the rejected list, so it can't get
+	// lost in between
+	if _, err := queue.redisClient.LPush(queue.republishedKey, payload); err != nil {
+		return err
+	}
+	n, err := queue.redisClient.LRemLPush(queue.rejectedKey, payload, queue.readyKey, republished)
+	if err != nil {
+		return err
+	}
+	if n == 0 { // someone else returned or purged it meanwhile
+		if _, err := queue.redisClient.LRem(queue.republishedKey, 1, payload); err != nil {
+			return err
+		}
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// findRejected returns the payload of the rejected delivery with the given
+// ID. It scans from the head, so deliveries which get rejected meanwhile only
+// shift the ones not scanned yet towards the tail.
+func (queue *redisQueue) findRejected(id string) (string, error) {
+	for start := int64(0); ; start += republishChunkSize {
+		payloads, err := queue.redisClient.LRange(queue.rejectedKey, start, start+republishChunkSize-1)
+		if err != nil {
+			return "", err
+		}
+		for _, payload := range payloads {
+			if deliveryID(payload) == id {
+				return payload, nil
+			}
+		}
+		if len(payloads) < republishChunkSize {
+			return "", ErrorNotFound
+		}
+	}
+}
+
+// PeekRepublished returns up to max of the oldest original deliveries which
+// got replaced via RepublishRejected(), oldest first
+func (queue *redisQueue) PeekRepublished(max int64) ([]Message, error) {
+	return queue.peek(queue.republishedKey, max)
+}
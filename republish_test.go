@@ -0,0 +1,66 @@
+package rmq
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepublishRejected(t *testing.T) {
+	connection, err := OpenConnection("republish-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("republish-q", WithTrail())
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.Contains(t, []error{nil, ErrorNotFound}, err)
+	queue, err = connection.OpenQueue("republish-q", WithTrail())
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.PublishWithHeader(Header{"key": "value"}, `{"name":"a"}`, `{"name":"b"}`))
+	for i := 0; i < 2; i++ {
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		assert.NoError(t, delivery.Reject())
+	}
+
+	messages, err := queue.PeekRejected(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.NotEqual(t, messages[0].ID(), messages[1].ID())
+
+	fix := func(body []byte) []byte {
+		return []byte(strings.Replace(string(body), "}", `,"id":1}`, 1))
+	}
+	assert.NoError(t, queue.RepublishRejected(messages[1].ID(), fix))
+	assert.Equal(t, ErrorNotFound, queue.RepublishRejected(messages[1].ID(), fix))
+	assert.Equal(t, ErrorNotFound, queue.RepublishRejected("unknown", fix))
+
+	rejected, err := queue.PeekRejected(10)
+	assert.NoError(t, err)
+	require.Len(t, rejected, 1)
+	assert.Equal(t, `{"name":"a"}`, rejected[0].Payload)
+
+	ready, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, ready, 1)
+	assert.Equal(t, `{"name":"b","id":1}`, ready[0].Payload)
+	assert.Equal(t, "value", ready[0].Header["key"])
+	trail := ready[0].Header.Trail()
+	require.NotEmpty(t, trail)
+	assert.Equal(t, TrailRepaired, trail[len(trail)-1].Event)
+
+	originals, err := queue.PeekRepublished(10)
+	assert.NoError(t, err)
+	require.Len(t, originals, 1)
+	assert.Equal(t, `{"name":"b"}`, originals[0].Payload)
+	assert.Equal(t, messages[1].ID(), originals[0].ID())
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	originals, err = queue.PeekRepublished(10)
+	assert.NoError(t, err)
+	assert.Empty(t, originals)
+}
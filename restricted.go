@@ -0,0 +1,71 @@
+package rmq
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RestrictedACL are the redis (6.2 or later) ACL rules a user needs to run
+// rmq in restricted command mode, see Options.RestrictedCommands. They allow
+// only the commands rmq uses, including the ones called by its Lua scripts,
+// on rmq keys and channels. Use them to create the user, like:
+//
+//	ACL SETUSER rmq on >password <RestrictedACL>
+//
+// Add rules for other key patterns if you configure any, like a fallback
+// redis with the same user.
+const RestrictedACL = "resetkeys ~rmq::* resetchannels &rmq::* -@all " +
+	"+ping +select " +
+	"+get +set +setnx +del +exists +ttl +pexpire +incrby +rename " +
+	"+lpush +rpush +lpop +llen +lindex +lrange +lrem +ltrim +rpoplpush " +
+	"+sadd +srem +smembers +sscan " +
+	"+zadd +zrem +zcard +zrangebyscore +zremrangebyscore " +
+	"+eval +evalsha +publish +subscribe +unsubscribe"
+
+// RestrictedCommandError is returned in restricted command mode by
+// operations which would need a command RestrictedACL doesn't allow or which
+// would delete a key shared by all queues and connections
+type RestrictedCommandError struct {
+	Command string // like "DEL"
+	Key     string // empty if the command doesn't take a key
+}
+
+func (e *RestrictedCommandError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("rmq restricted command mode doesn't allow %s", e.Command)
+	}
+	return fmt.Sprintf("rmq restricted command mode doesn't allow %s of shared key %s", e.Command, e.Key)
+}
+
+// restrictedClient enforces restricted command mode on the operations which
+// could affect others using the same redis
+type restrictedClient struct {
+	RedisClient
+}
+
+func (client restrictedClient) Del(key string) (affected int64, err error) {
+	if isSharedKey(key) {
+		return 0, &RestrictedCommandError{Command: "DEL", Key: key}
+	}
+	return client.RedisClient.Del(key)
+}
+
+func (client restrictedClient) FlushDb() error {
+	return &RestrictedCommandError{Command: "FLUSHDB"}
+}
+
+// isSharedKey returns whether key belongs to all queues and connections
+// rather than to a single one
+func isSharedKey(key string) bool {
+	switch key {
+	case queuesKey, connectionsKey, schedulerLeaderKey:
+		return true
+	}
+	for _, template := range []string{cleanerRunsKey, semaphoreTemplate, familyTemplate} {
+		prefix := template[:strings.LastIndex(template, "::")+2]
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestrictedCommands(t *testing.T) {
+	options := TestOptions
+	options.RestrictedCommands = true
+	connection, err := OpenConnectionWithOptions("restricted-conn", NewTestRedisClient(), nil, options)
+	require.NoError(t, err)
+
+	assert.Equal(t, &RestrictedCommandError{Command: "FLUSHDB"}, connection.flushDb())
+	assert.Equal(t, &RestrictedCommandError{Command: "DEL", Key: queuesKey}, connection.unlistAllQueues())
+	assert.EqualError(t, connection.unlistAllQueues(), "rmq restricted command mode doesn't allow DEL of shared key rmq::queues")
+
+	// queues work as usual
+	queue, err := connection.OpenQueue("restricted-q")
+	require.NoError(t, err)
+	assert.NoError(t, queue.Publish("restricted-d"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, delivery.Ack())
+	assert.NoError(t, queue.Freeze())
+	assert.NoError(t, queue.Unfreeze())
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.Close())
+}
+
+func TestIsSharedKey(t *testing.T) {
+	assert.True(t, isSharedKey(connectionsKey))
+	assert.True(t, isSharedKey(cleanerSuccessKey))
+	assert.True(t, isSharedKey("rmq::semaphore::imports"))
+	assert.True(t, isSharedKey("rmq::family::tenants"))
+	assert.False(t, isSharedKey("rmq::queue::[tasks]::ready"))
+	assert.False(t, isSharedKey("rmq::connection::conn-abc123::heartbeat"))
+}
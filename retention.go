@@ -0,0 +1,258 @@
+package rmq
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// RetentionPolicy limits how long deliveries are kept in a queue. Ages are
+// measured from the publish time, so they only apply to deliveries published
+// with WithPublishTime(). Zero durations keep deliveries forever.
+// RejectedMaxAge also applies to the rejected lists of error classes (see
+// Delivery.RejectAs()), unless ClassMaxAge has an age for the class.
+type RetentionPolicy struct {
+	ReadyMaxAge    time.Duration            `json:"readyMaxAge"`
+	RejectedMaxAge time.Duration            `json:"rejectedMaxAge"`
+	ClassMaxAge    map[string]time.Duration `json:"classMaxAge,omitempty"` // by error class
+}
+
+// SetRetention persists the retention policy of this queue in redis, where
+// it gets enforced by a Janitor. A zero policy removes it.
+func (queue *redisQueue) SetRetention(policy RetentionPolicy) error {
+	if policy.ReadyMaxAge == 0 && policy.RejectedMaxAge == 0 && len(policy.ClassMaxAge) == 0 {
+		_, err := queue.redisClient.Del(queue.retentionKey)
+		return err
+	}
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return queue.redisClient.Set(queue.retentionKey, string(bytes), 0)
+}
+
+// Retention returns the retention policy of this queue, see SetRetention()
+func (queue *redisQueue) Retention() (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	value, err := queue.redisClient.Get(queue.retentionKey)
+	if err == ErrorNotFound {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+	err = json.Unmarshal([]byte(value), &policy)
+	return policy, err
+}
+
+// enforceRetention drops the deliveries which are older than allowed by the
+// retention policy and returns how many it dropped
+func (queue *redisQueue) enforceRetention(now time.Time) (int64, error) {
+	policy, err := queue.Retention()
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := int64(0)
+	if policy.ReadyMaxAge > 0 {
+		n, err := queue.dropOlder(queue.readyKey, now.Add(-policy.ReadyMaxAge))
+		if err != nil {
+			return dropped, err
+		}
+		dropped += n
+	}
+	if policy.RejectedMaxAge > 0 {
+		n, err := queue.dropOlder(queue.rejectedKey, now.Add(-policy.RejectedMaxAge))
+		if err != nil {
+			return dropped, err
+		}
+		dropped += n
+	}
+	if policy.RejectedMaxAge == 0 && len(policy.ClassMaxAge) == 0 {
+		return dropped, nil
+	}
+
+	classes, err := queue.RejectedClasses()
+	if err != nil {
+		return dropped, err
+	}
+	for _, class := range classes {
+		maxAge, ok := policy.ClassMaxAge[class]
+		if !ok {
+			maxAge = policy.RejectedMaxAge
+		}
+		if maxAge <= 0 {
+			continue
+		}
+		n, err := queue.dropOlder(queueRejectedClassKey(queue.name, class), now.Add(-maxAge))
+		if err != nil {
+			return dropped, err
+		}
+		dropped += n
+	}
+	return dropped, nil
+}
+
+// dropOlder drops deliveries from the tail (oldest end) of the given list as
+// long as they got published before cutoff. It stops at the first delivery
+// which is younger or has no publish time.
+func (queue *redisQueue) dropOlder(key string, cutoff time.Time) (dropped int64, err error) {
+	for {
+		payload, err := queue.redisClient.LIndex(key, -1)
+		if err == ErrorNotFound { // empty
+			return dropped, nil
+		}
+		if err != nil {
+			return dropped, err
+		}
+
+		header, _ := decodeHeader(payload)
+		publishedAt, ok := header.publishedAt()
+		if !ok || !publishedAt.Before(cutoff) {
+			return dropped, nil
+		}
+
+		// remove by value from the tail, in case a consumer took this one
+		// meanwhile and the tail is a different delivery now
+		n, err := queue.redisClient.LRem(key, -1, payload)
+		if err != nil {
+			return dropped, err
+		}
+		if n == 0 {
+			continue // someone else took it
+		}
+		dropped += n
+	}
+}
+
+// Janitor enforces the retention policies, return rejected policies and aging
+// policies of all open queues, see Queue.SetRetention(),
+// Queue.SetReturnRejectedPolicy() and Queue.SetAgingPolicy(). It also
+// returns due delayed deliveries to ready, which consumers only do while
+// they are running.
+type Janitor struct {
+	connection Connection
+}
+
+func NewJanitor(connection Connection) *Janitor {
+	return &Janitor{connection: connection}
+}
+
+// Clean drops the deliveries which are older than allowed by the retention
+// policies of their queues. If there was no error it returns the number of
+// dropped deliveries across all queues.
+func (janitor *Janitor) Clean() (dropped int64, err error) {
+	queueNames, err := janitor.connection.GetOpenQueues()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, queueName := range queueNames {
+		n, err := janitor.connection.openQueue(queueName).enforceRetention(now)
+		if err != nil {
+			return dropped, err
+		}
+		dropped += n
+	}
+
+	return dropped, nil
+}
+
+// ReturnRejected returns rejected deliveries to the ready lists of their
+// queues as allowed by their return rejected policies. If there was no error
+// it returns the number of returned deliveries across all queues.
+func (janitor *Janitor) ReturnRejected() (returned int64, err error) {
+	queueNames, err := janitor.connection.GetOpenQueues()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, queueName := range queueNames {
+		n, err := janitor.connection.openQueue(queueName).enforceReturnPolicy(now)
+		if err != nil {
+			return returned, err
+		}
+		returned += n
+	}
+
+	return returned, nil
+}
+
+// PromoteAged promotes deliveries which waited too long to higher priority
+// queues as defined by the aging policies of their queues. If there was no
+// error it returns the number of promoted deliveries across all queues.
+func (janitor *Janitor) PromoteAged() (promoted int64, err error) {
+	queueNames, err := janitor.connection.GetOpenQueues()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, queueName := range queueNames {
+		n, err := janitor.connection.openQueue(queueName).enforceAging(now)
+		if err != nil {
+			return promoted, err
+		}
+		promoted += n
+	}
+
+	return promoted, nil
+}
+
+// PromoteDelayed returns the delayed deliveries which are due to the ready
+// lists of their queues, see Queue.PublishAt() and RetryAfter(). If there
+// was no error it returns the number of returned deliveries across all
+// queues.
+func (janitor *Janitor) PromoteDelayed() (promoted int64, err error) {
+	queueNames, err := janitor.connection.GetOpenQueues()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, queueName := range queueNames {
+		n, err := janitor.connection.openQueue(queueName).promoteDue(now)
+		if err != nil {
+			return promoted, err
+		}
+		promoted += n
+	}
+
+	return promoted, nil
+}
+
+// Run calls Clean(), ReturnRejected(), PromoteAged() and PromoteDelayed()
+// once per interval until the context is done. Returns the context's error
+// or any redis error. Use an interval which is shorter than the ones of the return rejected
+// policies and the max waits of the aging policies.
+func (janitor *Janitor) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := janitor.Clean(); err != nil {
+			return err
+		}
+		if _, err := janitor.ReturnRejected(); err != nil {
+			return err
+		}
+		if _, err := janitor.PromoteAged(); err != nil {
+			return err
+		}
+		if _, err := janitor.PromoteDelayed(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package rmq
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention(t *testing.T) {
+	connection, err := OpenConnection("retention-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("retention-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	policy := RetentionPolicy{ReadyMaxAge: time.Hour, RejectedMaxAge: 2 * time.Hour}
+	assert.NoError(t, queue.SetRetention(policy))
+	stored, err := queue.Retention()
+	assert.NoError(t, err)
+	assert.Equal(t, policy, stored)
+
+	publishedAt := func(age time.Duration) Header {
+		return Header{HeaderPublishedAt: strconv.FormatInt(time.Now().Add(-age).UnixNano(), 10)}
+	}
+
+	// rejected 90 minutes after publishing, kept for now
+	assert.NoError(t, queue.PublishWithHeader(publishedAt(90*time.Minute), "retention-r1"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, delivery.Reject())
+
+	assert.NoError(t, queue.PublishWithHeader(publishedAt(3*time.Hour), "retention-d1", "retention-d2"))
+	assert.NoError(t, queue.PublishWithHeader(publishedAt(time.Minute), "retention-d3"))
+	assert.NoError(t, queue.Publish("retention-d4")) // no publish time
+
+	dropped, err := NewJanitor(connection).Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), dropped)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	count, err = queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// tighter policy drops the rejected one too
+	assert.NoError(t, queue.SetRetention(RetentionPolicy{RejectedMaxAge: time.Hour}))
+	dropped, err = NewJanitor(connection).Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), dropped)
+
+	assert.NoError(t, queue.SetRetention(RetentionPolicy{}))
+	stored, err = queue.Retention()
+	assert.NoError(t, err)
+	assert.Equal(t, RetentionPolicy{}, stored)
+
+	// destroying the queue removes its policy
+	assert.NoError(t, queue.SetRetention(policy))
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	stored, err = queue.Retention()
+	assert.NoError(t, err)
+	assert.Equal(t, RetentionPolicy{}, stored)
+	assert.NoError(t, connection.stopHeartbeat())
+}
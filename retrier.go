@@ -0,0 +1,188 @@
+package rmq
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// RetryDecision defines what a Retrier does with a rejected delivery
+type RetryDecision int
+
+const (
+	RetryDelivery RetryDecision = iota // return the delivery to the ready list
+	DropDelivery                       // remove the delivery
+	ParkDelivery                       // publish the delivery to the park queue
+)
+
+// RetryClassifier decides what to do with a rejected delivery
+type RetryClassifier func(payload string) RetryDecision
+
+// Retrier reprocesses rejected deliveries according to a RetryClassifier.
+// Only run one retrier per queue and source (rejected deliveries or dead
+// letter queue) at a time.
+type Retrier struct {
+	sourceKey   string // key to list of deliveries to be classified
+	retryingKey string // key to list of deliveries currently being classified
+	readyKey    string // key to list retried deliveries get returned to
+	parkKey     string // key to list parked deliveries get published to, empty if none
+	redisClient RedisClient
+	classify    RetryClassifier
+	streams     bool // whether one of the queues got opened with WithStream(), Retry() returns ErrorStreamMixed then
+}
+
+// NewRetrier returns a retrier which classifies the rejected deliveries of the
+// given queue. Retried deliveries get returned to the ready list of the queue,
+// parked ones get published to parkQueue, which may be nil if the classifier
+// never parks deliveries. None of the queues must be opened with
+// WithStream().
+// NOTE: panics if the queues are not opened via a redis connection
+func NewRetrier(queue Queue, parkQueue Queue, classify RetryClassifier) *Retrier {
+	redisQueue := queue.(*redisQueue)
+	return newRetrier(redisQueue, redisQueue.rejectedKey, parkQueue, classify)
+}
+
+// NewDeadLetterRetrier returns a retrier which classifies the deliveries in
+// the given dead letter queue (see WithDeadLetter()). Retried deliveries get
+// returned to the ready list of queue, parked ones get published to
+// parkQueue, which may be nil if the classifier never parks deliveries. None
+// of the queues must be opened with WithStream().
+// NOTE: panics if the queues are not opened via a redis connection
+func NewDeadLetterRetrier(deadLetterQueue Queue, queue Queue, parkQueue Queue, classify RetryClassifier) *Retrier {
+	retrier := newRetrier(queue.(*redisQueue), deadLetterQueue.(*redisQueue).readyKey, parkQueue, classify)
+	retrier.streams = retrier.streams || isStreamQueue(deadLetterQueue)
+	return retrier
+}
+
+func newRetrier(queue *redisQueue, sourceKey string, parkQueue Queue, classify RetryClassifier) *Retrier {
+	retrier := &Retrier{
+		sourceKey:   sourceKey,
+		retryingKey: strings.Replace(retryingTemplate, phSource, sourceKey, 1),
+		readyKey:    queue.readyKey,
+		redisClient: queue.redisClient,
+		classify:    classify,
+		streams:     isStreamQueue(queue),
+	}
+	if parkQueue != nil {
+		retrier.parkKey = parkQueue.(*redisQueue).readyKey
+		retrier.streams = retrier.streams || isStreamQueue(parkQueue)
+	}
+	return retrier
+}
+
+// Retry classifies up to max deliveries. It doesn't pick up deliveries which
+// got rejected again while it was running. If there was no error it returns
+// the number of retried, dropped and parked deliveries.
+func (retrier *Retrier) Retry(max int64) (retried, dropped, parked int64, err error) {
+	if retrier.streams {
+		return 0, 0, 0, ErrorStreamMixed
+	}
+	// return deliveries left over by a retrier which got interrupted
+	if err := retrier.recover(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	count, err := retrier.redisClient.LLen(retrier.sourceKey)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if count > max {
+		count = max
+	}
+
+	for i := int64(0); i < count; i++ {
+		payload, err := retrier.redisClient.RPopLPush(retrier.sourceKey, retrier.retryingKey)
+		if err == ErrorNotFound {
+			break // someone else took the rest
+		}
+		if err != nil {
+			return retried, dropped, parked, err
+		}
+
+		_, body := decodeHeader(payload)
+		switch retrier.classify(body) {
+		case RetryDelivery:
+			if err := retrier.finish(payload, retrier.readyKey); err != nil {
+				return retried, dropped, parked, err
+			}
+			retried++
+		case DropDelivery:
+			if err := retrier.finish(payload, ""); err != nil {
+				return retried, dropped, parked, err
+			}
+			dropped++
+		case ParkDelivery:
+			if retrier.parkKey == "" {
+				return retried, dropped, parked, ErrorNoParkQueue
+			}
+			if err := retrier.finish(payload, retrier.parkKey); err != nil {
+				return retried, dropped, parked, err
+			}
+			parked++
+		default: // left being classified, the next retrier classifies it again
+			return retried, dropped, parked, ErrorUnknownDecision
+		}
+	}
+
+	return retried, dropped, parked, nil
+}
+
+// Run calls Retry() repeatedly until the context is done. After retrying
+// deliveries it waits for a backoff which doubles after each such round (up
+// to maxBackoff), so deliveries which keep getting rejected get retried less
+// and less often. Once there's nothing left to retry the backoff gets reset
+// to minBackoff. Returns the context's error or any redis error.
+func (retrier *Retrier) Run(ctx context.Context, minBackoff, maxBackoff time.Duration) error {
+	backoff := minBackoff
+	for {
+		retried, _, _, err := retrier.Retry(purgeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		wait := minBackoff
+		if retried > 0 {
+			wait = backoff
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		} else {
+			backoff = minBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// finish moves the classified delivery to the given list or drops it if
+// toKey is empty
+func (retrier *Retrier) finish(payload, toKey string) error {
+	// push before removing, so a crash in between leads to double delivery
+	// instead of a lost delivery
+	if toKey != "" {
+		if _, err := retrier.redisClient.LPush(toKey, payload); err != nil {
+			return err
+		}
+	}
+	_, err := retrier.redisClient.LRem(retrier.retryingKey, 1, payload)
+	return err
+}
+
+// recover moves all deliveries which are still being classified back to the
+// source list
+func (retrier *Retrier) recover() error {
+	for {
+		switch _, err := retrier.redisClient.RPopLPush(retrier.retryingKey, retrier.sourceKey); err {
+		case nil: // moved one
+			continue
+		case ErrorNotFound: // nothing left
+			return nil
+		default: // error
+			return err
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package rmq
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func classifyByPrefix(payload string) RetryDecision {
+	switch {
+	case strings.HasPrefix(payload, "drop"):
+		return DropDelivery
+	case strings.HasPrefix(payload, "park"):
+		return ParkDelivery
+	default:
+		return RetryDelivery
+	}
+}
+
+func rejectAll(t *testing.T, queue Queue, payloads ...string) {
+	require.NoError(t, queue.Publish(payloads...))
+	for range payloads {
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, delivery.Reject())
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	connection, err := OpenConnection("retrier-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("retrier-q")
+	assert.NoError(t, err)
+	parkQueue, err := connection.OpenQueue("retrier-park")
+	assert.NoError(t, err)
+	for _, q := range []Queue{queue, parkQueue} {
+		_, err = q.PurgeReady()
+		assert.NoError(t, err)
+		_, err = q.PurgeRejected()
+		assert.NoError(t, err)
+	}
+
+	rejectAll(t, queue, "retry-1", "drop-1", "park-1", "retry-2")
+
+	retrier := NewRetrier(queue, parkQueue, classifyByPrefix)
+	retried, dropped, parked, err := retrier.Retry(3)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 1, 1}, []int64{retried, dropped, parked})
+	retried, dropped, parked, err = retrier.Retry(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 0, 0}, []int64{retried, dropped, parked})
+
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	count, err = queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = parkQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// parking requires a park queue
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	rejectAll(t, queue, "park-2")
+	_, _, _, err = NewRetrier(queue, nil, classifyByPrefix).Retry(10)
+	assert.Equal(t, ErrorNoParkQueue, err)
+	// the delivery gets classified again by the next retrier
+	_, _, parked, err = retrier.Retry(10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), parked)
+
+	// unknown decisions leave the delivery to the next retrier
+	rejectAll(t, queue, "unknown-1")
+	_, _, _, err = NewRetrier(queue, nil, func(string) RetryDecision { return RetryDecision(42) }).Retry(10)
+	assert.Equal(t, ErrorUnknownDecision, err)
+	retried, _, _, err = retrier.Retry(10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), retried)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestDeadLetterRetrier(t *testing.T) {
+	connection, err := OpenConnection("dlq-retrier-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	deadLetterQueue, err := connection.OpenQueue("dlq-retrier-dlq")
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("dlq-retrier-q", WithDeadLetter(deadLetterQueue))
+	assert.NoError(t, err)
+	for _, q := range []Queue{queue, deadLetterQueue} {
+		_, err = q.PurgeReady()
+		assert.NoError(t, err)
+	}
+
+	rejectAll(t, queue, "retry-1", "drop-1")
+	count, err := deadLetterQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	retrier := NewDeadLetterRetrier(deadLetterQueue, queue, nil, classifyByPrefix)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, retrier.Run(ctx, time.Millisecond, 10*time.Millisecond))
+
+	count, err = deadLetterQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// deliveries left over by an interrupted retrier of the rejected
+	// deliveries don't get recovered into the dead letter queue
+	redisQueue := queue.(*redisQueue)
+	rejectedRetrier := NewRetrier(queue, nil, classifyByPrefix)
+	assert.NotEqual(t, rejectedRetrier.retryingKey, retrier.retryingKey)
+	_, err = redisQueue.redisClient.LPush(rejectedRetrier.retryingKey, "retry-2")
+	assert.NoError(t, err)
+	_, _, _, err = retrier.Retry(10)
+	assert.NoError(t, err)
+	count, err = deadLetterQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	_, err = redisQueue.redisClient.Del(rejectedRetrier.retryingKey)
+	assert.NoError(t, err)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,86 @@
+package rmq
+
+import (
+	"time"
+)
+
+// RetryPolicy defines how often rejected deliveries get retried before they
+// go to the rejected list (or dead letter queue), see WithRetryPolicy()
+type RetryPolicy struct {
+	MaxRetries int           // how often a delivery gets requeued before it stays rejected
+	MinBackoff time.Duration // delay of the first retry, doubled for each further retry (default 1s)
+	MaxBackoff time.Duration // max delay of retries (default 1h)
+}
+
+// WithRetryPolicy makes Reject() requeue deliveries after an exponential
+// backoff until they got retried policy.MaxRetries times. Only then they get
+// moved to the rejected list, or the dead letter queue if set (see
+// WithDeadLetter()), where they can be inspected via PeekRejected(), purged
+// via PurgeRejected() and replayed via ReplayRejected(). How often a delivery
+// got retried is available via Header.Attempts(). Deliveries rejected via
+// RejectAs(), because of a PermanentError (see WithErrorPolicy()) or because
+// they couldn't be decoded (see WithCodecs()) don't get retried.
+func WithRetryPolicy(policy RetryPolicy) QueueOption {
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = defaultMinErrorBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultMaxErrorBackoff
+	}
+
+	return func(queue *redisQueue) {
+		queue.retryPolicy = &policy
+	}
+}
+
+// backoff returns the delay of a delivery which got retried attempts times
+// before
+func (policy *RetryPolicy) backoff(attempts int) time.Duration {
+	return exponentialBackoff(policy.MinBackoff, policy.MaxBackoff, attempts)
+}
+
+// ReplayRejected is like ReturnRejected(), but resets the attempts of the
+// returned deliveries (see Header.Attempts()), so they get retried according
+// to the queue's retry policy again, see WithRetryPolicy()
+func (queue *redisQueue) ReplayRejected(max int64) (int64, error) {
+	if err := queue.checkList(); err != nil {
+		return 0, err
+	}
+	return queue.moveWith(queue.popReplayed, queue.rejectedKey, queue.readyKey, max, TrailReturned, false)
+}
+
+// popReplayed atomically moves the oldest delivery of one list to the start
+// of another with its attempts reset and returns it as moved
+func (queue *redisQueue) popReplayed(from, to string) (string, error) {
+	for {
+		payload, err := queue.redisClient.LIndex(from, -1)
+		if err != nil {
+			return "", err
+		}
+		replayed := withoutAttempts(payload)
+		affected, err := queue.redisClient.LRemLPush(from, payload, to, replayed)
+		if err != nil {
+			return "", err
+		}
+		if affected > 0 {
+			return replayed, nil
+		}
+		// got removed by someone else in the meantime, try the next one
+	}
+}
+
+// withoutAttempts returns the payload with the attempts removed from its
+// header
+func withoutAttempts(payload string) string {
+	header, body := decodeHeader(payload)
+	if _, ok := header[HeaderAttempts]; !ok {
+		return payload
+	}
+	updated := make(Header, len(header))
+	for key, value := range header {
+		if key != HeaderAttempts {
+			updated[key] = value
+		}
+	}
+	return encodeHeader(updated, body)
+}
@@ -0,0 +1,118 @@
+package rmq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	connection, err := OpenConnection("retry-policy-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("retry-policy-q", WithRetryPolicy(RetryPolicy{
+		MaxRetries: 2,
+		MinBackoff: 10 * time.Millisecond,
+	}))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+	_, err = connection.(*redisConnection).redisClient.Del(queue.(*redisQueue).delayedKey)
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	var attempts []int
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumerFunc("retry-policy-cons", func(delivery Delivery) {
+		mu.Lock()
+		attempts = append(attempts, delivery.Header().Attempts())
+		mu.Unlock()
+		assert.NoError(t, delivery.Reject())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("retry-policy-d1"))
+
+	// retried after 10ms and 20ms, then rejected for good
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []int{0, 1, 2}, attempts)
+	mu.Unlock()
+	<-queue.StopConsuming()
+
+	rejected, err := queue.PeekRejected(10)
+	assert.NoError(t, err)
+	if assert.Len(t, rejected, 1) {
+		assert.Equal(t, "retry-policy-d1", rejected[0].Payload)
+		assert.Equal(t, 2, rejected[0].Header.Attempts())
+	}
+
+	// replayed deliveries get their attempts reset
+	count, err := queue.ReplayRejected(10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	ready, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	if assert.Len(t, ready, 1) {
+		assert.Equal(t, "retry-policy-d1", ready[0].Payload)
+		assert.Equal(t, 0, ready[0].Header.Attempts())
+	}
+	count, err = queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	// pushing without push queue rejects right away
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("retry-policy-d2"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	if assert.NoError(t, err) {
+		assert.NoError(t, delivery.Push())
+	}
+	count, err = queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestRetryPolicyPermanentError(t *testing.T) {
+	connection, err := OpenConnection("retry-permanent-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("retry-permanent-q", WithAutoAck(),
+		WithRetryPolicy(RetryPolicy{MaxRetries: 2, MinBackoff: 10 * time.Millisecond}),
+		WithErrorPolicy(ErrorPolicy{Classify: func(error) ErrorClass { return PermanentError }}),
+	)
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumer("retry-permanent-cons", HandlerFunc(func(Delivery) error {
+		return errPermanent
+	}))
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("retry-permanent-d1"))
+
+	time.Sleep(100 * time.Millisecond)
+	rejected, err := queue.PeekRejected(10)
+	assert.NoError(t, err)
+	if assert.Len(t, rejected, 1) { // not retried
+		assert.Equal(t, 0, rejected[0].Header.Attempts())
+	}
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestWithoutAttempts(t *testing.T) {
+	assert.Equal(t, "plain", withoutAttempts("plain"))
+	assert.Equal(t, "plain", withoutAttempts(withAttempt("plain")))
+	encoded := encodeHeader(Header{"key": "value"}, "body")
+	assert.Equal(t, encoded, withoutAttempts(withAttempt(encoded)))
+}
@@ -0,0 +1,117 @@
+package rmq
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// number of rejected deliveries checked per round trip when returning only
+// young ones, see ReturnRejectedPolicy.MaxAge
+const returnPolicyChunkSize = 100
+
+// ReturnRejectedPolicy makes a Janitor return rejected deliveries of a queue
+// to its ready list on a schedule, so the rejected list becomes a managed
+// retry tier instead of a graveyard which needs manual intervention
+type ReturnRejectedPolicy struct {
+	Max      int64         `json:"max"`      // deliveries returned per interval at most
+	Interval time.Duration `json:"interval"` // how often deliveries get returned
+	// MaxAge only returns deliveries which got published less than MaxAge
+	// ago, older ones stay rejected. This only applies to deliveries
+	// published with WithPublishTime(), the ones without publish time stay
+	// rejected too. Zero returns all deliveries.
+	MaxAge time.Duration `json:"maxAge,omitempty"`
+}
+
+// SetReturnRejectedPolicy persists the return rejected policy of this queue
+// in redis, where it gets enforced by a Janitor. The cap applies across all
+// janitors. A zero policy removes it.
+func (queue *redisQueue) SetReturnRejectedPolicy(policy ReturnRejectedPolicy) error {
+	if policy == (ReturnRejectedPolicy{}) {
+		_, err := queue.redisClient.Del(queue.requeueKey)
+		return err
+	}
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+	if policy.Max <= 0 || policy.Interval <= 0 {
+		return ErrorInvalidPolicy
+	}
+
+	bytes, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return queue.redisClient.Set(queue.requeueKey, string(bytes), 0)
+}
+
+// ReturnRejectedPolicy returns the return rejected policy of this queue, see
+// SetReturnRejectedPolicy()
+func (queue *redisQueue) ReturnRejectedPolicy() (ReturnRejectedPolicy, error) {
+	var policy ReturnRejectedPolicy
+	value, err := queue.redisClient.Get(queue.requeueKey)
+	if err == ErrorNotFound {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+	err = json.Unmarshal([]byte(value), &policy)
+	return policy, err
+}
+
+// enforceReturnPolicy returns rejected deliveries as allowed by the return
+// rejected policy and returns how many it returned. Only the first janitor
+// per policy interval returns any.
+func (queue *redisQueue) enforceReturnPolicy(now time.Time) (int64, error) {
+	policy, err := queue.ReturnRejectedPolicy()
+	if err != nil || policy.Max <= 0 {
+		return 0, err
+	}
+
+	due, err := queue.redisClient.SetNX(queue.returnedKey, "1", policy.Interval)
+	if err != nil || !due {
+		return 0, err
+	}
+
+	if policy.MaxAge <= 0 {
+		return queue.ReturnRejected(policy.Max)
+	}
+	return queue.returnYounger(policy.Max, now.Add(-policy.MaxAge))
+}
+
+// returnYounger returns up to max rejected deliveries which got published
+// after cutoff to the ready list, oldest rejected first. The other ones stay
+// at the tail of the rejected list, so they get skipped by index.
+func (queue *redisQueue) returnYounger(max int64, cutoff time.Time) (returned int64, err error) {
+	skipped := int64(0) // at the tail of the rejected list
+	for returned < max {
+		payloads, err := queue.redisClient.LRange(queue.rejectedKey, -skipped-returnPolicyChunkSize, -skipped-1)
+		if err != nil {
+			return returned, err
+		}
+		if len(payloads) == 0 {
+			return returned, nil
+		}
+
+		for i := len(payloads) - 1; i >= 0 && returned < max; i-- {
+			header, _ := decodeHeader(payloads[i])
+			publishedAt, ok := header.publishedAt()
+			if !ok || publishedAt.Before(cutoff) {
+				skipped++
+				continue
+			}
+
+			pushed := payloads[i]
+			if updated, ok := addBreadcrumb(payloads[i], TrailReturned, queue.connectionName); ok {
+				pushed = updated
+			}
+			// remove by value, in case someone else returned this one meanwhile
+			n, err := queue.redisClient.LRemLPush(queue.rejectedKey, payloads[i], queue.readyKey, pushed)
+			if err != nil {
+				return returned, err
+			}
+			returned += n
+		}
+	}
+	return returned, nil
+}
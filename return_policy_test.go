@@ -0,0 +1,97 @@
+package rmq
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReturnRejectedPolicy(t *testing.T) {
+	connection, err := OpenConnection("return-policy-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("return-policy-q", WithTrail())
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	require.NoError(t, err)
+	redisQueue := queue.(*redisQueue)
+	_, err = redisQueue.redisClient.Del(redisQueue.returnedKey)
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrorInvalidPolicy, queue.SetReturnRejectedPolicy(ReturnRejectedPolicy{Max: 2}))
+	policy := ReturnRejectedPolicy{Max: 2, Interval: time.Hour, MaxAge: time.Hour}
+	assert.NoError(t, queue.SetReturnRejectedPolicy(policy))
+	stored, err := queue.ReturnRejectedPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, policy, stored)
+
+	reject := func(header Header, payloads ...string) {
+		assert.NoError(t, queue.PublishWithHeader(header, payloads...))
+		for range payloads {
+			delivery, err := queue.ConsumeOne(context.Background())
+			require.NoError(t, err)
+			assert.NoError(t, delivery.Reject())
+		}
+	}
+	publishedAt := func(age time.Duration) Header {
+		return Header{HeaderPublishedAt: strconv.FormatInt(time.Now().Add(-age).UnixNano(), 10)}
+	}
+	reject(publishedAt(2*time.Hour), "return-policy-old")
+	reject(nil, "return-policy-unknown")
+	reject(publishedAt(time.Minute), "return-policy-y1", "return-policy-y2", "return-policy-y3")
+
+	// returns the two oldest rejected young deliveries, skipping the others
+	janitor := NewJanitor(connection)
+	returned, err := janitor.ReturnRejected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), returned)
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "return-policy-y1", messages[0].Payload)
+	assert.Equal(t, "return-policy-y2", messages[1].Payload)
+	trail := messages[0].Header.Trail()
+	require.Len(t, trail, 3)
+	assert.Equal(t, TrailReturned, trail[2].Event)
+
+	// capped per interval
+	returned, err = janitor.ReturnRejected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), returned)
+
+	_, err = redisQueue.redisClient.Del(redisQueue.returnedKey) // next interval
+	require.NoError(t, err)
+	returned, err = janitor.ReturnRejected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), returned)
+	messages, err = queue.PeekRejected(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	// without max age all get returned
+	assert.NoError(t, queue.SetReturnRejectedPolicy(ReturnRejectedPolicy{Max: 10, Interval: time.Hour}))
+	_, err = redisQueue.redisClient.Del(redisQueue.returnedKey)
+	require.NoError(t, err)
+	returned, err = janitor.ReturnRejected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), returned)
+
+	assert.NoError(t, queue.SetReturnRejectedPolicy(ReturnRejectedPolicy{}))
+	stored, err = queue.ReturnRejectedPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, ReturnRejectedPolicy{}, stored)
+
+	// destroying the queue removes its policy
+	assert.NoError(t, queue.SetReturnRejectedPolicy(ReturnRejectedPolicy{Max: 10, Interval: time.Hour}))
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	stored, err = queue.ReturnRejectedPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, ReturnRejectedPolicy{}, stored)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,99 @@
+package rmq
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ScalerMetrics are the metrics of a queue which are relevant for scaling its
+// consumers, as served by ScalerHandler
+type ScalerMetrics struct {
+	Queue     string  `json:"queue"`
+	Ready     int64   `json:"ready"`
+	Unacked   int64   `json:"unacked"`
+	Rejected  int64   `json:"rejected"`
+	Backlog   int64   `json:"backlog"`   // ready and unacked deliveries
+	Consumers int64   `json:"consumers"` // consumers across all connections
+	Rate      float64 `json:"rate"`      // fetched deliveries per second since the previous request
+	Drain     float64 `json:"drain"`     // seconds to consume the backlog at rate, -1 if there is no rate
+}
+
+type scalerSample struct {
+	fetched int64
+	time    time.Time
+}
+
+// ScalerHandler is a http.Handler serving the ScalerMetrics of the queue given
+// by the `queue` query parameter as JSON. It can be used as a target of KEDA's
+// metrics-api scaler or as the source of an HPA external metrics adapter.
+type ScalerHandler struct {
+	connection Connection
+	mu         sync.Mutex              // protects samples
+	samples    map[string]scalerSample // previous fetched count per queue
+}
+
+func NewScalerHandler(connection Connection) *ScalerHandler {
+	return &ScalerHandler{
+		connection: connection,
+		samples:    map[string]scalerSample{},
+	}
+}
+
+// Metrics returns the current ScalerMetrics of the given queue. The rate is
+// derived from the number of deliveries fetched since the previous call for
+// the same queue, so it's zero on the first call. As consumers report fetched
+// deliveries once per heartbeat interval, calls should be further apart than
+// that.
+func (handler *ScalerHandler) Metrics(queueName string) (ScalerMetrics, error) {
+	stats, err := handler.connection.CollectStats([]string{queueName})
+	if err != nil {
+		return ScalerMetrics{}, err
+	}
+	stat := stats.QueueStats[queueName]
+	fetched := stat.FetchedCount
+	metrics := ScalerMetrics{
+		Queue:     queueName,
+		Ready:     stat.ReadyCount,
+		Unacked:   stat.UnackedCount(),
+		Rejected:  stat.RejectedCount,
+		Consumers: stat.ConsumerCount(),
+	}
+	metrics.Backlog = metrics.Ready + metrics.Unacked
+
+	now := time.Now()
+	handler.mu.Lock()
+	previous, found := handler.samples[queueName]
+	handler.samples[queueName] = scalerSample{fetched: fetched, time: now}
+	handler.mu.Unlock()
+
+	if elapsed := now.Sub(previous.time).Seconds(); found && elapsed > 0 && fetched >= previous.fetched {
+		metrics.Rate = float64(fetched-previous.fetched) / elapsed
+	}
+	metrics.Drain = -1
+	if drain, ok := drainTime(metrics.Backlog, metrics.Rate); ok {
+		metrics.Drain = drain.Seconds()
+	}
+
+	return metrics, nil
+}
+
+func (handler *ScalerHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	queueName := request.FormValue("queue")
+	if queueName == "" {
+		http.Error(writer, "missing queue parameter", http.StatusBadRequest)
+		return
+	}
+
+	metrics, err := handler.Metrics(queueName)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(metrics); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
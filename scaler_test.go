@@ -0,0 +1,77 @@
+package rmq
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScalerHandler(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.HeartbeatInterval = time.Millisecond
+	connection, err := OpenConnectionWithOptions("scaler-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	_, err = NewCleaner(connection).Clean() // remove connections left by previous runs
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("scaler-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = redisClient.Del("rmq::queue::[scaler-q]::fetched")
+	assert.NoError(t, err)
+
+	handler := NewScalerHandler(connection)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(query string) (int, ScalerMetrics) {
+		response, err := http.Get(server.URL + query)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		var metrics ScalerMetrics
+		if response.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(&metrics))
+		}
+		return response.StatusCode, metrics
+	}
+
+	status, _ := get("")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	assert.NoError(t, queue.Publish("s1", "s2", "s3"))
+	status, metrics := get("?queue=scaler-q")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, ScalerMetrics{Queue: "scaler-q", Ready: 3, Backlog: 3, Drain: -1}, metrics)
+
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	consumer := NewTestConsumer("scaler-cons")
+	consumer.AutoAck = false
+	_, err = queue.AddConsumer("scaler-cons", consumer)
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond) // wait for fetched deliveries to get reported
+
+	status, metrics = get("?queue=scaler-q")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(0), metrics.Ready)
+	assert.Equal(t, int64(3), metrics.Unacked)
+	assert.Equal(t, int64(3), metrics.Backlog)
+	assert.Equal(t, int64(1), metrics.Consumers)
+	assert.True(t, metrics.Rate > 0)
+	assert.InDelta(t, 3/metrics.Rate, metrics.Drain, 0.001)
+
+	// destroying the queue resets its fetched count
+	<-queue.StopConsuming()
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	fetched, err := queue.(*redisQueue).fetchedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), fetched)
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,232 @@
+package rmq
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/adjust/rmq/v4/internal/goroutines"
+)
+
+// Task is a periodic maintenance task run by a Scheduler
+type Task func(ctx context.Context) error
+
+type scheduledTask struct {
+	name       string
+	interval   time.Duration
+	leaderOnly bool
+	task       Task
+	next       time.Time // when the task is due next
+	errorCount int       // number of consecutive errors
+}
+
+// Scheduler runs periodic maintenance tasks, like custom cleaners or metric
+// snapshots, one after the other in a single goroutine. Tasks registered via
+// EveryOnLeader() only run on the connection currently holding the
+// scheduler's leader lock, so only one process runs them at a time. The
+// leader refreshes the lock once per heartbeat interval, also while a task
+// is running. If it dies the lock expires after the heartbeat duration and
+// another connection takes over.
+type Scheduler struct {
+	connection *redisConnection
+	mu         sync.Mutex // protects tasks and leader
+	tasks      []*scheduledTask
+	leader     bool // whether this connection holds the leader lock
+}
+
+// NewScheduler returns a scheduler running tasks on behalf of the given
+// connection
+// NOTE: panics if connection is not a redis connection
+func NewScheduler(connection Connection) *Scheduler {
+	return &Scheduler{connection: connection.(*redisConnection)}
+}
+
+// Every registers a task which runs on this connection once per interval.
+// Tasks can also be registered while the scheduler is running.
+func (scheduler *Scheduler) Every(name string, interval time.Duration, task Task) {
+	scheduler.add(name, interval, false, task)
+}
+
+// EveryOnLeader registers a task which runs once per interval, but only on
+// the connection holding the leader lock
+func (scheduler *Scheduler) EveryOnLeader(name string, interval time.Duration, task Task) {
+	scheduler.add(name, interval, true, task)
+}
+
+func (scheduler *Scheduler) add(name string, interval time.Duration, leaderOnly bool, task Task) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	scheduler.tasks = append(scheduler.tasks, &scheduledTask{
+		name:       name,
+		interval:   interval,
+		leaderOnly: leaderOnly,
+		task:       task,
+	})
+}
+
+// IsLeader returns whether this connection held the leader lock when the
+// scheduler last checked
+func (scheduler *Scheduler) IsLeader() bool {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	return scheduler.leader
+}
+
+// Run runs the registered tasks until the context is done, each task first
+// right away and then once per interval. Task errors get sent to the errors
+// chan as TaskError and don't stop the scheduler. Releases the leader lock
+// before returning the context's error.
+func (scheduler *Scheduler) Run(ctx context.Context) error {
+	defer scheduler.releaseLeader()
+	scheduler.refreshLeader()
+	stopRefresh := scheduler.startRefresh()
+	defer stopRefresh()
+
+	for {
+		now := time.Now()
+		next := now.Add(scheduler.connection.options.HeartbeatInterval) // pick up tasks registered meanwhile
+		for _, task := range scheduler.dueTasks(now, &next) {
+			scheduler.run(ctx, task)
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// dueTasks returns the tasks due at now which should run on this connection
+// and schedules their next run. It sets next to the earliest time a task is
+// due after that, if it's before next.
+func (scheduler *Scheduler) dueTasks(now time.Time, next *time.Time) []*scheduledTask {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	var due []*scheduledTask
+	for _, task := range scheduler.tasks {
+		if !task.next.After(now) {
+			task.next = now.Add(task.interval)
+			if !task.leaderOnly || scheduler.leader {
+				due = append(due, task)
+			}
+		}
+		if task.next.Before(*next) {
+			*next = task.next
+		}
+	}
+	return due
+}
+
+func (scheduler *Scheduler) run(ctx context.Context, task *scheduledTask) {
+	if err := task.task(ctx); err != nil && ctx.Err() == nil {
+		task.errorCount++
+		scheduler.sendError(&TaskError{Task: task.name, Err: err, Count: task.errorCount})
+		return
+	}
+	task.errorCount = 0
+}
+
+func (scheduler *Scheduler) sendError(err error) {
+	select { // try to add error to channel, but don't block
+	case scheduler.connection.errChan <- err:
+	default:
+	}
+}
+
+// startRefresh calls refreshLeader() once per heartbeat interval in its own
+// goroutine, so the leader lock doesn't expire while a long task is running
+// and another connection doesn't start running the same task meanwhile.
+// Returns a func stopping it.
+func (scheduler *Scheduler) startRefresh() func() {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	goroutines.Go("scheduler", func() {
+		defer close(stopped)
+		ticker := time.NewTicker(scheduler.connection.options.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				scheduler.refreshLeader()
+			}
+		}
+	})
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// refreshLeader tries to acquire the leader lock or refreshes it if this
+// connection already holds it, if there are leader only tasks. Errors get
+// sent to the errors chan as TaskError.
+func (scheduler *Scheduler) refreshLeader() {
+	if !scheduler.hasLeaderTasks() {
+		return
+	}
+	if err := scheduler.lockLeader(); err != nil {
+		scheduler.sendError(&TaskError{Task: "leader lock", Err: err, Count: 1})
+	}
+}
+
+// lockLeader refreshes or acquires the leader lock and records whether this
+// connection holds it
+func (scheduler *Scheduler) lockLeader() error {
+	connection := scheduler.connection
+	leader := false
+	defer func() {
+		scheduler.mu.Lock()
+		switch {
+		case leader && !scheduler.leader:
+			connection.options.logf(LogInfo, "rmq scheduler acquired leader lock %s", connection)
+		case !leader && scheduler.leader:
+			connection.options.logf(LogInfo, "rmq scheduler lost leader lock %s", connection)
+		}
+		scheduler.leader = leader
+		scheduler.mu.Unlock()
+	}()
+
+	// only refresh the lock while this connection still holds it
+	refreshed, err := connection.redisClient.ExpireIfEqual(schedulerLeaderKey, connection.Name, connection.options.HeartbeatDuration)
+	if err != nil || refreshed {
+		leader = refreshed
+		return err
+	}
+
+	acquired, err := connection.redisClient.SetNX(schedulerLeaderKey, connection.Name, connection.options.HeartbeatDuration)
+	leader = acquired
+	return err
+}
+
+func (scheduler *Scheduler) hasLeaderTasks() bool {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	for _, task := range scheduler.tasks {
+		if task.leaderOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseLeader releases the leader lock if this connection holds it, so
+// another connection can take over without waiting for it to expire
+func (scheduler *Scheduler) releaseLeader() {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	scheduler.leader = false
+
+	connection := scheduler.connection
+	if connection.options.RestrictedCommands {
+		return // the shared lock expires instead
+	}
+	if holder, err := connection.redisClient.Get(schedulerLeaderKey); err != nil || holder != connection.Name {
+		return
+	}
+	connection.redisClient.Del(schedulerLeaderKey)
+}
@@ -0,0 +1,144 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	_, err := redisClient.Del(schedulerLeaderKey)
+	assert.NoError(t, err)
+	options := TestOptions
+	options.HeartbeatInterval = 5 * time.Millisecond
+
+	var mu sync.Mutex
+	runs := map[string]int{}
+	count := func(name string) Task {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[name]++
+			return nil
+		}
+	}
+
+	// every connection runs Every() tasks, only the leader runs leader tasks
+	schedulers := []*Scheduler{}
+	cancels := []context.CancelFunc{}
+	done := []chan error{}
+	for _, name := range []string{"scheduler-a", "scheduler-b"} {
+		connection, err := OpenConnectionWithOptions(name, redisClient, nil, options)
+		assert.NoError(t, err)
+		scheduler := NewScheduler(connection)
+		scheduler.Every("snapshot", 10*time.Millisecond, count(name+"-snapshot"))
+		scheduler.EveryOnLeader("clean", 10*time.Millisecond, count("clean"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errChan := make(chan error, 1)
+		go func() { errChan <- scheduler.Run(ctx) }()
+		time.Sleep(2 * time.Millisecond) // make scheduler-a the leader
+		schedulers = append(schedulers, scheduler)
+		cancels = append(cancels, cancel)
+		done = append(done, errChan)
+	}
+
+	time.Sleep(55 * time.Millisecond)
+	mu.Lock()
+	assert.InDelta(t, 6, runs["scheduler-a-snapshot"], 2)
+	assert.InDelta(t, 6, runs["scheduler-b-snapshot"], 2)
+	assert.InDelta(t, 6, runs["clean"], 2)
+	mu.Unlock()
+	assert.True(t, schedulers[0].IsLeader())
+	assert.False(t, schedulers[1].IsLeader())
+
+	// once the leader stops, another connection takes over
+	cancels[0]()
+	assert.Equal(t, context.Canceled, <-done[0])
+	assert.False(t, schedulers[0].IsLeader())
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, schedulers[1].IsLeader())
+	mu.Lock()
+	before := runs["clean"]
+	mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	mu.Lock()
+	assert.True(t, runs["clean"] > before)
+	mu.Unlock()
+
+	cancels[1]()
+	assert.Equal(t, context.Canceled, <-done[1])
+	for _, scheduler := range schedulers {
+		assert.NoError(t, scheduler.connection.stopHeartbeat())
+	}
+}
+
+func TestSchedulerTaskErrors(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnectionWithOptions("scheduler-errors", NewTestRedisClient(), errChan, TestOptions)
+	assert.NoError(t, err)
+	scheduler := NewScheduler(connection)
+	scheduler.Every("failing", time.Millisecond, func(context.Context) error { return errors.New("failed") })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, scheduler.Run(ctx))
+
+	// errors get reported and don't stop the scheduler
+	var taskErr *TaskError
+	if assert.True(t, errors.As(<-errChan, &taskErr)) {
+		assert.Equal(t, "failing", taskErr.Task)
+		assert.Equal(t, 1, taskErr.Count)
+	}
+	if assert.True(t, errors.As(<-errChan, &taskErr)) {
+		assert.Equal(t, 2, taskErr.Count)
+	}
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+// refreshCountingClient counts the refreshes of the client's locks
+type refreshCountingClient struct {
+	RedisClient
+	refreshes *int64
+}
+
+func (client refreshCountingClient) ExpireIfEqual(key, value string, expiration time.Duration) (bool, error) {
+	atomic.AddInt64(client.refreshes, 1)
+	return client.RedisClient.ExpireIfEqual(key, value, expiration)
+}
+
+func TestSchedulerLongTask(t *testing.T) {
+	var refreshes int64
+	redisClient := refreshCountingClient{RedisClient: NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})), refreshes: &refreshes}
+	_, err := redisClient.Del(schedulerLeaderKey)
+	assert.NoError(t, err)
+	options := TestOptions
+	options.HeartbeatInterval = 5 * time.Millisecond
+	connection, err := OpenConnectionWithOptions("scheduler-long", redisClient, nil, options)
+	assert.NoError(t, err)
+
+	// leader lock gets refreshed while its task is running
+	var during int64
+	scheduler := NewScheduler(connection)
+	scheduler.EveryOnLeader("slow", time.Minute, func(context.Context) error {
+		before := atomic.LoadInt64(&refreshes)
+		time.Sleep(60 * time.Millisecond)
+		atomic.StoreInt64(&during, atomic.LoadInt64(&refreshes)-before)
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, scheduler.Run(ctx))
+	assert.True(t, atomic.LoadInt64(&during) >= 5, "%d refreshes", atomic.LoadInt64(&during))
+
+	holder, err := redisClient.Get(schedulerLeaderKey)
+	assert.Equal(t, ErrorNotFound, err, holder) // released when Run() returned
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,36 @@
+package rmq
+
+// SchemaRegistry resolves schemas by their ID and validates payloads against
+// them, typically backed by an external schema registry, see WithSchema()
+type SchemaRegistry interface {
+	// Validate returns an error if the payload doesn't match the schema with
+	// the given ID, or if there is no such schema
+	Validate(schemaID, payload string) error
+}
+
+// WithSchema makes Publish() validate payloads against the schema with the
+// given ID and add the ID to their header (see HeaderSchemaID), so invalid
+// payloads don't get published. Consumers validate deliveries against the
+// schema in their header, which catches producers and consumers drifting
+// apart early. Deliveries published without schema ID pass unvalidated. It's
+// a codec (see WithCodecs()), pass it before codecs changing the payload so
+// it sees plain payloads.
+func WithSchema(registry SchemaRegistry, schemaID string) QueueOption {
+	return WithCodecs(Codec{
+		Encode: func(header Header, payload string) (Header, string, error) {
+			if err := registry.Validate(schemaID, payload); err != nil {
+				return header, payload, err
+			}
+			header[HeaderSchemaID] = schemaID
+			return header, payload, nil
+		},
+		Decode: func(header Header, payload string) (Header, string, error) {
+			if id, ok := header[HeaderSchemaID]; ok {
+				if err := registry.Validate(id, payload); err != nil {
+					return header, payload, err
+				}
+			}
+			return header, payload, nil
+		},
+	})
+}
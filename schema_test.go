@@ -0,0 +1,75 @@
+package rmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSchemaRegistry knows schemas listing the required fields of JSON objects
+type testSchemaRegistry map[string][]string
+
+func (registry testSchemaRegistry) Validate(schemaID, payload string) error {
+	fields, ok := registry[schemaID]
+	if !ok {
+		return errors.New("unknown schema")
+	}
+	object := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(payload), &object); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if _, ok := object[field]; !ok {
+			return errors.New("missing " + field)
+		}
+	}
+	return nil
+}
+
+func TestSchema(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("schema-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+	registry := testSchemaRegistry{"v1": {"id"}, "v2": {"id", "name"}}
+	queue, err := connection.OpenQueue("schema-q", WithSchema(registry, "v1"))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	// invalid payloads don't get published
+	assert.EqualError(t, queue.Publish(`{"name":"x"}`), "missing id")
+	assert.NoError(t, queue.Publish(`{"id":1}`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	delivery, err := queue.ConsumeOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1}`, delivery.Payload())
+	assert.Equal(t, "v1", delivery.Header()[HeaderSchemaID])
+	assert.NoError(t, delivery.Ack())
+
+	// deliveries violating the schema in their header get rejected
+	plainQueue, err := connection.OpenQueue("schema-q")
+	assert.NoError(t, err)
+	assert.NoError(t, plainQueue.PublishWithHeader(Header{HeaderSchemaID: "v2"}, `{"id":2}`))
+	assert.NoError(t, plainQueue.Publish(`unvalidated`))
+	delivery, err = queue.ConsumeOne(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, `unvalidated`, delivery.Payload())
+	assert.NoError(t, delivery.Ack())
+	decodeErr := &DecodeError{}
+	require.True(t, errors.As(<-errChan, &decodeErr))
+	assert.EqualError(t, decodeErr, "rmq.DecodeError: missing name")
+	count, err := queue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
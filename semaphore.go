@@ -0,0 +1,152 @@
+package rmq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// semaphorePollInterval is how often Acquire() and consumers waiting for a
+// slot check whether one got released
+const semaphorePollInterval = 50 * time.Millisecond
+
+// Semaphore is a distributed counting semaphore stored in redis. It caps the
+// number of concurrent holders across all connections, for example to limit
+// calls to a fragile downstream service. Each holder occupies a slot until it
+// releases it or its ttl passes, so slots of dead holders free up eventually.
+// Use WithSemaphore() to acquire a slot for each delivery consumed from a
+// queue.
+type Semaphore struct {
+	name           string
+	key            string // sorted set of holders scored by when they expire
+	limit          int64
+	ttl            time.Duration
+	connectionName string
+	redisClient    RedisClient
+	errChan        chan<- error
+}
+
+// NewSemaphore returns the semaphore with the given name, which allows at
+// most limit holders at a time. Slots which don't get released expire after
+// ttl, which should be longer than any holder needs them. All semaphores with
+// the same name must use the same limit.
+// NOTE: panics if connection is not a redis connection
+func NewSemaphore(connection Connection, name string, limit int64, ttl time.Duration) *Semaphore {
+	redisConnection := connection.(*redisConnection)
+	return &Semaphore{
+		name:           name,
+		key:            strings.Replace(semaphoreTemplate, phSemaphore, name, 1),
+		limit:          limit,
+		ttl:            ttl,
+		connectionName: redisConnection.Name,
+		redisClient:    redisConnection.redisClient,
+		errChan:        redisConnection.errChan,
+	}
+}
+
+func (semaphore *Semaphore) String() string {
+	return fmt.Sprintf("[%s limit %d]", semaphore.name, semaphore.limit)
+}
+
+// TryAcquire tries to acquire a slot without waiting. Returns the holder to
+// pass to Release() and whether a slot was acquired.
+func (semaphore *Semaphore) TryAcquire() (holder string, acquired bool, err error) {
+	now := time.Now()
+	holder = fmt.Sprintf("%s-%s", semaphore.connectionName, RandomString(6))
+	score := float64(now.Add(semaphore.ttl).UnixNano() / int64(time.Millisecond))
+	expiredScore := float64(now.UnixNano() / int64(time.Millisecond))
+
+	acquired, err = semaphore.redisClient.ZAddLimit(semaphore.key, holder, score, expiredScore, semaphore.limit)
+	if err != nil || !acquired {
+		return "", false, err
+	}
+	return holder, true, nil
+}
+
+// Acquire waits until a slot got acquired or ctx is done. Returns the holder
+// to pass to Release().
+func (semaphore *Semaphore) Acquire(ctx context.Context) (holder string, err error) {
+	for {
+		holder, acquired, err := semaphore.TryAcquire()
+		if err != nil || acquired {
+			return holder, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(semaphorePollInterval):
+		}
+	}
+}
+
+// Release releases the slot of the given holder. Returns ErrorNotFound if the
+// holder didn't hold a slot (anymore), for example because its ttl passed.
+func (semaphore *Semaphore) Release(holder string) error {
+	affected, err := semaphore.redisClient.ZRem(semaphore.key, holder)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// WithSemaphore makes consumers acquire a slot of the given semaphore before
+// each delivery gets passed to Consume(). The slot gets released once the
+// delivery gets acked, rejected or pushed. Deliveries which don't get handled
+// keep their slot until the semaphore's ttl passes. If consuming stops while
+// a consumer is waiting for a slot, its delivery is treated like a prefetched
+// one, see WithStopPolicy(). Doesn't apply to batch consumers.
+func WithSemaphore(semaphore *Semaphore) QueueOption {
+	return func(queue *redisQueue) {
+		queue.semaphore = semaphore
+	}
+}
+
+// acquireSemaphore waits for a slot of the queue's semaphore and makes the
+// delivery release it once it got handled. Returns false if consuming got
+// stopped before a slot got acquired.
+func (queue *redisQueue) acquireSemaphore(delivery *redisDelivery) bool {
+	errorCount := 0
+	for {
+		holder, acquired, err := queue.semaphore.TryAcquire()
+		switch {
+		case err != nil:
+			errorCount++
+			select { // try to add error to channel, but don't block
+			case queue.errChan <- &ConsumeError{RedisErr: err, Count: errorCount}:
+			default:
+			}
+		case acquired:
+			delivery.release = func() {
+				if err := queue.semaphore.Release(holder); err != nil && err != ErrorNotFound {
+					select { // try to add error to channel, but don't block
+					case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+					default:
+					}
+				}
+			}
+			return true
+		default:
+			errorCount = 0
+		}
+
+		select {
+		case <-queue.consumerStop:
+			if queue.stopPolicy == ReturnOnStop {
+				if err := queue.returnDelivery(delivery); err != nil {
+					select { // try to add error to channel, but don't block
+					case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+					default:
+					}
+				}
+			}
+			// with LeaveOnStop the delivery remains unacked, the cleaner will return it
+			return false
+		case <-time.After(semaphorePollInterval):
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package rmq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemaphore(t *testing.T) {
+	connection, err := OpenConnection("semaphore-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	semaphore := NewSemaphore(connection, "semaphore-sem", 2, time.Minute)
+	_, err = semaphore.redisClient.Del(semaphore.key)
+	assert.NoError(t, err)
+
+	holder1, acquired, err := semaphore.TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	_, acquired, err = semaphore.TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	_, acquired, err = semaphore.TryAcquire()
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = semaphore.Acquire(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.NoError(t, semaphore.Release(holder1))
+	assert.Equal(t, ErrorNotFound, semaphore.Release(holder1))
+	_, err = semaphore.Acquire(context.Background())
+	assert.NoError(t, err)
+
+	// slots of holders which don't release them expire
+	expiring := NewSemaphore(connection, "semaphore-expiring", 1, 10*time.Millisecond)
+	_, err = expiring.redisClient.Del(expiring.key)
+	assert.NoError(t, err)
+	_, acquired, err = expiring.TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	_, acquired, err = expiring.TryAcquire()
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	time.Sleep(20 * time.Millisecond)
+	_, acquired, err = expiring.TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestWithSemaphore(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning, consumed := 0, 0, 0
+	consume := func(delivery Delivery) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		consumed++
+		mu.Unlock()
+		assert.NoError(t, delivery.Ack()) // releases the slot
+	}
+
+	// two connections with three consumers each share two slots
+	connections := []Connection{}
+	queues := []Queue{}
+	for i := 0; i < 2; i++ {
+		connection, err := OpenConnection("semaphore-conn", "tcp", "localhost:6379", 1, nil)
+		assert.NoError(t, err)
+		semaphore := NewSemaphore(connection, "semaphore-consume", 2, time.Minute)
+		if i == 0 {
+			_, err = semaphore.redisClient.Del(semaphore.key)
+			assert.NoError(t, err)
+		}
+		queue, err := connection.OpenQueue("semaphore-q", WithSemaphore(semaphore))
+		assert.NoError(t, err)
+		if i == 0 {
+			_, err = queue.PurgeReady()
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+		for j := 0; j < 3; j++ {
+			_, err = queue.AddConsumerFunc("semaphore-cons", consume)
+			assert.NoError(t, err)
+		}
+		connections = append(connections, connection)
+		queues = append(queues, queue)
+	}
+
+	assert.NoError(t, queues[0].Publish("s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8"))
+	time.Sleep(500 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 8, consumed)
+	assert.Equal(t, 2, maxRunning)
+	mu.Unlock()
+
+	for i, queue := range queues {
+		<-queue.StopConsuming()
+		assert.NoError(t, connections[i].stopHeartbeat())
+	}
+}
@@ -0,0 +1,78 @@
+package rmq
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/adjust/rmq/v4/internal/goroutines"
+)
+
+// exit codes returned by RunUntilSignal()
+const (
+	ExitGraceful = 0 // all consumers finished their current deliveries in time
+	ExitForced   = 1 // consumers didn't finish within the shutdown timeout or a second signal arrived
+)
+
+// RunUntilSignal blocks until the process receives SIGINT or SIGTERM and then
+// stops consuming the given queues, or all queues of the connection if none
+// are given. It waits for the consumers to finish their current deliveries
+// for up to the connection's ShutdownTimeout (see Options) and returns the
+// exit code to pass to os.Exit(). A second signal stops waiting immediately.
+// Prefetched deliveries are handled according to the queues' stop policies
+// (see WithStopPolicy()).
+func RunUntilSignal(connection Connection, queues ...Queue) int {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	options := ProductionOptions
+	if redisConnection, ok := connection.(*redisConnection); ok {
+		options = redisConnection.options
+	}
+	return runUntilSignal(connection, queues, signals, options)
+}
+
+func runUntilSignal(connection Connection, queues []Queue, signals <-chan os.Signal, options Options) int {
+	sig := <-signals
+	options.logf(LogInfo, "rmq received %s, stopping consuming", sig)
+
+	var finished <-chan struct{}
+	if len(queues) > 0 {
+		finished = stopQueues(queues)
+	} else {
+		finished = connection.StopAllConsuming()
+	}
+
+	timer := time.NewTimer(options.ShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-finished:
+		options.logf(LogInfo, "rmq stopped consuming gracefully")
+		return ExitGraceful
+	case <-timer.C:
+		options.logf(LogInfo, "rmq consumers didn't finish within %s", options.ShutdownTimeout)
+	case sig := <-signals:
+		options.logf(LogInfo, "rmq received %s again, not waiting for consumers", sig)
+	}
+	return ExitForced
+}
+
+// stopQueues stops consuming the given queues and returns a channel which
+// gets closed once all of them finished
+func stopQueues(queues []Queue) <-chan struct{} {
+	chans := make([]<-chan struct{}, 0, len(queues))
+	for _, queue := range queues {
+		chans = append(chans, queue.StopConsuming())
+	}
+
+	finished := make(chan struct{})
+	goroutines.Go("stop", func() {
+		for _, c := range chans {
+			<-c
+		}
+		close(finished)
+	})
+	return finished
+}
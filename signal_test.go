@@ -0,0 +1,61 @@
+package rmq
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunUntilSignal(t *testing.T) {
+	connection, err := OpenConnection("signal-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("signal-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	options := TestOptions
+	options.ShutdownTimeout = 50 * time.Millisecond
+
+	// consumers finishing in time
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	consumer := NewTestConsumer("signal-cons")
+	consumer.SleepDuration = 10 * time.Millisecond
+	_, err = queue.AddConsumer("signal-cons", consumer)
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("signal-d1"))
+	time.Sleep(5 * time.Millisecond)
+
+	signals := make(chan os.Signal, 2)
+	signals <- syscall.SIGTERM
+	assert.Equal(t, ExitGraceful, runUntilSignal(connection, nil, signals, options))
+	assert.Len(t, consumer.LastDeliveries, 1)
+
+	// consumers not finishing in time
+	queue, err = connection.OpenQueue("signal-q")
+	assert.NoError(t, err)
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	consumer = NewTestConsumer("signal-cons")
+	consumer.AutoFinish = false
+	_, err = queue.AddConsumer("signal-cons", consumer)
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("signal-d2"))
+	time.Sleep(5 * time.Millisecond)
+
+	signals <- syscall.SIGINT
+	start := time.Now()
+	assert.Equal(t, ExitForced, runUntilSignal(connection, []Queue{queue}, signals, options))
+	assert.True(t, time.Since(start) >= options.ShutdownTimeout)
+
+	// a second signal doesn't wait for the timeout
+	signals <- syscall.SIGINT
+	signals <- syscall.SIGINT
+	options.ShutdownTimeout = time.Hour
+	assert.Equal(t, ExitForced, runUntilSignal(connection, []Queue{queue}, signals, options))
+
+	consumer.Finish()
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
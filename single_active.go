@@ -0,0 +1,121 @@
+package rmq
+
+import (
+	"time"
+
+	"github.com/adjust/rmq/v4/internal/goroutines"
+)
+
+// WithSingleActiveConsumer makes only one connection at a time consume this
+// queue, so deliveries get consumed in strict FIFO order. The connection
+// holding the lock refreshes it once per heartbeat interval, also while its
+// consumers are busy with prefetched deliveries. If it stops
+// consuming it releases the lock, if it dies the lock expires after the
+// heartbeat duration and another connection takes over. Only a single
+// consumer can be added per connection.
+// NOTE: Deliveries left unacked by a dead connection get returned to the end
+// of the ready list by the cleaner, so strict order only holds if consumers
+// don't die while prefetching. Use a prefetch limit of 1 to minimize this.
+func WithSingleActiveConsumer() QueueOption {
+	return func(queue *redisQueue) {
+		queue.singleActive = true
+	}
+}
+
+// refreshActive tries to acquire the single active lock or refreshes it if
+// this connection already holds it. Returns whether this connection holds it.
+func (queue *redisQueue) refreshActive() (bool, error) {
+	queue.activeMu.Lock()
+	defer queue.activeMu.Unlock()
+
+	now := time.Now()
+	if queue.active {
+		if now.Sub(queue.activeRefreshed) < queue.options.HeartbeatInterval {
+			return true, nil
+		}
+		if err := queue.refreshHeld(now); err != nil || queue.active {
+			return true, err
+		}
+		// lock expired, try to acquire it again below
+	}
+
+	acquired, err := queue.redisClient.SetNX(queue.activeKey, queue.connectionName, queue.options.HeartbeatDuration)
+	if err != nil || !acquired {
+		return false, err
+	}
+
+	queue.active = true
+	queue.activeRefreshed = now
+	queue.options.logf(LogDebug, "rmq queue acquired single active lock %s", queue)
+	return true, nil
+}
+
+// refreshHeld refreshes the single active lock held by this connection,
+// unless it expired and another connection might have acquired it meanwhile.
+// Must be called with activeMu locked.
+func (queue *redisQueue) refreshHeld(now time.Time) error {
+	refreshed, err := queue.redisClient.ExpireIfEqual(queue.activeKey, queue.connectionName, queue.options.HeartbeatDuration)
+	if err != nil {
+		return err
+	}
+	if refreshed {
+		queue.activeRefreshed = now
+		return nil
+	}
+
+	queue.active = false
+	queue.options.logf(LogInfo, "rmq queue lost single active lock %s", queue)
+	return nil
+}
+
+// startActiveRefresh refreshes the single active lock once per heartbeat
+// interval while this connection holds it, so it doesn't expire while the
+// consume goroutine is blocked waiting for consumers to take prefetched
+// deliveries. Returns a func stopping it, see StopConsuming().
+func (queue *redisQueue) startActiveRefresh() func() {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	goroutines.Go("active", func() {
+		defer close(stopped)
+		ticker := time.NewTicker(queue.options.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				queue.activeMu.Lock()
+				var err error
+				if queue.active {
+					err = queue.refreshHeld(now)
+				}
+				queue.activeMu.Unlock()
+				if err != nil {
+					select { // try to add error to channel, but don't block
+					case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+					default:
+					}
+				}
+			}
+		}
+	})
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// releaseActive releases the single active lock if this connection holds it,
+// so another connection can take over without waiting for it to expire
+func (queue *redisQueue) releaseActive() {
+	queue.activeMu.Lock()
+	defer queue.activeMu.Unlock()
+
+	if !queue.active {
+		return
+	}
+	queue.active = false
+	if holder, err := queue.redisClient.Get(queue.activeKey); err != nil || holder != queue.connectionName {
+		return
+	}
+	queue.redisClient.Del(queue.activeKey)
+}
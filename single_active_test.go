@@ -0,0 +1,107 @@
+package rmq
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSingleActiveConsumer(t *testing.T) {
+	var mu sync.Mutex
+	consumed := map[string][]string{}
+
+	var queues []Queue
+	var connections []Connection
+	for _, name := range []string{"first", "second"} {
+		name := name
+		connection, err := OpenConnection("single-"+name, "tcp", "localhost:6379", 1, nil)
+		assert.NoError(t, err)
+		queue, err := connection.OpenQueue("single-q", WithSingleActiveConsumer())
+		assert.NoError(t, err)
+		if name == "first" {
+			_, err = queue.PurgeReady()
+			assert.NoError(t, err)
+			// lock might be left over by a previous test run
+			_, err = queue.(*redisQueue).redisClient.Del(queue.(*redisQueue).activeKey)
+			assert.NoError(t, err)
+		}
+
+		assert.NoError(t, queue.StartConsuming(1, time.Millisecond))
+		_, err = queue.AddConsumerFunc("single-cons", func(delivery Delivery) {
+			mu.Lock()
+			consumed[name] = append(consumed[name], delivery.Payload())
+			mu.Unlock()
+			assert.NoError(t, delivery.Ack())
+		})
+		assert.NoError(t, err)
+		_, err = queue.AddConsumerFunc("single-cons", func(Delivery) {})
+		assert.Equal(t, ErrorSingleConsumer, err)
+
+		queues = append(queues, queue)
+		connections = append(connections, connection)
+		time.Sleep(5 * time.Millisecond) // let the first one acquire the lock
+	}
+
+	assert.NoError(t, queues[0].Publish("d1", "d2", "d3"))
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, map[string][]string{"first": {"d1", "d2", "d3"}}, consumed)
+	mu.Unlock()
+
+	// second connection takes over once the first one stopped
+	<-queues[0].StopConsuming()
+	assert.NoError(t, queues[0].Publish("d4", "d5"))
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"d4", "d5"}, consumed["second"])
+	mu.Unlock()
+
+	<-queues[1].StopConsuming()
+	for _, connection := range connections {
+		assert.NoError(t, connection.stopHeartbeat())
+	}
+}
+
+func TestSingleActiveRefresh(t *testing.T) {
+	options := TestOptions
+	options.HeartbeatDuration = 50 * time.Millisecond
+	options.HeartbeatInterval = 10 * time.Millisecond
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	connection, err := OpenConnectionWithOptions("single-refresh-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("single-refresh-q", WithSingleActiveConsumer())
+	assert.NoError(t, err)
+	redisQueue := queue.(*redisQueue)
+	_, err = redisQueue.redisClient.Del(redisQueue.activeKey)
+	assert.NoError(t, err)
+
+	active, err := redisQueue.refreshActive()
+	assert.NoError(t, err)
+	assert.True(t, active)
+
+	// lock stays refreshed while nothing consumes
+	stop := redisQueue.startActiveRefresh()
+	time.Sleep(120 * time.Millisecond)
+	holder, err := redisQueue.redisClient.Get(redisQueue.activeKey)
+	assert.NoError(t, err)
+	assert.Equal(t, redisQueue.connectionName, holder)
+	stop()
+
+	// lock got acquired by another connection after it expired
+	assert.NoError(t, redisQueue.redisClient.Set(redisQueue.activeKey, "other-conn", time.Minute))
+	redisQueue.activeRefreshed = time.Time{}
+	active, err = redisQueue.refreshActive()
+	assert.NoError(t, err)
+	assert.False(t, active)
+	holder, err = redisQueue.redisClient.Get(redisQueue.activeKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "other-conn", holder)
+
+	redisQueue.releaseActive()
+	_, err = redisQueue.redisClient.Del(redisQueue.activeKey)
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
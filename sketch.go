@@ -0,0 +1,138 @@
+package rmq
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// relative accuracy of the quantiles returned by durationSketch
+const sketchAccuracy = 0.01
+
+var sketchGamma = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+
+// durationSketch is a streaming sketch of durations (similar to DDSketch).
+// Durations get counted in logarithmically sized buckets, so quantiles are
+// accurate within sketchAccuracy while the sketch only needs a few hundred
+// buckets. Sketches of several connections can be merged by adding up their
+// bucket counts.
+type durationSketch struct {
+	mu      sync.Mutex
+	buckets map[int]int64 // count by bucket index
+	count   int64
+}
+
+func newDurationSketch() *durationSketch {
+	return &durationSketch{buckets: map[int]int64{}}
+}
+
+func sketchIndex(duration time.Duration) int {
+	if duration <= time.Nanosecond {
+		return 0
+	}
+	return int(math.Ceil(math.Log(float64(duration)) / math.Log(sketchGamma)))
+}
+
+// sketchValue returns the duration represented by the bucket with the given index
+func sketchValue(index int) time.Duration {
+	return time.Duration(2 * math.Pow(sketchGamma, float64(index)) / (sketchGamma + 1))
+}
+
+func (sketch *durationSketch) add(duration time.Duration) {
+	sketch.mu.Lock()
+	defer sketch.mu.Unlock()
+	sketch.buckets[sketchIndex(duration)]++
+	sketch.count++
+}
+
+func (sketch *durationSketch) merge(other *durationSketch) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	sketch.mu.Lock()
+	defer sketch.mu.Unlock()
+	for index, count := range other.buckets {
+		sketch.buckets[index] += count
+	}
+	sketch.count += other.count
+}
+
+func (sketch *durationSketch) reset() {
+	sketch.mu.Lock()
+	defer sketch.mu.Unlock()
+	sketch.buckets = map[int]int64{}
+	sketch.count = 0
+}
+
+func (sketch *durationSketch) len() int64 {
+	sketch.mu.Lock()
+	defer sketch.mu.Unlock()
+	return sketch.count
+}
+
+// quantile returns the duration below which the given fraction (between 0
+// and 1) of the added durations fall. Returns zero if the sketch is empty.
+func (sketch *durationSketch) quantile(q float64) time.Duration {
+	sketch.mu.Lock()
+	defer sketch.mu.Unlock()
+	if sketch.count == 0 {
+		return 0
+	}
+
+	indexes := make([]int, 0, len(sketch.buckets))
+	for index := range sketch.buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	rank := int64(q * float64(sketch.count-1))
+	seen := int64(0)
+	for _, index := range indexes {
+		seen += sketch.buckets[index]
+		if seen > rank {
+			return sketchValue(index)
+		}
+	}
+	return sketchValue(indexes[len(indexes)-1])
+}
+
+// encode returns the sketch as stored in redis: space separated pairs of
+// bucket index and count
+func (sketch *durationSketch) encode() string {
+	sketch.mu.Lock()
+	defer sketch.mu.Unlock()
+
+	var buffer bytes.Buffer
+	for index, count := range sketch.buckets {
+		if buffer.Len() > 0 {
+			buffer.WriteByte(' ')
+		}
+		fmt.Fprintf(&buffer, "%d:%d", index, count)
+	}
+	return buffer.String()
+}
+
+func decodeDurationSketch(encoded string) (*durationSketch, error) {
+	sketch := newDurationSketch()
+	for _, pair := range strings.Fields(encoded) {
+		i := strings.IndexByte(pair, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("rmq: invalid duration sketch bucket %q", pair)
+		}
+		index, err := strconv.Atoi(pair[:i])
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.ParseInt(pair[i+1:], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sketch.buckets[index] += count
+		sketch.count += count
+	}
+	return sketch, nil
+}
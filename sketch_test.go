@@ -0,0 +1,53 @@
+package rmq
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurationSketch(t *testing.T) {
+	sketch := newDurationSketch()
+	assert.Equal(t, time.Duration(0), sketch.quantile(0.5))
+
+	for i := 1; i <= 1000; i++ {
+		sketch.add(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, int64(1000), sketch.len())
+	assertQuantile(t, 500*time.Millisecond, sketch.quantile(0.5))
+	assertQuantile(t, 950*time.Millisecond, sketch.quantile(0.95))
+	assertQuantile(t, 990*time.Millisecond, sketch.quantile(0.99))
+	assertQuantile(t, time.Millisecond, sketch.quantile(0))
+	assertQuantile(t, time.Second, sketch.quantile(1))
+
+	decoded, err := decodeDurationSketch(sketch.encode())
+	require.NoError(t, err)
+	assert.Equal(t, sketch.buckets, decoded.buckets)
+	assert.Equal(t, sketch.count, decoded.count)
+
+	// merging shuffled halves gives the same quantiles
+	first, second := newDurationSketch(), newDurationSketch()
+	for _, i := range rand.Perm(1000) {
+		if i%2 == 0 {
+			first.add(time.Duration(i+1) * time.Millisecond)
+		} else {
+			second.add(time.Duration(i+1) * time.Millisecond)
+		}
+	}
+	first.merge(second)
+	assert.Equal(t, sketch.buckets, first.buckets)
+
+	sketch.reset()
+	assert.Equal(t, int64(0), sketch.len())
+
+	_, err = decodeDurationSketch("12:x")
+	assert.Error(t, err)
+}
+
+func assertQuantile(t *testing.T, expected, actual time.Duration) {
+	t.Helper()
+	assert.InEpsilon(t, float64(expected), float64(actual), 0.02, "expected %s, got %s", expected, actual)
+}
@@ -0,0 +1,85 @@
+package rmq
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WithSlowConsumerDetection tracks how long each consumer of this queue takes
+// to consume a delivery. If a consumer takes longer than threshold for the
+// given number of consecutive deliveries, a SlowConsumerError gets sent to
+// errChan. With evict the consumer then stops taking deliveries, so a single
+// degraded consumer doesn't hold up the prefetched deliveries. Those are
+// shared by all consumers of the connection, so the remaining consumers take
+// them over. The last consumer of a connection never gets evicted.
+// NOTE: doesn't apply to batch consumers
+func WithSlowConsumerDetection(threshold time.Duration, strikes int, evict bool) QueueOption {
+	return func(queue *redisQueue) {
+		queue.slowThreshold = threshold
+		queue.slowStrikes = strikes
+		queue.slowEvict = evict
+	}
+}
+
+// checkSlow updates the number of consecutive slow deliveries of the given
+// consumer after it consumed a delivery in the given duration. Returns whether
+// the consumer got evicted and must stop consuming.
+func (queue *redisQueue) checkSlow(consumerName string, duration time.Duration, slowCount *int) bool {
+	if queue.slowThreshold <= 0 {
+		return false
+	}
+	if duration <= queue.slowThreshold {
+		*slowCount = 0
+		return false
+	}
+
+	*slowCount++
+	if *slowCount < queue.slowStrikes {
+		return false
+	}
+
+	evicted := queue.slowEvict && queue.evictConsumer(consumerName)
+	select { // try to add error to channel, but don't block
+	case queue.errChan <- &SlowConsumerError{Queue: queue.name, Consumer: consumerName, Duration: duration, Count: *slowCount, Evicted: evicted}:
+	default:
+	}
+	*slowCount = 0 // start counting again if not evicted
+	return evicted
+}
+
+// evictConsumer removes the given consumer unless it's the last one taking
+// deliveries on this connection. Returns whether it got removed.
+func (queue *redisQueue) evictConsumer(consumerName string) bool {
+	for {
+		running := atomic.LoadInt32(&queue.runningCount)
+		if running <= 1 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&queue.runningCount, running, running-1) {
+			break
+		}
+	}
+
+	atomic.AddInt32(&queue.consumerCount, -1)
+	atomic.AddInt64(&queue.concurrency, -1)
+	queue.options.logf(LogInfo, "rmq queue evicting slow consumer %s %s", queue, consumerName)
+	queue.removeConsumer(QueueEvent{Event: ConsumerRemoved, Queue: queue.name, Connection: queue.connectionName, Consumer: consumerName})
+	return true
+}
+
+// removeConsumer removes the consumer of the event from the consumers of
+// this connection and publishes the event
+func (queue *redisQueue) removeConsumer(event QueueEvent) {
+	if _, err := queue.redisClient.SRem(queue.consumersKey, event.Consumer); err != nil {
+		select { // try to add error to channel, but don't block
+		case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+		default:
+		}
+	}
+	if err := publishQueueEvent(queue.redisClient, event); err != nil {
+		select { // try to add error to channel, but don't block
+		case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package rmq
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowConsumerDetection(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("slow-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("slow-q", WithSlowConsumerDetection(5*time.Millisecond, 2, true))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	var slowCount, fastCount int32
+	assert.NoError(t, queue.StartConsuming(1, time.Millisecond))
+	slowName, err := queue.AddConsumerFunc("slow-cons", func(delivery Delivery) {
+		atomic.AddInt32(&slowCount, 1)
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+
+	// the last consumer doesn't get evicted
+	assert.NoError(t, queue.Publish("slow-d1", "slow-d2"))
+	var slowErr *SlowConsumerError
+	select {
+	case err := <-errChan:
+		require.IsType(t, slowErr, err)
+		slowErr = err.(*SlowConsumerError)
+	case <-time.After(time.Second):
+		t.Fatal("no slow consumer error")
+	}
+	assert.Equal(t, slowName, slowErr.Consumer)
+	assert.Equal(t, 2, slowErr.Count)
+	assert.False(t, slowErr.Evicted)
+
+	_, err = queue.AddConsumerFunc("fast-cons", func(delivery Delivery) {
+		atomic.AddInt32(&fastCount, 1)
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+
+	// the slow consumer gets evicted once another one takes over
+	assert.NoError(t, queue.Publish("slow-d3", "slow-d4", "slow-d5", "slow-d6"))
+	select {
+	case err := <-errChan:
+		require.IsType(t, slowErr, err)
+		slowErr = err.(*SlowConsumerError)
+	case <-time.After(time.Second):
+		t.Fatal("no slow consumer error")
+	}
+	assert.True(t, slowErr.Evicted)
+
+	consumed := atomic.LoadInt32(&slowCount)
+	assert.NoError(t, queue.Publish("slow-d7", "slow-d8", "slow-d9"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, consumed, atomic.LoadInt32(&slowCount))
+	assert.Equal(t, int32(9), consumed+atomic.LoadInt32(&fastCount))
+
+	consumers, err := queue.getConsumers()
+	assert.NoError(t, err)
+	assert.Len(t, consumers, 1)
+	redisQueue := queue.(*redisQueue)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&redisQueue.consumerCount))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&redisQueue.concurrency)) // reported in the stats
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
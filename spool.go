@@ -0,0 +1,228 @@
+package rmq
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adjust/rmq/v4/internal/goroutines"
+)
+
+// spoolFlushingSuffix gets appended to the path of a spool file while its
+// deliveries get published, see drainSpool()
+const spoolFlushingSuffix = ".flushing"
+
+// spool is an append-only file of deliveries which couldn't be published,
+// one JSON encoded payload per line, see WithSpool()
+type spool struct {
+	path     string
+	mu       sync.Mutex // protects the files and the fields below
+	pending  bool       // whether the files hold deliveries
+	flushing bool       // whether a flusher is running
+	flushed  int        // deliveries of the flushing file published so far
+	stopped  bool       // whether the connection stopped, see stopSpool()
+	stop     chan struct{}
+	flusher  sync.WaitGroup
+}
+
+// WithSpool makes Publish() append deliveries to the file at path whenever
+// publishing them to redis fails (and to the fallback, see WithFallback()),
+// so producers don't lose deliveries during an outage, even if they restart
+// in the meantime. A background flusher publishes the spooled deliveries once
+// redis is reachable again, retrying every RetryInterval of the connection's
+// options. While deliveries are spooled new ones get spooled too, to preserve
+// their order. Spooled deliveries wait while the queue is frozen, unless the
+// frozen policy is PublishWhileFrozen. Use one file per queue and process.
+// Publish() only returns an error if spooling fails too.
+// The flusher stops once the connection stops consuming (see
+// Connection.StopAllConsuming()) or gets closed, deliveries left in the
+// spool then get flushed by the next process using it. If that process
+// crashes while flushing, some deliveries might get published twice.
+func WithSpool(path string) QueueOption {
+	return func(queue *redisQueue) {
+		queue.spool = &spool{path: path, stop: make(chan struct{})}
+		for _, p := range []string{path + spoolFlushingSuffix, path} {
+			if info, err := os.Stat(p); err == nil && info.Size() > 0 { // left by a previous process
+				queue.spool.pending = true
+			}
+		}
+		if queue.spool.pending {
+			queue.startFlusher()
+		}
+	}
+}
+
+// startFlusher starts flushing the spool in the background unless the
+// connection stopped. The caller must hold the spool's lock, unless it's
+// not in use yet.
+func (queue *redisQueue) startFlusher() {
+	if queue.spool.stopped {
+		return
+	}
+	queue.spool.flushing = true
+	queue.spool.flusher.Add(1)
+	goroutines.Go("spool flush", queue.flushSpool)
+}
+
+// stopSpool stops the flusher and waits for it to return, see WithSpool()
+func (queue *redisQueue) stopSpool() {
+	queue.spool.mu.Lock()
+	if !queue.spool.stopped {
+		queue.spool.stopped = true
+		close(queue.spool.stop)
+	}
+	queue.spool.mu.Unlock()
+	queue.spool.flusher.Wait()
+}
+
+// spoolPending spools the encoded payloads if earlier deliveries are still
+// spooled. Returns whether the payloads got spooled.
+func (queue *redisQueue) spoolPending(payload []string) (bool, error) {
+	queue.spool.mu.Lock()
+	defer queue.spool.mu.Unlock()
+
+	if !queue.spool.pending {
+		return false, nil
+	}
+	return true, queue.appendSpool(payload)
+}
+
+// publishSpool spools the encoded payloads after publishing them failed with
+// err. Returns err if spooling failed.
+func (queue *redisQueue) publishSpool(payload []string, err error) error {
+	queue.spool.mu.Lock()
+	defer queue.spool.mu.Unlock()
+
+	if spoolErr := queue.appendSpool(payload); spoolErr != nil {
+		return err
+	}
+	queue.options.logf(LogInfo, "rmq queue spooled deliveries %s %d: %s", queue, len(payload), err)
+	return nil
+}
+
+// appendSpool appends the payloads to the spool file and makes sure a
+// flusher is running. The caller must hold the spool's lock.
+func (queue *redisQueue) appendSpool(payload []string) error {
+	file, err := os.OpenFile(queue.spool.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, p := range payload {
+		line, _ := json.Marshal(p) // can't fail for strings
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	queue.spool.pending = true
+	if !queue.spool.flushing {
+		queue.startFlusher()
+	}
+	return nil
+}
+
+// flushSpool retries publishing the spooled deliveries until it succeeded or
+// the connection stopped
+func (queue *redisQueue) flushSpool() {
+	defer queue.spool.flusher.Done()
+	for !queue.drainSpool() {
+		timer := time.NewTimer(queue.options.RetryInterval)
+		select {
+		case <-timer.C:
+		case <-queue.spool.stop:
+			timer.Stop()
+			queue.spool.mu.Lock()
+			queue.spool.flushing = false
+			queue.spool.mu.Unlock()
+			return
+		}
+	}
+}
+
+// drainSpool publishes all spooled deliveries in chunks and empties the
+// spool. Returns false if that failed. The spool file gets renamed before
+// its deliveries get published, so they don't get spooled again if removing
+// it fails afterwards.
+func (queue *redisQueue) drainSpool() bool {
+	queue.spool.mu.Lock()
+	defer queue.spool.mu.Unlock()
+
+	if queue.frozenPolicy != PublishWhileFrozen {
+		if frozen, err := queue.IsFrozen(); err != nil || frozen {
+			return false // keep them on disk until the queue gets unfrozen
+		}
+	}
+
+	flushingPath := queue.spool.path + spoolFlushingSuffix
+	total := 0
+	for {
+		payload, err := readSpool(flushingPath)
+		if err != nil {
+			queue.options.logf(LogInfo, "rmq queue failed to read spool %s: %s", queue, err)
+			return false
+		}
+		if payload == nil { // no flushing file, continue with the spool file
+			switch err := os.Rename(queue.spool.path, flushingPath); {
+			case os.IsNotExist(err): // nothing spooled
+				queue.spool.pending = false
+				queue.spool.flushing = false
+				queue.options.logf(LogInfo, "rmq queue flushed spool %s %d", queue, total)
+				return true
+			case err != nil:
+				queue.options.logf(LogInfo, "rmq queue failed to rename spool %s: %s", queue, err)
+				return false
+			}
+			queue.spool.flushed = 0
+			continue
+		}
+
+		for queue.spool.flushed < len(payload) {
+			end := queue.spool.flushed + publishBatchSize
+			if end > len(payload) {
+				end = len(payload)
+			}
+			if err := queue.publishEncoded(unusedContext, payload[queue.spool.flushed:end]); err != nil {
+				return false
+			}
+			total += end - queue.spool.flushed
+			queue.spool.flushed = end
+		}
+		if err := os.Remove(flushingPath); err != nil {
+			// flushed stays set, so its deliveries don't get published twice
+			queue.options.logf(LogInfo, "rmq queue failed to remove spool %s: %s", queue, err)
+			return false
+		}
+	}
+}
+
+// readSpool returns the payloads of the spool file, oldest first
+func readSpool(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	payload := []string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<30) // allow large payloads
+	for scanner.Scan() {
+		var p string
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			continue // partially written line of a crashed process
+		}
+		payload = append(payload, p)
+	}
+	return payload, scanner.Err()
+}
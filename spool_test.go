@@ -0,0 +1,109 @@
+package rmq
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmq-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool-q")
+
+	primary := &downRedisClient{RedisClient: NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))}
+	connection, err := OpenConnectionWithOptions("spool-conn", primary, nil, TestOptions)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("spool-q", WithSpool(path))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("spool-d1"))
+	primary.setDown(true)
+	assert.NoError(t, queue.Publish("spool-d2", "spool-d3\nwith newline"))
+	time.Sleep(20 * time.Millisecond) // flusher keeps failing
+	payloads, err := readSpools(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"spool-d2", "spool-d3\nwith newline"}, payloads)
+
+	// flushes in order once redis is back
+	primary.setDown(false)
+	assert.NoError(t, queue.Publish("spool-d4")) // spooled behind the others
+	time.Sleep(50 * time.Millisecond)
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{Payload: "spool-d1"}, {Payload: "spool-d2"}, {Payload: "spool-d3\nwith newline"}, {Payload: "spool-d4"}}, messages)
+	payloads, err = readSpools(path)
+	assert.NoError(t, err)
+	assert.Empty(t, payloads)
+
+	// deliveries spooled by a previous process get flushed on open
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	primary.setDown(true)
+	assert.NoError(t, queue.Publish("spool-d5"))
+	restartedConnection, err := OpenConnectionWithOptions("spool-conn", primary.RedisClient, nil, TestOptions)
+	assert.NoError(t, err)
+	restartedQueue, err := restartedConnection.OpenQueue("spool-q", WithSpool(path))
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	messages, err = restartedQueue.PeekReady(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{Payload: "spool-d5"}}, messages)
+	primary.setDown(false)
+	assert.NoError(t, connection.Close())
+	assert.NoError(t, restartedConnection.Close())
+	_, err = os.Stat(path + spoolFlushingSuffix)
+	assert.True(t, os.IsNotExist(err))
+
+	// a file left flushing by a crashed process gets flushed first
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path+spoolFlushingSuffix, []byte("\"spool-d6\"\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(path, []byte("\"spool-d7\"\n"), 0600))
+	restartedConnection, err = OpenConnectionWithOptions("spool-conn", primary.RedisClient, nil, TestOptions)
+	assert.NoError(t, err)
+	restartedQueue, err = restartedConnection.OpenQueue("spool-q", WithSpool(path))
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	messages, err = restartedQueue.PeekReady(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{Payload: "spool-d6"}, {Payload: "spool-d7"}}, messages)
+	assert.NoError(t, restartedConnection.Close())
+
+	// the flusher stops with the connection, leaving the deliveries spooled
+	primary.setDown(true)
+	connection, err = OpenConnectionWithOptions("spool-conn", primary, nil, TestOptions)
+	assert.NoError(t, err)
+	queue, err = connection.OpenQueue("spool-q", WithSpool(path))
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("spool-d8"))
+	<-connection.StopAllConsuming()
+	spool := queue.(*redisQueue).spool
+	spool.mu.Lock()
+	assert.False(t, spool.flushing)
+	spool.mu.Unlock()
+	payloads, err = readSpools(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"spool-d8"}, payloads)
+	primary.setDown(false)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+// readSpools returns the deliveries of the flushing file and the spool file
+func readSpools(path string) ([]string, error) {
+	flushing, err := readSpool(path + spoolFlushingSuffix)
+	if err != nil {
+		return nil, err
+	}
+	payloads, err := readSpool(path)
+	return append(flushing, payloads...), err
+}
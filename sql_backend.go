@@ -0,0 +1,853 @@
+package rmq
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQLDialect selects the SQL flavor a SQLBackend talks, see NewSQLBackend()
+type SQLDialect int
+
+const (
+	SQLite   SQLDialect = iota // SQLite 3, like github.com/mattn/go-sqlite3
+	Postgres                   // PostgreSQL 9.5 or later, like github.com/lib/pq
+)
+
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS rmq_strings (name TEXT NOT NULL PRIMARY KEY, value TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS rmq_lists (name TEXT NOT NULL, pos BIGINT NOT NULL, value TEXT NOT NULL, PRIMARY KEY (name, pos))`,
+	`CREATE TABLE IF NOT EXISTS rmq_sets (name TEXT NOT NULL, member TEXT NOT NULL, PRIMARY KEY (name, member))`,
+	`CREATE TABLE IF NOT EXISTS rmq_zsets (name TEXT NOT NULL, member TEXT NOT NULL, score DOUBLE PRECISION NOT NULL, PRIMARY KEY (name, member))`,
+	`CREATE INDEX IF NOT EXISTS rmq_zsets_score ON rmq_zsets (name, score)`,
+	`CREATE TABLE IF NOT EXISTS rmq_expires (name TEXT NOT NULL PRIMARY KEY, expires_at BIGINT NOT NULL)`,
+}
+
+// tables holding the values of keys, see sqlTx.del()
+var sqlValueTables = []string{"rmq_strings", "rmq_lists", "rmq_sets", "rmq_zsets"}
+
+// SQLBackend is a Backend which keeps queues in SQL tables, for local
+// development and small deployments without redis. Every operation runs in
+// its own transaction. Operations of the same backend get serialized, with
+// Postgres different processes also lock the keys they touch.
+// NOTE: Publish() only reaches subscribers of the same backend, so queue
+// events and signals don't get across processes.
+// NOTE: its conformance tests run in the module testsupport/sqlbackend, with
+// SQLite in CI and with Postgres if RMQ_TEST_POSTGRES_DSN is set
+type SQLBackend struct {
+	db      *sql.DB
+	dialect SQLDialect
+	mu      sync.Mutex // serializes transactions
+
+	subsMu sync.Mutex
+	subs   map[string][]chan string // subscribers by channel
+}
+
+// NewSQLBackend returns a backend which uses the given database and creates
+// its tables unless they exist already. rmq doesn't import any SQL driver,
+// so db must be opened with a driver for dialect. With SQLite use
+// "_txlock=immediate" if several processes share the database file, and a
+// single open connection for in-memory databases.
+func NewSQLBackend(db *sql.DB, dialect SQLDialect) (*SQLBackend, error) {
+	for _, statement := range sqlSchema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLBackend{db: db, dialect: dialect, subs: map[string][]chan string{}}, nil
+}
+
+func (backend *SQLBackend) Set(key string, value string, expiration time.Duration) error {
+	return backend.do([]string{key}, func(tx sqlTx) error {
+		if _, err := tx.del(key); err != nil {
+			return err
+		}
+		return tx.set(key, value, expiration)
+	})
+}
+
+func (backend *SQLBackend) SetNX(key string, value string, expiration time.Duration) (set bool, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		exists, err := tx.exists(key)
+		if err != nil || exists {
+			return err
+		}
+		set = true
+		return tx.set(key, value, expiration)
+	})
+	return set, err
+}
+
+func (backend *SQLBackend) ExpireIfEqual(key, value string, expiration time.Duration) (refreshed bool, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		switch stored, err := tx.get(key); {
+		case err == ErrorNotFound:
+			return nil
+		case err != nil:
+			return err
+		case stored != value:
+			return nil
+		}
+		refreshed = true
+		return tx.expire(key, expiration)
+	})
+	return refreshed, err
+}
+
+func (backend *SQLBackend) Get(key string) (value string, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		value, err = tx.get(key)
+		return err
+	})
+	return value, err
+}
+
+func (backend *SQLBackend) Del(key string) (affected int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		deleted, err := tx.del(key)
+		if deleted {
+			affected = 1
+		}
+		return err
+	})
+	return affected, err
+}
+
+// TTL returns the remaining time to live of key, -1 if it doesn't expire and
+// -2 if it doesn't exist, like redis
+func (backend *SQLBackend) TTL(key string) (ttl time.Duration, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		var expiresAt int64
+		err := tx.queryRow(`SELECT expires_at FROM rmq_expires WHERE name = ?`, key).Scan(&expiresAt)
+		switch err {
+		case nil:
+			ttl = time.Duration((expiresAt-sqlNow()+500)/1000) * time.Second
+			return nil
+		case sql.ErrNoRows:
+		default:
+			return err
+		}
+
+		exists, err := tx.exists(key)
+		if exists {
+			ttl = -1
+		} else {
+			ttl = -2
+		}
+		return err
+	})
+	return ttl, err
+}
+
+func (backend *SQLBackend) IncrBy(key string, value int64) (total int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		stored, err := tx.get(key)
+		switch err {
+		case nil:
+			if total, err = strconv.ParseInt(stored, 10, 64); err != nil {
+				return errors.New("ERR value is not an integer or out of range")
+			}
+			total += value
+			_, err = tx.exec(`UPDATE rmq_strings SET value = ? WHERE name = ?`, strconv.FormatInt(total, 10), key)
+			return err
+		case ErrorNotFound:
+			total = value
+			return tx.set(key, strconv.FormatInt(total, 10), 0)
+		default:
+			return err
+		}
+	})
+	return total, err
+}
+
+func (backend *SQLBackend) LPush(key string, value ...string) (total int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		if err := tx.lpush(key, value...); err != nil {
+			return err
+		}
+		total, err = tx.llen(key)
+		return err
+	})
+	return total, err
+}
+
+func (backend *SQLBackend) RPush(key string, value ...string) (total int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		if err := tx.rpush(key, value...); err != nil {
+			return err
+		}
+		total, err = tx.llen(key)
+		return err
+	})
+	return total, err
+}
+
+func (backend *SQLBackend) LLen(key string) (affected int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		affected, err = tx.llen(key)
+		return err
+	})
+	return affected, err
+}
+
+func (backend *SQLBackend) LLens(keys ...string) (lengths []int64, err error) {
+	err = backend.do(keys, func(tx sqlTx) error {
+		lengths = make([]int64, len(keys))
+		for i, key := range keys {
+			if lengths[i], err = tx.llen(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return lengths, err
+}
+
+func (backend *SQLBackend) LIndex(key string, index int64) (value string, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		length, err := tx.llen(key)
+		if err != nil {
+			return err
+		}
+		if index < 0 {
+			index += length
+		}
+		if index < 0 || index >= length {
+			return ErrorNotFound
+		}
+		return tx.queryRow(`SELECT value FROM rmq_lists WHERE name = ? ORDER BY pos LIMIT 1 OFFSET ?`, key, index).Scan(&value)
+	})
+	return value, err
+}
+
+func (backend *SQLBackend) LRange(key string, start, stop int64) (values []string, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		length, err := tx.llen(key)
+		if err != nil {
+			return err
+		}
+		start, stop = sqlRange(start, stop, length)
+		if start > stop {
+			values = []string{}
+			return nil
+		}
+		values, err = tx.strings(`SELECT value FROM rmq_lists WHERE name = ? ORDER BY pos LIMIT ? OFFSET ?`, key, stop-start+1, start)
+		return err
+	})
+	return values, err
+}
+
+func (backend *SQLBackend) LRem(key string, count int64, value string) (affected int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		affected, err = tx.lrem(key, count, value)
+		return err
+	})
+	return affected, err
+}
+
+func (backend *SQLBackend) LTrim(key string, start, stop int64) error {
+	return backend.do([]string{key}, func(tx sqlTx) error {
+		positions, err := tx.int64s(`SELECT pos FROM rmq_lists WHERE name = ? ORDER BY pos`, key)
+		if err != nil {
+			return err
+		}
+		start, stop = sqlRange(start, stop, int64(len(positions)))
+		if start > stop {
+			_, err := tx.del(key)
+			return err
+		}
+		_, err = tx.exec(`DELETE FROM rmq_lists WHERE name = ? AND (pos < ? OR pos > ?)`, key, positions[start], positions[stop])
+		return err
+	})
+}
+
+func (backend *SQLBackend) RPopLPush(source, destination string) (value string, err error) {
+	err = backend.do([]string{source, destination}, func(tx sqlTx) error {
+		value, err = tx.rpoplpush(source, destination)
+		return err
+	})
+	return value, err
+}
+
+func (backend *SQLBackend) RPopLPushUnless(source, destination, guardKey string) (value string, guarded bool, err error) {
+	err = backend.do([]string{source, destination, guardKey}, func(tx sqlTx) error {
+		if guarded, err = tx.exists(guardKey); err != nil || guarded {
+			return err
+		}
+		value, err = tx.rpoplpush(source, destination)
+		return err
+	})
+	return value, guarded, err
+}
+
+func (backend *SQLBackend) LPopRPush(source, destination string) (value string, err error) {
+	err = backend.do([]string{source, destination}, func(tx sqlTx) error {
+		value, err = tx.lpoprpush(source, destination)
+		return err
+	})
+	return value, err
+}
+
+func (backend *SQLBackend) LPopRPushUnless(source, destination, guardKey string) (value string, guarded bool, err error) {
+	err = backend.do([]string{source, destination, guardKey}, func(tx sqlTx) error {
+		if guarded, err = tx.exists(guardKey); err != nil || guarded {
+			return err
+		}
+		value, err = tx.lpoprpush(source, destination)
+		return err
+	})
+	return value, guarded, err
+}
+
+func (backend *SQLBackend) LPushAllExpire(key, pushKey string, expiration time.Duration) (moved int64, err error) {
+	err = backend.do([]string{key, pushKey}, func(tx sqlTx) error {
+		values, err := tx.strings(`SELECT value FROM rmq_lists WHERE name = ? ORDER BY pos`, key)
+		if err != nil || len(values) == 0 {
+			return err
+		}
+		// push the rightmost value first to keep the order
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+		if err := tx.lpush(pushKey, values...); err != nil {
+			return err
+		}
+		if _, err := tx.del(key); err != nil {
+			return err
+		}
+		moved = int64(len(values))
+		return tx.expire(pushKey, expiration)
+	})
+	return moved, err
+}
+
+func (backend *SQLBackend) RPushAll(key, pushKey string) (moved int64, err error) {
+	err = backend.do([]string{key, pushKey}, func(tx sqlTx) error {
+		values, err := tx.strings(`SELECT value FROM rmq_lists WHERE name = ? ORDER BY pos`, key)
+		if err != nil || len(values) == 0 {
+			return err
+		}
+		if err := tx.rpush(pushKey, values...); err != nil {
+			return err
+		}
+		moved = int64(len(values))
+		_, err = tx.del(key)
+		return err
+	})
+	return moved, err
+}
+
+func (backend *SQLBackend) LRemLPush(removeKey, value, pushKey, pushValue string) (affected int64, err error) {
+	err = backend.do([]string{removeKey, pushKey}, func(tx sqlTx) error {
+		if affected, err = tx.lrem(removeKey, 1, value); err != nil || affected == 0 {
+			return err
+		}
+		return tx.lpush(pushKey, pushValue)
+	})
+	return affected, err
+}
+
+func (backend *SQLBackend) LRemLPushNX(removeKey, value, pushKey, pushValue, onceKey string, expiration time.Duration) (affected int64, pushed bool, err error) {
+	err = backend.do([]string{removeKey, pushKey, onceKey}, func(tx sqlTx) error {
+		if affected, err = tx.lrem(removeKey, 1, value); err != nil || affected == 0 {
+			return err
+		}
+		exists, err := tx.exists(onceKey)
+		if err != nil || exists {
+			return err
+		}
+		if err := tx.set(onceKey, "1", expiration); err != nil {
+			return err
+		}
+		pushed = true
+		return tx.lpush(pushKey, pushValue)
+	})
+	return affected, pushed, err
+}
+
+func (backend *SQLBackend) SAdd(key, value string) (total int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		var count int64
+		if err := tx.queryRow(`SELECT COUNT(*) FROM rmq_sets WHERE name = ? AND member = ?`, key, value).Scan(&count); err != nil || count > 0 {
+			return err
+		}
+		total = 1
+		_, err := tx.exec(`INSERT INTO rmq_sets (name, member) VALUES (?, ?)`, key, value)
+		return err
+	})
+	return total, err
+}
+
+func (backend *SQLBackend) SMembers(key string) (members []string, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		members, err = tx.strings(`SELECT member FROM rmq_sets WHERE name = ?`, key)
+		return err
+	})
+	return members, err
+}
+
+// SScan uses offsets in the set ordered by member as cursors
+func (backend *SQLBackend) SScan(key string, cursor uint64, count int64) (members []string, next uint64, err error) {
+	if count <= 0 {
+		count = 10 // like redis
+	}
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		members, err = tx.strings(`SELECT member FROM rmq_sets WHERE name = ? ORDER BY member LIMIT ? OFFSET ?`, key, count, int64(cursor))
+		if int64(len(members)) == count {
+			next = cursor + uint64(count)
+		}
+		return err
+	})
+	return members, next, err
+}
+
+func (backend *SQLBackend) SRem(key, value string) (affected int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		affected, err = tx.affected(`DELETE FROM rmq_sets WHERE name = ? AND member = ?`, key, value)
+		return err
+	})
+	return affected, err
+}
+
+func (backend *SQLBackend) ZAddLimit(key, member string, score, expiredScore float64, limit int64) (added bool, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		if _, err := tx.exec(`DELETE FROM rmq_zsets WHERE name = ? AND score <= ?`, key, expiredScore); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.queryRow(`SELECT COUNT(*) FROM rmq_zsets WHERE name = ?`, key).Scan(&count); err != nil || count >= limit {
+			return err
+		}
+		added = true
+		return tx.zadd(key, member, score)
+	})
+	return added, err
+}
+
+func (backend *SQLBackend) ZAdd(key, member string, score float64) (added int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		var count int64
+		if err := tx.queryRow(`SELECT COUNT(*) FROM rmq_zsets WHERE name = ? AND member = ?`, key, member).Scan(&count); err != nil {
+			return err
+		}
+		added = 1 - count
+		return tx.zadd(key, member, score)
+	})
+	return added, err
+}
+
+func (backend *SQLBackend) ZRem(key, member string) (affected int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		affected, err = tx.affected(`DELETE FROM rmq_zsets WHERE name = ? AND member = ?`, key, member)
+		return err
+	})
+	return affected, err
+}
+
+func (backend *SQLBackend) ZCard(key string) (count int64, err error) {
+	err = backend.do([]string{key}, func(tx sqlTx) error {
+		return tx.queryRow(`SELECT COUNT(*) FROM rmq_zsets WHERE name = ?`, key).Scan(&count)
+	})
+	return count, err
+}
+
+func (backend *SQLBackend) LRemZAdd(removeKey, value, zsetKey, member string, score float64) (affected int64, err error) {
+	err = backend.do([]string{removeKey, zsetKey}, func(tx sqlTx) error {
+		if affected, err = tx.lrem(removeKey, 1, value); err != nil || affected == 0 {
+			return err
+		}
+		return tx.zadd(zsetKey, member, score)
+	})
+	return affected, err
+}
+
+func (backend *SQLBackend) ZPopRPush(key string, maxScore float64, count int64, trim int, pushKey string) (moved int64, err error) {
+	err = backend.do([]string{key, pushKey}, func(tx sqlTx) error {
+		members, err := tx.strings(`SELECT member FROM rmq_zsets WHERE name = ? AND score <= ? ORDER BY score, member LIMIT ?`, key, maxScore, count)
+		if err != nil {
+			return err
+		}
+		values := make([]string, len(members))
+		for i, member := range members {
+			if _, err := tx.exec(`DELETE FROM rmq_zsets WHERE name = ? AND member = ?`, key, member); err != nil {
+				return err
+			}
+			if trim < len(member) {
+				values[i] = member[trim:]
+			}
+		}
+		moved = int64(len(members))
+		return tx.rpush(pushKey, values...)
+	})
+	return moved, err
+}
+
+// Publish sends message to the subscribers of channel of this backend.
+// Subscribers which don't keep up miss messages.
+func (backend *SQLBackend) Publish(channel, message string) error {
+	backend.subsMu.Lock()
+	defer backend.subsMu.Unlock()
+
+	for _, subscriber := range backend.subs[channel] {
+		select {
+		case subscriber <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+func (backend *SQLBackend) Subscribe(channel string) (messages <-chan string, unsubscribe func() error, err error) {
+	backend.subsMu.Lock()
+	defer backend.subsMu.Unlock()
+
+	subscriber := make(chan string, 100)
+	backend.subs[channel] = append(backend.subs[channel], subscriber)
+
+	unsubscribe = func() error {
+		backend.subsMu.Lock()
+		defer backend.subsMu.Unlock()
+
+		remaining := []chan string{}
+		for _, other := range backend.subs[channel] {
+			if other == subscriber {
+				close(subscriber)
+				continue
+			}
+			remaining = append(remaining, other)
+		}
+		backend.subs[channel] = remaining
+		return nil
+	}
+	return subscriber, unsubscribe, nil
+}
+
+// FlushDb deletes the contents of all rmq tables
+func (backend *SQLBackend) FlushDb() error {
+	return backend.do(nil, func(tx sqlTx) error {
+		for _, table := range append(sqlValueTables, "rmq_expires") {
+			if _, err := tx.exec(`DELETE FROM ` + table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// do runs fn in a transaction, after locking the given keys and deleting
+// them if they expired
+func (backend *SQLBackend) do(keys []string, fn func(tx sqlTx) error) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	dbTx, err := backend.db.Begin()
+	if err != nil {
+		return err
+	}
+	tx := sqlTx{Tx: dbTx, dialect: backend.dialect}
+	if err := tx.prepare(keys); err != nil {
+		dbTx.Rollback()
+		return err
+	}
+	if err := fn(tx); err != nil {
+		dbTx.Rollback()
+		return err
+	}
+	return dbTx.Commit()
+}
+
+// sqlTx implements the operations of SQLBackend within a transaction
+type sqlTx struct {
+	*sql.Tx
+	dialect SQLDialect
+}
+
+// prepare locks the keys (only needed with Postgres, SQLite locks the whole
+// database) and deletes the expired ones
+func (tx sqlTx) prepare(keys []string) error {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted) // lock in a consistent order to avoid deadlocks
+	for i, key := range sorted {
+		if i > 0 && key == sorted[i-1] {
+			continue
+		}
+		if tx.dialect == Postgres {
+			if _, err := tx.exec(`SELECT pg_advisory_xact_lock(hashtext(?))`, key); err != nil {
+				return err
+			}
+		}
+		var expiresAt int64
+		err := tx.queryRow(`SELECT expires_at FROM rmq_expires WHERE name = ?`, key).Scan(&expiresAt)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		case expiresAt <= sqlNow():
+			if _, err := tx.del(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (tx sqlTx) exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Exec(tx.rebind(query), args...)
+}
+
+func (tx sqlTx) queryRow(query string, args ...interface{}) *sql.Row {
+	return tx.QueryRow(tx.rebind(query), args...)
+}
+
+func (tx sqlTx) affected(query string, args ...interface{}) (int64, error) {
+	result, err := tx.exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// strings returns the first column of all rows of the query
+func (tx sqlTx) strings(query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(tx.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// int64s returns the first column of all rows of the query
+func (tx sqlTx) int64s(query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.Query(tx.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []int64
+	for rows.Next() {
+		var value int64
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// rebind replaces the ? placeholders of query with $1, $2, ... for Postgres
+func (tx sqlTx) rebind(query string) string {
+	return sqlRebind(tx.dialect, query)
+}
+
+func (tx sqlTx) exists(key string) (bool, error) {
+	for _, table := range sqlValueTables {
+		var count int64
+		if err := tx.queryRow(`SELECT COUNT(*) FROM `+table+` WHERE name = ?`, key).Scan(&count); err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// del deletes key and its expiry, returns whether it existed
+func (tx sqlTx) del(key string) (deleted bool, err error) {
+	for _, table := range sqlValueTables {
+		affected, err := tx.affected(`DELETE FROM `+table+` WHERE name = ?`, key)
+		if err != nil {
+			return false, err
+		}
+		deleted = deleted || affected > 0
+	}
+	_, err = tx.exec(`DELETE FROM rmq_expires WHERE name = ?`, key)
+	return deleted, err
+}
+
+func (tx sqlTx) get(key string) (value string, err error) {
+	err = tx.queryRow(`SELECT value FROM rmq_strings WHERE name = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", ErrorNotFound
+	}
+	return value, err
+}
+
+// set inserts the string key, which must not exist
+func (tx sqlTx) set(key, value string, expiration time.Duration) error {
+	if _, err := tx.exec(`INSERT INTO rmq_strings (name, value) VALUES (?, ?)`, key, value); err != nil {
+		return err
+	}
+	if expiration <= 0 {
+		return nil
+	}
+	return tx.expire(key, expiration)
+}
+
+func (tx sqlTx) expire(key string, expiration time.Duration) error {
+	if _, err := tx.exec(`DELETE FROM rmq_expires WHERE name = ?`, key); err != nil {
+		return err
+	}
+	_, err := tx.exec(`INSERT INTO rmq_expires (name, expires_at) VALUES (?, ?)`, key, sqlNow()+expiration.Milliseconds())
+	return err
+}
+
+func (tx sqlTx) llen(key string) (length int64, err error) {
+	err = tx.queryRow(`SELECT COUNT(*) FROM rmq_lists WHERE name = ?`, key).Scan(&length)
+	return length, err
+}
+
+// lpush inserts the values one after the other at the head of the list, so
+// the last value ends up first
+func (tx sqlTx) lpush(key string, values ...string) error {
+	var first sql.NullInt64
+	if err := tx.queryRow(`SELECT MIN(pos) FROM rmq_lists WHERE name = ?`, key).Scan(&first); err != nil {
+		return err
+	}
+	pos := first.Int64
+	for _, value := range values {
+		pos--
+		if _, err := tx.exec(`INSERT INTO rmq_lists (name, pos, value) VALUES (?, ?, ?)`, key, pos, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rpush appends the values to the tail of the list
+func (tx sqlTx) rpush(key string, values ...string) error {
+	var last sql.NullInt64
+	if err := tx.queryRow(`SELECT MAX(pos) FROM rmq_lists WHERE name = ?`, key).Scan(&last); err != nil {
+		return err
+	}
+	pos := last.Int64
+	for _, value := range values {
+		pos++
+		if _, err := tx.exec(`INSERT INTO rmq_lists (name, pos, value) VALUES (?, ?, ?)`, key, pos, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lrem removes count occurrences of value, from the head if count is
+// positive, from the tail if it's negative and all if it's 0
+func (tx sqlTx) lrem(key string, count int64, value string) (affected int64, err error) {
+	query := `SELECT pos FROM rmq_lists WHERE name = ? AND value = ? ORDER BY pos`
+	args := []interface{}{key, value}
+	switch {
+	case count > 0:
+		query += ` LIMIT ?`
+		args = append(args, count)
+	case count < 0:
+		query += ` DESC LIMIT ?`
+		args = append(args, -count)
+	}
+	positions, err := tx.int64s(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	for _, pos := range positions {
+		if _, err := tx.exec(`DELETE FROM rmq_lists WHERE name = ? AND pos = ?`, key, pos); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(positions)), nil
+}
+
+func (tx sqlTx) rpoplpush(source, destination string) (value string, err error) {
+	var pos int64
+	err = tx.queryRow(`SELECT pos, value FROM rmq_lists WHERE name = ? ORDER BY pos DESC LIMIT 1`, source).Scan(&pos, &value)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return "", ErrorNotFound
+	default:
+		return "", err
+	}
+	if _, err := tx.exec(`DELETE FROM rmq_lists WHERE name = ? AND pos = ?`, source, pos); err != nil {
+		return "", err
+	}
+	return value, tx.lpush(destination, value)
+}
+
+func (tx sqlTx) lpoprpush(source, destination string) (value string, err error) {
+	var pos int64
+	err = tx.queryRow(`SELECT pos, value FROM rmq_lists WHERE name = ? ORDER BY pos LIMIT 1`, source).Scan(&pos, &value)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return "", ErrorNotFound
+	default:
+		return "", err
+	}
+	if _, err := tx.exec(`DELETE FROM rmq_lists WHERE name = ? AND pos = ?`, source, pos); err != nil {
+		return "", err
+	}
+	return value, tx.rpush(destination, value)
+}
+
+func (tx sqlTx) zadd(key, member string, score float64) error {
+	if _, err := tx.exec(`DELETE FROM rmq_zsets WHERE name = ? AND member = ?`, key, member); err != nil {
+		return err
+	}
+	_, err := tx.exec(`INSERT INTO rmq_zsets (name, member, score) VALUES (?, ?, ?)`, key, member, score)
+	return err
+}
+
+// sqlRange turns redis style start and stop indexes (negative ones count from
+// the tail) into indexes within a list of the given length. Returns
+// start > stop if the range is empty.
+func sqlRange(start, stop, length int64) (int64, int64) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	return start, stop
+}
+
+func sqlRebind(dialect SQLDialect, query string) string {
+	if dialect != Postgres {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			builder.WriteRune(r)
+			continue
+		}
+		n++
+		builder.WriteString("$" + strconv.Itoa(n))
+	}
+	return builder.String()
+}
+
+// sqlNow returns the current time in unix milliseconds, as stored in
+// rmq_expires
+func sqlNow() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
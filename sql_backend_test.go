@@ -0,0 +1,28 @@
+package rmq
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The SQL backend needs a driver, which rmq doesn't depend on, so only its
+// helpers get tested here. Its conformance tests run in the module
+// testsupport/sqlbackend.
+
+func TestSQLRebind(t *testing.T) {
+	query := `SELECT value FROM rmq_lists WHERE name = ? ORDER BY pos LIMIT ? OFFSET ?`
+	assert.Equal(t, query, sqlRebind(SQLite, query))
+	assert.Equal(t, `SELECT value FROM rmq_lists WHERE name = $1 ORDER BY pos LIMIT $2 OFFSET $3`, sqlRebind(Postgres, query))
+}
+
+func TestSQLRange(t *testing.T) {
+	start, stop := sqlRange(0, -1, 3)
+	assert.Equal(t, []int64{0, 2}, []int64{start, stop})
+	start, stop = sqlRange(-5, 10, 3)
+	assert.Equal(t, []int64{0, 2}, []int64{start, stop})
+	start, stop = sqlRange(2, 1, 3)
+	assert.True(t, start > stop)
+	start, stop = sqlRange(0, -1, 0)
+	assert.True(t, start > stop)
+}
@@ -3,27 +3,49 @@ package rmq
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 type ConnectionStat struct {
-	active       bool
-	unackedCount int64
-	consumers    []string
+	active          bool
+	unackedCount    int64
+	consumers       []string
+	bufferedCount   int64         // prefetched deliveries waiting for consumers
+	bufferSize      int64         // capacity of the prefetch buffer
+	blockedDuration time.Duration // total time spent waiting for consumers to take prefetched deliveries
+	concurrency     int64         // number of deliveries the consumers can consume at once
+	durations       *durationSketch
 }
 
 func (stat ConnectionStat) String() string {
-	return fmt.Sprintf("[unacked:%d consumers:%d]",
+	return fmt.Sprintf("[unacked:%d consumers:%d concurrency:%d buffered:%d/%d blocked:%s]",
 		stat.unackedCount,
 		len(stat.consumers),
+		stat.concurrency,
+		stat.bufferedCount,
+		stat.bufferSize,
+		stat.blockedDuration,
 	)
 }
 
 type ConnectionStats map[string]ConnectionStat
 
 type QueueStat struct {
-	ReadyCount      int64 `json:"ready"`
-	RejectedCount   int64 `json:"rejected"`
+	ReadyCount      int64             `json:"ready"`
+	RejectedCount   int64             `json:"rejected"`
+	RejectedClasses map[string]int64  `json:"rejectedClasses,omitempty"` // by error class, not included in RejectedCount, see Delivery.RejectAs()
+	Tenants         map[string]int64  `json:"tenants,omitempty"`         // ready deliveries by tenant, included in ReadyCount, see Queue.PublishForTenant()
+	Priorities      map[int]int64     `json:"priorities,omitempty"`      // ready deliveries by priority other than 0, included in ReadyCount, see Queue.PublishWithPriority()
+	CleanedCount    int64             `json:"cleaned,omitempty"`
+	LostAckCount    int64             `json:"lostAcks,omitempty"`
+	FetchedCount    int64             `json:"fetched,omitempty"` // deliveries fetched by all connections, see Stats.TimeToDrain()
+	Feeds           []string          `json:"feeds,omitempty"`   // queues this queue feeds into, see Queue.DeclareFeeds()
+	Tags            map[string]string `json:"tags,omitempty"`    // see Queue.SetTags()
+	BigKeys         []string          `json:"bigKeys,omitempty"` // lists holding more deliveries than Options.BigKeyLimit of the connection collecting the stats
 	connectionStats ConnectionStats
 }
 
@@ -59,6 +81,85 @@ func (stat QueueStat) ConsumerCount() int64 {
 	return consumer
 }
 
+// BufferFillRatio returns how full the prefetch buffers of all consuming
+// connections are, between 0 and 1. A ratio close to 1 means the consumers
+// can't keep up (slow handlers or too few consumers), while a ratio close to 0
+// with a growing ready count means the prefetch limit is too low.
+func (stat QueueStat) BufferFillRatio() float64 {
+	buffered, size := int64(0), int64(0)
+	for _, connectionStat := range stat.connectionStats {
+		buffered += connectionStat.bufferedCount
+		size += connectionStat.bufferSize
+	}
+	if size == 0 {
+		return 0
+	}
+	return float64(buffered) / float64(size)
+}
+
+// Concurrency returns the number of deliveries the consumers of all consuming
+// connections can consume at once, which is the theoretical processing
+// capacity of the queue. Consumers count once, batch consumers with their
+// batch size. Connection wide limits like Options.MaxConcurrency are not taken
+// into account. Compare with the backlog to plan capacity, see Backlog().
+func (stat QueueStat) Concurrency() int64 {
+	concurrency := int64(0)
+	for _, connectionStat := range stat.connectionStats {
+		concurrency += connectionStat.concurrency
+	}
+	return concurrency
+}
+
+// PrefetchLimit returns the total size of the prefetch buffers of all
+// consuming connections, see Queue.StartConsuming()
+func (stat QueueStat) PrefetchLimit() int64 {
+	limit := int64(0)
+	for _, connectionStat := range stat.connectionStats {
+		limit += connectionStat.bufferSize
+	}
+	return limit
+}
+
+// Backlog returns the number of deliveries waiting to be consumed for each
+// delivery the consumers can consume at once, see Concurrency(). Returns 0 if
+// the queue is empty and +Inf if there is a backlog but no consumers.
+func (stat QueueStat) Backlog() float64 {
+	waiting := stat.ReadyCount + stat.UnackedCount()
+	concurrency := stat.Concurrency()
+	if waiting == 0 {
+		return 0
+	}
+	if concurrency == 0 {
+		return math.Inf(1)
+	}
+	return float64(waiting) / float64(concurrency)
+}
+
+// BlockedDuration returns the total time the consuming connections spent
+// waiting for their consumers to take prefetched deliveries
+func (stat QueueStat) BlockedDuration() time.Duration {
+	blocked := time.Duration(0)
+	for _, connectionStat := range stat.connectionStats {
+		blocked += connectionStat.blockedDuration
+	}
+	return blocked
+}
+
+// HandlerDuration returns the given quantile (between 0 and 1) of how long
+// the consumers of all consuming connections took to consume a delivery, for
+// example HandlerDuration(0.99) for the p99. The durations are tracked since
+// the connections started consuming or the last ResetStats() call and are
+// accurate within 1%. For batch consumers whole batches count.
+func (stat QueueStat) HandlerDuration(quantile float64) time.Duration {
+	durations := newDurationSketch()
+	for _, connectionStat := range stat.connectionStats {
+		if connectionStat.durations != nil {
+			durations.merge(connectionStat.durations)
+		}
+	}
+	return durations.quantile(quantile)
+}
+
 func (stat QueueStat) ConnectionCount() int64 {
 	return int64(len(stat.connectionStats))
 }
@@ -67,94 +168,293 @@ type QueueStats map[string]QueueStat
 
 type Stats struct {
 	QueueStats       QueueStats      `json:"queues"`
+	CleanerStat      CleanerStat     `json:"cleaner"`
 	otherConnections map[string]bool // non consuming connections, active or not
+	collected        time.Time       // when the stats got collected, see TimeToDrain()
 }
 
 func NewStats() Stats {
 	return Stats{
 		QueueStats:       QueueStats{},
 		otherConnections: map[string]bool{},
+		collected:        time.Now(),
 	}
 }
 
+// StatsOptions control how CollectStatsWithOptions() loads the stats from
+// redis. The zero value uses the defaults.
+type StatsOptions struct {
+	// BatchSize is the number of queues whose ready and rejected counts get
+	// requested per pipelined round trip, defaults to 100
+	BatchSize int
+	// Concurrency is the number of connections whose stats get loaded
+	// concurrently, defaults to 1 to keep the load on redis low
+	Concurrency int
+}
+
+const defaultStatsBatchSize = 100
+
 func CollectStats(queueList []string, mainConnection Connection) (Stats, error) {
+	return CollectStatsWithOptions(queueList, mainConnection, StatsOptions{})
+}
+
+// CollectStatsWithOptions is like CollectStats(), but lets you choose how
+// many queue counts get loaded per round trip and how many connections get
+// inspected concurrently. Sets of connections and queues are iterated with
+// SSCAN, so collecting stats over thousands of them doesn't block redis.
+func CollectStatsWithOptions(queueList []string, mainConnection Connection, options StatsOptions) (Stats, error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultStatsBatchSize
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+
 	stats := NewStats()
-	for _, queueName := range queueList {
-		queue := mainConnection.openQueue(queueName)
-		readyCount, err := queue.readyCount()
-		if err != nil {
-			return stats, err
+	for start := 0; start < len(queueList); start += options.BatchSize {
+		end := start + options.BatchSize
+		if end > len(queueList) {
+			end = len(queueList)
 		}
-		rejectedCount, err := queue.rejectedCount()
-		if err != nil {
+		if err := stats.collectQueues(queueList[start:end], mainConnection); err != nil {
 			return stats, err
 		}
-		stats.QueueStats[queueName] = NewQueueStat(readyCount, rejectedCount)
 	}
 
+	cleanerStat, err := mainConnection.cleanerStat()
+	if err != nil {
+		return stats, err
+	}
+	stats.CleanerStat = cleanerStat
+
 	connectionNames, err := mainConnection.getConnections()
 	if err != nil {
 		return stats, err
 	}
 
+	var (
+		mu       sync.Mutex // protects stats and firstErr
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	names := make(chan string)
+	for i := 0; i < options.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for connectionName := range names {
+				if err := stats.collectConnection(connectionName, mainConnection, &mu); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
 	for _, connectionName := range connectionNames {
-		hijackedConnection := mainConnection.hijackConnection(connectionName)
-
-		var connectionActive bool
-		switch err := hijackedConnection.checkHeartbeat(); err {
-		case nil:
-			connectionActive = true
-		case ErrorNotFound:
-			connectionActive = false
-		default:
-			return stats, err
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
 		}
+		names <- connectionName
+	}
+	close(names)
+	wg.Wait()
+
+	return stats, firstErr
+}
+
+// collectQueues adds the stats of the given open queues, loading their ready
+// and rejected counts in one round trip
+func (stats Stats) collectQueues(queueNames []string, mainConnection Connection) error {
+	readyCounts, rejectedCounts, err := mainConnection.queueCounts(queueNames)
+	if err != nil {
+		return err
+	}
 
-		queueNames, err := hijackedConnection.getConsumingQueues()
+	for i, queueName := range queueNames {
+		queue := mainConnection.openQueue(queueName)
+		feeds, err := queue.Feeds()
 		if err != nil {
-			return stats, err
+			return err
+		}
+		cleanedCount, err := queue.cleanedCount()
+		if err != nil {
+			return err
+		}
+		lostAckCount, err := queue.lostAckCount()
+		if err != nil {
+			return err
+		}
+		fetchedCount, err := queue.fetchedCount()
+		if err != nil {
+			return err
+		}
+		tags, err := queue.Tags()
+		if err != nil {
+			return err
+		}
+		classCounts, err := queue.rejectedClassCounts()
+		if err != nil {
+			return err
+		}
+		tenantCounts, err := queue.tenantCounts()
+		if err != nil {
+			return err
+		}
+		priorityCounts, err := queue.priorityCounts()
+		if err != nil {
+			return err
+		}
+		streamCount, err := queue.streamCount()
+		if err != nil {
+			return err
+		}
+		readyCount := readyCounts[i] + streamCount
+		for _, count := range priorityCounts {
+			readyCount += count
 		}
-		if len(queueNames) == 0 {
-			stats.otherConnections[connectionName] = connectionActive
-			continue
+		for _, count := range tenantCounts {
+			readyCount += count
 		}
+		queueStat := NewQueueStat(readyCount, rejectedCounts[i])
+		queueStat.RejectedClasses = classCounts
+		queueStat.Tenants = tenantCounts
+		queueStat.Priorities = priorityCounts
+		queueStat.CleanedCount = cleanedCount
+		queueStat.LostAckCount = lostAckCount
+		queueStat.FetchedCount = fetchedCount
+		if len(feeds) > 0 {
+			queueStat.Feeds = feeds
+		}
+		queueStat.Tags = tags
+		queueStat.BigKeys = queue.bigKeys(queueStat)
+		stats.QueueStats[queueName] = queueStat
+	}
+	return nil
+}
 
-		for _, queueName := range queueNames {
-			queue := hijackedConnection.openQueue(queueName)
-			consumers, err := queue.getConsumers()
-			if err != nil {
-				return stats, err
-			}
-			openQueueStat, ok := stats.QueueStats[queueName]
-			if !ok {
-				continue
-			}
-			unackedCount, err := queue.unackedCount()
-			if err != nil {
-				return stats, err
-			}
-			openQueueStat.connectionStats[connectionName] = ConnectionStat{
-				active:       connectionActive,
-				unackedCount: unackedCount,
-				consumers:    consumers,
-			}
+// collectConnection adds the stats of the given connection. Loads them from
+// redis without holding mu and only locks it to add them to stats.
+func (stats Stats) collectConnection(connectionName string, mainConnection Connection, mu *sync.Mutex) error {
+	hijackedConnection := mainConnection.hijackConnection(connectionName)
+
+	var connectionActive bool
+	switch err := hijackedConnection.checkHeartbeat(); err {
+	case nil:
+		connectionActive = true
+	case ErrorNotFound:
+		connectionActive = false
+	default:
+		return err
+	}
+
+	queueNames, err := hijackedConnection.getConsumingQueues()
+	if err != nil {
+		return err
+	}
+	if len(queueNames) == 0 {
+		mu.Lock()
+		stats.otherConnections[connectionName] = connectionActive
+		mu.Unlock()
+		return nil
+	}
+
+	connectionStats := map[string]ConnectionStat{}
+	for _, queueName := range queueNames {
+		if _, ok := stats.QueueStats[queueName]; !ok {
+			continue // QueueStats is only written before connections get collected
+		}
+		queue := hijackedConnection.openQueue(queueName)
+		consumers, err := queue.getConsumers()
+		if err != nil {
+			return err
+		}
+		unackedCount, err := queue.unackedCount()
+		if err != nil {
+			return err
+		}
+		bufferedCount, bufferSize, blockedDuration, concurrency, err := queue.bufferStat()
+		if err != nil {
+			return err
+		}
+		durations, err := queue.durationsStat()
+		if err != nil {
+			return err
+		}
+		connectionStats[queueName] = ConnectionStat{
+			active:          connectionActive,
+			unackedCount:    unackedCount,
+			consumers:       consumers,
+			bufferedCount:   bufferedCount,
+			bufferSize:      bufferSize,
+			blockedDuration: blockedDuration,
+			concurrency:     concurrency,
+			durations:       durations,
 		}
 	}
 
-	return stats, nil
+	mu.Lock()
+	defer mu.Unlock()
+	for queueName, connectionStat := range connectionStats {
+		stats.QueueStats[queueName].connectionStats[connectionName] = connectionStat
+	}
+	return nil
 }
 
 func (stats Stats) String() string {
 	var buffer bytes.Buffer
 
 	for queueName, queueStat := range stats.QueueStats {
-		buffer.WriteString(fmt.Sprintf("    queue:%s ready:%d rejected:%d unacked:%d consumers:%d\n",
+		buffer.WriteString(fmt.Sprintf("    queue:%s ready:%d rejected:%d unacked:%d consumers:%d concurrency:%d prefetch:%d fill:%.2f blocked:%s p50:%s p95:%s p99:%s\n",
 			queueName, queueStat.ReadyCount, queueStat.RejectedCount, queueStat.UnackedCount(), queueStat.ConsumerCount(),
+			queueStat.Concurrency(), queueStat.PrefetchLimit(),
+			queueStat.BufferFillRatio(), queueStat.BlockedDuration(),
+			queueStat.HandlerDuration(0.5), queueStat.HandlerDuration(0.95), queueStat.HandlerDuration(0.99),
 		))
+		if len(queueStat.Tags) > 0 {
+			buffer.WriteString(fmt.Sprintf("        tags:%s\n", strings.Join(sortedTags(queueStat.Tags), ",")))
+		}
+		if len(queueStat.BigKeys) > 0 {
+			buffer.WriteString(fmt.Sprintf("        big keys:%s\n", strings.Join(queueStat.BigKeys, ",")))
+		}
+		if len(queueStat.RejectedClasses) > 0 {
+			classes := make([]string, 0, len(queueStat.RejectedClasses))
+			for class, count := range queueStat.RejectedClasses {
+				classes = append(classes, fmt.Sprintf("%s=%d", class, count))
+			}
+			sort.Strings(classes)
+			buffer.WriteString(fmt.Sprintf("        rejected classes:%s\n", strings.Join(classes, ",")))
+		}
+		if len(queueStat.Tenants) > 0 {
+			tenants := make([]string, 0, len(queueStat.Tenants))
+			for tenant, count := range queueStat.Tenants {
+				tenants = append(tenants, fmt.Sprintf("%s=%d", tenant, count))
+			}
+			sort.Strings(tenants)
+			buffer.WriteString(fmt.Sprintf("        tenants:%s\n", strings.Join(tenants, ",")))
+		}
+		if len(queueStat.Priorities) > 0 {
+			priorities := make([]int, 0, len(queueStat.Priorities))
+			for priority := range queueStat.Priorities {
+				priorities = append(priorities, priority)
+			}
+			sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+			bands := make([]string, len(priorities))
+			for i, priority := range priorities {
+				bands[i] = fmt.Sprintf("%d=%d", priority, queueStat.Priorities[priority])
+			}
+			buffer.WriteString(fmt.Sprintf("        priorities:%s\n", strings.Join(bands, ",")))
+		}
 
 		for connectionName, connectionStat := range queueStat.connectionStats {
-			buffer.WriteString(fmt.Sprintf("        connection:%s unacked:%d consumers:%d active:%t\n",
-				connectionName, connectionStat.unackedCount, len(connectionStat.consumers), connectionStat.active,
+			buffer.WriteString(fmt.Sprintf("        connection:%s unacked:%d consumers:%d concurrency:%d active:%t buffered:%d/%d blocked:%s\n",
+				connectionName, connectionStat.unackedCount, len(connectionStat.consumers), connectionStat.concurrency, connectionStat.active,
+				connectionStat.bufferedCount, connectionStat.bufferSize, connectionStat.blockedDuration,
 			))
 		}
 	}
@@ -169,35 +469,84 @@ func (stats Stats) String() string {
 }
 
 func (stats Stats) GetHtml(layout, refresh string) string {
-	buffer := bytes.NewBufferString("<html>")
+	return stats.getHtml(layout, refresh, nil, nil)
+}
+
+// statsStyle renders the stats table dark if the browser prefers that and
+// highlights counters which changed since the previous snapshot
+const statsStyle = `<style>` +
+	`@media (prefers-color-scheme: dark) { body { background-color: #1e1e1e; color: #d4d4d4 } }` +
+	`.changed { color: orange; font-weight: bold }` +
+	`</style>`
+
+// getHtml renders the stats as HTML table. If previous is set, counters which
+// changed since then get highlighted. If trends is set, a sparkline of the
+// ready counts in trends gets rendered for each queue.
+func (stats Stats) getHtml(layout, refresh string, previous *Stats, trends map[string][]int64) string {
+	buffer := bytes.NewBufferString("<html><head>")
 
 	if refresh != "" {
-		buffer.WriteString(fmt.Sprintf(`<head><meta http-equiv="refresh" content="%s">`, refresh))
+		buffer.WriteString(fmt.Sprintf(`<meta http-equiv="refresh" content="%s">`, refresh))
+	}
+	buffer.WriteString(statsStyle)
+
+	trendHeader, trendFiller := "", "" // extra column if trends are rendered
+	if trends != nil {
+		trendHeader, trendFiller = `trend</td><td></td><td>`, `</td><td></td><td>`
 	}
 
-	buffer.WriteString(`<body><table style="font-family:monospace">`)
+	buffer.WriteString(`</head><body><table style="font-family:monospace">`)
 	buffer.WriteString(`<tr><td>` +
 		`queue</td><td></td><td>` +
 		`ready</td><td></td><td>` +
+		trendHeader +
 		`rejected</td><td></td><td>` +
 		`</td><td></td><td>` +
 		`connections</td><td></td><td>` +
 		`unacked</td><td></td><td>` +
-		`consumers</td><td></td></tr>`,
+		`consumers</td><td></td><td>` +
+		`feeds</td><td></td></tr>`,
 	)
 
 	for _, queueName := range stats.sortedQueueNames() {
 		queueStat := stats.QueueStats[queueName]
+		var previousStat *QueueStat
+		if previous != nil {
+			if stat, ok := previous.QueueStats[queueName]; ok {
+				previousStat = &stat
+			}
+		}
+		changed := func(count func(QueueStat) int64) string {
+			if previousStat != nil && count(*previousStat) != count(queueStat) {
+				return fmt.Sprintf(`<span class="changed">%d</span>`, count(queueStat))
+			}
+			return fmt.Sprint(count(queueStat))
+		}
+
+		trend := ""
+		if trends != nil {
+			trend = sparkline(trends[queueName]) + `</td><td></td><td>`
+		}
+
 		connectionNames := queueStat.connectionStats.sortedNames()
 		buffer.WriteString(fmt.Sprintf(`<tr><td>`+
 			`%s</td><td></td><td>`+
-			`%d</td><td></td><td>`+
-			`%d</td><td></td><td>`+
+			`%s</td><td></td><td>`+
+			`%s`+
+			`%s</td><td></td><td>`+
 			`%s</td><td></td><td>`+
 			`%d</td><td></td><td>`+
-			`%d</td><td></td><td>`+
-			`%d</td><td></td></tr>`,
-			queueName, queueStat.ReadyCount, queueStat.RejectedCount, "", len(connectionNames), queueStat.UnackedCount(), queueStat.ConsumerCount(),
+			`%s</td><td></td><td>`+
+			`%s</td><td></td><td>`+
+			`%s</td><td></td></tr>`,
+			queueName,
+			changed(func(stat QueueStat) int64 { return stat.ReadyCount }),
+			trend,
+			changed(func(stat QueueStat) int64 { return stat.RejectedCount }),
+			"", len(connectionNames),
+			changed(QueueStat.UnackedCount),
+			changed(QueueStat.ConsumerCount),
+			strings.Join(queueStat.Feeds, ", "),
 		))
 
 		if layout != "condensed" {
@@ -206,12 +555,13 @@ func (stats Stats) GetHtml(layout, refresh string) string {
 				buffer.WriteString(fmt.Sprintf(`<tr style="color:lightgrey"><td>`+
 					`%s</td><td></td><td>`+
 					`%s</td><td></td><td>`+
+					`%s`+
 					`%s</td><td></td><td>`+
 					`%s</td><td></td><td>`+
 					`%s</td><td></td><td>`+
 					`%d</td><td></td><td>`+
 					`%d</td><td></td></tr>`,
-					"", "", "", ActiveSign(connectionStat.active), connectionName, connectionStat.unackedCount, len(connectionStat.consumers),
+					"", "", trendFiller, "", ActiveSign(connectionStat.active), connectionName, connectionStat.unackedCount, len(connectionStat.consumers),
 				))
 			}
 		}
@@ -224,12 +574,13 @@ func (stats Stats) GetHtml(layout, refresh string) string {
 			buffer.WriteString(fmt.Sprintf(`<tr style="color:lightgrey"><td>`+
 				`%s</td><td></td><td>`+
 				`%s</td><td></td><td>`+
+				`%s`+
 				`%s</td><td></td><td>`+
 				`%s</td><td></td><td>`+
 				`%s</td><td></td><td>`+
 				`%s</td><td></td><td>`+
 				`%s</td><td></td></tr>`,
-				"", "", "", ActiveSign(active), connectionName, "", "",
+				"", "", trendFiller, "", ActiveSign(active), connectionName, "", "",
 			))
 		}
 	}
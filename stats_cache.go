@@ -0,0 +1,120 @@
+package rmq
+
+import (
+	"sync"
+	"time"
+)
+
+// StatsCache caches collected stats, so a stats endpoint can be hit by several
+// scrapers without multiplying the load on redis. Callers share one refresh
+// at a time, see CollectStats().
+type StatsCache struct {
+	connection Connection
+	ttl        time.Duration
+	maxAge     time.Duration
+
+	mu               sync.Mutex
+	entries          map[string]*statsCacheEntry // by queue name
+	cleanerStat      CleanerStat
+	otherConnections map[string]bool
+}
+
+type statsCacheEntry struct {
+	stat    QueueStat
+	checked time.Time // when the ready and rejected counts were last loaded
+	loaded  time.Time // when stat was last loaded
+}
+
+// NewStatsCache returns a cache which reloads the ready and rejected counts of
+// a queue once they're older than ttl. All other stats of the queue, like its
+// connections and unacked counts, only get reloaded if those counts changed
+// or the stats are older than maxAge.
+func NewStatsCache(connection Connection, ttl, maxAge time.Duration) *StatsCache {
+	return &StatsCache{
+		connection:       connection,
+		ttl:              ttl,
+		maxAge:           maxAge,
+		entries:          map[string]*statsCacheEntry{},
+		otherConnections: map[string]bool{},
+	}
+}
+
+// CollectStats returns the stats of the given queues like CollectStats(), but
+// serves them from the cache where possible. Concurrent calls wait for each
+// other, so expired stats get reloaded only once.
+func (cache *StatsCache) CollectStats(queueList []string) (Stats, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	var expired []string
+	for _, queueName := range queueList {
+		if entry, ok := cache.entries[queueName]; !ok || now.Sub(entry.checked) >= cache.ttl {
+			expired = append(expired, queueName)
+		}
+	}
+	if len(expired) > 0 {
+		if err := cache.refresh(expired, now); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	stats := NewStats()
+	for _, queueName := range queueList {
+		stats.QueueStats[queueName] = cache.entries[queueName].stat
+	}
+	stats.CleanerStat = cache.cleanerStat
+	for connectionName, active := range cache.otherConnections {
+		stats.otherConnections[connectionName] = active
+	}
+	return stats, nil
+}
+
+// refresh reloads the counts of the given queues and all other stats of the
+// queues whose counts changed
+func (cache *StatsCache) refresh(queueNames []string, now time.Time) error {
+	var changed []string
+	for start := 0; start < len(queueNames); start += defaultStatsBatchSize {
+		end := start + defaultStatsBatchSize
+		if end > len(queueNames) {
+			end = len(queueNames)
+		}
+		readyCounts, rejectedCounts, err := cache.connection.queueCounts(queueNames[start:end])
+		if err != nil {
+			return err
+		}
+		for i, queueName := range queueNames[start:end] {
+			entry, ok := cache.entries[queueName]
+			if !ok || now.Sub(entry.loaded) >= cache.maxAge ||
+				entry.stat.ReadyCount != readyCounts[i] || entry.stat.RejectedCount != rejectedCounts[i] {
+				changed = append(changed, queueName)
+				continue
+			}
+			entry.checked = now
+		}
+	}
+
+	if len(changed) == 0 {
+		cleanerStat, err := cache.connection.cleanerStat()
+		if err != nil {
+			return err
+		}
+		cache.cleanerStat = cleanerStat
+		return nil
+	}
+
+	stats, err := CollectStats(changed, cache.connection)
+	if err != nil {
+		return err
+	}
+	for _, queueName := range changed {
+		cache.entries[queueName] = &statsCacheEntry{
+			stat:    stats.QueueStats[queueName],
+			checked: now,
+			loaded:  now,
+		}
+	}
+	cache.cleanerStat = stats.CleanerStat
+	cache.otherConnections = stats.otherConnections
+	return nil
+}
@@ -0,0 +1,63 @@
+package rmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCache(t *testing.T) {
+	connection, err := OpenConnection("cache-stats-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("cache-stats-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	received, release := make(chan struct{}), make(chan struct{})
+	assert.NoError(t, queue.StartConsuming(1, time.Millisecond))
+	_, err = queue.AddConsumerFunc("cache-stats-cons", func(delivery Delivery) {
+		close(received)
+		<-release
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("cache-stats-d1"))
+	<-received
+
+	queueNames := []string{"cache-stats-q"}
+	cache := NewStatsCache(connection, time.Millisecond, time.Hour)
+	stats, err := cache.CollectStats(queueNames)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stats.QueueStats["cache-stats-q"].ReadyCount)
+	assert.Equal(t, int64(1), stats.QueueStats["cache-stats-q"].UnackedCount())
+	longCache := NewStatsCache(connection, time.Hour, time.Hour)
+	stats, err = longCache.CollectStats(queueNames)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stats.QueueStats["cache-stats-q"].ReadyCount)
+
+	close(release)
+	<-queue.StopConsuming()
+
+	// counts didn't change, so the unacked count doesn't get reloaded
+	time.Sleep(2 * time.Millisecond)
+	stats, err = cache.CollectStats(queueNames)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.QueueStats["cache-stats-q"].UnackedCount())
+
+	assert.NoError(t, queue.Publish("cache-stats-d2"))
+	time.Sleep(2 * time.Millisecond)
+	stats, err = cache.CollectStats(queueNames)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.QueueStats["cache-stats-q"].ReadyCount)
+	assert.Equal(t, int64(0), stats.QueueStats["cache-stats-q"].UnackedCount())
+
+	// counts didn't expire yet
+	stats, err = longCache.CollectStats(queueNames)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stats.QueueStats["cache-stats-q"].ReadyCount)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
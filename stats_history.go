@@ -0,0 +1,89 @@
+package rmq
+
+import (
+	"strings"
+	"sync"
+)
+
+// sparkTicks are the characters used to render sparklines, lowest first
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// StatsHistory keeps the latest stats snapshots in memory, so they can be
+// rendered as a live dashboard. Collect and add a snapshot on every request of
+// an auto refreshing page, see GetHtml().
+type StatsHistory struct {
+	mu        sync.Mutex
+	size      int
+	snapshots []Stats // oldest first
+}
+
+// NewStatsHistory returns a history which keeps at most size snapshots
+func NewStatsHistory(size int) *StatsHistory {
+	return &StatsHistory{size: size}
+}
+
+// Add adds a snapshot to the history, dropping the oldest one if the history
+// is full
+func (history *StatsHistory) Add(stats Stats) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	history.snapshots = append(history.snapshots, stats)
+	if len(history.snapshots) > history.size {
+		history.snapshots = history.snapshots[len(history.snapshots)-history.size:]
+	}
+}
+
+// GetHtml renders the latest snapshot like Stats.GetHtml(), but highlights
+// the counters which changed since the previous snapshot and adds a sparkline
+// of each queue's ready count across all snapshots
+func (history *StatsHistory) GetHtml(layout, refresh string) string {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	if len(history.snapshots) == 0 {
+		return NewStats().getHtml(layout, refresh, nil, map[string][]int64{})
+	}
+
+	trends := map[string][]int64{}
+	for _, snapshot := range history.snapshots {
+		for queueName, queueStat := range snapshot.QueueStats {
+			trends[queueName] = append(trends[queueName], queueStat.ReadyCount)
+		}
+	}
+
+	latest := history.snapshots[len(history.snapshots)-1]
+	if len(history.snapshots) == 1 {
+		return latest.getHtml(layout, refresh, nil, trends)
+	}
+	previous := history.snapshots[len(history.snapshots)-2]
+	return latest.getHtml(layout, refresh, &previous, trends)
+}
+
+// sparkline renders the given counts as line of unicode block characters,
+// scaled between their minimum and maximum
+func sparkline(counts []int64) string {
+	if len(counts) == 0 {
+		return ""
+	}
+
+	min, max := counts[0], counts[0]
+	for _, count := range counts {
+		if count < min {
+			min = count
+		}
+		if count > max {
+			max = count
+		}
+	}
+
+	var builder strings.Builder
+	for _, count := range counts {
+		tick := 0
+		if max > min {
+			tick = int((count - min) * int64(len(sparkTicks)-1) / (max - min))
+		}
+		builder.WriteRune(sparkTicks[tick])
+	}
+	return builder.String()
+}
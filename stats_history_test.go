@@ -0,0 +1,36 @@
+package rmq
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsHistory(t *testing.T) {
+	history := NewStatsHistory(3)
+	html := history.GetHtml("", "5")
+	assert.Contains(t, html, `<meta http-equiv="refresh" content="5">`)
+	assert.Contains(t, html, "prefers-color-scheme: dark")
+	assert.Contains(t, html, "trend")
+
+	for _, ready := range []int64{10, 0, 5, 10} {
+		stats := NewStats()
+		stats.QueueStats["history-q1"] = NewQueueStat(ready, 2)
+		history.Add(stats)
+	}
+
+	html = history.GetHtml("", "")
+	// the first snapshot got dropped
+	assert.Contains(t, html, "<td>▁▄█</td>")
+	// ready changed, rejected didn't
+	assert.Contains(t, html, `<td><span class="changed">10</span></td>`)
+	assert.Contains(t, html, `<td>2</td>`)
+	assert.NotContains(t, html, `<span class="changed">2</span>`)
+}
+
+func TestSparkline(t *testing.T) {
+	assert.Equal(t, "", sparkline(nil))
+	assert.Equal(t, "▁▁", sparkline([]int64{3, 3}))
+	assert.Equal(t, "▁▂▃▄▅▆▇█", sparkline([]int64{0, 1, 2, 3, 4, 5, 6, 7}))
+	assert.Equal(t, "█▁▄", sparkline([]int64{100, 0, 50}))
+}
@@ -1,9 +1,12 @@
 package rmq
 
 import (
+	"fmt"
+	"math"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -72,3 +75,211 @@ func TestStats(t *testing.T) {
 	assert.NoError(t, conn1.stopHeartbeat())
 	assert.NoError(t, conn2.stopHeartbeat())
 }
+
+func TestBufferStats(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.HeartbeatInterval = time.Millisecond
+	connection, err := OpenConnectionWithOptions("buffer-stats-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("buffer-stats-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.StartConsuming(4, time.Millisecond))
+	release := make(chan struct{})
+	_, err = queue.AddConsumerFunc("buffer-stats-cons", func(delivery Delivery) {
+		<-release
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("d1", "d2", "d3", "d4", "d5"))
+	time.Sleep(10 * time.Millisecond)
+
+	stats, err := CollectStats([]string{"buffer-stats-q"}, connection)
+	assert.NoError(t, err)
+	queueStat := stats.QueueStats["buffer-stats-q"]
+	assert.Equal(t, 0.75, queueStat.BufferFillRatio()) // 3 of 4 waiting, 1 being consumed
+	assert.True(t, queueStat.BlockedDuration() > 0)
+
+	close(release)
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestConcurrencyStats(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.HeartbeatInterval = time.Millisecond
+	connection, err := OpenConnectionWithOptions("concurrency-stats-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("concurrency-stats-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	stats, err := CollectStats([]string{"concurrency-stats-q"}, connection)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0), stats.QueueStats["concurrency-stats-q"].Backlog())
+
+	assert.NoError(t, queue.Publish("c1", "c2", "c3", "c4", "c5", "c6", "c7", "c8", "c9", "c10", "c11", "c12"))
+	stats, err = CollectStats([]string{"concurrency-stats-q"}, connection)
+	assert.NoError(t, err)
+	assert.True(t, math.IsInf(stats.QueueStats["concurrency-stats-q"].Backlog(), 1)) // no consumers
+
+	assert.NoError(t, queue.StartConsuming(4, time.Millisecond))
+	_, err = queue.AddConsumerFunc("concurrency-stats-cons", func(delivery Delivery) {})
+	assert.NoError(t, err)
+	_, err = queue.AddBatchConsumer("concurrency-stats-batch", 5, time.Second, NewTestBatchConsumer())
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	stats, err = CollectStats([]string{"concurrency-stats-q"}, connection)
+	assert.NoError(t, err)
+	queueStat := stats.QueueStats["concurrency-stats-q"]
+	assert.Equal(t, int64(6), queueStat.Concurrency()) // 1 + batch size
+	assert.Equal(t, int64(4), queueStat.PrefetchLimit())
+	assert.Equal(t, float64(2), queueStat.Backlog()) // 12 waiting, 6 at once
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestResetStats(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.HeartbeatInterval = time.Millisecond
+	connection, err := OpenConnectionWithOptions("reset-stats-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("reset-stats-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, queue.ResetStats())
+
+	assert.NoError(t, queue.StartConsuming(2, time.Millisecond))
+	release := make(chan struct{})
+	_, err = queue.AddConsumerFunc("reset-stats-cons", func(delivery Delivery) {
+		<-release
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("r1", "r2", "r3"))
+	time.Sleep(10 * time.Millisecond)
+
+	fetched, err := queue.fetchedCount()
+	assert.NoError(t, err)
+	assert.True(t, fetched >= 2)
+	stats, err := CollectStats([]string{"reset-stats-q"}, connection)
+	assert.NoError(t, err)
+	blocked := stats.QueueStats["reset-stats-q"].BlockedDuration()
+	assert.True(t, blocked > 0)
+
+	assert.NoError(t, queue.ResetStats())
+	fetched, err = queue.fetchedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), fetched)
+	stats, err = CollectStats([]string{"reset-stats-q"}, connection)
+	assert.NoError(t, err)
+	assert.True(t, stats.QueueStats["reset-stats-q"].BlockedDuration() < blocked)
+
+	// both resets got recorded, newest first
+	resets, err := queue.StatsResets()
+	assert.NoError(t, err)
+	require.Len(t, resets, 2)
+	for _, reset := range resets {
+		assert.Equal(t, connection.(*redisConnection).Name, reset.Connection)
+	}
+	assert.False(t, resets[0].Time.Before(resets[1].Time))
+
+	close(release)
+	<-queue.StopConsuming()
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	resets, err = queue.StatsResets()
+	assert.NoError(t, err)
+	assert.Empty(t, resets)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestHandlerDurations(t *testing.T) {
+	redisClient := NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}))
+	options := TestOptions
+	options.HeartbeatInterval = time.Millisecond
+	connection, err := OpenConnectionWithOptions("durations-conn", redisClient, nil, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("durations-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumerFunc("durations-cons", func(delivery Delivery) {
+		if delivery.Payload() == "slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("fast", "fast", "fast", "fast", "fast", "fast", "fast", "fast", "slow", "slow"))
+	time.Sleep(80 * time.Millisecond)
+
+	stats, err := CollectStats([]string{"durations-q"}, connection)
+	assert.NoError(t, err)
+	queueStat := stats.QueueStats["durations-q"]
+	assert.True(t, queueStat.HandlerDuration(0.5) < 10*time.Millisecond, "p50 %s", queueStat.HandlerDuration(0.5))
+	assert.True(t, queueStat.HandlerDuration(0.95) >= 19*time.Millisecond, "p95 %s", queueStat.HandlerDuration(0.95))
+
+	<-queue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestCollectStatsWithOptions(t *testing.T) {
+	connection, err := OpenConnection("options-stats-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queueNames := []string{"options-stats-q1", "options-stats-q2", "options-stats-q3", "options-stats-q4", "options-stats-q5"}
+	for i, queueName := range queueNames {
+		queue, err := connection.OpenQueue(queueName)
+		require.NoError(t, err)
+		_, err = queue.PurgeReady()
+		assert.NoError(t, err)
+		for j := 0; j <= i; j++ {
+			assert.NoError(t, queue.Publish("options-stats-d"))
+		}
+	}
+	consumingQueue, err := connection.OpenQueue("options-stats-q1")
+	require.NoError(t, err)
+	assert.NoError(t, consumingQueue.StartConsuming(10, time.Millisecond))
+	_, err = consumingQueue.AddConsumer("options-stats-cons", NewTestConsumer("options-stats-A"))
+	assert.NoError(t, err)
+
+	// batches of two queues, three connections at a time
+	stats, err := CollectStatsWithOptions(queueNames, connection, StatsOptions{BatchSize: 2, Concurrency: 3})
+	assert.NoError(t, err)
+	require.Len(t, stats.QueueStats, len(queueNames))
+	for i, queueName := range queueNames[1:] {
+		assert.Equal(t, int64(i+2), stats.QueueStats[queueName].ReadyCount, queueName)
+		assert.Equal(t, int64(0), stats.QueueStats[queueName].ConsumerCount(), queueName)
+	}
+	assert.Equal(t, int64(1), stats.QueueStats["options-stats-q1"].ConsumerCount())
+
+	// many members get scanned in several steps
+	redisConnection := connection.(*redisConnection)
+	key := "options-stats-set"
+	_, err = redisConnection.redisClient.Del(key)
+	assert.NoError(t, err)
+	expected := make([]string, 0, 3*scanCount)
+	for i := 0; i < 3*scanCount; i++ {
+		member := fmt.Sprintf("options-stats-m%d", i)
+		_, err = redisConnection.redisClient.SAdd(key, member)
+		assert.NoError(t, err)
+		expected = append(expected, member)
+	}
+	members, err := redisConnection.scanSet(key)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expected, members)
+
+	<-consumingQueue.StopConsuming()
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,114 @@
+package rmq
+
+import (
+	"math/rand"
+	"time"
+)
+
+const stealLockDuration = 10 * time.Second // TTL of the work stealing lock
+
+// EnableWorkStealing makes this queue share its prefetched deliveries with
+// idle connections consuming the same queue. When the consumers of this
+// connection can't keep up (prefetch limit reached) while the consumers of
+// another connection are idle (no prefetched deliveries and ready list
+// empty), half of the prefetched deliveries get handed off to the idle
+// connection (see HandoffUnacked). This smoothes out skew caused by slow
+// consumers. It must be enabled on all connections which should take part.
+// Must be called before StartConsuming().
+func (queue *redisQueue) EnableWorkStealing() {
+	queue.workStealing = true
+}
+
+// setIdle adds this connection to the set of idle connections of this queue
+// or removes it from there
+func (queue *redisQueue) setIdle(idle bool) error {
+	if idle == queue.idle {
+		return nil
+	}
+
+	var err error
+	if idle {
+		_, err = queue.redisClient.SAdd(queue.idleKey, queue.connectionName)
+	} else {
+		_, err = queue.redisClient.SRem(queue.idleKey, queue.connectionName)
+	}
+	if err != nil {
+		return err
+	}
+
+	queue.idle = idle
+	return nil
+}
+
+// shareWork hands off half of the prefetched deliveries to one of the idle
+// connections of this queue, if there are any
+func (queue *redisQueue) shareWork() error {
+	buffered := len(queue.deliveryChan)
+	if buffered < 2 {
+		return nil // not worth it
+	}
+
+	connectionNames, err := queue.redisClient.SMembers(queue.idleKey)
+	if err != nil {
+		return err
+	}
+	idleNames := connectionNames[:0]
+	for _, connectionName := range connectionNames {
+		if connectionName != queue.connectionName {
+			idleNames = append(idleNames, connectionName)
+		}
+	}
+	if len(idleNames) == 0 {
+		return nil
+	}
+
+	// make sure only one connection hands off work at a time
+	locked, err := queue.redisClient.SetNX(queue.stealKey, queue.connectionName, stealLockDuration)
+	if err != nil || !locked {
+		return err
+	}
+	defer queue.redisClient.Del(queue.stealKey)
+
+	idleName := idleNames[rand.Intn(len(idleNames))]
+	switch err := queue.checkHandoffTarget(idleName); err {
+	case nil:
+	case ErrorNotFound: // stale entry
+		_, err := queue.redisClient.SRem(queue.idleKey, idleName)
+		return err
+	default:
+		return err
+	}
+
+	if err := queue.handoffBuffered(queueHandoffKey(idleName, queue.name), buffered/2); err != nil {
+		return err
+	}
+
+	// the idle connection is busy now
+	_, err = queue.redisClient.SRem(queue.idleKey, idleName)
+	return err
+}
+
+// handoffBuffered moves up to n prefetched deliveries to the given handoff list
+func (queue *redisQueue) handoffBuffered(handoffKey string, n int) error {
+	for i := 0; i < n; i++ {
+		var delivery *redisDelivery
+		select {
+		case d := <-queue.deliveryChan:
+			delivery = d.(*redisDelivery)
+		default: // consumers took the rest
+			return nil
+		}
+
+		// push to handoff list before removing from unacked, so a crash in
+		// between leads to double delivery instead of a lost delivery
+		if _, err := queue.redisClient.LPush(handoffKey, delivery.payload); err != nil {
+			queue.deliveryChan <- delivery // we just made room for it
+			return err
+		}
+		queue.journalRemove(delivery)
+		if _, err := queue.redisClient.LRem(queue.unackedKey, 1, delivery.payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
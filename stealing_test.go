@@ -0,0 +1,60 @@
+package rmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkStealing(t *testing.T) {
+	busyConn, err := OpenConnection("steal-busy", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	busyQueue, err := busyConn.OpenQueue("steal-q")
+	assert.NoError(t, err)
+	_, err = busyQueue.PurgeReady()
+	assert.NoError(t, err)
+	busyQueue.EnableWorkStealing()
+	assert.NoError(t, busyQueue.StartConsuming(10, time.Millisecond))
+
+	slowConsumer := NewTestConsumer("steal-slow")
+	slowConsumer.AutoAck = false
+	slowConsumer.AutoFinish = false
+	_, err = busyQueue.AddConsumer("steal-slow", slowConsumer)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, busyQueue.Publish("steal-d"))
+	}
+	time.Sleep(10 * time.Millisecond)
+	count, err := busyQueue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), count)
+
+	idleConn, err := OpenConnection("steal-idle", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	idleQueue, err := idleConn.OpenQueue("steal-q")
+	assert.NoError(t, err)
+	idleQueue.EnableWorkStealing()
+	assert.NoError(t, idleQueue.StartConsuming(10, time.Millisecond))
+	idleConsumer := NewTestConsumer("steal-idle")
+	_, err = idleQueue.AddConsumer("steal-idle", idleConsumer)
+	assert.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	// 9 deliveries were buffered, the idle connection got half of them
+	assert.Len(t, idleConsumer.LastDeliveries, 4)
+	count, err = busyQueue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+	count, err = idleQueue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	slowConsumer.Finish()
+	assert.NoError(t, slowConsumer.LastDelivery.Ack())
+	<-idleQueue.StopConsuming()
+	busyQueue.StopConsuming()
+	assert.NoError(t, busyConn.stopHeartbeat())
+	assert.NoError(t, idleConn.stopHeartbeat())
+}
@@ -0,0 +1,343 @@
+package rmq
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// streamGroup is the consumer group connections read streams as, each of
+	// them as the consumer named like the connection
+	streamGroup = "rmq"
+	// max number of pending entries returned to the stream at once
+	streamReturnBatchSize = int64(100)
+)
+
+// StreamBackend is a Backend which can also keep queues in streams, see
+// WithStream(). The operations are modeled after the redis stream commands
+// of the same name:
+//   - Stream entries hold a single value. Their IDs grow in the order they got
+//     added, XADD with a generated ID does that in redis.
+//   - Entries get read by consumers of a group. Read entries are pending for
+//     their consumer until they get acked, later reads of the group don't
+//     return them again. Operations on streams or groups which don't exist
+//     behave as on empty ones.
+//   - The compound operations (XAckDel, XAckDelLPush, RPopXAdd, XReturn,
+//     XReturnUnless) must be atomic like the ones of Backend.
+//
+// RedisWrapper implements StreamBackend on top of redis 5 or later and
+// TestRedisClient in memory. testsupport.RunBackendConformance() tests it
+// for backends implementing it.
+type StreamBackend interface {
+	Backend
+
+	// XAdd appends an entry for each value to the stream stored at key, in
+	// the given order
+	XAdd(key string, value ...string) error
+	// XLen returns the number of entries of the stream stored at key,
+	// including the pending ones
+	XLen(key string) (length int64, err error)
+	// XReadGroup returns up to count entries of the stream stored at key
+	// which group didn't read yet, oldest first, and makes them pending for
+	// consumer. Creates the group if it doesn't exist yet.
+	XReadGroup(key, group, consumer string, count int64) (entries []StreamEntry, err error)
+	// XPending returns the IDs of up to count entries pending for consumer,
+	// oldest first
+	XPending(key, group, consumer string, count int64) (ids []string, err error)
+	// XPendingCount returns the number of entries pending for consumer, or
+	// for all consumers of group if consumer is empty
+	XPendingCount(key, group, consumer string) (count int64, err error)
+	// XAckDel acks and deletes the entry with the given ID if it's pending.
+	// Returns the number of acked entries.
+	XAckDel(key, group, id string) (affected int64, err error)
+	// XAckDelLPush is like XAckDel, but also inserts pushValue at the head of
+	// the list stored at pushKey if the entry was pending
+	XAckDelLPush(key, group, id, pushKey, pushValue string) (affected int64, err error)
+	// RPopXAdd removes the last value (tail) of the list stored at source and
+	// appends it as entry to the stream stored at key. Returns ErrorNotFound
+	// if source is empty.
+	RPopXAdd(source, key string) (value string, err error)
+	// XReturn appends the values of the pending entries with the given IDs as
+	// new entries and acks and deletes the pending ones, so the group reads
+	// them again. Skips entries which aren't pending. Returns the number of
+	// returned entries.
+	XReturn(key, group string, ids ...string) (returned int64, err error)
+	// XReturnUnless is like XReturn, but checks that guardKey doesn't exist
+	// first. If it does nothing gets returned and guarded is true.
+	XReturnUnless(key, group, guardKey string, ids ...string) (returned int64, guarded bool, err error)
+	// XDelConsumer removes consumer from group, entries still pending for it
+	// stay in the stream without being pending anymore
+	XDelConsumer(key, group, consumer string) error
+}
+
+// StreamEntry is an entry of a stream as returned by StreamBackend.XReadGroup()
+type StreamEntry struct {
+	ID    string
+	Value string
+}
+
+// WithStream makes the queue keep its ready and unacked deliveries in a redis
+// stream instead of lists. Publish() appends them via XADD, consumers read
+// them via XREADGROUP (all connections as the consumer group "rmq") and
+// Ack() removes them via XACK and XDEL. The unacked deliveries of a
+// connection are its pending entries, which ReturnUnacked() and the cleaner
+// append to the stream again, so they get consumed after the deliveries
+// which are ready already. Rejected deliveries go to the rejected list as
+// usual and ReturnRejected() appends them to the stream.
+// Once a queue got opened with this option it keeps its deliveries in the
+// stream for all connections, also ones opening it without the option.
+// Deliveries which were ready in its list before stay there.
+// Options and operations which need the deliveries in lists, like
+// WithPriorities(), WithRetryPolicy(), PeekReady() or AckAndPublish() to a
+// queue opened with this option, return ErrorStreamMixed. The backend must
+// implement StreamBackend, otherwise OpenQueue() returns ErrorNoStreams.
+func WithStream() QueueOption {
+	return func(queue *redisQueue) {
+		queue.streamKey = queueStreamKey(queue.name)
+	}
+}
+
+func queueStreamKey(queueName string) string {
+	return strings.Replace(queueStreamTemplate, phQueue, queueName, 1)
+}
+
+// isStreamQueue returns whether the queue got opened with WithStream()
+// NOTE: panics if queue is not a *redisQueue
+func isStreamQueue(queue Queue) bool {
+	return queue.(*redisQueue).streamKey != ""
+}
+
+// loadStream makes the queue use its stream if it got opened with
+// WithStream() on any connection, see streamQueuesKey. OpenQueue() loads it
+// right away, queue handles of the cleaner and stats when they need it.
+func (queue *redisQueue) loadStream() error {
+	if queue.streamLoaded {
+		return nil
+	}
+	if queue.streamKey == "" {
+		streamQueues, err := queue.redisClient.SMembers(streamQueuesKey)
+		if err != nil {
+			return err
+		}
+		if !containsString(streamQueues, queue.name) {
+			queue.streamLoaded = true
+			return nil
+		}
+		queue.streamKey = queueStreamKey(queue.name)
+	}
+
+	streams, ok := queue.redisClient.(StreamBackend)
+	if !ok {
+		return ErrorNoStreams
+	}
+	queue.streams = streams
+	queue.streamLoaded = true
+	return nil
+}
+
+// registerStream adds the queue to the queues opened with WithStream(), if it
+// is one of them
+func (queue *redisQueue) registerStream() error {
+	if queue.streams == nil {
+		return nil
+	}
+	_, err := queue.redisClient.SAdd(streamQueuesKey, queue.name)
+	return err
+}
+
+// checkList returns ErrorStreamMixed if the queue keeps its deliveries in its
+// stream, for operations which need them in lists
+func (queue *redisQueue) checkList() error {
+	if err := queue.loadStream(); err != nil {
+		return err
+	}
+	if queue.streams != nil {
+		return ErrorStreamMixed
+	}
+	return nil
+}
+
+// checkStreamOptions returns ErrorStreamMixed if the queue keeps its
+// deliveries in its stream but got opened with options which need lists, or
+// if it got opened with options which move deliveries to the ready lists of
+// queues opened with WithStream()
+func (queue *redisQueue) checkStreamOptions() error {
+	if queue.streamTargets {
+		return ErrorStreamMixed
+	}
+	if queue.streams == nil {
+		return nil
+	}
+	if queue.priorities != nil || queue.tenants != nil || len(queue.siblingReadyKeys) > 0 ||
+		queue.fallbackClient != nil || queue.spool != nil || queue.journal != nil ||
+		queue.retryPolicy != nil || queue.errorPolicy != nil || queue.dryRun || queue.trail ||
+		queue.purgeUndo > 0 || queue.overflowPolicy == ReturnOnOverflow {
+		return ErrorStreamMixed
+	}
+	return nil
+}
+
+// consumeStream reads up to batchSize deliveries from the stream and passes
+// them to the consumers, like consumeBatch() does with the ready list
+func (queue *redisQueue) consumeStream(batchSize int64) error {
+	if queue.rateInterval > 0 {
+		batchSize = 1 // the rate limit applies per delivery
+	}
+	if !queue.waitForRateLimit() {
+		return ErrorConsumingStopped
+	}
+
+	entries, err := queue.streams.XReadGroup(queue.streamKey, streamGroup, queue.connectionName, batchSize)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		// no ready deliveries, wait for new ones
+		queue.sleep()
+		return nil
+	}
+	queue.fetched += int64(len(entries))
+
+	for i, entry := range entries {
+		delivery := queue.newStreamDelivery(entry)
+		if !queue.decode(delivery) {
+			continue
+		}
+		select {
+		case queue.deliveryChan <- delivery:
+			continue
+		default: // buffer full, wait for consumers below
+		}
+
+		blockedSince := time.Now()
+		queue.endTurn()
+		select {
+		case queue.deliveryChan <- delivery:
+			queue.blockedDuration += time.Since(blockedSince)
+			queue.takeTurn()
+		case <-queue.consumingStopped:
+			queue.takeTurn()
+			// with LeaveOnStop the entries remain pending, the cleaner will return them
+			if queue.stopPolicy != LeaveOnStop {
+				ids := make([]string, 0, len(entries)-i)
+				for _, entry := range entries[i:] {
+					ids = append(ids, entry.ID)
+				}
+				if _, err := queue.streams.XReturn(queue.streamKey, streamGroup, ids...); err != nil {
+					return err
+				}
+			}
+			return ErrorConsumingStopped
+		}
+	}
+	return nil
+}
+
+// fetchStream reads the next ready delivery from the stream, see
+// ConsumeOne(). Returns ErrorNotFound if there is none.
+func (queue *redisQueue) fetchStream() (*redisDelivery, error) {
+	entries, err := queue.streams.XReadGroup(queue.streamKey, streamGroup, queue.connectionName, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrorNotFound
+	}
+	return queue.newStreamDelivery(entries[0]), nil
+}
+
+func (queue *redisQueue) newStreamDelivery(entry StreamEntry) *redisDelivery {
+	delivery := queue.newDelivery(entry.Value)
+	delivery.streams = queue.streams
+	delivery.streamKey = queue.streamKey
+	delivery.streamID = entry.ID
+	return delivery
+}
+
+// returnStream appends up to max entries pending for this connection to the
+// stream again and returns how many it returned. If guardKey is not empty
+// each batch atomically checks that it doesn't exist, like moveCleaned()
+// does with the heartbeat, and returns errorConnectionAlive otherwise.
+func (queue *redisQueue) returnStream(max int64, guardKey string) (n int64, err error) {
+	for n < max {
+		count := streamReturnBatchSize
+		if max-n < count {
+			count = max - n
+		}
+		ids, err := queue.streams.XPending(queue.streamKey, streamGroup, queue.connectionName, count)
+		if err != nil {
+			return n, err
+		}
+		if len(ids) == 0 { // nothing left
+			return n, nil
+		}
+
+		var returned int64
+		if guardKey == "" {
+			returned, err = queue.streams.XReturn(queue.streamKey, streamGroup, ids...)
+		} else {
+			var guarded bool
+			returned, guarded, err = queue.streams.XReturnUnless(queue.streamKey, streamGroup, guardKey, ids...)
+			if err == nil && guarded {
+				return n, errorConnectionAlive
+			}
+		}
+		n += returned
+		if err != nil {
+			return n, err
+		}
+		if returned == 0 { // acked in the meantime, maybe by another cleaner
+			return n, nil
+		}
+	}
+	return n, nil
+}
+
+// returnRejectedStream appends up to max rejected deliveries to the stream
+// and returns how many it returned, see ReturnRejected()
+func (queue *redisQueue) returnRejectedStream(max int64) (n int64, err error) {
+	for n = 0; n < max; n++ {
+		switch _, err := queue.streams.RPopXAdd(queue.rejectedKey, queue.streamKey); err {
+		case nil: // returned one
+		case ErrorNotFound: // nothing left
+			return n, nil
+		default:
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// streamCount returns the number of ready deliveries in the stream, zero if
+// the queue doesn't keep its deliveries in a stream. Pending entries don't
+// count, they are unacked.
+func (queue *redisQueue) streamCount() (int64, error) {
+	if err := queue.loadStream(); err != nil || queue.streams == nil {
+		return 0, err
+	}
+	length, err := queue.streams.XLen(queue.streamKey)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := queue.streams.XPendingCount(queue.streamKey, streamGroup, "")
+	if err != nil {
+		return 0, err
+	}
+	return length - pending, nil
+}
+
+// destroyStream deletes the stream, including its pending entries, and
+// returns how many ready deliveries it had, see Destroy()
+func (queue *redisQueue) destroyStream() (int64, error) {
+	count, err := queue.streamCount()
+	if err != nil || queue.streams == nil {
+		return 0, err
+	}
+	if _, err := queue.redisClient.Del(queue.streamKey); err != nil {
+		return 0, err
+	}
+	if _, err := queue.redisClient.SRem(streamQueuesKey, queue.name); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
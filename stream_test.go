@@ -0,0 +1,197 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	connection, err := OpenConnection("stream-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("stream-q", WithStream())
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.NoError(t, err)
+	queue, err = connection.OpenQueue("stream-q", WithStream())
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("s1", "s2", "s3", "s4"))
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	consumer := NewTestConsumer("stream-consumer")
+	assert.NoError(t, queue.StartConsuming(2, time.Millisecond))
+	_, err = queue.AddConsumer("stream-consumer", consumer)
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	<-queue.StopConsuming()
+	require.Len(t, consumer.LastDeliveries, 4)
+	for i, payload := range []string{"s1", "s2", "s3", "s4"} {
+		assert.Equal(t, payload, consumer.LastDeliveries[i].Payload())
+	}
+
+	assert.NoError(t, queue.Publish("s5", "s6", "s7", "s8"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, delivery.Ack())
+	delivery, err = queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, delivery.Reject())
+	_, err = queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+
+	// s7 is pending, s6 rejected
+	stats, err := connection.CollectStats([]string{"stream-q"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.QueueStats["stream-q"].ReadyCount)
+	assert.Equal(t, int64(1), stats.QueueStats["stream-q"].RejectedCount)
+	assert.Equal(t, int64(1), stats.QueueStats["stream-q"].UnackedCount())
+
+	returned, err := queue.ReturnUnacked(10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), returned)
+	returned, err = queue.ReturnRejected(10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), returned)
+	count, err = queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// returned deliveries get consumed after the ready ones
+	var payloads []string
+	for i := 0; i < 3; i++ {
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		payloads = append(payloads, delivery.Payload())
+		assert.NoError(t, delivery.Ack())
+	}
+	assert.Equal(t, []string{"s8", "s7", "s6"}, payloads)
+	empty, err := queue.(*redisQueue).isEmpty()
+	assert.NoError(t, err)
+	assert.True(t, empty)
+
+	assert.NoError(t, queue.Publish("s9"))
+	readyCount, _, err := queue.Destroy()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), readyCount)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestStreamCleaned(t *testing.T) {
+	connection, err := OpenConnection("stream-dead-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("stream-cleaned-q", WithStream())
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.NoError(t, err)
+	queue, err = connection.OpenQueue("stream-cleaned-q", WithStream())
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("c1", "c2", "c3"))
+	for i := 0; i < 2; i++ {
+		_, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+	}
+
+	// queues opened without the option keep using the stream
+	otherConnection, err := OpenConnection("stream-other-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	otherQueue, err := otherConnection.OpenQueue("stream-cleaned-q")
+	require.NoError(t, err)
+	cleaner := NewCleaner(otherConnection)
+	_, err = cleaner.Clean()
+	assert.NoError(t, err)
+	unacked, err := queue.unackedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), unacked) // the connection is alive
+
+	// the connection dies with c1 and c2 pending
+	assert.NoError(t, connection.stopHeartbeat())
+	_, err = cleaner.Clean()
+	assert.NoError(t, err)
+	count, err := otherQueue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	var payloads []string
+	for i := 0; i < 3; i++ {
+		delivery, err := otherQueue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		payloads = append(payloads, delivery.Payload())
+		assert.NoError(t, delivery.Ack())
+	}
+	assert.Equal(t, []string{"c3", "c1", "c2"}, payloads)
+
+	_, _, err = otherQueue.Destroy()
+	assert.NoError(t, err)
+	assert.NoError(t, otherConnection.stopHeartbeat())
+}
+
+func TestStreamMixed(t *testing.T) {
+	connection, err := OpenConnection("stream-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("stream-mixed-q", WithStream())
+	require.NoError(t, err)
+	listQueue, err := connection.OpenQueue("stream-list-q")
+	require.NoError(t, err)
+
+	_, err = connection.OpenQueue("stream-mixed-q", WithPriorities())
+	assert.Equal(t, ErrorStreamMixed, err)
+	_, err = connection.OpenQueue("stream-mixed-q", WithStream(), WithRetryPolicy(RetryPolicy{MaxRetries: 3}))
+	assert.Equal(t, ErrorStreamMixed, err)
+	_, err = connection.OpenQueue("stream-list-q", WithDeadLetter(queue))
+	assert.Equal(t, ErrorStreamMixed, err)
+	_, err = connection.OpenQueue("stream-list-q", WithErrorPolicy(ErrorPolicy{ParkQueue: queue}))
+	assert.Equal(t, ErrorStreamMixed, err)
+
+	_, err = queue.PeekReady(10)
+	assert.Equal(t, ErrorStreamMixed, err)
+	assert.Equal(t, ErrorStreamMixed, queue.PublishMarker("marker"))
+	assert.Equal(t, ErrorStreamMixed, queue.PublishAt(time.Now(), "later"))
+	_, err = queue.PurgeReady()
+	assert.Equal(t, ErrorStreamMixed, err)
+	_, _, _, err = NewRetrier(queue, nil, func(string) RetryDecision { return RetryDelivery }).Retry(10)
+	assert.Equal(t, ErrorStreamMixed, err)
+
+	assert.NoError(t, queue.Publish("m1", "m2"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, ErrorStreamMixed, delivery.(*redisDelivery).delay(time.Minute, true))
+	// stream deliveries can be moved to queues keeping them in lists
+	assert.NoError(t, delivery.AckAndPublish(listQueue, "moved"))
+	messages, err := listQueue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "moved", messages[0].Payload)
+
+	// but deliveries can't be moved to queues keeping them in streams
+	assert.NoError(t, listQueue.Publish("l1"))
+	delivery, err = listQueue.ConsumeOne(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, ErrorStreamMixed, delivery.AckAndPublish(queue, "moved"))
+	NewMover(queue, func(delivery Delivery) (string, error) { return delivery.Payload(), nil }, 0).Consume(delivery)
+	rejected, err := listQueue.rejectedCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rejected)
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	_, _, err = listQueue.Destroy()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestStreamNotSupported(t *testing.T) {
+	connection, err := OpenConnectionWithRmqRedisClient("stream-conn", refusingClient{NewTestRedisClient()}, nil)
+	require.NoError(t, err)
+	_, err = connection.OpenQueue("stream-q", WithStream())
+	assert.Equal(t, ErrorNoStreams, err)
+	queue, err := connection.OpenQueue("stream-q")
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("l1"))
+}
@@ -0,0 +1,67 @@
+package rmq
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// SetTags replaces the tags of this queue, for example {"team": "payments",
+// "tier": "critical"}. Tags get stored in redis, so all connections see them.
+// They are included in the stats (see QueueStat.Tags), so dashboards and
+// exporters can group queues by them, see Stats.GroupByTag(). Pass no tags
+// to remove them.
+func (queue *redisQueue) SetTags(tags map[string]string) error {
+	if len(tags) == 0 {
+		_, err := queue.redisClient.Del(queue.tagsKey)
+		return err
+	}
+
+	bytes, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return queue.redisClient.Set(queue.tagsKey, string(bytes), 0)
+}
+
+// Tags returns the tags of this queue, see SetTags(). Returns nil if the
+// queue has no tags.
+func (queue *redisQueue) Tags() (map[string]string, error) {
+	value, err := queue.redisClient.Get(queue.tagsKey)
+	if err == ErrorNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tags map[string]string
+	if err := json.Unmarshal([]byte(value), &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// GroupByTag returns the stats of the queues grouped by the value of the
+// given tag, see Queue.SetTags(). Queues without the tag are grouped under
+// the empty value.
+func (stats Stats) GroupByTag(tag string) map[string]QueueStats {
+	groups := map[string]QueueStats{}
+	for queueName, queueStat := range stats.QueueStats {
+		value := queueStat.Tags[tag]
+		if groups[value] == nil {
+			groups[value] = QueueStats{}
+		}
+		groups[value][queueName] = queueStat
+	}
+	return groups
+}
+
+// sortedTags returns the tags formatted as key=value, sorted by key
+func sortedTags(tags map[string]string) []string {
+	formatted := make([]string, 0, len(tags))
+	for key, value := range tags {
+		formatted = append(formatted, key+"="+value)
+	}
+	sort.Strings(formatted)
+	return formatted
+}
@@ -0,0 +1,58 @@
+package rmq
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTags(t *testing.T) {
+	connection, err := OpenConnection("tags-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	payments, err := connection.OpenQueue("tags-payments")
+	require.NoError(t, err)
+	untagged, err := connection.OpenQueue("tags-untagged")
+	require.NoError(t, err)
+	require.NoError(t, untagged.SetTags(nil))
+
+	tags, err := untagged.Tags()
+	assert.NoError(t, err)
+	assert.Nil(t, tags)
+
+	assert.NoError(t, payments.SetTags(map[string]string{"team": "payments", "tier": "critical"}))
+	tags, err = payments.Tags()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "tier": "critical"}, tags)
+
+	stats, err := CollectStats([]string{"tags-payments", "tags-untagged"}, connection)
+	require.NoError(t, err)
+	assert.Equal(t, tags, stats.QueueStats["tags-payments"].Tags)
+	assert.Contains(t, stats.String(), "tags:team=payments,tier=critical\n")
+	bytes, err := json.Marshal(stats.QueueStats["tags-payments"])
+	assert.NoError(t, err)
+	assert.Contains(t, string(bytes), `"tags":{"team":"payments","tier":"critical"}`)
+
+	groups := stats.GroupByTag("team")
+	assert.Len(t, groups, 2)
+	assert.Contains(t, groups["payments"], "tags-payments")
+	assert.Contains(t, groups[""], "tags-untagged")
+
+	assert.NoError(t, payments.SetTags(map[string]string{"team": "billing"})) // replaces
+	tags, err = payments.Tags()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "billing"}, tags)
+
+	_, _, err = payments.Destroy()
+	assert.NoError(t, err)
+	tags, err = payments.Tags()
+	assert.NoError(t, err)
+	assert.Nil(t, tags)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestSortedTags(t *testing.T) {
+	assert.Equal(t, []string{}, sortedTags(nil))
+	assert.Equal(t, []string{"a=1", "b=2"}, sortedTags(map[string]string{"b": "2", "a": "1"}))
+}
@@ -0,0 +1,165 @@
+package rmq
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WithTenantFairness makes consumers of this queue fetch round-robin from
+// the ready lists of the tenants sharing it, so a tenant which publishes a
+// lot of deliveries at once can't starve the others. Deliveries published
+// via PublishForTenant() go to the ready list of their tenant, the ones
+// published otherwise (or returned to ready, for example by the cleaner)
+// take turns like another tenant.
+// NOTE: deliveries published via PublishForTenant() only get consumed by
+// consumers of queues opened with this option
+func WithTenantFairness() QueueOption {
+	return func(queue *redisQueue) {
+		queue.tenants = &tenantRing{}
+	}
+}
+
+// tenantRing is the round-robin state of a queue opened with
+// WithTenantFairness()
+type tenantRing struct {
+	mu        sync.Mutex
+	readyKeys []string // of the tenants in this round, the queue's own ready list first
+	next      int      // index into readyKeys
+}
+
+// PublishForTenant adds deliveries of the given tenant to the queue, see
+// WithTenantFairness(). The tenant is available via Header.Tenant(). Like
+// markers they bypass the frozen policy, the spool and the fallback.
+func (queue *redisQueue) PublishForTenant(tenant string, payload ...string) error {
+	if tenant == "" {
+		return queue.Publish(payload...)
+	}
+	if err := queue.checkList(); err != nil {
+		return err
+	}
+	payload, err := queue.encode(Header{HeaderTenant: tenant}, payload)
+	if err != nil {
+		return err
+	}
+	if err := queue.checkBigKey(queue.redisClient, queueTenantKey(queue.name, tenant), len(payload)); err != nil {
+		return err
+	}
+
+	// register the tenant after the list got written, see fetchTenant()
+	if _, err := queue.redisClient.LPush(queueTenantKey(queue.name, tenant), payload...); err != nil {
+		return err
+	}
+	_, err = queue.redisClient.SAdd(queue.tenantsKey, tenant)
+	return err
+}
+
+// Tenant returns the tenant of the delivery if it got published via
+// Queue.PublishForTenant()
+func (header Header) Tenant() (tenant string, ok bool) {
+	tenant, ok = header[HeaderTenant]
+	return tenant, ok
+}
+
+func queueTenantKey(queueName, tenant string) string {
+	tenantKey := strings.Replace(queueTenantTemplate, phQueue, queueName, 1)
+	return strings.Replace(tenantKey, phTenant, tenant, 1)
+}
+
+// Tenants returns the tenants with ready deliveries in this queue, in sorted
+// order. Tenants whose ready list just got empty might still be included.
+func (queue *redisQueue) Tenants() ([]string, error) {
+	tenants, err := queue.redisClient.SMembers(queue.tenantsKey)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// fetchTenant moves the next ready delivery of the next tenant in turn to
+// the unacked list and returns it. Returns ErrorNotFound if there is none.
+func (queue *redisQueue) fetchTenant() (string, error) {
+	ring := queue.tenants
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	empty := 0 // ready lists found empty, at most one more than in a round
+	for {
+		if ring.next >= len(ring.readyKeys) {
+			if err := queue.startTenantRound(); err != nil {
+				return "", err
+			}
+		}
+		if empty > len(ring.readyKeys) {
+			return "", ErrorNotFound
+		}
+
+		readyKey := ring.readyKeys[ring.next]
+		ring.next++
+		payload, err := queue.redisClient.RPopLPush(readyKey, queue.unackedKey)
+		if err != ErrorNotFound {
+			return payload, err
+		}
+		empty++
+		if readyKey == queue.readyKey {
+			continue
+		}
+		if err := queue.removeTenant(readyKey); err != nil {
+			return "", err
+		}
+	}
+}
+
+// startTenantRound starts the next round with the currently registered
+// tenants, must be called with mu locked
+func (queue *redisQueue) startTenantRound() error {
+	tenants, err := queue.Tenants()
+	if err != nil {
+		return err
+	}
+
+	readyKeys := make([]string, 0, len(tenants)+1)
+	readyKeys = append(readyKeys, queue.readyKey)
+	for _, tenant := range tenants {
+		readyKeys = append(readyKeys, queueTenantKey(queue.name, tenant))
+	}
+	queue.tenants.readyKeys = readyKeys
+	queue.tenants.next = 0
+	return nil
+}
+
+// removeTenant unregisters the tenant of the empty ready list. Publishers
+// register tenants after writing to their list, so if it got written to
+// before the check below it gets registered again, either here or by them.
+func (queue *redisQueue) removeTenant(readyKey string) error {
+	tenant := strings.TrimPrefix(readyKey, queueTenantKey(queue.name, ""))
+	if _, err := queue.redisClient.SRem(queue.tenantsKey, tenant); err != nil {
+		return err
+	}
+	count, err := queue.redisClient.LLen(readyKey)
+	if err != nil || count == 0 {
+		return err
+	}
+	_, err = queue.redisClient.SAdd(queue.tenantsKey, tenant)
+	return err
+}
+
+// tenantCounts returns the number of ready deliveries per tenant, nil if no
+// tenant has any, see PublishForTenant()
+func (queue *redisQueue) tenantCounts() (map[string]int64, error) {
+	tenants, err := queue.Tenants()
+	if err != nil || len(tenants) == 0 {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(tenants))
+	for _, tenant := range tenants {
+		count, err := queue.redisClient.LLen(queueTenantKey(queue.name, tenant))
+		if err != nil {
+			return nil, err
+		}
+		counts[tenant] = count
+	}
+	return counts, nil
+}
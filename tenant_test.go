@@ -0,0 +1,118 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantFairness(t *testing.T) {
+	connection, err := OpenConnection("tenant-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("tenant-q", WithTenantFairness())
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.True(t, err == nil || err == ErrorNotFound)
+	queue, err = connection.OpenQueue("tenant-q", WithTenantFairness())
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.PublishForTenant("tenant-a", "a1", "a2", "a3", "a4"))
+	assert.NoError(t, queue.PublishForTenant("tenant-b", "b1", "b2"))
+	assert.NoError(t, queue.Publish("p1"))
+
+	tenants, err := queue.Tenants()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant-a", "tenant-b"}, tenants)
+	counts, err := queue.tenantCounts()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"tenant-a": 4, "tenant-b": 2}, counts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var payloads []string
+	for i := 0; i < 7; i++ {
+		delivery, err := queue.ConsumeOne(ctx)
+		require.NoError(t, err)
+		payloads = append(payloads, delivery.Payload())
+		if delivery.Payload() == "a1" {
+			tenant, ok := delivery.Header().Tenant()
+			assert.True(t, ok)
+			assert.Equal(t, "tenant-a", tenant)
+		}
+		assert.NoError(t, delivery.Ack())
+	}
+	// tenant-b doesn't have to wait for all of tenant-a's deliveries
+	assert.Equal(t, []string{"p1", "a1", "b1", "a2", "b2", "a3", "a4"}, payloads)
+
+	tenants, err = queue.Tenants()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant-a"}, tenants) // emptied, but not fetched from since
+
+	assert.NoError(t, queue.PublishForTenant("tenant-c", "c1"))
+	readyCount, _, err := queue.Destroy()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), readyCount)
+	tenants, err = queue.Tenants()
+	assert.NoError(t, err)
+	assert.Empty(t, tenants)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestTenantWithoutFairness(t *testing.T) {
+	connection, err := OpenConnection("tenant-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("tenant-plain-q")
+	require.NoError(t, err)
+	_, err = queue.PurgeReady()
+	require.NoError(t, err)
+
+	// an empty tenant publishes to the queue's own ready list
+	assert.NoError(t, queue.PublishForTenant("", "p1"))
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "p1", messages[0].Payload)
+
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestTenantsCounted(t *testing.T) {
+	connection, err := OpenConnection("tenant-conn", "tcp", "localhost:6379", 1, nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("tenant-counted-q", WithTenantFairness())
+	require.NoError(t, err)
+	_, _, err = queue.Destroy()
+	require.True(t, err == nil || err == ErrorNotFound)
+	queue, err = connection.OpenQueue("tenant-counted-q", WithTenantFairness())
+	require.NoError(t, err)
+
+	assert.NoError(t, queue.PublishForTenant("tenant-a", "a1", "a2"))
+	assert.NoError(t, queue.Publish("p1"))
+
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	stats, err := connection.CollectStats([]string{"tenant-counted-q"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stats.QueueStats["tenant-counted-q"].ReadyCount)
+	assert.Equal(t, map[string]int64{"tenant-a": 2}, stats.QueueStats["tenant-counted-q"].Tenants)
+
+	// WaitUntilEmpty() doesn't return while tenants have ready deliveries
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, queue.WaitUntilEmpty(ctx))
+
+	purged, err := queue.PurgeReady()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+	assert.NoError(t, queue.WaitUntilEmpty(context.Background()))
+
+	_, _, err = queue.Destroy()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
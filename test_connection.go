@@ -1,6 +1,7 @@
 package rmq
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -18,24 +19,41 @@ func NewTestConnection() TestConnection {
 	}
 }
 
-func (connection TestConnection) OpenQueue(name string) (Queue, error) {
+func (connection TestConnection) OpenQueue(name string, _ ...QueueOption) (Queue, error) {
 	queue, _ := connection.queues.LoadOrStore(name, NewTestQueue(name))
 	return queue.(*TestQueue), nil
 }
 
+func (TestConnection) InspectConnection(string) (ConnectionInspection, error) {
+	panic(errorNotSupported)
+}
+
+func (TestConnection) StopAllConsumingContext(context.Context) error {
+	panic(errorNotSupported)
+}
+
 func (TestConnection) CollectStats([]string) (Stats, error)  { panic(errorNotSupported) }
 func (TestConnection) GetOpenQueues() ([]string, error)      { panic(errorNotSupported) }
 func (TestConnection) StopAllConsuming() <-chan struct{}     { panic(errorNotSupported) }
+func (TestConnection) ReturnAllUnacked() (int64, error)      { panic(errorNotSupported) }
+func (TestConnection) ShutdownConnection(string) error       { panic(errorNotSupported) }
+func (TestConnection) Close() error                          { panic(errorNotSupported) }
 func (TestConnection) checkHeartbeat() error                 { panic(errorNotSupported) }
 func (TestConnection) getConnections() ([]string, error)     { panic(errorNotSupported) }
 func (TestConnection) hijackConnection(string) Connection    { panic(errorNotSupported) }
 func (TestConnection) closeStaleConnection() error           { panic(errorNotSupported) }
 func (TestConnection) getConsumingQueues() ([]string, error) { panic(errorNotSupported) }
+func (TestConnection) recordCleanerRun(cleanerRun) error     { panic(errorNotSupported) }
+func (TestConnection) cleanerStat() (CleanerStat, error)     { panic(errorNotSupported) }
 func (TestConnection) unlistAllQueues() error                { panic(errorNotSupported) }
 func (TestConnection) openQueue(string) Queue                { panic(errorNotSupported) }
 func (TestConnection) stopHeartbeat() error                  { panic(errorNotSupported) }
 func (TestConnection) flushDb() error                        { panic(errorNotSupported) }
 
+func (TestConnection) queueCounts([]string) ([]int64, []int64, error) {
+	panic(errorNotSupported)
+}
+
 // test helpers for test inspection and similar
 
 func (connection TestConnection) GetDeliveries(queueName string) []string {
@@ -1,13 +1,17 @@
 package rmq
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 )
 
 type TestDelivery struct {
-	State   State
-	payload string
+	State        State
+	Class        string // error class passed to RejectAs()
+	Checkpointed []byte // state of the latest Checkpoint() call, set to simulate a redelivery
+	payload      string
+	header       Header
 }
 
 func NewTestDelivery(content interface{}) *TestDelivery {
@@ -33,6 +37,14 @@ func (delivery *TestDelivery) Payload() string {
 	return delivery.payload
 }
 
+func (delivery *TestDelivery) Header() Header {
+	return delivery.header
+}
+
+func (delivery *TestDelivery) Context() context.Context {
+	return context.Background()
+}
+
 func (delivery *TestDelivery) Ack() error {
 	if delivery.State != Unacked {
 		return ErrorNotFound
@@ -49,6 +61,14 @@ func (delivery *TestDelivery) Reject() error {
 	return nil
 }
 
+func (delivery *TestDelivery) RejectAs(class string) error {
+	if err := delivery.Reject(); err != nil {
+		return err
+	}
+	delivery.Class = class
+	return nil
+}
+
 func (delivery *TestDelivery) Push() error {
 	if delivery.State != Unacked {
 		return ErrorNotFound
@@ -56,3 +76,22 @@ func (delivery *TestDelivery) Push() error {
 	delivery.State = Pushed
 	return nil
 }
+
+func (delivery *TestDelivery) AckAndPublish(queue Queue, payload string) error {
+	if err := delivery.Ack(); err != nil {
+		return err
+	}
+	return queue.Publish(payload)
+}
+
+func (delivery *TestDelivery) Checkpoint(state []byte) error {
+	delivery.Checkpointed = state
+	return nil
+}
+
+func (delivery *TestDelivery) LastCheckpoint() ([]byte, error) {
+	if delivery.Checkpointed == nil {
+		return nil, ErrorNotFound
+	}
+	return delivery.Checkpointed, nil
+}
@@ -1,10 +1,15 @@
 package rmq
 
-import "time"
+import (
+	"context"
+	"strconv"
+	"time"
+)
 
 type TestQueue struct {
 	name           string
 	LastDeliveries []string
+	LastHeaders    []Header // headers of LastDeliveries, nil if published without
 }
 
 func NewTestQueue(name string) *TestQueue {
@@ -18,10 +23,51 @@ func (queue *TestQueue) String() string {
 }
 
 func (queue *TestQueue) Publish(payload ...string) error {
+	return queue.PublishWithHeader(nil, payload...)
+}
+
+func (queue *TestQueue) PublishContext(ctx context.Context, payload ...string) error {
+	return queue.Publish(payload...)
+}
+
+func (queue *TestQueue) PublishWithHeader(header Header, payload ...string) error {
 	queue.LastDeliveries = append(queue.LastDeliveries, payload...)
+	for range payload {
+		queue.LastHeaders = append(queue.LastHeaders, header)
+	}
 	return nil
 }
 
+func (queue *TestQueue) PublishMarker(name string) error {
+	return queue.PublishWithHeader(Header{HeaderMarker: name}, name)
+}
+
+func (queue *TestQueue) PublishForTenant(tenant string, payload ...string) error {
+	return queue.PublishWithHeader(Header{HeaderTenant: tenant}, payload...)
+}
+
+// PublishDelayed and PublishAt record the deliveries right away, regardless
+// of when they would become ready
+func (queue *TestQueue) PublishDelayed(delay time.Duration, payload ...string) error {
+	return queue.Publish(payload...)
+}
+
+func (queue *TestQueue) PublishAt(at time.Time, payload ...string) error {
+	return queue.Publish(payload...)
+}
+
+func (queue *TestQueue) PublishWithPriority(priority int, payload ...string) error {
+	return queue.PublishWithHeader(Header{HeaderPriority: strconv.Itoa(priority)}, payload...)
+}
+
+func (queue *TestQueue) PublishBatch(payloads []string) (int, error) {
+	return len(payloads), queue.Publish(payloads...)
+}
+
+func (queue *TestQueue) PublishBatchBytes(payloads [][]byte) (int, error) {
+	return len(payloads), queue.PublishBytes(payloads...)
+}
+
 func (queue *TestQueue) PublishBytes(payload ...[]byte) error {
 	stringifiedBytes := make([]string, len(payload))
 	for i, b := range payload {
@@ -30,27 +76,89 @@ func (queue *TestQueue) PublishBytes(payload ...[]byte) error {
 	return queue.Publish(stringifiedBytes...)
 }
 
+func (*TestQueue) SetFrozenPolicy(FrozenPolicy, int)                    { panic(errorNotSupported) }
+func (*TestQueue) FlushFrozenBuffer() error                             { panic(errorNotSupported) }
 func (*TestQueue) SetPushQueue(Queue)                                   { panic(errorNotSupported) }
+func (*TestQueue) EnableWorkStealing()                                  { panic(errorNotSupported) }
 func (*TestQueue) StartConsuming(int64, time.Duration) error            { panic(errorNotSupported) }
+func (*TestQueue) ConsumeOne(context.Context) (Delivery, error)         { panic(errorNotSupported) }
+func (*TestQueue) Deliveries(context.Context) <-chan Delivery           { panic(errorNotSupported) }
 func (*TestQueue) StopConsuming() <-chan struct{}                       { panic(errorNotSupported) }
+func (*TestQueue) StopConsumingContext(context.Context) error           { panic(errorNotSupported) }
 func (*TestQueue) AddConsumer(string, Consumer) (string, error)         { panic(errorNotSupported) }
 func (*TestQueue) AddConsumerFunc(string, ConsumerFunc) (string, error) { panic(errorNotSupported) }
 func (*TestQueue) AddBatchConsumer(string, int64, time.Duration, BatchConsumer) (string, error) {
 	panic(errorNotSupported)
 }
-func (*TestQueue) ReturnUnacked(int64) (int64, error)  { panic(errorNotSupported) }
-func (*TestQueue) ReturnRejected(int64) (int64, error) { panic(errorNotSupported) }
-func (*TestQueue) PurgeReady() (int64, error)          { panic(errorNotSupported) }
-func (*TestQueue) PurgeRejected() (int64, error)       { panic(errorNotSupported) }
-func (*TestQueue) Destroy() (int64, int64, error)      { panic(errorNotSupported) }
-func (*TestQueue) closeInStaleConnection() error       { panic(errorNotSupported) }
-func (*TestQueue) readyCount() (int64, error)          { panic(errorNotSupported) }
-func (*TestQueue) unackedCount() (int64, error)        { panic(errorNotSupported) }
-func (*TestQueue) rejectedCount() (int64, error)       { panic(errorNotSupported) }
-func (*TestQueue) getConsumers() ([]string, error)     { panic(errorNotSupported) }
+func (*TestQueue) AddAffinityConsumers(string, AffinityFunc, ...Consumer) ([]string, error) {
+	panic(errorNotSupported)
+}
+func (*TestQueue) ReturnUnackedWithProgress(context.Context, int64, int64, func(int64)) (int64, error) {
+	panic(errorNotSupported)
+}
+func (*TestQueue) ReturnUnacked(int64) (int64, error)                      { panic(errorNotSupported) }
+func (*TestQueue) ReturnRejected(int64) (int64, error)                     { panic(errorNotSupported) }
+func (*TestQueue) ReplayRejected(int64) (int64, error)                     { panic(errorNotSupported) }
+func (*TestQueue) HandoffUnacked(string, int64) (int64, error)             { panic(errorNotSupported) }
+func (*TestQueue) Freeze() error                                           { panic(errorNotSupported) }
+func (*TestQueue) Unfreeze() error                                         { panic(errorNotSupported) }
+func (*TestQueue) IsFrozen() (bool, error)                                 { panic(errorNotSupported) }
+func (*TestQueue) PurgeReady() (int64, error)                              { panic(errorNotSupported) }
+func (*TestQueue) PurgeRejected() (int64, error)                           { panic(errorNotSupported) }
+func (*TestQueue) UndoPurge() (int64, error)                               { panic(errorNotSupported) }
+func (*TestQueue) ReconcileFallback() (int64, error)                       { panic(errorNotSupported) }
+func (*TestQueue) Destroy() (int64, int64, error)                          { panic(errorNotSupported) }
+func (*TestQueue) WaitUntilEmpty(context.Context) error                    { panic(errorNotSupported) }
+func (*TestQueue) PeekReady(int64) ([]Message, error)                      { panic(errorNotSupported) }
+func (*TestQueue) PeekRejected(int64) ([]Message, error)                   { panic(errorNotSupported) }
+func (*TestQueue) PeekRepublished(int64) ([]Message, error)                { panic(errorNotSupported) }
+func (*TestQueue) RepublishRejected(string, func([]byte) []byte) error     { panic(errorNotSupported) }
+func (*TestQueue) RejectedClasses() ([]string, error)                      { panic(errorNotSupported) }
+func (*TestQueue) Tenants() ([]string, error)                              { panic(errorNotSupported) }
+func (*TestQueue) ReturnRejectedClass(string, int64) (int64, error)        { panic(errorNotSupported) }
+func (*TestQueue) PurgeRejectedClass(string) (int64, error)                { panic(errorNotSupported) }
+func (*TestQueue) PeekRejectedClass(string, int64) ([]Message, error)      { panic(errorNotSupported) }
+func (*TestQueue) DeclareFeeds(...Queue) error                             { panic(errorNotSupported) }
+func (*TestQueue) Feeds() ([]string, error)                                { panic(errorNotSupported) }
+func (*TestQueue) closeInStaleConnection() error                           { panic(errorNotSupported) }
+func (*TestQueue) returnCleaned() (int64, error)                           { panic(errorNotSupported) }
+func (*TestQueue) returnHandoff() (int64, error)                           { panic(errorNotSupported) }
+func (*TestQueue) countCleaned(int64) error                                { panic(errorNotSupported) }
+func (*TestQueue) readyCount() (int64, error)                              { panic(errorNotSupported) }
+func (*TestQueue) unackedCount() (int64, error)                            { panic(errorNotSupported) }
+func (*TestQueue) rejectedCount() (int64, error)                           { panic(errorNotSupported) }
+func (*TestQueue) getConsumers() ([]string, error)                         { panic(errorNotSupported) }
+func (*TestQueue) SetRetention(RetentionPolicy) error                      { panic(errorNotSupported) }
+func (*TestQueue) Retention() (RetentionPolicy, error)                     { panic(errorNotSupported) }
+func (*TestQueue) SetTags(map[string]string) error                         { panic(errorNotSupported) }
+func (*TestQueue) Tags() (map[string]string, error)                        { panic(errorNotSupported) }
+func (*TestQueue) SetRedeliveryOrder(RedeliveryOrder) error                { panic(errorNotSupported) }
+func (*TestQueue) RedeliveryOrder() (RedeliveryOrder, error)               { panic(errorNotSupported) }
+func (*TestQueue) ResetStats() error                                       { panic(errorNotSupported) }
+func (*TestQueue) StatsResets() ([]StatsReset, error)                      { panic(errorNotSupported) }
+func (*TestQueue) InFlight() map[string]int64                              { panic(errorNotSupported) }
+func (*TestQueue) enforceRetention(time.Time) (int64, error)               { panic(errorNotSupported) }
+func (*TestQueue) enforceReturnPolicy(time.Time) (int64, error)            { panic(errorNotSupported) }
+func (*TestQueue) SetReturnRejectedPolicy(ReturnRejectedPolicy) error      { panic(errorNotSupported) }
+func (*TestQueue) ReturnRejectedPolicy() (ReturnRejectedPolicy, error)     { panic(errorNotSupported) }
+func (*TestQueue) enforceAging(time.Time) (int64, error)                   { panic(errorNotSupported) }
+func (*TestQueue) promoteDue(time.Time) (int64, error)                     { panic(errorNotSupported) }
+func (*TestQueue) SetAgingPolicy(AgingPolicy) error                        { panic(errorNotSupported) }
+func (*TestQueue) AgingPolicy() (AgingPolicy, error)                       { panic(errorNotSupported) }
+func (*TestQueue) bufferStat() (int64, int64, time.Duration, int64, error) { panic(errorNotSupported) }
+func (*TestQueue) fetchedCount() (int64, error)                            { panic(errorNotSupported) }
+func (*TestQueue) cleanedCount() (int64, error)                            { panic(errorNotSupported) }
+func (*TestQueue) rejectedClassCounts() (map[string]int64, error)          { panic(errorNotSupported) }
+func (*TestQueue) tenantCounts() (map[string]int64, error)                 { panic(errorNotSupported) }
+func (*TestQueue) priorityCounts() (map[int]int64, error)                  { panic(errorNotSupported) }
+func (*TestQueue) streamCount() (int64, error)                             { panic(errorNotSupported) }
+func (*TestQueue) bigKeys(QueueStat) []string                              { panic(errorNotSupported) }
+func (*TestQueue) lostAckCount() (int64, error)                            { panic(errorNotSupported) }
+func (*TestQueue) durationsStat() (*durationSketch, error)                 { panic(errorNotSupported) }
 
 // test helper
 
 func (queue *TestQueue) Reset() {
 	queue.LastDeliveries = []string{}
+	queue.LastHeaders = []Header{}
 }
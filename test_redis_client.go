@@ -2,6 +2,8 @@ package rmq
 
 import (
 	"errors"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -11,6 +13,7 @@ import (
 type TestRedisClient struct {
 	store sync.Map
 	ttl   sync.Map
+	subs  sync.Map // subscribers by channel
 }
 
 var lock sync.Mutex
@@ -41,11 +44,58 @@ func (client *TestRedisClient) Set(key string, value string, expiration time.Dur
 	return nil
 }
 
+// SetNX sets key to hold the string value if key does not exist.
+// In that case, it is equal to SET. When key already holds a value, no operation is performed.
+func (client *TestRedisClient) SetNX(key string, value string, expiration time.Duration) (set bool, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if expiration, found := client.ttl.Load(key); found && expiration.(int64) < time.Now().Unix() {
+		//It was there, but it expired; removing it now
+		client.store.Delete(key)
+		client.ttl.Delete(key)
+	}
+
+	if _, found := client.store.Load(key); found {
+		return false, nil
+	}
+
+	client.store.Store(key, value)
+	if expiration.Seconds() != 0.0 {
+		client.ttl.Store(key, time.Now().Add(expiration).Unix())
+	}
+
+	return true, nil
+}
+
+// ExpireIfEqual sets the expiration of key if it holds value. Returns whether
+// the expiration got set.
+func (client *TestRedisClient) ExpireIfEqual(key, value string, expiration time.Duration) (refreshed bool, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if expiration, found := client.ttl.Load(key); found && expiration.(int64) < time.Now().Unix() {
+		return false, nil
+	}
+	if stored, found := client.store.Load(key); !found || stored != value {
+		return false, nil
+	}
+
+	client.ttl.Store(key, time.Now().Add(expiration).Unix())
+	return true, nil
+}
+
 // Get the value of key.
 // If the key does not exist or isn't a string
-// the special value nil is returned.
+// ErrorNotFound is returned.
 func (client *TestRedisClient) Get(key string) (string, error) {
 
+	if expiration, found := client.ttl.Load(key); found && expiration.(int64) < time.Now().Unix() {
+		return "", ErrorNotFound
+	}
+
 	value, found := client.store.Load(key)
 
 	if found {
@@ -54,7 +104,7 @@ func (client *TestRedisClient) Get(key string) (string, error) {
 		}
 	}
 
-	return "nil", nil
+	return "", ErrorNotFound
 }
 
 //Del removes the specified key. A key is ignored if it does not exist.
@@ -71,6 +121,32 @@ func (client *TestRedisClient) Del(key string) (affected int64, err error) {
 
 }
 
+// IncrBy increments the number stored at key by value. If the key does not
+// exist, it is set to 0 before performing the operation.
+func (client *TestRedisClient) IncrBy(key string, value int64) (total int64, err error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if expiration, found := client.ttl.Load(key); found && expiration.(int64) < time.Now().Unix() {
+		client.ttl.Delete(key)
+		client.store.Delete(key)
+	}
+
+	if stored, found := client.store.Load(key); found {
+		stringValue, casted := stored.(string)
+		if !casted {
+			return 0, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		if total, err = strconv.ParseInt(stringValue, 10, 64); err != nil {
+			return 0, errors.New("ERR value is not an integer or out of range")
+		}
+	}
+
+	total += value
+	client.store.Store(key, strconv.FormatInt(total, 10))
+	return total, nil
+}
+
 // TTL returns the remaining time to live of a key that has a timeout.
 // This introspection capability allows a Redis client to check how many seconds a given key will continue to be part of the dataset.
 // In Redis 2.6 or older the command returns -1 if the key does not exist or if the key exist but has no associated expire.
@@ -125,8 +201,89 @@ func (client *TestRedisClient) LPush(key string, value ...string) (total int64,
 		return 0, nil
 	}
 
-	client.storeList(key, append(value, list...))
-	return int64(len(list)) + 1, nil
+	//Each value gets inserted at the head, so the last one ends up first
+	pushed := make([]string, 0, len(value)+len(list))
+	for i := len(value) - 1; i >= 0; i-- {
+		pushed = append(pushed, value[i])
+	}
+	pushed = append(pushed, list...)
+	client.storeList(key, pushed)
+	return int64(len(pushed)), nil
+}
+
+// RPush inserts the specified values at the tail of the list stored at key.
+// If key does not exist, it is created as empty list before performing the push operation.
+// When key holds a value that is not a list, an error is returned.
+func (client *TestRedisClient) RPush(key string, value ...string) (total int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(key)
+
+	if err != nil {
+		return 0, nil
+	}
+
+	list = append(list, value...)
+	client.storeList(key, list)
+	return int64(len(list)), nil
+}
+
+// LIndex returns the element at index in the list stored at key. Negative
+// indices count from the tail of the list, -1 being the last element.
+// Returns ErrorNotFound if index is out of range.
+// LRange returns the specified elements of the list stored at key. The offsets
+// start and stop are zero-based indexes and can be negative to count from the
+// end of the list.
+func (client *TestRedisClient) LRange(key string, start, stop int64) (values []string, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(key)
+
+	if err != nil {
+		return []string{}, nil
+	}
+
+	length := int64(len(list))
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return []string{}, nil
+	}
+	return append([]string{}, list[start:stop+1]...), nil
+}
+
+func (client *TestRedisClient) LIndex(key string, index int64) (value string, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(key)
+
+	if err != nil {
+		return "", ErrorNotFound
+	}
+
+	if index < 0 {
+		index += int64(len(list))
+	}
+	if index < 0 || index >= int64(len(list)) {
+		return "", ErrorNotFound
+	}
+	return list[index], nil
 }
 
 //LLen returns the length of the list stored at key.
@@ -141,6 +298,15 @@ func (client *TestRedisClient) LLen(key string) (affected int64, err error) {
 	return int64(len(list)), nil
 }
 
+// LLens returns the lengths of the lists stored at keys, in the same order.
+func (client *TestRedisClient) LLens(keys ...string) (lengths []int64, err error) {
+	lengths = make([]int64, len(keys))
+	for i, key := range keys {
+		lengths[i], _ = client.LLen(key)
+	}
+	return lengths, nil
+}
+
 // LRem removes the first count occurrences of elements equal to
 // value from the list stored at key. The count argument influences
 // the operation in the following ways:
@@ -239,6 +405,12 @@ func (client *TestRedisClient) LTrim(key string, start, stop int64) error {
 	if stop < 0 {
 		stop += int64(len(list))
 	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(len(list)) {
+		stop = int64(len(list)) - 1
+	}
 
 	//invalid values cause the remove of the key
 	if start > stop {
@@ -246,7 +418,7 @@ func (client *TestRedisClient) LTrim(key string, start, stop int64) error {
 		return nil
 	}
 
-	client.storeList(key, list[start:stop])
+	client.storeList(key, list[start:stop+1])
 	return nil
 }
 
@@ -283,6 +455,121 @@ func (client *TestRedisClient) RPopLPush(source, destination string) (value stri
 	return sourceList[len(sourceList)-1], nil
 }
 
+// RPopLPushUnless is like RPopLPush, but doesn't move anything if guardKey
+// exists.
+func (client *TestRedisClient) RPopLPushUnless(source, destination, guardKey string) (value string, guarded bool, err error) {
+	if _, err := client.Get(guardKey); err == nil {
+		return "", true, nil
+	}
+	value, err = client.RPopLPush(source, destination)
+	return value, false, err
+}
+
+// LPopRPush removes the first element (head) of the list stored at source
+// and appends it to the list stored at destination
+func (client *TestRedisClient) LPopRPush(source, destination string) (value string, err error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	sourceList, sourceErr := client.findList(source)
+	destList, destErr := client.findList(destination)
+	if sourceErr != nil || destErr != nil || len(sourceList) == 0 {
+		return "", ErrorNotFound
+	}
+
+	client.storeList(source, sourceList[1:])
+	client.storeList(destination, append(destList, sourceList[0]))
+	return sourceList[0], nil
+}
+
+// LPopRPushUnless is like LPopRPush, but doesn't move anything if guardKey
+// exists.
+func (client *TestRedisClient) LPopRPushUnless(source, destination, guardKey string) (value string, guarded bool, err error) {
+	if _, err := client.Get(guardKey); err == nil {
+		return "", true, nil
+	}
+	value, err = client.LPopRPush(source, destination)
+	return value, false, err
+}
+
+// LRemLPush removes the first occurrence of value from the list stored at
+// removeKey and, if it was found, inserts pushValue at the head of the list
+// stored at pushKey. Both happen while holding the lock, so atomically.
+func (client *TestRedisClient) LRemLPush(removeKey, value, pushKey, pushValue string) (affected int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(removeKey)
+	if err != nil {
+		return 0, nil
+	}
+
+	for index, element := range list {
+		if element != value {
+			continue
+		}
+
+		newList := make([]string, 0, len(list)-1)
+		newList = append(newList, list[:index]...)
+		client.storeList(removeKey, append(newList, list[index+1:]...))
+
+		pushList, err := client.findList(pushKey)
+		if err != nil {
+			return 1, nil
+		}
+		client.storeList(pushKey, append([]string{pushValue}, pushList...))
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// LRemLPushNX is like LRemLPush, but only pushes if onceKey doesn't exist
+// yet, in which case it also sets onceKey with the given expiration
+func (client *TestRedisClient) LRemLPushNX(removeKey, value, pushKey, pushValue, onceKey string, expiration time.Duration) (affected int64, pushed bool, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(removeKey)
+	if err != nil {
+		return 0, false, nil
+	}
+
+	for index, element := range list {
+		if element != value {
+			continue
+		}
+
+		newList := make([]string, 0, len(list)-1)
+		newList = append(newList, list[:index]...)
+		client.storeList(removeKey, append(newList, list[index+1:]...))
+
+		if ttl, found := client.ttl.Load(onceKey); found && ttl.(int64) < time.Now().Unix() {
+			//It was there, but it expired; removing it now
+			client.store.Delete(onceKey)
+			client.ttl.Delete(onceKey)
+		}
+		if _, found := client.store.Load(onceKey); found {
+			return 1, false, nil
+		}
+		client.store.Store(onceKey, "1")
+		if expiration.Seconds() != 0.0 {
+			client.ttl.Store(onceKey, time.Now().Add(expiration).Unix())
+		}
+
+		pushList, err := client.findList(pushKey)
+		if err != nil {
+			return 1, false, nil
+		}
+		client.storeList(pushKey, append([]string{pushValue}, pushList...))
+		return 1, true, nil
+	}
+
+	return 0, false, nil
+}
+
 // SAdd adds the specified members to the set stored at key.
 // Specified members that are already a member of this set are ignored.
 // If key does not exist, a new set is created before adding the specified members.
@@ -318,6 +605,13 @@ func (client *TestRedisClient) SMembers(key string) (members []string, err error
 	return members, nil
 }
 
+// SScan returns all the members of the set value stored at key in one step,
+// so the returned cursor is always 0.
+func (client *TestRedisClient) SScan(key string, cursor uint64, count int64) (members []string, next uint64, err error) {
+	members, err = client.SMembers(key)
+	return members, 0, err
+}
+
 // SRem removes the specified members from the set stored at key.
 // Specified members that are not a member of this set are ignored.
 // If key does not exist, it is treated as an empty set and this command returns 0.
@@ -340,6 +634,495 @@ func (client *TestRedisClient) SRem(key, value string) (affected int64, err erro
 	return 0, nil
 }
 
+// ZAddLimit atomically removes all members with a score up to expiredScore
+// from the sorted set stored at key and then adds member with score if fewer
+// than limit members are left. Returns whether member got added.
+func (client *TestRedisClient) ZAddLimit(key, member string, score, expiredScore float64, limit int64) (added bool, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	zset, err := client.findSortedSet(key)
+	if err != nil {
+		return false, err
+	}
+
+	for m, s := range zset {
+		if s <= expiredScore {
+			delete(zset, m)
+		}
+	}
+
+	if int64(len(zset)) >= limit {
+		client.storeSortedSet(key, zset)
+		return false, nil
+	}
+
+	zset[member] = score
+	client.storeSortedSet(key, zset)
+	return true, nil
+}
+
+// ZAdd adds member with score to the sorted set key, or updates its score if
+// it's already a member. Returns the number of added members.
+func (client *TestRedisClient) ZAdd(key, member string, score float64) (added int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	zset, err := client.findSortedSet(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, found := zset[member]; !found {
+		added = 1
+	}
+	zset[member] = score
+	client.storeSortedSet(key, zset)
+	return added, nil
+}
+
+// ZRem removes the specified member from the sorted set stored at key.
+// Returns the number of removed members.
+func (client *TestRedisClient) ZRem(key, member string) (affected int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	zset, err := client.findSortedSet(key)
+	if err != nil {
+		return 0, nil
+	}
+
+	if _, found := zset[member]; !found {
+		return 0, nil
+	}
+
+	delete(zset, member)
+	client.storeSortedSet(key, zset)
+	return 1, nil
+}
+
+// ZCard returns the number of members of the sorted set stored at key
+func (client *TestRedisClient) ZCard(key string) (count int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	zset, err := client.findSortedSet(key)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(zset)), nil
+}
+
+// LRemZAdd atomically removes value from removeKey and adds member with score
+// to the sorted set zsetKey if value was removed. Returns the number of
+// removed values.
+func (client *TestRedisClient) LRemZAdd(removeKey, value, zsetKey, member string, score float64) (affected int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(removeKey)
+	if err != nil {
+		return 0, nil
+	}
+
+	for index, element := range list {
+		if element != value {
+			continue
+		}
+
+		newList := make([]string, 0, len(list)-1)
+		newList = append(newList, list[:index]...)
+		client.storeList(removeKey, append(newList, list[index+1:]...))
+
+		zset, err := client.findSortedSet(zsetKey)
+		if err != nil {
+			return 1, nil
+		}
+		zset[member] = score
+		client.storeSortedSet(zsetKey, zset)
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// ZPopRPush atomically removes up to count members with a score up to
+// maxScore from the sorted set key, lowest scores first, and pushes them
+// without their first trim bytes to the right of pushKey. Returns the number
+// of moved members.
+func (client *TestRedisClient) ZPopRPush(key string, maxScore float64, count int64, trim int, pushKey string) (moved int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	zset, err := client.findSortedSet(key)
+	if err != nil {
+		return 0, err
+	}
+
+	members := []string{}
+	for member, score := range zset {
+		if score <= maxScore {
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if zset[members[i]] != zset[members[j]] {
+			return zset[members[i]] < zset[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	if int64(len(members)) > count {
+		members = members[:count]
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	list, err := client.findList(pushKey)
+	if err != nil {
+		return 0, err
+	}
+	for _, member := range members {
+		delete(zset, member)
+		list = append(list, member[trim:])
+	}
+	client.storeSortedSet(key, zset)
+	client.storeList(pushKey, list)
+	return int64(len(members)), nil
+}
+
+// LPushAllExpire atomically moves all values of key to the left of pushKey,
+// keeping their order, and makes pushKey expire after expiration. Returns
+// the number of moved values.
+func (client *TestRedisClient) LPushAllExpire(key, pushKey string, expiration time.Duration) (moved int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(key)
+	if err != nil || len(list) == 0 {
+		return 0, err
+	}
+	pushList, err := client.findList(pushKey)
+	if err != nil {
+		return 0, err
+	}
+
+	client.storeList(pushKey, append(list, pushList...))
+	client.ttl.Store(pushKey, time.Now().Add(expiration).Unix())
+	client.store.Delete(key)
+	client.ttl.Delete(key)
+	return int64(len(list)), nil
+}
+
+// RPushAll atomically moves all values of key to the right of pushKey,
+// keeping their order. Returns the number of moved values.
+func (client *TestRedisClient) RPushAll(key, pushKey string) (moved int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(key)
+	if err != nil || len(list) == 0 {
+		return 0, err
+	}
+	pushList, err := client.findList(pushKey)
+	if err != nil {
+		return 0, err
+	}
+
+	client.storeList(pushKey, append(pushList, list...))
+	client.store.Delete(key)
+	client.ttl.Delete(key)
+	return int64(len(list)), nil
+}
+
+// XAdd appends an entry for each value to the stream stored at key, in the
+// given order.
+func (client *TestRedisClient) XAdd(key string, value ...string) error {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stream, err := client.findStream(key)
+	if err != nil {
+		return err
+	}
+	for _, v := range value {
+		stream.add(v)
+	}
+	client.store.Store(key, stream)
+	return nil
+}
+
+// XLen returns the number of entries of the stream stored at key, including
+// the pending ones.
+func (client *TestRedisClient) XLen(key string) (length int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stream, err := client.findStream(key)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(stream.entries)), nil
+}
+
+// XReadGroup returns up to count entries of the stream stored at key which
+// group didn't read yet, oldest first, and makes them pending for consumer.
+// Creates the group if it doesn't exist yet.
+func (client *TestRedisClient) XReadGroup(key, group, consumer string, count int64) (entries []StreamEntry, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stream, err := client.findStream(key)
+	if err != nil {
+		return nil, err
+	}
+	streamGroup := stream.group(group)
+	for _, entry := range stream.entries {
+		if int64(len(entries)) >= count {
+			break
+		}
+		if entry.seq <= streamGroup.lastSeq {
+			continue
+		}
+		streamGroup.lastSeq = entry.seq
+		streamGroup.pending = append(streamGroup.pending, testStreamPending{id: entry.ID, consumer: consumer})
+		entries = append(entries, entry.StreamEntry)
+	}
+	client.store.Store(key, stream)
+	return entries, nil
+}
+
+// XPending returns the IDs of up to count entries pending for consumer,
+// oldest first.
+func (client *TestRedisClient) XPending(key, group, consumer string, count int64) (ids []string, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stream, err := client.findStream(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, pending := range stream.group(group).pending {
+		if int64(len(ids)) >= count {
+			break
+		}
+		if pending.consumer == consumer {
+			ids = append(ids, pending.id)
+		}
+	}
+	return ids, nil
+}
+
+// XPendingCount returns the number of entries pending for consumer, or for
+// all consumers of group if consumer is empty.
+func (client *TestRedisClient) XPendingCount(key, group, consumer string) (count int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stream, err := client.findStream(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, pending := range stream.group(group).pending {
+		if consumer == "" || pending.consumer == consumer {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// XAckDel atomically acks and deletes the entry with the given ID if it's
+// pending. Returns the number of acked entries.
+func (client *TestRedisClient) XAckDel(key, group, id string) (affected int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stream, err := client.findStream(key)
+	if err != nil || !stream.ack(group, id) {
+		return 0, err
+	}
+	stream.remove(id)
+	return 1, nil
+}
+
+// XAckDelLPush atomically acks and deletes the entry with the given ID and
+// inserts pushValue at the head of pushKey if the entry was pending. Returns
+// the number of acked entries.
+func (client *TestRedisClient) XAckDelLPush(key, group, id, pushKey, pushValue string) (affected int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stream, err := client.findStream(key)
+	if err != nil {
+		return 0, err
+	}
+	list, err := client.findList(pushKey)
+	if err != nil {
+		return 0, err
+	}
+	if !stream.ack(group, id) {
+		return 0, nil
+	}
+	stream.remove(id)
+	client.storeList(pushKey, append([]string{pushValue}, list...))
+	return 1, nil
+}
+
+// RPopXAdd atomically removes the last value (tail) of the list stored at
+// source and appends it as entry to the stream stored at key. Returns
+// ErrorNotFound if source is empty.
+func (client *TestRedisClient) RPopXAdd(source, key string) (value string, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	list, err := client.findList(source)
+	if err != nil || len(list) == 0 {
+		return "", ErrorNotFound
+	}
+	stream, err := client.findStream(key)
+	if err != nil {
+		return "", err
+	}
+
+	value = list[len(list)-1]
+	client.storeList(source, list[:len(list)-1])
+	stream.add(value)
+	client.store.Store(key, stream)
+	return value, nil
+}
+
+// XReturn atomically appends the values of the pending entries with the
+// given IDs as new entries and acks and deletes the pending ones. Returns the
+// number of returned entries.
+func (client *TestRedisClient) XReturn(key, group string, ids ...string) (returned int64, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	return client.xreturn(key, group, ids)
+}
+
+// XReturnUnless is like XReturn, but returns nothing and guarded true if
+// guardKey exists.
+func (client *TestRedisClient) XReturnUnless(key, group, guardKey string, ids ...string) (returned int64, guarded bool, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if expiration, found := client.ttl.Load(guardKey); !found || expiration.(int64) >= time.Now().Unix() {
+		if _, found := client.store.Load(guardKey); found {
+			return 0, true, nil
+		}
+	}
+	returned, err = client.xreturn(key, group, ids)
+	return returned, false, err
+}
+
+func (client *TestRedisClient) xreturn(key, group string, ids []string) (returned int64, err error) {
+	stream, err := client.findStream(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		value, found := stream.value(id)
+		if !stream.ack(group, id) || !found {
+			continue
+		}
+		stream.remove(id)
+		stream.add(value)
+		returned++
+	}
+	return returned, nil
+}
+
+// XDelConsumer removes consumer from group, entries still pending for it stay
+// in the stream without being pending anymore.
+func (client *TestRedisClient) XDelConsumer(key, group, consumer string) error {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stream, err := client.findStream(key)
+	if err != nil {
+		return err
+	}
+	streamGroup := stream.group(group)
+	remaining := streamGroup.pending[:0]
+	for _, pending := range streamGroup.pending {
+		if pending.consumer != consumer {
+			remaining = append(remaining, pending)
+		}
+	}
+	streamGroup.pending = remaining
+	return nil
+}
+
+// Publish sends message to all subscribers of channel. Like in redis,
+// subscribers which don't keep up miss messages.
+func (client *TestRedisClient) Publish(channel, message string) error {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	subscribers, found := client.subs.Load(channel)
+	if !found {
+		return nil
+	}
+	for _, subscriber := range subscribers.([]chan string) {
+		select {
+		case subscriber <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes to channel and returns the received messages. The
+// messages chan gets closed after calling unsubscribe.
+func (client *TestRedisClient) Subscribe(channel string) (messages <-chan string, unsubscribe func() error, err error) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	subscriber := make(chan string, 100)
+	subscribers, _ := client.subs.LoadOrStore(channel, []chan string{})
+	client.subs.Store(channel, append(subscribers.([]chan string), subscriber))
+
+	unsubscribe = func() error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		subscribers, _ := client.subs.Load(channel)
+		remaining := []chan string{}
+		for _, other := range subscribers.([]chan string) {
+			if other == subscriber {
+				close(subscriber)
+				continue
+			}
+			remaining = append(remaining, other)
+		}
+		client.subs.Store(channel, remaining)
+		return nil
+	}
+	return subscriber, unsubscribe, nil
+}
+
 // FlushDb delete all the keys of the currently selected DB. This command never fails.
 func (client *TestRedisClient) FlushDb() error {
 	client.store = *new(sync.Map)
@@ -371,6 +1154,118 @@ func (client *TestRedisClient) findSet(key string) (map[string]struct{}, error)
 	return make(map[string]struct{}), nil
 }
 
+//storeSortedSet stores the sorted set at key, empty sorted sets get removed
+func (client *TestRedisClient) storeSortedSet(key string, zset map[string]float64) {
+	if len(zset) == 0 {
+		client.store.Delete(key)
+		return
+	}
+	client.store.Store(key, zset)
+}
+
+//findSortedSet finds a sorted set, mapping members to their scores
+func (client *TestRedisClient) findSortedSet(key string) (map[string]float64, error) {
+	storedValue, found := client.store.Load(key)
+	if found {
+		zset, casted := storedValue.(map[string]float64)
+
+		if casted {
+			return zset, nil
+		}
+
+		return nil, errors.New("Stored value wasn't a sorted set")
+	}
+
+	//return an empty sorted set if not found
+	return make(map[string]float64), nil
+}
+
+//testStream is a stream with its consumer groups, stored as pointer
+type testStream struct {
+	entries []testStreamEntry // oldest first
+	lastSeq int64
+	groups  map[string]*testStreamGroup
+}
+
+type testStreamEntry struct {
+	StreamEntry
+	seq int64
+}
+
+type testStreamGroup struct {
+	lastSeq int64               // seq of the last entry the group read
+	pending []testStreamPending // oldest first
+}
+
+type testStreamPending struct {
+	id       string
+	consumer string
+}
+
+func (stream *testStream) add(value string) {
+	stream.lastSeq++
+	id := strconv.FormatInt(stream.lastSeq, 10) + "-0"
+	stream.entries = append(stream.entries, testStreamEntry{StreamEntry{ID: id, Value: value}, stream.lastSeq})
+}
+
+func (stream *testStream) value(id string) (value string, found bool) {
+	for _, entry := range stream.entries {
+		if entry.ID == id {
+			return entry.Value, true
+		}
+	}
+	return "", false
+}
+
+func (stream *testStream) remove(id string) {
+	for index, entry := range stream.entries {
+		if entry.ID == id {
+			stream.entries = append(stream.entries[:index], stream.entries[index+1:]...)
+			return
+		}
+	}
+}
+
+//group returns the consumer group, creating it if it doesn't exist yet
+func (stream *testStream) group(name string) *testStreamGroup {
+	group, found := stream.groups[name]
+	if !found {
+		group = &testStreamGroup{}
+		stream.groups[name] = group
+	}
+	return group
+}
+
+//ack removes the entry with the given ID from the pending ones of group and
+//returns whether it was pending
+func (stream *testStream) ack(group, id string) bool {
+	streamGroup := stream.group(group)
+	for index, pending := range streamGroup.pending {
+		if pending.id == id {
+			streamGroup.pending = append(streamGroup.pending[:index], streamGroup.pending[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+//findStream returns the stream stored at key, an empty one if key doesn't
+//exist. Changes to it are stored right away if key exists.
+func (client *TestRedisClient) findStream(key string) (*testStream, error) {
+	storedValue, found := client.store.Load(key)
+	if found {
+		stream, casted := storedValue.(*testStream)
+
+		if casted {
+			return stream, nil
+		}
+
+		return nil, errors.New("Stored value wasn't a stream")
+	}
+
+	return &testStream{groups: map[string]*testStreamGroup{}}, nil
+}
+
 //storeList is an helper function so others don't have to deal with pointers
 func (client *TestRedisClient) storeList(key string, list []string) {
 	client.store.Store(key, &list)
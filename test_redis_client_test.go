@@ -144,3 +144,183 @@ func TestTestRedisClient_LPush(t *testing.T) {
 		})
 	}
 }
+
+func TestTestRedisClient_SetNX(t *testing.T) {
+	client := NewTestRedisClient()
+
+	set, err := client.SetNX("somekey", "somevalue", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, set)
+
+	set, err = client.SetNX("somekey", "othervalue", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, set)
+
+	v, err := client.Get("somekey")
+	assert.NoError(t, err)
+	assert.Equal(t, "somevalue", v)
+
+	_, err = client.Del("somekey")
+	assert.NoError(t, err)
+	set, err = client.SetNX("somekey", "othervalue", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, set)
+}
+
+func TestTestRedisClient_LIndex(t *testing.T) {
+	client := NewTestRedisClient()
+	_, err := client.RPush("list", "a", "b", "c")
+	assert.NoError(t, err)
+
+	value, err := client.LIndex("list", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+	value, err = client.LIndex("list", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", value)
+	_, err = client.LIndex("list", 3)
+	assert.Equal(t, ErrorNotFound, err)
+	_, err = client.LIndex("missing", -1)
+	assert.Equal(t, ErrorNotFound, err)
+}
+
+func TestTestRedisClient_LRange(t *testing.T) {
+	client := NewTestRedisClient()
+	_, err := client.RPush("list", "a", "b", "c")
+	assert.NoError(t, err)
+
+	values, err := client.LRange("list", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, values)
+	values, err = client.LRange("list", -2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, values)
+	values, err = client.LRange("list", 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, values)
+	values, err = client.LRange("missing", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, values)
+}
+
+func TestTestRedisClient_LRemLPush(t *testing.T) {
+	client := NewTestRedisClient()
+	_, err := client.RPush("from", "a", "b")
+	assert.NoError(t, err)
+
+	affected, err := client.LRemLPush("from", "a", "to", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+	value, err := client.LIndex("from", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", value)
+	value, err = client.LIndex("to", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", value)
+
+	// nothing gets pushed if nothing was removed
+	affected, err = client.LRemLPush("from", "a", "to", "d")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), affected)
+	length, err := client.LLen("to")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), length)
+}
+
+func TestTestRedisClient_ZAddLimit(t *testing.T) {
+	client := NewTestRedisClient()
+
+	added, err := client.ZAddLimit("zset", "a", 10, 0, 2)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	added, err = client.ZAddLimit("zset", "b", 20, 0, 2)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	added, err = client.ZAddLimit("zset", "c", 30, 0, 2)
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	// a expired
+	added, err = client.ZAddLimit("zset", "c", 30, 10, 2)
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	affected, err := client.ZRem("zset", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+	affected, err = client.ZRem("zset", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), affected)
+}
+
+func TestTestRedisClient_ZPopRPush(t *testing.T) {
+	client := NewTestRedisClient()
+	_, err := client.RPush("from", "a", "b")
+	assert.NoError(t, err)
+
+	affected, err := client.LRemZAdd("from", "a", "zset", "1-a", 20)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+	affected, err = client.LRemZAdd("from", "b", "zset", "2-b", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+	affected, err = client.LRemZAdd("from", "c", "zset", "3-c", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), affected)
+
+	moved, err := client.ZPopRPush("zset", 5, 10, 2, "to")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), moved)
+	moved, err = client.ZPopRPush("zset", 20, 10, 2, "to")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), moved)
+	values, err := client.LRange("to", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, values)
+}
+
+func TestTestRedisClient_PublishSubscribe(t *testing.T) {
+	client := NewTestRedisClient()
+	assert.NoError(t, client.Publish("channel", "missed"))
+
+	messages, unsubscribe, err := client.Subscribe("channel")
+	assert.NoError(t, err)
+	assert.NoError(t, client.Publish("channel", "a"))
+	assert.NoError(t, client.Publish("other", "b"))
+	assert.Equal(t, "a", <-messages)
+
+	assert.NoError(t, unsubscribe())
+	_, ok := <-messages
+	assert.False(t, ok)
+	assert.NoError(t, client.Publish("channel", "c"))
+}
+
+func TestTestRedisClient_LPushAllExpire(t *testing.T) {
+	client := NewTestRedisClient()
+	_, err := client.RPush("from", "a", "b")
+	assert.NoError(t, err)
+	_, err = client.LPush("to", "c")
+	assert.NoError(t, err)
+
+	moved, err := client.LPushAllExpire("from", "to", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), moved)
+	values, err := client.LRange("to", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, values)
+	moved, err = client.LPushAllExpire("from", "to", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), moved)
+
+	_, err = client.RPush("ready", "d")
+	assert.NoError(t, err)
+	moved, err = client.RPushAll("to", "ready")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), moved)
+	values, err = client.LRange("ready", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d", "a", "b", "c"}, values)
+	values, err = client.LRange("to", 0, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+}
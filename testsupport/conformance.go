@@ -0,0 +1,527 @@
+package testsupport
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/adjust/rmq/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunBackendConformance checks that backend provides the semantics rmq
+// relies on, see rmq.Backend. It covers the backend operations as well as
+// publishing, consuming, acking, rejecting and cleaning deliveries of queues
+// on top of it, also of queues opened with rmq.WithStream() if backend
+// implements rmq.StreamBackend. Call it from a test of your Backend implementation:
+//
+//	func TestMyBackend(t *testing.T) {
+//		testsupport.RunBackendConformance(t, NewMyBackend())
+//	}
+//
+// NOTE: flushes the backend first, so don't run it against a backend in use
+func RunBackendConformance(t *testing.T, backend rmq.Backend) {
+	require.NoError(t, backend.FlushDb())
+
+	t.Run("keys", func(t *testing.T) {
+		_, err := backend.Get("backend-key")
+		assert.Equal(t, rmq.ErrorNotFound, err)
+		assert.NoError(t, backend.Set("backend-key", "v1", 0))
+		value, err := backend.Get("backend-key")
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", value)
+		ttl, err := backend.TTL("backend-key")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(-1), ttl)
+
+		set, err := backend.SetNX("backend-key", "v2", time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, set)
+		set, err = backend.SetNX("backend-nx", "v2", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, set)
+		ttl, err = backend.TTL("backend-nx")
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0)
+		refreshed, err := backend.ExpireIfEqual("backend-nx", "v1", time.Hour)
+		assert.NoError(t, err)
+		assert.False(t, refreshed) // holds v2
+		refreshed, err = backend.ExpireIfEqual("backend-missing", "v2", time.Hour)
+		assert.NoError(t, err)
+		assert.False(t, refreshed)
+		refreshed, err = backend.ExpireIfEqual("backend-nx", "v2", time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, refreshed)
+		ttl, err = backend.TTL("backend-nx")
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0)
+
+		total, err := backend.IncrBy("backend-counter", 2)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		total, err = backend.IncrBy("backend-counter", 3)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), total)
+
+		affected, err := backend.Del("backend-key")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		affected, err = backend.Del("backend-key")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), affected)
+		ttl, err = backend.TTL("backend-key")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(-2), ttl)
+	})
+
+	t.Run("lists", func(t *testing.T) {
+		total, err := backend.LPush("backend-list", "a", "b")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		total, err = backend.RPush("backend-list", "c", "d")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), total)
+		assertBackendList(t, backend, "backend-list", "b", "a", "c", "d")
+
+		value, err := backend.LIndex("backend-list", -1)
+		assert.NoError(t, err)
+		assert.Equal(t, "d", value)
+		_, err = backend.LIndex("backend-list", 4)
+		assert.Equal(t, rmq.ErrorNotFound, err)
+		values, err := backend.LRange("backend-list", 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "c"}, values)
+
+		lengths, err := backend.LLens("backend-list", "backend-none")
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{4, 0}, lengths)
+
+		value, err = backend.RPopLPush("backend-list", "backend-other")
+		assert.NoError(t, err)
+		assert.Equal(t, "d", value)
+		_, err = backend.RPopLPush("backend-none", "backend-other")
+		assert.Equal(t, rmq.ErrorNotFound, err)
+		assert.NoError(t, backend.Set("backend-guard", "1", 0))
+		_, guarded, err := backend.RPopLPushUnless("backend-list", "backend-other", "backend-guard")
+		assert.NoError(t, err)
+		assert.True(t, guarded)
+		value, guarded, err = backend.RPopLPushUnless("backend-list", "backend-other", "backend-unguarded")
+		assert.NoError(t, err)
+		assert.False(t, guarded)
+		assert.Equal(t, "c", value)
+		assertBackendList(t, backend, "backend-list", "b", "a")
+		assertBackendList(t, backend, "backend-other", "c", "d")
+
+		value, err = backend.LPopRPush("backend-list", "backend-other")
+		assert.NoError(t, err)
+		assert.Equal(t, "b", value)
+		_, err = backend.LPopRPush("backend-none", "backend-other")
+		assert.Equal(t, rmq.ErrorNotFound, err)
+		_, guarded, err = backend.LPopRPushUnless("backend-list", "backend-other", "backend-guard")
+		assert.NoError(t, err)
+		assert.True(t, guarded)
+		_, guarded, err = backend.LPopRPushUnless("backend-none", "backend-other", "backend-unguarded")
+		assert.Equal(t, rmq.ErrorNotFound, err)
+		assert.False(t, guarded)
+		assertBackendList(t, backend, "backend-list", "a")
+		assertBackendList(t, backend, "backend-other", "c", "d", "b")
+		_, err = backend.RPopLPush("backend-other", "backend-list")
+		assert.NoError(t, err)
+		assertBackendList(t, backend, "backend-list", "b", "a")
+		assertBackendList(t, backend, "backend-other", "c", "d")
+
+		affected, err := backend.LRem("backend-list", 1, "a")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		affected, err = backend.LRemLPush("backend-other", "c", "backend-list", "c2")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		affected, err = backend.LRemLPush("backend-other", "c", "backend-list", "c3")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), affected)
+		affected, pushed, err := backend.LRemLPushNX("backend-other", "d", "backend-list", "d2", "backend-once", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		assert.True(t, pushed)
+		assert.NoError(t, backend.Set("backend-once", "1", 0))
+		_, err = backend.RPush("backend-other", "e")
+		assert.NoError(t, err)
+		affected, pushed, err = backend.LRemLPushNX("backend-other", "e", "backend-list", "e2", "backend-once", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		assert.False(t, pushed)
+		assertBackendList(t, backend, "backend-list", "d2", "c2", "b")
+		assertBackendList(t, backend, "backend-other")
+
+		_, err = backend.RPush("backend-other", "x", "y")
+		assert.NoError(t, err)
+		moved, err := backend.RPushAll("backend-other", "backend-list")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), moved)
+		assertBackendList(t, backend, "backend-list", "d2", "c2", "b", "x", "y")
+		_, err = backend.RPush("backend-other", "v", "w")
+		assert.NoError(t, err)
+		moved, err = backend.LPushAllExpire("backend-other", "backend-list", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), moved)
+		assertBackendList(t, backend, "backend-list", "v", "w", "d2", "c2", "b", "x", "y")
+		ttl, err := backend.TTL("backend-list")
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0)
+
+		assert.NoError(t, backend.LTrim("backend-list", 1, 2))
+		assertBackendList(t, backend, "backend-list", "w", "d2")
+	})
+
+	t.Run("sets", func(t *testing.T) {
+		for _, member := range []string{"a", "b", "a"} {
+			_, err := backend.SAdd("backend-set", member)
+			assert.NoError(t, err)
+		}
+		members, err := backend.SMembers("backend-set")
+		assert.NoError(t, err)
+		sort.Strings(members)
+		assert.Equal(t, []string{"a", "b"}, members)
+		members, err = scanMembers(backend, "backend-set")
+		assert.NoError(t, err)
+		sort.Strings(members)
+		assert.Equal(t, []string{"a", "b"}, members)
+
+		affected, err := backend.SRem("backend-set", "a")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		affected, err = backend.SRem("backend-set", "a")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), affected)
+		members, err = backend.SMembers("backend-set")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"b"}, members)
+	})
+
+	t.Run("sorted sets", func(t *testing.T) {
+		added, err := backend.ZAddLimit("backend-zset", "xxa", 1, 0, 2)
+		assert.NoError(t, err)
+		assert.True(t, added)
+		added, err = backend.ZAddLimit("backend-zset", "xxb", 2, 0, 2)
+		assert.NoError(t, err)
+		assert.True(t, added)
+		added, err = backend.ZAddLimit("backend-zset", "xxc", 3, 0, 2)
+		assert.NoError(t, err)
+		assert.False(t, added)
+		added, err = backend.ZAddLimit("backend-zset", "xxc", 3, 1, 2) // expires xxa
+		assert.NoError(t, err)
+		assert.True(t, added)
+		affected, err := backend.ZRem("backend-zset", "xxc")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		affected, err = backend.ZAdd("backend-zset", "xxf", 6)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		affected, err = backend.ZAdd("backend-zset", "xxf", 7) // updates the score
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), affected)
+
+		_, err = backend.RPush("backend-zlist", "d")
+		assert.NoError(t, err)
+		affected, err = backend.LRemZAdd("backend-zlist", "d", "backend-zset", "xxd", 4)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		affected, err = backend.LRemZAdd("backend-zlist", "d", "backend-zset", "xxe", 5)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), affected)
+
+		moved, err := backend.ZPopRPush("backend-zset", 4, 10, 2, "backend-zlist")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), moved)
+		assertBackendList(t, backend, "backend-zlist", "b", "d")
+		moved, err = backend.ZPopRPush("backend-zset", 4, 10, 0, "backend-zlist")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), moved)
+		count, err := backend.ZCard("backend-zset")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count) // xxf
+	})
+
+	t.Run("pub/sub", func(t *testing.T) {
+		messages, unsubscribe, err := backend.Subscribe("backend-channel")
+		require.NoError(t, err)
+		assert.NoError(t, backend.Publish("backend-channel", "hello"))
+		select {
+		case message := <-messages:
+			assert.Equal(t, "hello", message)
+		case <-time.After(time.Second):
+			t.Error("no message received")
+		}
+		assert.NoError(t, unsubscribe())
+		for range messages {
+		}
+	})
+
+	t.Run("publish and consume", func(t *testing.T) {
+		connection, err := rmq.OpenConnectionWithBackend("conformance-conn", backend, nil)
+		require.NoError(t, err)
+		defer connection.Close()
+		queue, err := connection.OpenQueue("conformance-consume")
+		require.NoError(t, err)
+
+		assert.NoError(t, queue.Publish("conformance-d1", "conformance-d2"))
+		assert.NoError(t, queue.PublishWithHeader(rmq.Header{"key": "value"}, "conformance-d3"))
+		assert.Equal(t, int64(3), queueStat(t, connection, "conformance-consume").ReadyCount)
+
+		// oldest first
+		for _, payload := range []string{"conformance-d1", "conformance-d2"} {
+			delivery, err := queue.ConsumeOne(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, payload, delivery.Payload())
+			assert.NoError(t, delivery.Ack())
+		}
+
+		acked := make(chan rmq.Delivery, 1)
+		assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+		_, err = queue.AddConsumerFunc("conformance-consumer", func(delivery rmq.Delivery) {
+			assert.NoError(t, delivery.Ack())
+			acked <- delivery
+		})
+		assert.NoError(t, err)
+		select {
+		case delivery := <-acked:
+			assert.Equal(t, "conformance-d3", delivery.Payload())
+			assert.Equal(t, rmq.Header{"key": "value"}, delivery.Header())
+		case <-time.After(time.Second):
+			t.Error("delivery not consumed")
+		}
+		<-queue.StopConsuming()
+
+		assert.Equal(t, int64(0), queueStat(t, connection, "conformance-consume").UnackedCount())
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		connection, err := rmq.OpenConnectionWithBackend("conformance-conn", backend, nil)
+		require.NoError(t, err)
+		defer connection.Close()
+		queue, err := connection.OpenQueue("conformance-reject")
+		require.NoError(t, err)
+
+		assert.NoError(t, queue.Publish("conformance-r1"))
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		assert.NoError(t, delivery.Reject())
+		assert.Equal(t, rmq.ErrorNotFound, delivery.Ack()) // handled already
+		assert.Equal(t, int64(1), queueStat(t, connection, "conformance-reject").RejectedCount)
+
+		returned, err := queue.ReturnRejected(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), returned)
+		assert.Equal(t, int64(1), queueStat(t, connection, "conformance-reject").ReadyCount)
+		purged, err := queue.PurgeReady()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+	})
+
+	t.Run("clean", func(t *testing.T) {
+		connection, err := rmq.OpenConnectionWithBackend("conformance-conn", backend, nil)
+		require.NoError(t, err)
+		queue, err := connection.OpenQueue("conformance-clean")
+		require.NoError(t, err)
+
+		assert.NoError(t, queue.Publish("conformance-c1", "conformance-c2"))
+		_, err = queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		stats, err := rmq.CollectStats([]string{"conformance-clean"}, connection)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), stats.QueueStats["conformance-clean"].ReadyCount)
+		assert.Equal(t, int64(1), stats.QueueStats["conformance-clean"].UnackedCount())
+
+		// the cleaner returns the unacked delivery once the connection died
+		cleanerConnection, err := rmq.OpenConnectionWithBackend("conformance-cleaner", backend, nil)
+		require.NoError(t, err)
+		defer cleanerConnection.Close()
+		cleaner := rmq.NewCleaner(cleanerConnection)
+		returned, err := cleaner.Clean()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), returned)
+		assert.NoError(t, connection.Close())
+		returned, err = cleaner.Clean()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), returned)
+
+		assert.Equal(t, int64(2), queueStat(t, cleanerConnection, "conformance-clean").ReadyCount)
+		connections, err := backend.SMembers("rmq::connections")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{fmt.Sprint(cleanerConnection)}, connections)
+	})
+
+	streams, ok := backend.(rmq.StreamBackend)
+	if !ok {
+		return
+	}
+
+	t.Run("streams", func(t *testing.T) {
+		entries, err := streams.XReadGroup("backend-stream", "group", "consumer1", 10)
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+		assert.NoError(t, streams.XAdd("backend-stream", "s1", "s2", "s3"))
+		length, err := streams.XLen("backend-stream")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), length)
+
+		// oldest first, read entries are pending for their consumer
+		entries, err = streams.XReadGroup("backend-stream", "group", "consumer1", 2)
+		assert.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "s1", entries[0].Value)
+		assert.Equal(t, "s2", entries[1].Value)
+		entries2, err := streams.XReadGroup("backend-stream", "group", "consumer2", 10)
+		assert.NoError(t, err)
+		require.Len(t, entries2, 1)
+		assert.Equal(t, "s3", entries2[0].Value)
+		entries3, err := streams.XReadGroup("backend-stream", "group", "consumer2", 10)
+		assert.NoError(t, err)
+		assert.Empty(t, entries3)
+		ids, err := streams.XPending("backend-stream", "group", "consumer1", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{entries[0].ID, entries[1].ID}, ids)
+		count, err := streams.XPendingCount("backend-stream", "group", "consumer1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+		count, err = streams.XPendingCount("backend-stream", "group", "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+
+		affected, err := streams.XAckDel("backend-stream", "group", entries[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		affected, err = streams.XAckDel("backend-stream", "group", entries[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), affected)
+		affected, err = streams.XAckDelLPush("backend-stream", "group", entries2[0].ID, "backend-stream-list", "pushed")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		assertBackendList(t, backend, "backend-stream-list", "pushed")
+		length, err = streams.XLen("backend-stream")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), length)
+
+		// returned entries get read again after the ready ones
+		assert.NoError(t, streams.XAdd("backend-stream", "s4"))
+		returned, err := streams.XReturn("backend-stream", "group", entries[1].ID, entries[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), returned) // s1 is acked already
+		assert.NoError(t, backend.Set("backend-stream-guard", "1", 0))
+		returned, guarded, err := streams.XReturnUnless("backend-stream", "group", "backend-stream-guard", entries[1].ID)
+		assert.NoError(t, err)
+		assert.True(t, guarded)
+		assert.Equal(t, int64(0), returned)
+		value, err := streams.RPopXAdd("backend-stream-list", "backend-stream")
+		assert.NoError(t, err)
+		assert.Equal(t, "pushed", value)
+		_, err = streams.RPopXAdd("backend-stream-list", "backend-stream")
+		assert.Equal(t, rmq.ErrorNotFound, err)
+		entries, err = streams.XReadGroup("backend-stream", "group", "consumer1", 10)
+		assert.NoError(t, err)
+		require.Len(t, entries, 3)
+		assert.Equal(t, "s4", entries[0].Value)
+		assert.Equal(t, "s2", entries[1].Value)
+		assert.Equal(t, "pushed", entries[2].Value)
+
+		returned, guarded, err = streams.XReturnUnless("backend-stream", "group", "backend-stream-missing", entries[2].ID)
+		assert.NoError(t, err)
+		assert.False(t, guarded)
+		assert.Equal(t, int64(1), returned)
+		assert.NoError(t, streams.XDelConsumer("backend-stream", "group", "consumer1"))
+		count, err = streams.XPendingCount("backend-stream", "group", "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+		length, err = streams.XLen("backend-stream")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), length)
+	})
+
+	t.Run("stream queue", func(t *testing.T) {
+		connection, err := rmq.OpenConnectionWithBackend("conformance-conn", backend, nil)
+		require.NoError(t, err)
+		queue, err := connection.OpenQueue("conformance-stream", rmq.WithStream())
+		require.NoError(t, err)
+
+		assert.NoError(t, queue.Publish("conformance-s1", "conformance-s2", "conformance-s3"))
+		assert.Equal(t, int64(3), queueStat(t, connection, "conformance-stream").ReadyCount)
+		delivery, err := queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "conformance-s1", delivery.Payload())
+		assert.NoError(t, delivery.Ack())
+		delivery, err = queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "conformance-s2", delivery.Payload())
+		assert.NoError(t, delivery.Reject())
+		_, err = queue.ConsumeOne(context.Background())
+		require.NoError(t, err)
+		stat := queueStat(t, connection, "conformance-stream")
+		assert.Equal(t, int64(0), stat.ReadyCount)
+		assert.Equal(t, int64(1), stat.RejectedCount)
+		assert.Equal(t, int64(1), stat.UnackedCount())
+
+		// the cleaner returns the pending delivery once the connection died
+		cleanerConnection, err := rmq.OpenConnectionWithBackend("conformance-cleaner", backend, nil)
+		require.NoError(t, err)
+		defer cleanerConnection.Close()
+		assert.NoError(t, connection.Close())
+		returned, err := rmq.NewCleaner(cleanerConnection).Clean()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), returned)
+		cleanerQueue, err := cleanerConnection.OpenQueue("conformance-stream")
+		require.NoError(t, err)
+		returned, err = cleanerQueue.ReturnRejected(10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), returned)
+		assert.Equal(t, int64(2), queueStat(t, cleanerConnection, "conformance-stream").ReadyCount)
+
+		for _, payload := range []string{"conformance-s3", "conformance-s2"} {
+			delivery, err := cleanerQueue.ConsumeOne(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, payload, delivery.Payload())
+			assert.NoError(t, delivery.Ack())
+		}
+		stat = queueStat(t, cleanerConnection, "conformance-stream")
+		assert.Equal(t, int64(0), stat.ReadyCount)
+		assert.Equal(t, int64(0), stat.UnackedCount())
+	})
+}
+
+func assertBackendList(t *testing.T, backend rmq.Backend, key string, expected ...string) {
+	t.Helper()
+	values, err := backend.LRange(key, 0, -1)
+	assert.NoError(t, err)
+	if len(expected) == 0 {
+		assert.Empty(t, values)
+		return
+	}
+	assert.Equal(t, expected, values)
+}
+
+// queueStat returns the stats of the given queue
+func queueStat(t *testing.T, connection rmq.Connection, queueName string) rmq.QueueStat {
+	t.Helper()
+	stats, err := rmq.CollectStats([]string{queueName}, connection)
+	assert.NoError(t, err)
+	return stats.QueueStats[queueName]
+}
+
+// scanMembers returns the members of the set at key using SScan
+func scanMembers(backend rmq.Backend, key string) ([]string, error) {
+	var members []string
+	var cursor uint64
+	for {
+		batch, next, err := backend.SScan(key, cursor, 10)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, batch...)
+		if next == 0 {
+			return members, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,18 @@
+package testsupport
+
+import (
+	"testing"
+
+	"github.com/adjust/rmq/v4"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestBackendConformance(t *testing.T) {
+	t.Run("redis", func(t *testing.T) {
+		backend := rmq.NewRedisWrapper(redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 4}))
+		RunBackendConformance(t, backend)
+	})
+	t.Run("test", func(t *testing.T) {
+		RunBackendConformance(t, rmq.NewTestRedisClient())
+	})
+}
@@ -0,0 +1,5 @@
+// Package sqlbackend runs the backend conformance tests against the SQL
+// backend. It's a module of its own, so rmq doesn't depend on SQL drivers.
+//
+//	cd testsupport/sqlbackend && go test ./...
+package sqlbackend
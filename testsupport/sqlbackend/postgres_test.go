@@ -0,0 +1,27 @@
+package sqlbackend
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/adjust/rmq/v4"
+	"github.com/adjust/rmq/v4/testsupport"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+// set RMQ_TEST_POSTGRES_DSN to the database to run in, its rmq tables get
+// flushed
+func TestPostgresBackend(t *testing.T) {
+	dsn := os.Getenv("RMQ_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("RMQ_TEST_POSTGRES_DSN not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	backend, err := rmq.NewSQLBackend(db, rmq.Postgres)
+	require.NoError(t, err)
+
+	testsupport.RunBackendConformance(t, backend)
+}
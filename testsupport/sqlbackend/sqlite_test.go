@@ -0,0 +1,21 @@
+package sqlbackend
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/adjust/rmq/v4"
+	"github.com/adjust/rmq/v4/testsupport"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteBackend(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?cache=shared&_txlock=immediate")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	backend, err := rmq.NewSQLBackend(db, rmq.SQLite)
+	require.NoError(t, err)
+
+	testsupport.RunBackendConformance(t, backend)
+}
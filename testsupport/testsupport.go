@@ -0,0 +1,55 @@
+// Package testsupport provides helpers for tests of code using rmq.
+package testsupport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adjust/rmq/v4/internal/goroutines"
+)
+
+// LeakTimeout is how long VerifyNoLeaks() waits for goroutines to finish
+var LeakTimeout = time.Second
+
+// VerifyNoLeaks fails the test if goroutines started by rmq are still
+// running, like heartbeats of connections which didn't get closed or
+// consumers of queues which didn't stop consuming. It waits up to
+// LeakTimeout for them to finish. Defer it at the start of a test, after
+// which all connections opened by the test must get closed:
+//
+//	defer testsupport.VerifyNoLeaks(t)
+//	connection, err := rmq.OpenConnection("tag", "tcp", "localhost:6379", 1, nil)
+//	...
+//	connection.Close()
+//
+// NOTE: counts the goroutines of all tests in the process, so don't use it
+// in tests running in parallel with other tests using rmq
+func VerifyNoLeaks(t testing.TB) {
+	t.Helper()
+
+	deadline := time.Now().Add(LeakTimeout)
+	for {
+		running := goroutines.Running()
+		if len(running) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("rmq goroutines still running after %s: %s", LeakTimeout, formatRunning(running))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// formatRunning returns the counts sorted by kind, like "consumer: 2, heartbeat: 1"
+func formatRunning(running map[string]int) string {
+	kinds := make([]string, 0, len(running))
+	for kind, count := range running {
+		kinds = append(kinds, fmt.Sprintf("%s: %d", kind, count))
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ", ")
+}
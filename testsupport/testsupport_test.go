@@ -0,0 +1,81 @@
+package testsupport
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adjust/rmq/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder records the errors of a test instead of failing it
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (recorder *recorder) Errorf(format string, args ...interface{}) {
+	recorder.errors = append(recorder.errors, fmt.Sprintf(format, args...))
+}
+
+func TestVerifyNoLeaks(t *testing.T) {
+	defer func(timeout time.Duration) { LeakTimeout = timeout }(LeakTimeout)
+	LeakTimeout = 50 * time.Millisecond
+
+	connection, err := rmq.OpenConnectionWithTestRedisClient("leaks-conn", nil)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("leaks-q")
+	require.NoError(t, err)
+	assert.NoError(t, queue.StartConsuming(10, time.Millisecond))
+	_, err = queue.AddConsumerFunc("leaks-cons", func(delivery rmq.Delivery) {})
+	assert.NoError(t, err)
+
+	leaked := &recorder{TB: t}
+	VerifyNoLeaks(leaked)
+	require.Len(t, leaked.errors, 1)
+	assert.Contains(t, leaked.errors[0], "consume: 1, consumer: 1, heartbeat: 1")
+
+	// stopping the queue leaves the heartbeat
+	<-queue.StopConsuming()
+	leaked = &recorder{TB: t}
+	VerifyNoLeaks(leaked)
+	require.Len(t, leaked.errors, 1)
+	assert.Contains(t, leaked.errors[0], ": heartbeat: 1")
+
+	assert.NoError(t, connection.Close())
+	VerifyNoLeaks(t)
+}
+
+// failingLPushClient fails publishing deliveries
+type failingLPushClient struct {
+	rmq.RedisClient
+}
+
+func (client *failingLPushClient) LPush(key string, value ...string) (int64, error) {
+	return 0, errors.New("lpush failed")
+}
+
+func TestVerifyNoLeaksSpool(t *testing.T) {
+	defer func(timeout time.Duration) { LeakTimeout = timeout }(LeakTimeout)
+	LeakTimeout = 50 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "rmq-leaks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &failingLPushClient{RedisClient: rmq.NewTestRedisClient()}
+	connection, err := rmq.OpenConnectionWithOptions("leaks-conn", client, nil, rmq.TestOptions)
+	require.NoError(t, err)
+	queue, err := connection.OpenQueue("leaks-q", rmq.WithSpool(filepath.Join(dir, "leaks-q")))
+	require.NoError(t, err)
+	assert.NoError(t, queue.Publish("leaks-d1")) // gets spooled
+
+	assert.NoError(t, connection.Close())
+	VerifyNoLeaks(t)
+}
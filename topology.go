@@ -0,0 +1,57 @@
+package rmq
+
+import "sort"
+
+// DeclareFeeds declares that deliveries of this queue result in deliveries of
+// the given downstream queues, for example because a Mover moves them. The
+// declared topology is shown in the stats (see QueueStat.Feeds) and helps to
+// find out where a backlog originates (see Stats.BacklogOrigins()).
+// NOTE: panics if any of the downstream queues is not a *redisQueue
+func (queue *redisQueue) DeclareFeeds(downstream ...Queue) error {
+	for _, downstreamQueue := range downstream {
+		if _, err := queue.redisClient.SAdd(queue.feedsKey, downstreamQueue.(*redisQueue).name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Feeds returns the names of the queues this queue was declared to feed via
+// DeclareFeeds(), sorted by name
+func (queue *redisQueue) Feeds() ([]string, error) {
+	feeds, err := queue.redisClient.SMembers(queue.feedsKey)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(feeds)
+	return feeds, nil
+}
+
+// BacklogOrigins returns the names of the queues with at least minReady ready
+// deliveries which aren't fed by another such queue, according to the
+// topology declared via Queue.DeclareFeeds(). A backlog in those queues is not
+// caused by a backlog further upstream, so that's where to start looking.
+func (stats Stats) BacklogOrigins(minReady int64) []string {
+	backlogged := func(queueName string) bool {
+		queueStat, ok := stats.QueueStats[queueName]
+		return ok && queueStat.ReadyCount >= minReady
+	}
+
+	fedByBacklog := map[string]bool{}
+	for queueName, queueStat := range stats.QueueStats {
+		if !backlogged(queueName) {
+			continue
+		}
+		for _, downstream := range queueStat.Feeds {
+			fedByBacklog[downstream] = true
+		}
+	}
+
+	origins := []string{}
+	for _, queueName := range stats.sortedQueueNames() {
+		if backlogged(queueName) && !fedByBacklog[queueName] {
+			origins = append(origins, queueName)
+		}
+	}
+	return origins
+}
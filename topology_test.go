@@ -0,0 +1,54 @@
+package rmq
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopology(t *testing.T) {
+	connection, err := OpenConnection("topology-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queues := map[string]Queue{}
+	for _, name := range []string{"topology-a", "topology-b", "topology-c", "topology-d"} {
+		queue, err := connection.OpenQueue(name)
+		assert.NoError(t, err)
+		_, _, err = queue.Destroy()
+		assert.NoError(t, err)
+		queue, err = connection.OpenQueue(name)
+		assert.NoError(t, err)
+		queues[name] = queue
+	}
+
+	assert.NoError(t, queues["topology-a"].DeclareFeeds(queues["topology-b"]))
+	assert.NoError(t, queues["topology-b"].DeclareFeeds(queues["topology-c"], queues["topology-d"]))
+	assert.NoError(t, queues["topology-b"].DeclareFeeds(queues["topology-c"])) // declaring twice is fine
+	feeds, err := queues["topology-b"].Feeds()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"topology-c", "topology-d"}, feeds)
+
+	// backlogs in b and c, but c is only backlogged because b is
+	assert.NoError(t, queues["topology-b"].Publish("b1", "b2"))
+	assert.NoError(t, queues["topology-c"].Publish("c1", "c2"))
+	stats, err := CollectStats([]string{"topology-a", "topology-b", "topology-c", "topology-d"}, connection)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"topology-b"}, stats.QueueStats["topology-a"].Feeds)
+	assert.Nil(t, stats.QueueStats["topology-c"].Feeds)
+	assert.Equal(t, []string{"topology-b"}, stats.BacklogOrigins(2))
+	assert.Equal(t, []string{}, stats.BacklogOrigins(3))
+	assert.Contains(t, stats.GetHtml("", ""), "topology-c, topology-d")
+
+	bytes, err := json.Marshal(stats.QueueStats["topology-b"])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"ready":2,"rejected":0,"feeds":["topology-c","topology-d"]}`, string(bytes))
+
+	for _, queue := range queues {
+		_, _, err := queue.Destroy()
+		assert.NoError(t, err)
+	}
+	feeds, err = queues["topology-b"].Feeds()
+	assert.NoError(t, err)
+	assert.Len(t, feeds, 0)
+	assert.NoError(t, connection.stopHeartbeat())
+}
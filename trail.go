@@ -0,0 +1,80 @@
+package rmq
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// events recorded in the trail of deliveries, see WithTrail()
+const (
+	TrailPublished = "published"  // published to a queue
+	TrailRejected  = "rejected"   // rejected by a consumer
+	TrailPushed    = "pushed"     // pushed to the push queue by a consumer
+	TrailDelayed   = "delayed"    // delayed by a consumer via RetryAfter() or WithErrorPolicy()
+	TrailParked    = "parked"     // published to the park queue of WithErrorPolicy()
+	TrailReturned  = "returned"   // returned to ready via ReturnUnacked() or ReturnRejected()
+	TrailHandedOff = "handed off" // handed off to another connection via HandoffUnacked()
+	TrailCleaned   = "cleaned"    // returned to ready by the cleaner after its connection died
+	TrailRepaired  = "repaired"   // returned to ready with a new payload via RepublishRejected()
+	TrailPromoted  = "promoted"   // published to a higher priority queue by a Janitor, see AgingPolicy
+)
+
+// only the latest breadcrumbs are kept, so deliveries which keep getting
+// rejected and returned don't grow indefinitely
+const maxTrailLength = 20
+
+// Breadcrumb is an entry in the trail of a delivery
+type Breadcrumb struct {
+	Event      string    `json:"e"`
+	Connection string    `json:"c"` // connection which published, consumed or held the delivery
+	Time       time.Time `json:"t"`
+}
+
+// WithTrail makes Publish() start a trail of breadcrumbs in the header of each
+// delivery. Whenever the delivery gets rejected, pushed, delayed, parked,
+// returned, handed off or cleaned, rmq adds a breadcrumb to its trail, so when inspecting rejected
+// or parked deliveries (see PeekRejected()) you can see their full journey.
+// Note that deliveries with trail get rewritten on each of these events.
+func WithTrail() QueueOption {
+	return func(queue *redisQueue) {
+		queue.trail = true
+	}
+}
+
+// Trail returns the breadcrumbs recorded for the delivery, oldest first. It's
+// nil unless the delivery got published with WithTrail().
+func (header Header) Trail() []Breadcrumb {
+	var trail []Breadcrumb
+	if err := json.Unmarshal([]byte(header[HeaderTrail]), &trail); err != nil {
+		return nil
+	}
+	return trail
+}
+
+func encodeTrail(trail []Breadcrumb) string {
+	if len(trail) > maxTrailLength {
+		trail = trail[len(trail)-maxTrailLength:]
+	}
+	bytes, err := json.Marshal(trail)
+	if err != nil { // can't happen for breadcrumbs
+		return ""
+	}
+	return string(bytes)
+}
+
+// addBreadcrumb returns the payload as stored in redis with a breadcrumb for the
+// given event added to its trail. Returns false if the delivery has no trail.
+func addBreadcrumb(payload, event, connectionName string) (string, bool) {
+	header, body := decodeHeader(payload)
+	if _, ok := header[HeaderTrail]; !ok {
+		return payload, false
+	}
+
+	updated := make(Header, len(header))
+	for key, value := range header {
+		updated[key] = value
+	}
+	trail := append(header.Trail(), Breadcrumb{Event: event, Connection: connectionName, Time: time.Now()})
+	updated[HeaderTrail] = encodeTrail(trail)
+	return encodeHeader(updated, body), true
+}
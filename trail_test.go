@@ -0,0 +1,110 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrail(t *testing.T) {
+	connection, err := OpenConnection("trail-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("trail-q", WithTrail())
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("trail-d"))
+	delivery, err := queue.ConsumeOne(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "trail-d", delivery.Payload())
+	assert.NoError(t, delivery.Reject())
+	_, err = queue.ReturnRejected(1)
+	assert.NoError(t, err)
+
+	// the consuming connection dies, the cleaner returns the delivery
+	consumerConnection, err := OpenConnection("trail-consumer-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	consumerQueue, err := consumerConnection.OpenQueue("trail-q")
+	assert.NoError(t, err)
+	_, err = consumerQueue.ConsumeOne(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, consumerConnection.stopHeartbeat())
+	_, err = NewCleaner(connection).Clean()
+	assert.NoError(t, err)
+
+	messages, err := queue.PeekReady(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "trail-d", messages[0].Payload)
+
+	delivery, err = queue.ConsumeOne(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, delivery.Reject())
+
+	messages, err = queue.PeekRejected(10)
+	assert.NoError(t, err)
+	require.Len(t, messages, 1)
+	trail := messages[0].Header.Trail()
+	require.Len(t, trail, 5)
+	events := []string{}
+	for _, breadcrumb := range trail {
+		events = append(events, breadcrumb.Event)
+	}
+	assert.Equal(t, []string{TrailPublished, TrailRejected, TrailReturned, TrailCleaned, TrailRejected}, events)
+	assert.Equal(t, connection.(*redisConnection).Name, trail[0].Connection)
+	assert.Equal(t, consumerConnection.(*redisConnection).Name, trail[3].Connection)
+	for i := 1; i < len(trail); i++ {
+		assert.False(t, trail[i].Time.Before(trail[i-1].Time))
+	}
+
+	// deliveries published without WithTrail() have none
+	assert.Nil(t, Header{}.Trail())
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestPeek(t *testing.T) {
+	connection, err := OpenConnection("peek-conn", "tcp", "localhost:6379", 1, nil)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("peek-q")
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	messages, err := queue.PeekReady(2)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 0)
+
+	assert.NoError(t, queue.PublishWithHeader(Header{"key": "value"}, "peek-d1"))
+	assert.NoError(t, queue.Publish("peek-d2", "peek-d3"))
+	messages, err = queue.PeekReady(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{Header: Header{"key": "value"}, Payload: "peek-d1"}, {Payload: "peek-d2"}}, messages)
+	count, err := queue.readyCount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestPeekRedacted(t *testing.T) {
+	options := TestOptions
+	options.Redact = RedactPayload
+	connection, err := OpenConnectionWithOptions("peek-conn", NewTestRedisClient(), nil, options)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("peek-redacted-q")
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.PublishWithHeader(Header{"key": "value"}, "secret"))
+	messages, err := queue.PeekReady(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{Header: Header{"key": "value"}, Payload: "[redacted 6 bytes]"}}, messages)
+
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,104 @@
+package rmq
+
+import (
+	"time"
+)
+
+// WithShutdownWatchdog reports the consumers of this queue which are still
+// consuming a delivery when the given timeout passed after StopConsuming()
+// got called. A HungConsumerError with the consumer and the ID of its
+// delivery gets sent to errChan for each of them and logged, so a shutdown
+// which never finishes tells you which handler it's waiting for. With
+// forceReturn their deliveries get returned to the ready list, so other
+// connections can consume them while the hung handler stays stuck. If it
+// finishes after all, the delivery might get consumed twice.
+// NOTE: doesn't apply to batch consumers
+func WithShutdownWatchdog(timeout time.Duration, forceReturn bool) QueueOption {
+	return func(queue *redisQueue) {
+		queue.watchdogAfter = timeout
+		queue.watchdogReturn = forceReturn
+	}
+}
+
+// consumingDelivery is the delivery a consumer is currently consuming, see
+// WithShutdownWatchdog()
+type consumingDelivery struct {
+	delivery *redisDelivery
+	started  time.Time
+}
+
+// trackConsuming records that the given consumer started consuming the
+// given delivery if the queue uses a shutdown watchdog. The returned func
+// must be called once the consumer is done with it.
+func (queue *redisQueue) trackConsuming(consumerName string, delivery Delivery) func() {
+	redisDelivery, ok := delivery.(*redisDelivery)
+	if queue.watchdogAfter <= 0 || !ok {
+		return func() {}
+	}
+
+	queue.consuming.Store(consumerName, &consumingDelivery{delivery: redisDelivery, started: time.Now()})
+	return func() { queue.consuming.Delete(consumerName) }
+}
+
+// startWatchdog calls reportHung() once the shutdown watchdog's timeout
+// passed. Returns a func stopping it, to be called once all consumers
+// finished.
+func (queue *redisQueue) startWatchdog() func() {
+	if queue.watchdogAfter <= 0 {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(queue.watchdogAfter, queue.reportHung)
+	return func() { timer.Stop() }
+}
+
+// reportHung reports the consumers which are still consuming a delivery they
+// didn't handle yet and returns those deliveries if forceReturn was set, see
+// WithShutdownWatchdog()
+func (queue *redisQueue) reportHung() {
+	queue.consuming.Range(func(key, value interface{}) bool {
+		consumerName, consuming := key.(string), value.(*consumingDelivery)
+		if consuming.delivery.handled() {
+			return true // only busy with what comes after the ack
+		}
+
+		returned := false
+		if queue.watchdogReturn {
+			returned = queue.forceReturn(consuming.delivery)
+		}
+
+		err := &HungConsumerError{
+			Queue:      queue.name,
+			Consumer:   consumerName,
+			DeliveryID: deliveryID(consuming.delivery.payload),
+			Elapsed:    time.Since(consuming.started),
+			Returned:   returned,
+		}
+		queue.options.logf(LogInfo, "rmq queue %s: %s", queue, err)
+		select { // try to add error to channel, but don't block
+		case queue.errChan <- err:
+		default:
+		}
+		return true
+	})
+}
+
+// forceReturn moves the delivery of a hung consumer from the unacked list
+// back to its ready list. Returns whether it got moved.
+func (queue *redisQueue) forceReturn(delivery *redisDelivery) bool {
+	var count int64
+	var err error
+	if delivery.streams != nil {
+		count, err = delivery.streams.XReturn(delivery.streamKey, streamGroup, delivery.streamID)
+	} else {
+		count, err = queue.redisClient.LRemLPush(delivery.unackedKey, delivery.payload, queue.readyKeyOf(delivery.payload), delivery.payload)
+	}
+	if err != nil {
+		select { // try to add error to channel, but don't block
+		case queue.errChan <- &ConsumeError{RedisErr: err, Count: 1}:
+		default:
+		}
+		return false
+	}
+	return count > 0
+}
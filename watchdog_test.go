@@ -0,0 +1,86 @@
+package rmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownWatchdog(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("watchdog-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("watchdog-q", WithShutdownWatchdog(10*time.Millisecond, true))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+	_, err = queue.PurgeRejected()
+	assert.NoError(t, err)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	assert.NoError(t, queue.StartConsuming(1, time.Millisecond))
+	consumerName, err := queue.AddConsumerFunc("watchdog-cons", func(delivery Delivery) {
+		close(started)
+		<-release
+		assert.Equal(t, ErrorNotFound, delivery.Ack()) // got returned meanwhile
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.Publish("watchdog-d1"))
+	<-started
+	finished := queue.StopConsuming()
+
+	var hungErr *HungConsumerError
+	select {
+	case err := <-errChan:
+		require.IsType(t, hungErr, err)
+		hungErr = err.(*HungConsumerError)
+	case <-time.After(time.Second):
+		t.Fatal("no hung consumer error")
+	}
+	assert.Equal(t, "watchdog-q", hungErr.Queue)
+	assert.Equal(t, consumerName, hungErr.Consumer)
+	assert.Equal(t, deliveryID("watchdog-d1"), hungErr.DeliveryID)
+	assert.True(t, hungErr.Elapsed >= 10*time.Millisecond)
+	assert.True(t, hungErr.Returned)
+
+	assertHandlerCounts(t, queue, 1, 0, 0)
+
+	close(release)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("consuming didn't stop")
+	}
+	assert.NoError(t, connection.stopHeartbeat())
+}
+
+func TestShutdownWatchdogFinished(t *testing.T) {
+	errChan := make(chan error, 10)
+	connection, err := OpenConnection("watchdog-conn", "tcp", "localhost:6379", 1, errChan)
+	assert.NoError(t, err)
+	queue, err := connection.OpenQueue("watchdog-fin-q", WithShutdownWatchdog(10*time.Millisecond, false))
+	assert.NoError(t, err)
+	_, err = queue.PurgeReady()
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.StartConsuming(1, time.Millisecond))
+	_, err = queue.AddConsumerFunc("watchdog-cons", func(delivery Delivery) {
+		assert.NoError(t, delivery.Ack())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Publish("watchdog-d2"))
+	time.Sleep(20 * time.Millisecond)
+	<-queue.StopConsuming()
+
+	// no consumer was busy when consuming stopped
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case err := <-errChan:
+		t.Fatalf("unexpected error: %s", err)
+	default:
+	}
+	assert.NoError(t, connection.stopHeartbeat())
+}
@@ -0,0 +1,149 @@
+package rmq
+
+import "sync"
+
+// WithWeight sets the share of the connection's concurrency slots (see
+// Options.MaxConcurrency) the consumers of this queue get while consumers of
+// several queues are waiting for one. For example with weights 7 and 3 the
+// first queue gets about 70% of the slots and the second one 30%, as long as
+// both have deliveries to consume. Queues which don't wait don't use up
+// their share, so the others get the free slots. The default weight is 1,
+// without MaxConcurrency weights don't apply.
+func WithWeight(weight int) QueueOption {
+	return func(queue *redisQueue) {
+		if weight > 0 {
+			queue.weight = weight
+		}
+	}
+}
+
+// acquire blocks until consuming another delivery (or batch) is within the
+// connection's concurrency limit, see MaxConcurrency and WithWeight(). The
+// returned function must be called once consuming finished.
+func (queue *redisQueue) acquire() (release func()) {
+	if queue.options.concurrency == nil {
+		return func() {}
+	}
+	weight := queue.weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return queue.options.concurrency.acquire(queue.name, weight)
+}
+
+// takeTurn blocks until the consume loop may poll redis, at most
+// Options.ConnectionBudget consume loops of the connection's queues poll at
+// the same time. Turns go to the queues by weight like concurrency slots. Only
+// called by the consume goroutine, which must call endTurn() before waiting
+// for anything but redis.
+func (queue *redisQueue) takeTurn() {
+	if queue.options.polls == nil || queue.pollTurn != nil {
+		return
+	}
+	weight := queue.weight
+	if weight <= 0 {
+		weight = 1
+	}
+	queue.pollTurn = queue.options.polls.acquire(queue.name, weight)
+}
+
+// endTurn lets the consume loops of other queues poll, see takeTurn()
+func (queue *redisQueue) endTurn() {
+	if queue.pollTurn != nil {
+		queue.pollTurn()
+		queue.pollTurn = nil
+	}
+}
+
+// slots limits how many deliveries (or batches) get consumed at the same
+// time across all queues of a connection, see Options.MaxConcurrency. Freed
+// slots go to the waiting queue which got the fewest slots relative to its
+// weight so far, in virtual time: Each slot a queue gets advances its virtual
+// time by 1/weight. A queue which starts waiting after being idle continues
+// from the virtual time of the latest slot, so being idle doesn't build up
+// credit.
+type slots struct {
+	mu      sync.Mutex
+	free    int
+	waiting []*slotWaiter      // in order of arrival
+	served  map[string]float64 // virtual time by queue name
+	clock   float64            // latest virtual time a queue got a slot at
+}
+
+type slotWaiter struct {
+	queueName string
+	weight    int
+	granted   chan struct{}
+}
+
+func newSlots(n int) *slots {
+	return &slots{free: n, served: map[string]float64{}}
+}
+
+// acquire blocks until the queue got a slot. The returned function must be
+// called once consuming finished.
+func (slots *slots) acquire(queueName string, weight int) (release func()) {
+	slots.mu.Lock()
+	if !slots.isWaiting(queueName) && slots.served[queueName] < slots.clock {
+		slots.served[queueName] = slots.clock // was idle
+	}
+	if slots.free > 0 && len(slots.waiting) == 0 {
+		slots.free--
+		slots.grant(queueName, weight)
+		slots.mu.Unlock()
+		return slots.release
+	}
+
+	waiter := &slotWaiter{queueName: queueName, weight: weight, granted: make(chan struct{}, 1)}
+	slots.waiting = append(slots.waiting, waiter)
+	slots.mu.Unlock()
+
+	<-waiter.granted
+	return slots.release
+}
+
+func (slots *slots) release() {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+
+	if len(slots.waiting) == 0 {
+		slots.free++
+		return
+	}
+
+	next := 0
+	for i, waiter := range slots.waiting {
+		if slots.finish(waiter) < slots.finish(slots.waiting[next]) {
+			next = i
+		}
+	}
+	waiter := slots.waiting[next]
+	slots.waiting = append(slots.waiting[:next], slots.waiting[next+1:]...)
+	slots.grant(waiter.queueName, waiter.weight)
+	waiter.granted <- struct{}{} // never blocks, a waiter gets one slot
+}
+
+// isWaiting returns whether consumers of the queue are waiting for a slot,
+// must be called with mu locked
+func (slots *slots) isWaiting(queueName string) bool {
+	for _, waiter := range slots.waiting {
+		if waiter.queueName == queueName {
+			return true
+		}
+	}
+	return false
+}
+
+// finish returns the virtual time the waiter's queue would have after getting
+// the next slot, must be called with mu locked
+func (slots *slots) finish(waiter *slotWaiter) float64 {
+	return slots.served[waiter.queueName] + 1/float64(waiter.weight)
+}
+
+// grant charges the queue for a slot, must be called with mu locked
+func (slots *slots) grant(queueName string, weight int) {
+	if slots.served[queueName] > slots.clock {
+		slots.clock = slots.served[queueName]
+	}
+	slots.served[queueName] += 1 / float64(weight)
+}
@@ -0,0 +1,91 @@
+package rmq
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlotsWeights(t *testing.T) {
+	slots := newSlots(1)
+	release := slots.acquire("idle", 1) // hold the only slot until all wait
+
+	var mu sync.Mutex
+	var granted []string
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, queue := range []struct {
+			name   string
+			weight int
+		}{{"a", 7}, {"b", 3}} {
+			wg.Add(1)
+			go func(name string, weight int) {
+				defer wg.Done()
+				release := slots.acquire(name, weight)
+				mu.Lock()
+				granted = append(granted, name)
+				mu.Unlock()
+				release()
+			}(queue.name, queue.weight)
+		}
+	}
+	require.Eventually(t, func() bool {
+		slots.mu.Lock()
+		defer slots.mu.Unlock()
+		return len(slots.waiting) == 100
+	}, time.Second, time.Millisecond)
+
+	release()
+	wg.Wait()
+
+	counts := map[string]int{}
+	for _, name := range granted[:20] {
+		counts[name]++
+	}
+	assert.InDelta(t, 14, counts["a"], 1)
+	assert.InDelta(t, 6, counts["b"], 1)
+	assert.Equal(t, 1, slots.free)
+}
+
+func TestSlotsIdleCredit(t *testing.T) {
+	slots := newSlots(1)
+	for i := 0; i < 10; i++ {
+		slots.acquire("a", 1)() // b is idle meanwhile
+	}
+
+	release := slots.acquire("a", 1)
+	var mu sync.Mutex
+	var granted []string
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for _, name := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				release := slots.acquire(name, 1)
+				mu.Lock()
+				granted = append(granted, name)
+				mu.Unlock()
+				release()
+			}(name)
+		}
+	}
+	require.Eventually(t, func() bool {
+		slots.mu.Lock()
+		defer slots.mu.Unlock()
+		return len(slots.waiting) == 8
+	}, time.Second, time.Millisecond)
+
+	release()
+	wg.Wait()
+
+	// b doesn't get all slots to catch up on the ones a got while it was idle
+	counts := map[string]int{}
+	for _, name := range granted[:4] {
+		counts[name]++
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, counts)
+}